@@ -0,0 +1,279 @@
+// Package ast maps syntax trees to user-defined Go structures, similar to what encoding/json does for JSON documents.
+//
+// Each structure represents a node, its fields are populated from child elements of that node.
+// Fields are selected with llx struct tags:
+//
+//	type Assign struct {
+//		Name  string   `llx:"token:name"`
+//		Value *Expr    `llx:"node:expr"`
+//		Ops   []string `llx:"literal:+"`
+//		Src   string   `llx:"text"`
+//	}
+//
+// Supported tags are:
+//   - node:name or just name — child nodes of given type;
+//   - token:name or $name — child tokens of given type;
+//   - literal:text — child tokens having given text;
+//   - text — concatenated text of all tokens of the node itself.
+//
+// Short forms use grammar notation, e.g. `llx:"$name"` and `llx:"expr"`; a node named "text" needs the long form.
+//
+// Supported field kinds are:
+//   - string: token text or concatenated text of all tokens of a node;
+//   - bool: set to true when a matching element is present;
+//   - integer and floating point numbers: element text parsed with strconv package;
+//   - *lexer.Token: the token itself, or the initial token of a node;
+//   - tree.Element, tree.NodeElement: the element itself;
+//   - struct: a node, populated recursively;
+//   - a type implementing Unmarshaler (or a pointer to such type);
+//   - a pointer to any of the above: allocated when corresponding child element is present (optional elements);
+//   - a slice of any of the above: one item is appended for each matching child element (repeated elements).
+//
+// Several fields may share the same tag. Non-slice fields keep the last matching element.
+// Untagged and unexported fields as well as child elements having no matching field are ignored.
+//
+// Unmarshal is the entry point for parsing a source directly into structures. Package parser provides
+// no such function, since ast builds on parser and tree packages.
+package ast
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/tree"
+)
+
+// Unmarshaler is implemented by types that populate themselves from a tree element.
+type Unmarshaler interface {
+	UnmarshalLLX(el tree.Element) error
+}
+
+var (
+	tokenPtrType    = reflect.TypeOf((*lexer.Token)(nil))
+	elementType     = reflect.TypeOf((*tree.Element)(nil)).Elem()
+	nodeElementType = reflect.TypeOf((*tree.NodeElement)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	treeHooks       = &parser.Hooks{Nodes: parser.NodeHooks{parser.AnyNode: tree.NodeHook}}
+)
+
+const (
+	textKey    = "text"
+	nodeKey    = "node:"
+	tokenKey   = "token:"
+	literalKey = "literal:"
+)
+
+// shortNameRe matches node and token type names allowed in short tags.
+var shortNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z_0-9-]*$")
+
+// Unmarshal parses src and stores resulting syntax tree in the struct pointed to by v,
+// the struct represents the root node. See Decode for details.
+func Unmarshal(p *parser.Parser, src string, v any, opts ...parser.ParseOption) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return typeError(nil, reflect.TypeOf(v))
+	}
+
+	res, e := p.ParseString("", src, treeHooks, opts...)
+	if e != nil {
+		return e
+	}
+
+	root, valid := res.(tree.NodeElement)
+	if !valid {
+		return typeError(nil, rv.Type())
+	}
+
+	return Decode(root, v)
+}
+
+// Decode stores tree element el in the value pointed to by v.
+// Returns TypeError if v is not a non-nil pointer or if some element cannot be stored in corresponding field,
+// TagError for malformed struct tags, and ValueError if element text cannot be converted to a number.
+// Errors returned by Unmarshaler implementations are passed as is.
+func Decode(el tree.Element, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return typeError(el, reflect.TypeOf(v))
+	}
+
+	return storeElement(el, rv.Elem())
+}
+
+type fieldMap map[string][]int
+
+func structFields(st reflect.Type) (fieldMap, error) {
+	fields := make(fieldMap)
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		tag, has := f.Tag.Lookup("llx")
+		if !has || !f.IsExported() {
+			continue
+		}
+
+		key := tag
+		switch {
+		case tag == textKey,
+			strings.HasPrefix(tag, nodeKey) && len(tag) > len(nodeKey),
+			strings.HasPrefix(tag, tokenKey) && len(tag) > len(tokenKey),
+			strings.HasPrefix(tag, literalKey) && len(tag) > len(literalKey):
+		case strings.HasPrefix(tag, "$") && shortNameRe.MatchString(tag[1:]):
+			key = tokenKey + tag[1:]
+		case shortNameRe.MatchString(tag):
+			key = nodeKey + tag
+		default:
+			return nil, tagError(st, f.Name, tag)
+		}
+
+		fields[key] = append(fields[key], i)
+	}
+	return fields, nil
+}
+
+func fillStruct(n tree.NodeElement, sv reflect.Value) error {
+	fields, e := structFields(sv.Type())
+	if e != nil {
+		return e
+	}
+
+	for _, fi := range fields[textKey] {
+		e = storeText(n, text(n), sv.Field(fi))
+		if e != nil {
+			return e
+		}
+	}
+
+	for c := n.FirstChild(); c != nil; c = c.Next() {
+		var keys []string
+		if c.IsNode() {
+			keys = []string{nodeKey + c.TypeName()}
+		} else {
+			keys = []string{tokenKey + c.TypeName()}
+			if c.Token() != nil {
+				keys = append(keys, literalKey+c.Token().Text())
+			}
+		}
+
+		for _, key := range keys {
+			for _, fi := range fields[key] {
+				e = storeField(c, sv.Field(fi))
+				if e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func storeField(el tree.Element, fv reflect.Value) error {
+	if fv.Kind() != reflect.Slice || fv.Type().Implements(unmarshalerType) {
+		return storeElement(el, fv)
+	}
+
+	item := reflect.New(fv.Type().Elem()).Elem()
+	e := storeElement(el, item)
+	if e == nil {
+		fv.Set(reflect.Append(fv, item))
+	}
+	return e
+}
+
+func storeElement(el tree.Element, v reflect.Value) error {
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler).UnmarshalLLX(el)
+	}
+
+	switch v.Type() {
+	case tokenPtrType:
+		v.Set(reflect.ValueOf(el.Token()))
+		return nil
+	case elementType:
+		v.Set(reflect.ValueOf(el))
+		return nil
+	case nodeElementType:
+		n, valid := el.(tree.NodeElement)
+		if !valid || !el.IsNode() {
+			return typeError(el, v.Type())
+		}
+
+		v.Set(reflect.ValueOf(n))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		pv := reflect.New(v.Type().Elem())
+		e := storeElement(el, pv.Elem())
+		if e == nil {
+			v.Set(pv)
+		}
+		return e
+
+	case reflect.Struct:
+		n, valid := el.(tree.NodeElement)
+		if valid && el.IsNode() {
+			return fillStruct(n, v)
+		}
+		return typeError(el, v.Type())
+	}
+
+	return storeText(el, text(el), v)
+}
+
+func storeText(el tree.Element, s string, v reflect.Value) error {
+	var e error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		v.SetBool(true)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var x int64
+		x, e = strconv.ParseInt(s, 0, v.Type().Bits())
+		v.SetInt(x)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var x uint64
+		x, e = strconv.ParseUint(s, 0, v.Type().Bits())
+		v.SetUint(x)
+
+	case reflect.Float32, reflect.Float64:
+		var x float64
+		x, e = strconv.ParseFloat(s, v.Type().Bits())
+		v.SetFloat(x)
+
+	default:
+		return typeError(el, v.Type())
+	}
+
+	if e != nil {
+		return valueError(el, s, v.Type())
+	}
+	return nil
+}
+
+func text(el tree.Element) string {
+	if !el.IsNode() {
+		if el.Token() == nil {
+			return ""
+		}
+		return el.Token().Text()
+	}
+
+	var b strings.Builder
+	tree.Walk(el, tree.WalkLtr, func(s tree.WalkStat) tree.WalkerFlags {
+		if !s.Element.IsNode() && s.Element.Token() != nil {
+			b.Write(s.Element.Token().Content())
+		}
+		return 0
+	})
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/tree"
+)
+
+const testGrammar = "!aside $space; $space = /\\s+/; $name = /[a-z]+/; $num = /\\d+(?:\\.\\d+)?/; $op = /[-+]/; $punct = /[=;]/; " +
+	"g = {assign}; assign = $name, '=', expr, ';'; expr = ['-'], val, {$op, val}; val = $num | $name;"
+
+type sign bool
+
+func (s *sign) UnmarshalLLX(el tree.Element) error {
+	*s = el.Token().Text() == "-"
+	return nil
+}
+
+type val struct {
+	Num  *float64 `llx:"token:num"`
+	Name *string  `llx:"token:name"`
+}
+
+type expr struct {
+	Negative sign           `llx:"literal:-"`
+	Ops      []string       `llx:"token:op"`
+	Vals     []val          `llx:"node:val"`
+	Elements []tree.Element `llx:"node:val"`
+	Src      string         `llx:"text"`
+}
+
+type assign struct {
+	Name  *lexer.Token     `llx:"token:name"`
+	Value *expr            `llx:"node:expr"`
+	Node  tree.NodeElement `llx:"node:expr"`
+}
+
+type root struct {
+	Assigns []*assign `llx:"node:assign"`
+	Count   []bool    `llx:"node:assign"`
+	skipped []assign  `llx:"node:assign"`
+	Ignored string
+}
+
+func newParser(t *testing.T) *parser.Parser {
+	p, e := parser.FromString("", testGrammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	return p
+}
+
+func TestUnmarshal(t *testing.T) {
+	var r root
+	e := Unmarshal(newParser(t), "x = 1.5 + y; z = - 2;", &r)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if len(r.Assigns) != 2 || len(r.Count) != 2 || r.skipped != nil || r.Ignored != "" {
+		t.Fatalf("unexpected result: %v", r)
+	}
+
+	a := r.Assigns[0]
+	if a.Name.Text() != "x" || a.Node == nil || a.Node.TypeName() != "expr" {
+		t.Errorf("unexpected first assignment: %v", a)
+	}
+	if a.Value.Src != "1.5+y" || a.Value.Negative || strings.Join(a.Value.Ops, " ") != "+" ||
+		len(a.Value.Vals) != 2 || len(a.Value.Elements) != 2 {
+		t.Errorf("unexpected first expression: %v", a.Value)
+	} else if *a.Value.Vals[0].Num != 1.5 || a.Value.Vals[0].Name != nil || *a.Value.Vals[1].Name != "y" {
+		t.Errorf("unexpected first values: %v", a.Value.Vals)
+	}
+
+	a = r.Assigns[1]
+	if a.Name.Text() != "z" || a.Value.Src != "-2" || !a.Value.Negative || strings.Join(a.Value.Ops, " ") != "-" ||
+		len(a.Value.Vals) != 1 || *a.Value.Vals[0].Num != 2 {
+		t.Errorf("unexpected second assignment: %v", a.Value)
+	}
+}
+
+func TestShortTags(t *testing.T) {
+	type shortVal struct {
+		Num  *string `llx:"$num"`
+		Name *string `llx:"$name"`
+	}
+	type shortExpr struct {
+		Text string     `llx:"val"`
+		Ops  []string   `llx:"$op"`
+		Vals []shortVal `llx:"val"`
+	}
+	type shortAssign struct {
+		Name  *lexer.Token `llx:"$name"`
+		Value *shortExpr   `llx:"expr"`
+		Text  string       `llx:"node:expr"`
+	}
+	var r struct {
+		Assigns []*shortAssign `llx:"assign"`
+	}
+
+	e := Unmarshal(newParser(t), "x = 1 + y; z = - 2;", &r)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if len(r.Assigns) != 2 {
+		t.Fatalf("unexpected result: %v", r)
+	}
+
+	a := r.Assigns[0]
+	if a.Name.Text() != "x" || a.Text != "1+y" || strings.Join(a.Value.Ops, " ") != "+" || len(a.Value.Vals) != 2 {
+		t.Errorf("unexpected first assignment: %v", a)
+	} else if *a.Value.Vals[0].Num != "1" || a.Value.Vals[0].Name != nil || *a.Value.Vals[1].Name != "y" || a.Value.Text != "y" {
+		t.Errorf("unexpected first expression: %v", a.Value)
+	}
+
+	a = r.Assigns[1]
+	if a.Name.Text() != "z" || a.Text != "-2" || strings.Join(a.Value.Ops, " ") != "-" || len(a.Value.Vals) != 1 || *a.Value.Vals[0].Num != "2" {
+		t.Errorf("unexpected second assignment: %v", a)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	type badTag struct {
+		X string `llx:"nodes:val"`
+	}
+	type badShortTag struct {
+		X string `llx:"$"`
+	}
+	type badToken struct {
+		Assigns []struct {
+			Name int `llx:"token:name"`
+		} `llx:"node:assign"`
+	}
+	type badNode struct {
+		Assigns []struct {
+			Name struct{} `llx:"token:name"`
+		} `llx:"node:assign"`
+	}
+	type badKind struct {
+		Assigns []struct {
+			Expr map[string]string `llx:"node:expr"`
+		} `llx:"node:assign"`
+	}
+
+	samples := []struct {
+		v   any
+		err int
+	}{
+		{root{}, TypeError},
+		{(*root)(nil), TypeError},
+		{&badTag{}, TagError},
+		{&badShortTag{}, TagError},
+		{&badToken{}, ValueError},
+		{&badNode{}, TypeError},
+		{&badKind{}, TypeError},
+	}
+
+	p := newParser(t)
+	for i, s := range samples {
+		e := Unmarshal(p, "x = 1;", s.v)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != s.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.err, e)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	n, e := tree.FromSExpr(`(assign x (expr (val 42)))`)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var a struct {
+		Name  string `llx:"token:name"`
+		Value struct {
+			Val struct {
+				Num uint8 `llx:"token:name"`
+			} `llx:"node:val"`
+		} `llx:"node:expr"`
+	}
+	e = Decode(n, &a)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if a.Name != "x" || a.Value.Val.Num != 42 {
+		t.Errorf("unexpected result: %v", a)
+	}
+
+	var s string
+	e = Decode(n, &s)
+	if e != nil || s != "x42" {
+		t.Errorf("expecting %q, got %q (error: %v)", "x42", s, e)
+	}
+}
@@ -0,0 +1,49 @@
+package ast
+
+import (
+	"reflect"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/tree"
+)
+
+// Error codes used by ast package:
+const (
+	// tree element cannot be stored in given value
+	TypeError = llx.MappingErrors + iota
+	// malformed llx struct tag
+	TagError
+	// element text cannot be converted to number
+	ValueError
+)
+
+func elementName(el tree.Element) string {
+	if el == nil {
+		return "root node"
+	}
+	if el.IsNode() {
+		return "node " + el.TypeName()
+	}
+	return "token " + el.TypeName()
+}
+
+func elementError(el tree.Element, code int, msg string, params ...any) *llx.Error {
+	if el != nil {
+		if first := tree.FirstTokenElement(el); first != nil && first.Token() != nil {
+			return llx.FormatErrorPos(first.Token(), code, msg, params...)
+		}
+	}
+	return llx.FormatError(code, msg, params...)
+}
+
+func typeError(el tree.Element, typ reflect.Type) *llx.Error {
+	return elementError(el, TypeError, "cannot store %s in %s", elementName(el), typ)
+}
+
+func tagError(st reflect.Type, field, tag string) *llx.Error {
+	return llx.FormatError(TagError, "malformed tag %q for field %s.%s", tag, st, field)
+}
+
+func valueError(el tree.Element, text string, typ reflect.Type) *llx.Error {
+	return elementError(el, ValueError, "cannot convert %q (%s) to %s", text, elementName(el), typ)
+}
@@ -2,10 +2,21 @@
 llxgen is a console utility translating grammar description to Go or JSON file.
 Usage is
 
-	llxgen ([-j] | [-p <name>] [-v <name>]) [-o <name>] <file>
+	llxgen ([-j] | [-p <name>] [-v <name>] [-e <format>] [-t]) [-O] [-o <name>] <file>
 
 -j flag instructs llxgen to output JSON file instead of Go source;
 
+-e <format> instructs llxgen to output grammar data file in given format ("bin" or "json", see grammar.Marshal)
+placed next to Go file and having the same name with .bin or .json suffix, and Go source embedding the data file
+and loading grammar at initialization, which is much faster to compile for large grammars;
+
+-t flag instructs llxgen to output additional Go file placed next to Go file and having the same name with _hooks suffix,
+the file contains constants for token type, node, action, and label names, <Name>Visitor interface having a method per node
+(e.g. OnExpr for "expr" node), <Name>BaseVisitor type implementing default behavior for all nodes,
+and New<Name>Hooks function returning parser.Hooks that call visitor methods, where <Name> is capitalized variable name;
+
+-O flag instructs llxgen to optimize grammar tables (see grammar.Optimize) and print table sizes before and after optimization;
+
 -o <name> defines output file name, default is the name of input file with .go or .json suffix;
 
 -p <name> defines Go package name, default is directory name of input file;
@@ -18,25 +29,31 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"go/format"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/ava12/llx/grammar"
 	"github.com/ava12/llx/langdef"
 )
 
 var (
-	generateJson                                  bool
-	inFileName, outFileName, packageName, varName string
+	generateJson, generateHooks, optimize                      bool
+	inFileName, outFileName, packageName, varName, embedFormat string
 )
 
+var embedFormats = map[string]grammar.Format{
+	"bin":  grammar.BinaryFormat,
+	"json": grammar.JSONFormat,
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(flag.CommandLine.Output(), "Usage is  llxgen ([-j] | [-p <name>] [-v <name>]) [-o <name>] <file>")
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage is  llxgen ([-j] | [-p <name>] [-v <name>] [-e <format>] [-t]) [-O] [-o <name>] <file>")
 		flag.PrintDefaults()
 		fmt.Fprintln(flag.CommandLine.Output(), "  <file>")
 		fmt.Fprintln(flag.CommandLine.Output(), "\tgrammar definition file name")
@@ -46,9 +63,13 @@ func main() {
 	flag.StringVar(&outFileName, "o", "", "output file name, default is the name of input file with .go or .json suffix")
 	flag.StringVar(&packageName, "p", "", "Go package name, default is dir name of output file")
 	flag.StringVar(&varName, "v", "", "Go variable name, default is the root node name")
+	flag.StringVar(&embedFormat, "e", "", "embedded grammar data format (bin or json), default is no embedding")
+	flag.BoolVar(&generateHooks, "t", false, "output additional Go file with typed hook interface")
+	flag.BoolVar(&optimize, "O", false, "optimize grammar tables and print their sizes")
 	flag.Parse()
 	inFileName = flag.Arg(0)
-	if inFileName == "" {
+	_, validFormat := embedFormats[embedFormat]
+	if inFileName == "" || embedFormat != "" && (generateJson || !validFormat) || generateJson && generateHooks {
 		flag.Usage()
 		os.Exit(2)
 	}
@@ -68,14 +89,39 @@ func main() {
 	if e == nil {
 		gr, e = langdef.ParseBytes(inFileName, src)
 	}
-	var content []byte
+	if e == nil && optimize {
+		var stats grammar.OptimizeStats
+		gr, stats = grammar.Optimize(gr)
+		fmt.Printf("states: %d -> %d, multi-rules: %d -> %d, rules: %d -> %d\n",
+			stats.Before.States, stats.After.States, stats.Before.MultiRules, stats.After.MultiRules,
+			stats.Before.Rules, stats.After.Rules)
+	}
+	var content, data, hooks []byte
+	var dataFileName, hooksFileName string
 	if e == nil {
-		if generateJson {
+		switch {
+		case generateJson:
 			content, e = makeJson(gr)
-		} else {
+		case embedFormat != "":
+			dataFileName = outFileName[:len(outFileName)-len(filepath.Ext(outFileName))] + "." + embedFormat
+			data, e = grammar.Marshal(gr, embedFormats[embedFormat])
+			if e == nil {
+				content, e = makeEmbed(gr, filepath.Base(dataFileName))
+			}
+		default:
 			content, e = makeGo(gr)
 		}
 	}
+	if e == nil && generateHooks {
+		hooksFileName = outFileName[:len(outFileName)-len(filepath.Ext(outFileName))] + "_hooks.go"
+		hooks, e = makeHooks(gr)
+	}
+	if e == nil && data != nil {
+		e = os.WriteFile(dataFileName, data, 0o666)
+	}
+	if e == nil && hooks != nil {
+		e = os.WriteFile(hooksFileName, hooks, 0o666)
+	}
 	if e == nil {
 		e = os.WriteFile(outFileName, content, 0o666)
 	}
@@ -87,14 +133,14 @@ func main() {
 }
 
 func makeJson(gr *grammar.Grammar) ([]byte, error) {
-	return json.MarshalIndent(gr, "", "  ")
+	return grammar.CanonicalJSON(gr)
 }
 
-func makeGo(gr *grammar.Grammar) ([]byte, error) {
+func checkGoNames(gr *grammar.Grammar) error {
 	if packageName == "" {
 		dir, e := filepath.Abs(outFileName)
 		if e != nil {
-			return nil, e
+			return e
 		}
 
 		dir, _ = filepath.Split(dir)
@@ -106,10 +152,43 @@ func makeGo(gr *grammar.Grammar) ([]byte, error) {
 
 	re := regexp.MustCompile("^[A-Za-z_][A-Za-z_0-9]*$")
 	if !re.MatchString(packageName) {
-		return nil, fmt.Errorf("invalid package name: %s", packageName)
+		return fmt.Errorf("invalid package name: %s", packageName)
 	}
 	if !re.MatchString(varName) {
-		return nil, fmt.Errorf("invalid variable name: %s", packageName)
+		return fmt.Errorf("invalid variable name: %s", varName)
+	}
+
+	return nil
+}
+
+func makeEmbed(gr *grammar.Grammar, dataFileName string) ([]byte, error) {
+	e := checkGoNames(gr)
+	if e != nil {
+		return nil, e
+	}
+
+	dataVarName := varName + "Data"
+	return []byte("// Code generated with llxgen.\n\n" +
+		"package " + packageName + "\n\n" +
+		"import (\n" +
+		"\t_ \"embed\"\n\n" +
+		"\t\"github.com/ava12/llx/grammar\"\n" +
+		")\n\n" +
+		"//go:embed " + dataFileName + "\n" +
+		"var " + dataVarName + " []byte\n\n" +
+		"var " + varName + " = func() *grammar.Grammar {\n" +
+		"\tg, e := grammar.Unmarshal(" + dataVarName + ")\n" +
+		"\tif e != nil {\n" +
+		"\t\tpanic(e)\n" +
+		"\t}\n" +
+		"\treturn g\n" +
+		"}()\n"), nil
+}
+
+func makeGo(gr *grammar.Grammar) ([]byte, error) {
+	e := checkGoNames(gr)
+	if e != nil {
+		return nil, e
 	}
 
 	var buffer bytes.Buffer
@@ -121,19 +200,35 @@ func makeGo(gr *grammar.Grammar) ([]byte, error) {
 
 	buffer.WriteString("\tTokens: []grammar.Token{\n")
 	for _, t := range gr.Tokens {
-		buffer.WriteString(fmt.Sprintf("\t\t{Name: %q, Re: %q, Group: %d, Flags: %d},\n", t.Name, t.Re, t.Group, t.Flags))
+		decoder := ""
+		if t.Decoder != "" {
+			decoder = fmt.Sprintf(", Decoder: %q", t.Decoder)
+		}
+		buffer.WriteString(fmt.Sprintf("\t\t{Name: %q, Re: %q, Group: %d, Flags: %d%s},\n", t.Name, t.Re, t.Group, t.Flags, decoder))
 	}
 	buffer.WriteString("\t},\n")
 
 	buffer.WriteString("\tNodes: []grammar.Node{\n")
 	for _, nt := range gr.Nodes {
-		buffer.WriteString(fmt.Sprintf("\t\t{Name: %q, FirstState: %d},\n", nt.Name, nt.FirstState))
+		flags := ""
+		if nt.Flags != 0 {
+			flags = fmt.Sprintf(", Flags: %d", nt.Flags)
+		}
+		buffer.WriteString(fmt.Sprintf("\t\t{Name: %q, FirstState: %d, FirstTokens: %s%s},\n", nt.Name, nt.FirstState, bitSetLiteral(nt.FirstTokens), flags))
 	}
 	buffer.WriteString("\t},\n")
 
 	buffer.WriteString("\tStates: []grammar.State{\n")
 	for _, st := range gr.States {
-		buffer.WriteString(fmt.Sprintf("\t\t{%d, %d, %d, %d, %d},\n", st.TokenTypes, st.LowMultiRule, st.HighMultiRule, st.LowRule, st.HighRule))
+		multiRules := ""
+		if st.HighMultiRule != 0 {
+			multiRules = fmt.Sprintf(", LowMultiRule: %d, HighMultiRule: %d", st.LowMultiRule, st.HighMultiRule)
+		}
+		caseless := ""
+		if st.Caseless {
+			caseless = ", Caseless: true"
+		}
+		buffer.WriteString(fmt.Sprintf("\t\t{TokenTypes: %s%s, LowRule: %d, HighRule: %d%s},\n", bitSetLiteral(st.TokenTypes), multiRules, st.LowRule, st.HighRule, caseless))
 	}
 	buffer.WriteString("\t},\n")
 
@@ -157,6 +252,174 @@ func makeGo(gr *grammar.Grammar) ([]byte, error) {
 		buffer.WriteString("\n\t},\n")
 	}
 
+	if len(gr.Actions) > 0 {
+		buffer.WriteString("\tActions: []grammar.Action{\n")
+		for _, a := range gr.Actions {
+			buffer.WriteString(fmt.Sprintf("\t\t{%q, %d},\n", a.Name, a.State))
+		}
+		buffer.WriteString("\t},\n")
+	}
+
+	if len(gr.Labels) > 0 {
+		buffer.WriteString("\tLabels: []grammar.Label{\n")
+		for _, l := range gr.Labels {
+			buffer.WriteString(fmt.Sprintf("\t\t{%q, %d},\n", l.Name, l.Rule))
+		}
+		buffer.WriteString("\t},\n")
+	}
+
+	if len(gr.Adjacent) > 0 {
+		states := make([]string, len(gr.Adjacent))
+		for i, si := range gr.Adjacent {
+			states[i] = fmt.Sprint(si)
+		}
+		buffer.WriteString("\tAdjacent: []int{" + strings.Join(states, ", ") + "},\n")
+	}
+
+	if len(gr.ErrorProductions) > 0 {
+		buffer.WriteString("\tErrorProductions: []grammar.ErrorProduction{\n")
+		for _, ep := range gr.ErrorProductions {
+			buffer.WriteString(fmt.Sprintf("\t\t{%q, %d},\n", ep.Message, ep.State))
+		}
+		buffer.WriteString("\t},\n")
+	}
+
+	if len(gr.NestedTokens) > 0 {
+		buffer.WriteString("\tNestedTokens: []grammar.NestedToken{\n")
+		for _, nt := range gr.NestedTokens {
+			buffer.WriteString(fmt.Sprintf("\t\t{%d, %q, %q},\n", nt.Token, nt.Open, nt.Close))
+		}
+		buffer.WriteString("\t},\n")
+	}
+
+	if len(gr.NodeGroups) > 0 {
+		buffer.WriteString("\tNodeGroups: []grammar.NodeGroup{\n")
+		for _, ng := range gr.NodeGroups {
+			buffer.WriteString(fmt.Sprintf("\t\t{%d, %d},\n", ng.Node, ng.Group))
+		}
+		buffer.WriteString("\t},\n")
+	}
+
 	buffer.WriteString("}\n")
 	return buffer.Bytes(), nil
 }
+
+// goName converts grammar name to exported Go identifier, e.g. "if-stmt" becomes "IfStmt".
+func goName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func makeHooks(gr *grammar.Grammar) ([]byte, error) {
+	e := checkGoNames(gr)
+	if e != nil {
+		return nil, e
+	}
+
+	prefix := goName(varName)
+	used := make(map[string]string)
+	useName := func(goName, name string) error {
+		if used[goName] != "" {
+			return fmt.Errorf("both %q and %q are converted to Go name %s", used[goName], name, goName)
+		}
+
+		used[goName] = name
+		return nil
+	}
+
+	var tokens, nodes, actions, labels, methods, base, hooks bytes.Buffer
+	for _, t := range gr.Tokens {
+		if t.Flags&grammar.LiteralToken == 0 {
+			constName := "Token" + goName(t.Name)
+			e = useName(constName, "$"+t.Name)
+			if e != nil {
+				return nil, e
+			}
+
+			tokens.WriteString(fmt.Sprintf("\t%s = %q\n", constName, t.Name))
+		}
+	}
+
+	for _, a := range gr.Actions {
+		constName := "Action" + goName(a.Name)
+		if used[constName] == "@"+a.Name {
+			continue
+		}
+
+		e = useName(constName, "@"+a.Name)
+		if e != nil {
+			return nil, e
+		}
+
+		actions.WriteString(fmt.Sprintf("\t%s = %q\n", constName, a.Name))
+	}
+	actionConsts := ""
+	if actions.Len() > 0 {
+		actionConsts = "// Action names.\nconst (\n" + actions.String() + ")\n\n"
+	}
+
+	for _, l := range gr.Labels {
+		constName := "Label" + goName(l.Name)
+		if used[constName] == l.Name+":" {
+			continue
+		}
+
+		e = useName(constName, l.Name+":")
+		if e != nil {
+			return nil, e
+		}
+
+		labels.WriteString(fmt.Sprintf("\t%s = %q\n", constName, l.Name))
+	}
+	labelConsts := ""
+	if labels.Len() > 0 {
+		labelConsts = "// Label names.\nconst (\n" + labels.String() + ")\n\n"
+	}
+
+	signature := "(tok *parser.Token, pc *parser.ParseContext) (parser.NodeHookInstance, error)"
+	for _, nt := range gr.Nodes {
+		constName := "Node" + goName(nt.Name)
+		method := "On" + goName(nt.Name)
+		e = useName(constName, nt.Name)
+		if e != nil {
+			return nil, e
+		}
+
+		nodes.WriteString(fmt.Sprintf("\t%s = %q\n", constName, nt.Name))
+		methods.WriteString(fmt.Sprintf("\t%s%s\n", method, signature))
+		base.WriteString(fmt.Sprintf("func (%sBaseVisitor) %s%s {\n\treturn nil, nil\n}\n\n", prefix, method, signature))
+		hooks.WriteString(fmt.Sprintf("\t\t%s: func(node string, tok *parser.Token, pc *parser.ParseContext) (parser.NodeHookInstance, error) {\n"+
+			"\t\t\treturn v.%s(tok, pc)\n\t\t},\n", constName, method))
+	}
+
+	return format.Source([]byte("// Code generated with llxgen.\n\n" +
+		"package " + packageName + "\n\n" +
+		"import \"github.com/ava12/llx/parser\"\n\n" +
+		"// Token type names.\nconst (\n" + tokens.String() + ")\n\n" +
+		"// Node names.\nconst (\n" + nodes.String() + ")\n\n" + actionConsts + labelConsts +
+		"// " + prefix + "Visitor has a method for each node, the method is called when node is pushed on stack.\n" +
+		"// Returned nil hook instance means default node processing.\n" +
+		"type " + prefix + "Visitor interface {\n" + methods.String() + "}\n\n" +
+		"// " + prefix + "BaseVisitor implements default processing for all nodes, intended to be embedded in visitors.\n" +
+		"type " + prefix + "BaseVisitor struct{}\n\n" + base.String() +
+		"// New" + prefix + "Hooks returns parser hooks calling visitor methods for corresponding nodes.\n" +
+		"func New" + prefix + "Hooks(v " + prefix + "Visitor) *parser.Hooks {\n" +
+		"\treturn &parser.Hooks{Nodes: parser.NodeHooks{\n" + hooks.String() + "\t}}\n}\n"))
+}
+
+func bitSetLiteral(bs grammar.BitSet) string {
+	if bs == nil {
+		return "nil"
+	}
+
+	words := make([]string, len(bs))
+	for i, w := range bs {
+		words[i] = fmt.Sprintf("%#x", w)
+	}
+	return "grammar.BitSet{" + strings.Join(words, ", ") + "}"
+}
@@ -0,0 +1,334 @@
+package llx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+// Diagnostic severities, zero value means error:
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+	SeverityHint
+)
+
+var severityNames = []string{"error", "warning", "info", "hint"}
+
+// String returns lowercase severity name, e.g. "error".
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// MarshalText returns severity name, so JSON representation of a severity is a string.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses severity name.
+func (s *Severity) UnmarshalText(text []byte) error {
+	for i, n := range severityNames {
+		if n == string(text) {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", text)
+}
+
+// Span is a source location. Zero line means that position is not known,
+// zero EndLine means that only the start position is known. End position is exclusive.
+type Span struct {
+	SourceName string `json:"source,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Col        int    `json:"col,omitempty"`
+	EndLine    int    `json:"endLine,omitempty"`
+	EndCol     int    `json:"endCol,omitempty"`
+}
+
+// String returns "name:line:col" location, parts that are not known are omitted.
+func (s Span) String() string {
+	var parts []string
+	if s.SourceName != "" {
+		parts = append(parts, s.SourceName)
+	}
+	if s.Line > 0 {
+		parts = append(parts, strconv.Itoa(s.Line))
+		if s.Col > 0 {
+			parts = append(parts, strconv.Itoa(s.Col))
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// RelatedInfo is a source location related to a diagnostic, with a message describing the relation.
+type RelatedInfo struct {
+	Span    Span   `json:"span"`
+	Message string `json:"message"`
+}
+
+// Diagnostic is a structured message about a source: an error, a warning, etc.
+type Diagnostic struct {
+	// Severity is the diagnostic severity.
+	Severity Severity `json:"severity"`
+
+	// Code contains error code (see Error.Code) or 0.
+	Code int `json:"code,omitempty"`
+
+	// Message contains message text without source name and position information.
+	Message string `json:"message"`
+
+	// Span is the primary location of diagnostic.
+	Span Span `json:"span"`
+
+	// Related lists secondary locations or nil.
+	Related []RelatedInfo `json:"related,omitempty"`
+
+	// Fix contains suggested replacement for the text at primary location or empty string.
+	Fix string `json:"fix,omitempty"`
+}
+
+// String returns single-line representation of diagnostic, e.g. "input:1:5: error[201]: unexpected ...".
+func (d Diagnostic) String() string {
+	res := d.Severity.String()
+	if d.Code != 0 {
+		res += "[" + strconv.Itoa(d.Code) + "]"
+	}
+	res += ": " + d.Message
+	if loc := d.Span.String(); loc != "" {
+		res = loc + ": " + res
+	}
+	if d.Fix != "" {
+		res += " (fix: " + strconv.Quote(d.Fix) + ")"
+	}
+	return res
+}
+
+// Diagnostic converts error to diagnostic with error severity. Include chain is converted to related locations.
+func (e *Error) Diagnostic() Diagnostic {
+	msg := e.Text
+	if msg == "" {
+		msg = e.Message
+	}
+	res := Diagnostic{
+		Code:    e.Code,
+		Message: msg,
+		Span:    Span{e.SourceName, e.Line, e.Col, e.EndLine, e.EndCol},
+		Fix:     e.Fix,
+	}
+	res.Related = append(res.Related, e.Related...)
+	for _, inc := range e.Includes {
+		res.Related = append(res.Related, RelatedInfo{Span{inc.SourceName, inc.Line, inc.Col, 0, 0}, "included from here"})
+	}
+	return res
+}
+
+// Diagnostics returns a single diagnostic converted from e.
+func (e *Error) Diagnostics() []Diagnostic {
+	return []Diagnostic{e.Diagnostic()}
+}
+
+// DiagnosticSource may be implemented by errors containing one or more diagnostics,
+// e.g. Error and parser.ErrorList.
+type DiagnosticSource interface {
+	Diagnostics() []Diagnostic
+}
+
+// Collector accumulates diagnostics.
+type Collector interface {
+	Collect(d Diagnostic)
+}
+
+// DiagnosticList is a Collector storing diagnostics in order of arrival.
+type DiagnosticList []Diagnostic
+
+// Collect appends d to the list.
+func (dl *DiagnosticList) Collect(d Diagnostic) {
+	*dl = append(*dl, d)
+}
+
+// Report passes diagnostics contained in err to collector. Errors not implementing DiagnosticSource
+// (directly or via wrapped errors) are reported as a single diagnostic with error severity, zero code,
+// and no position information. Does nothing if err is nil.
+func Report(c Collector, err error) {
+	if err == nil {
+		return
+	}
+
+	var ds DiagnosticSource
+	if errors.As(err, &ds) {
+		for _, d := range ds.Diagnostics() {
+			c.Collect(d)
+		}
+	} else {
+		c.Collect(Diagnostic{Message: err.Error()})
+	}
+}
+
+// Diagnostics returns diagnostics contained in err (see Report) or nil if err is nil.
+func Diagnostics(err error) []Diagnostic {
+	var res DiagnosticList
+	Report(&res, err)
+	return res
+}
+
+// FormatPlain returns diagnostics in single-line form (see Diagnostic.String), one per line.
+func FormatPlain(ds []Diagnostic) string {
+	var b strings.Builder
+	for _, d := range ds {
+		b.WriteString(d.String() + "\n")
+	}
+	return b.String()
+}
+
+// FormatJSON returns JSON array of diagnostics.
+func FormatJSON(ds []Diagnostic) ([]byte, error) {
+	if ds == nil {
+		ds = []Diagnostic{}
+	}
+	return json.Marshal(ds)
+}
+
+// SourceLines provides source text for FormatPretty; source.Set implements this interface.
+type SourceLines interface {
+	// SourceLine returns given 1-based line of named source without line terminator,
+	// returns false if the source or the line is not known.
+	SourceLine(name string, line int) (string, bool)
+}
+
+// FormatPretty returns multi-line representation of diagnostics similar to one used by modern compilers:
+// a header with severity, code, and message, followed by location and source excerpt with the primary span
+// underlined, related locations, and suggested fix. lines may be nil, excerpts are omitted in this case.
+// Columns are assumed to count runes, tabs in excerpts are preserved to keep underlines aligned.
+func FormatPretty(ds []Diagnostic, lines SourceLines) string {
+	return formatPretty(ds, lines, 0)
+}
+
+// verboseContextLines is the number of source lines shown before and after error line by FormatErrorVerbose.
+const verboseContextLines = 2
+
+// FormatErrorVerbose returns pretty representation of diagnostics contained in err (see Report and FormatPretty),
+// each source excerpt includes a few lines before and after the error line. lines may be nil.
+// Parsing discards processed sources from the queue, so pass a source.Set of parsed sources.
+// Returns empty string if err is nil.
+func FormatErrorVerbose(err error, lines SourceLines) string {
+	return formatPretty(Diagnostics(err), lines, verboseContextLines)
+}
+
+func formatPretty(ds []Diagnostic, lines SourceLines, context int) string {
+	var b strings.Builder
+	for i, d := range ds {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(d.Severity.String())
+		if d.Code != 0 {
+			b.WriteString("[" + strconv.Itoa(d.Code) + "]")
+		}
+		b.WriteString(": " + d.Message + "\n")
+		writeExcerpt(&b, d.Span, "", lines, context)
+		for _, r := range d.Related {
+			writeExcerpt(&b, r.Span, r.Message, lines, context)
+		}
+		if d.Fix != "" {
+			b.WriteString("  = help: replace with " + strconv.Quote(d.Fix) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// writeExcerpt writes location and underlined source line surrounded by up to context lines,
+// or just note if location is not known.
+func writeExcerpt(b *strings.Builder, s Span, note string, lines SourceLines, context int) {
+	loc := s.String()
+	if loc == "" {
+		if note != "" {
+			b.WriteString("  = note: " + note + "\n")
+		}
+		return
+	}
+
+	b.WriteString("  --> " + loc + "\n")
+	var text string
+	found := false
+	if lines != nil && s.Line > 0 {
+		text, found = lines.SourceLine(s.SourceName, s.Line)
+	}
+	if !found {
+		if note != "" {
+			b.WriteString("  = note: " + note + "\n")
+		}
+		return
+	}
+
+	var before, after []string
+	for l := s.Line - context; l < s.Line; l++ {
+		if t, f := lines.SourceLine(s.SourceName, l); f {
+			before = append(before, t)
+		}
+	}
+	for l := s.Line + 1; l <= s.Line+context; l++ {
+		t, f := lines.SourceLine(s.SourceName, l)
+		if !f {
+			break
+		}
+		after = append(after, t)
+	}
+
+	width := len(strconv.Itoa(s.Line + len(after)))
+	pad := strings.Repeat(" ", width)
+	writeLine := func(l int, t string) {
+		num := strconv.Itoa(l)
+		b.WriteString(strings.Repeat(" ", width-len(num)) + num + " | " + t + "\n")
+	}
+
+	b.WriteString(pad + " |\n")
+	for i, t := range before {
+		writeLine(s.Line-len(before)+i, t)
+	}
+	writeLine(s.Line, text)
+
+	runes := []rune(text)
+	start := s.Col - 1
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := start + 1
+	if s.EndLine == s.Line && s.EndCol-1 > start {
+		end = s.EndCol - 1
+	} else if s.EndLine > s.Line && len(runes) > start {
+		end = len(runes)
+	}
+
+	var u strings.Builder
+	for i := 0; i < end; i++ {
+		c := ' '
+		switch {
+		case i >= start:
+			c = '^'
+		case runes[i] == '\t':
+			c = '\t'
+		}
+		u.WriteRune(c)
+	}
+	if note != "" {
+		u.WriteString(" " + note)
+	}
+	b.WriteString(pad + " | " + u.String() + "\n")
+	for i, t := range after {
+		writeLine(s.Line+i+1, t)
+	}
+}
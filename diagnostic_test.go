@@ -0,0 +1,134 @@
+package llx_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/source"
+)
+
+func TestErrorDiagnostic(t *testing.T) {
+	p, e := parser.FromString("", "$space = /\\s+/; $name = /\\w+/; $op = /[()]/; !aside $space; g = $name, '(', $name, ')';")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := source.New("input", []byte("foo\n\tbar bar)\n"))
+	_, e = p.Parse(source.NewQueue().Append(src), nil)
+	ds := llx.Diagnostics(e)
+	if len(ds) != 1 {
+		t.Fatalf("expecting 1 diagnostic, got %v", ds)
+	}
+
+	d := ds[0]
+	expectedSpan := llx.Span{SourceName: "input", Line: 2, Col: 2, EndLine: 2, EndCol: 5}
+	if d.Severity != llx.SeverityError || d.Code != parser.UnexpectedTokenError || d.Span != expectedSpan {
+		t.Errorf("unexpected diagnostic: %#v", d)
+	}
+
+	plain := llx.FormatPlain(ds)
+	expected := "input:2:2: error[202]: unexpected \"name\" token (\"bar\"), expecting (\n"
+	if plain != expected {
+		t.Errorf("expecting plain output:\n%s\ngot:\n%s", expected, plain)
+	}
+
+	pretty := llx.FormatPretty(ds, source.Set{src})
+	expected = "error[202]: unexpected \"name\" token (\"bar\"), expecting (\n" +
+		"  --> input:2:2\n" +
+		"  |\n" +
+		"2 | \tbar bar)\n" +
+		"  | \t^^^\n"
+	if pretty != expected {
+		t.Errorf("expecting pretty output:\n%s\ngot:\n%s", expected, pretty)
+	}
+}
+
+func TestDiagnosticFormats(t *testing.T) {
+	d := llx.Diagnostic{
+		Severity: llx.SeverityWarning,
+		Code:     7,
+		Message:  "unclosed \"(\"",
+		Span:     llx.Span{SourceName: "a", Line: 1, Col: 3},
+		Related:  []llx.RelatedInfo{{Span: llx.Span{SourceName: "a", Line: 1, Col: 6}, Message: "expecting \")\" here"}},
+		Fix:      ")",
+	}
+
+	plain := llx.FormatPlain([]llx.Diagnostic{d})
+	expected := "a:1:3: warning[7]: unclosed \"(\" (fix: \")\")\n"
+	if plain != expected {
+		t.Errorf("expecting plain output:\n%s\ngot:\n%s", expected, plain)
+	}
+
+	pretty := llx.FormatPretty([]llx.Diagnostic{d}, source.Set{source.New("a", []byte("f (x ]"))})
+	expected = "warning[7]: unclosed \"(\"\n" +
+		"  --> a:1:3\n" +
+		"  |\n" +
+		"1 | f (x ]\n" +
+		"  |   ^\n" +
+		"  --> a:1:6\n" +
+		"  |\n" +
+		"1 | f (x ]\n" +
+		"  |      ^ expecting \")\" here\n" +
+		"  = help: replace with \")\"\n"
+	if pretty != expected {
+		t.Errorf("expecting pretty output:\n%s\ngot:\n%s", expected, pretty)
+	}
+
+	js, e := llx.FormatJSON([]llx.Diagnostic{d})
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []llx.Diagnostic
+	e = json.Unmarshal(js, &got)
+	if e != nil || len(got) != 1 || !reflect.DeepEqual(got[0], d) {
+		t.Errorf("JSON round trip failed: %s (error: %v)", js, e)
+	}
+}
+
+func TestReport(t *testing.T) {
+	var dl llx.DiagnosticList
+	llx.Report(&dl, nil)
+	llx.Report(&dl, errors.New("foo"))
+	llx.Report(&dl, parser.ErrorList{llx.FormatError(1, "bar"), llx.NewError(2, "baz", "src", 3, 4)})
+
+	expected := llx.DiagnosticList{
+		{Message: "foo"},
+		{Code: 1, Message: "bar"},
+		{Code: 2, Message: "baz", Span: llx.Span{SourceName: "src", Line: 3, Col: 4}},
+	}
+	if !reflect.DeepEqual(dl, expected) {
+		t.Errorf("expecting %v, got %v", expected, dl)
+	}
+}
+
+func TestFormatErrorVerbose(t *testing.T) {
+	p, e := parser.FromString("", "$space = /\\s+/; $name = /\\w+/; $op = /[()]/; !aside $space; g = {$name, '(', $name, ')'};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := source.New("input", []byte("a(b)\nc(d)\ne f)\ng(h)\ni(j)\nk(l)\n"))
+	_, e = p.Parse(source.NewQueue().Append(src), nil)
+	got := llx.FormatErrorVerbose(e, source.Set{src})
+	expected := "error[202]: unexpected \"name\" token (\"f\"), expecting (\n" +
+		"  --> input:3:3\n" +
+		"  |\n" +
+		"1 | a(b)\n" +
+		"2 | c(d)\n" +
+		"3 | e f)\n" +
+		"  |   ^\n" +
+		"4 | g(h)\n" +
+		"5 | i(j)\n"
+	if got != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if llx.FormatErrorVerbose(nil, source.Set{src}) != "" {
+		t.Errorf("expecting empty string for nil error")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/ava12/llx"
 	"github.com/ava12/llx/examples/calc/internal"
 	"github.com/ava12/llx/parser"
 )
@@ -84,8 +83,7 @@ func main() {
 		if e == nil {
 			writeNumber(res)
 		} else {
-			ee, f := e.(*llx.Error)
-			if f && ee.Code == parser.UnexpectedEoiError {
+			if parser.IsIncomplete(e) {
 				appendInput = true
 				prevInput = input
 			} else {
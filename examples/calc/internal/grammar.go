@@ -7,7 +7,7 @@ import "github.com/ava12/llx/grammar"
 var calcGrammar = &grammar.Grammar{
 	Tokens: []grammar.Token{
 		{Name: "space", Re: "\\s+", Group: 0, Flags: 4},
-		{Name: "number", Re: "-?\\d+(?:\\.\\d+)?(?:[Ee]-?\\d+)?", Group: 0, Flags: 0},
+		{Name: "number", Re: "-?\\d+(?:\\.\\d+)?(?:[Ee]-?\\d+)?", Group: 0, Flags: 0, Decoder: "float"},
 		{Name: "name", Re: "[A-Za-z][A-Za-z0-9_]*", Group: 0, Flags: 0},
 		{Name: "op", Re: "[(),=*\\/^+-]", Group: 0, Flags: 0},
 		{Name: "minus", Re: "-", Group: 1, Flags: 0},
@@ -22,47 +22,47 @@ var calcGrammar = &grammar.Grammar{
 		{Name: "^", Re: "", Group: 0, Flags: 1},
 	},
 	Nodes: []grammar.Node{
-		{Name: "calcGrammar", FirstState: 0},
-		{Name: "expr", FirstState: 1},
-		{Name: "assign", FirstState: 5},
-		{Name: "func", FirstState: 8},
-		{Name: "pro", FirstState: 16},
-		{Name: "pow", FirstState: 19},
-		{Name: "value", FirstState: 22},
-		{Name: "call", FirstState: 25},
+		{Name: "calcGrammar", FirstState: 0, FirstTokens: grammar.BitSet{0x226}},
+		{Name: "expr", FirstState: 1, FirstTokens: grammar.BitSet{0x226}},
+		{Name: "assign", FirstState: 5, FirstTokens: grammar.BitSet{0x4}},
+		{Name: "func", FirstState: 8, FirstTokens: grammar.BitSet{0x4}},
+		{Name: "pro", FirstState: 16, FirstTokens: grammar.BitSet{0x26}},
+		{Name: "pow", FirstState: 19, FirstTokens: grammar.BitSet{0x26}},
+		{Name: "value", FirstState: 22, FirstTokens: grammar.BitSet{0x26}},
+		{Name: "call", FirstState: 25, FirstTokens: grammar.BitSet{0x4}},
 	},
 	States: []grammar.State{
-		{31, 0, 1, 0, 3},
-		{31, 0, 0, 6, 10},
-		{15, 0, 0, 10, 13},
-		{25, 0, 0, 13, 16},
-		{15, 0, 0, 16, 19},
-		{5, 0, 0, 19, 20},
-		{9, 0, 0, 20, 21},
-		{31, 0, 0, 21, 25},
-		{5, 0, 0, 25, 26},
-		{9, 0, 0, 26, 27},
-		{13, 0, 0, 27, 29},
-		{9, 0, 0, 29, 30},
-		{9, 0, 0, 30, 31},
-		{31, 0, 0, 31, 35},
-		{9, 0, 0, 35, 37},
-		{5, 0, 0, 37, 38},
-		{15, 0, 0, 38, 41},
-		{9, 0, 0, 41, 44},
-		{15, 0, 0, 44, 47},
-		{15, 0, 0, 47, 50},
-		{9, 0, 0, 50, 52},
-		{15, 0, 0, 52, 55},
-		{15, 1, 2, 55, 57},
-		{31, 0, 0, 59, 63},
-		{9, 0, 0, 63, 64},
-		{5, 0, 0, 64, 65},
-		{9, 0, 0, 65, 66},
-		{31, 0, 0, 66, 71},
-		{9, 0, 0, 71, 72},
-		{9, 0, 0, 72, 74},
-		{31, 0, 0, 74, 78},
+		{TokenTypes: grammar.BitSet{0x1f}, LowMultiRule: 0, HighMultiRule: 1, LowRule: 0, HighRule: 3},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 6, HighRule: 10},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 10, HighRule: 13},
+		{TokenTypes: grammar.BitSet{0x19}, LowRule: 13, HighRule: 16},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 16, HighRule: 19},
+		{TokenTypes: grammar.BitSet{0x5}, LowRule: 19, HighRule: 20},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 20, HighRule: 21},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 21, HighRule: 25},
+		{TokenTypes: grammar.BitSet{0x5}, LowRule: 25, HighRule: 26},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 26, HighRule: 27},
+		{TokenTypes: grammar.BitSet{0xd}, LowRule: 27, HighRule: 29},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 29, HighRule: 30},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 30, HighRule: 31},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 31, HighRule: 35},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 35, HighRule: 37},
+		{TokenTypes: grammar.BitSet{0x5}, LowRule: 37, HighRule: 38},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 38, HighRule: 41},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 41, HighRule: 44},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 44, HighRule: 47},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 47, HighRule: 50},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 50, HighRule: 52},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 52, HighRule: 55},
+		{TokenTypes: grammar.BitSet{0xf}, LowMultiRule: 1, HighMultiRule: 2, LowRule: 55, HighRule: 57},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 59, HighRule: 63},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 63, HighRule: 64},
+		{TokenTypes: grammar.BitSet{0x5}, LowRule: 64, HighRule: 65},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 65, HighRule: 66},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 66, HighRule: 71},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 71, HighRule: 72},
+		{TokenTypes: grammar.BitSet{0x9}, LowRule: 72, HighRule: 74},
+		{TokenTypes: grammar.BitSet{0x1f}, LowRule: 74, HighRule: 78},
 	},
 	MultiRules: []grammar.MultiRule{
 		{2, 3, 6},
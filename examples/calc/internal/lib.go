@@ -3,7 +3,6 @@ package internal
 
 import (
 	"math"
-	"strconv"
 
 	"github.com/ava12/llx"
 	"github.com/ava12/llx/parser"
@@ -173,8 +172,7 @@ func (ch *chain) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (ch *chain) HandleNode(node string, result interface{}) error {
-	exp := result.(expr)
+func (ch *chain) HandleNode(node string, exp expr) error {
 	if exp.IsNumber() {
 		val, _ := exp.Compute(nil)
 		ch.value = ch.update(ch.value, val, ch.lastOp)
@@ -189,7 +187,7 @@ func (ch *chain) HandleToken(token *parser.Token) error {
 	return nil
 }
 
-func (ch *chain) EndNode() (result interface{}, e error) {
+func (ch *chain) EndNode() (result expr, e error) {
 	if ch.IsNumber() {
 		return newNumber(ch.value), nil
 	} else {
@@ -225,8 +223,7 @@ func (p *power) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (p *power) HandleNode(node string, result interface{}) error {
-	x := result.(expr)
+func (p *power) HandleNode(node string, x expr) error {
 	if p.base == nil {
 		p.base = x
 	} else {
@@ -239,7 +236,7 @@ func (p *power) HandleToken(token *parser.Token) error {
 	return nil
 }
 
-func (p *power) EndNode() (result interface{}, e error) {
+func (p *power) EndNode() (result expr, e error) {
 	if p.IsNumber() {
 		var x float64
 		x, e = p.Compute(nil)
@@ -293,9 +290,9 @@ func (a *assignment) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (a *assignment) HandleNode(node string, result interface{}) error {
+func (a *assignment) HandleNode(node string, result expr) error {
 	if node == "expr" {
-		a.value = result.(expr)
+		a.value = result
 	}
 	return nil
 }
@@ -307,7 +304,7 @@ func (a *assignment) HandleToken(token *parser.Token) error {
 	return nil
 }
 
-func (a *assignment) EndNode() (result interface{}, e error) {
+func (a *assignment) EndNode() (result expr, e error) {
 	return a, nil
 }
 
@@ -335,9 +332,9 @@ func (fd *funcDef) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (fd *funcDef) HandleNode(node string, result interface{}) error {
+func (fd *funcDef) HandleNode(node string, result expr) error {
 	if node == "expr" {
-		fd.body = result.(expr)
+		fd.body = result
 	}
 	return nil
 }
@@ -366,7 +363,7 @@ func (fd *funcDef) HandleToken(token *parser.Token) (e error) {
 	return
 }
 
-func (fd *funcDef) EndNode() (result interface{}, e error) {
+func (fd *funcDef) EndNode() (result expr, e error) {
 	return fd, nil
 }
 
@@ -407,9 +404,9 @@ func (fc *funcCall) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (fc *funcCall) HandleNode(node string, result interface{}) error {
+func (fc *funcCall) HandleNode(node string, result expr) error {
 	if node == "expr" {
-		fc.args = append(fc.args, result.(expr))
+		fc.args = append(fc.args, result)
 	}
 	return nil
 }
@@ -421,35 +418,10 @@ func (fc *funcCall) HandleToken(token *parser.Token) error {
 	return nil
 }
 
-func (fc *funcCall) EndNode() (result interface{}, e error) {
+func (fc *funcCall) EndNode() (result expr, e error) {
 	return fc, nil
 }
 
-type rootNode struct {
-	body expr
-}
-
-func newRootNode() *rootNode {
-	return &rootNode{}
-}
-
-func (r *rootNode) NewNode(node string, token *parser.Token) error {
-	return nil
-}
-
-func (r *rootNode) HandleNode(node string, result interface{}) error {
-	r.body = result.(expr)
-	return nil
-}
-
-func (r *rootNode) HandleToken(token *parser.Token) error {
-	return nil
-}
-
-func (r *rootNode) EndNode() (result interface{}, e error) {
-	return r.body, nil
-}
-
 type value struct {
 	body expr
 }
@@ -462,8 +434,8 @@ func (v *value) NewNode(node string, token *parser.Token) error {
 	return nil
 }
 
-func (v *value) HandleNode(node string, result interface{}) error {
-	v.body = result.(expr)
+func (v *value) HandleNode(node string, result expr) error {
+	v.body = result
 	return nil
 }
 
@@ -472,26 +444,19 @@ func (v *value) HandleToken(token *parser.Token) error {
 	case "name":
 		v.body = newVarName(token.Text())
 	case "number":
-		res, e := strconv.ParseFloat(token.Text(), 64)
-		if e == nil {
-			v.body = newNumber(res)
-		} else {
-			return e
-		}
+		v.body = newNumber(token.Value().(float64))
 	}
 	return nil
 }
 
-func (v *value) EndNode() (result interface{}, e error) {
+func (v *value) EndNode() (result expr, e error) {
 	return v.body, nil
 }
 
 var hooks = &parser.Hooks{
 	Nodes: parser.NodeHooks{
-		parser.AnyNode: func(node string, t *parser.Token, pc *parser.ParseContext) (res parser.NodeHookInstance, e error) {
+		parser.AnyNode: parser.TypedNodeHook(func(node string, t *parser.Token, pc *parser.ParseContext) (res parser.TypedHookInstance[expr], e error) {
 			switch node {
-			case "calcGrammar":
-				res = newRootNode()
 			case "func":
 				res = newFuncDef()
 			case "assign":
@@ -508,7 +473,7 @@ var hooks = &parser.Hooks{
 				res = newFuncCall()
 			}
 			return
-		},
+		}),
 	},
 }
 
@@ -520,7 +485,7 @@ var (
 func init() {
 	var e error
 	rootContext = newContext(nil)
-	calcParser, e = parser.New(calcGrammar)
+	calcParser, e = parser.New(calcGrammar, parser.WithRootUnwrap())
 	if e != nil {
 		panic(e)
 	}
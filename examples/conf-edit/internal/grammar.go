@@ -18,29 +18,29 @@ var confGrammar = &grammar.Grammar{
 		{Name: "]", Re: "", Group: 0, Flags: 1},
 	},
 	Nodes: []grammar.Node{
-		{Name: "confGrammar", FirstState: 0},
-		{Name: "def-section", FirstState: 2},
-		{Name: "section", FirstState: 4},
-		{Name: "entry", FirstState: 6},
-		{Name: "sep", FirstState: 10},
-		{Name: "header", FirstState: 11},
+		{Name: "confGrammar", FirstState: 0, FirstTokens: grammar.BitSet{0x114}},
+		{Name: "def-section", FirstState: 2, FirstTokens: grammar.BitSet{0x14}},
+		{Name: "section", FirstState: 4, FirstTokens: grammar.BitSet{0x100}},
+		{Name: "entry", FirstState: 6, FirstTokens: grammar.BitSet{0x10}},
+		{Name: "sep", FirstState: 10, FirstTokens: grammar.BitSet{0x4}},
+		{Name: "header", FirstState: 11, FirstTokens: grammar.BitSet{0x100}},
 	},
 	States: []grammar.State{
-		{55, 0, 0, 0, 4},
-		{35, 0, 0, 4, 6},
-		{23, 0, 0, 6, 8},
-		{23, 0, 0, 8, 11},
-		{35, 0, 0, 11, 12},
-		{23, 0, 0, 12, 15},
-		{19, 0, 0, 15, 16},
-		{35, 0, 0, 16, 17},
-		{71, 0, 0, 17, 20},
-		{7, 0, 0, 20, 22},
-		{7, 0, 0, 22, 23},
-		{35, 0, 0, 23, 24},
-		{11, 0, 0, 24, 25},
-		{35, 0, 0, 25, 26},
-		{7, 0, 0, 26, 27},
+		{TokenTypes: grammar.BitSet{0x37}, LowRule: 0, HighRule: 4},
+		{TokenTypes: grammar.BitSet{0x23}, LowRule: 4, HighRule: 6},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 6, HighRule: 8},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 8, HighRule: 11},
+		{TokenTypes: grammar.BitSet{0x23}, LowRule: 11, HighRule: 12},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 12, HighRule: 15},
+		{TokenTypes: grammar.BitSet{0x13}, LowRule: 15, HighRule: 16},
+		{TokenTypes: grammar.BitSet{0x23}, LowRule: 16, HighRule: 17},
+		{TokenTypes: grammar.BitSet{0x47}, LowRule: 17, HighRule: 20},
+		{TokenTypes: grammar.BitSet{0x7}, LowRule: 20, HighRule: 22},
+		{TokenTypes: grammar.BitSet{0x7}, LowRule: 22, HighRule: 23},
+		{TokenTypes: grammar.BitSet{0x23}, LowRule: 23, HighRule: 24},
+		{TokenTypes: grammar.BitSet{0xb}, LowRule: 24, HighRule: 25},
+		{TokenTypes: grammar.BitSet{0x23}, LowRule: 25, HighRule: 26},
+		{TokenTypes: grammar.BitSet{0x7}, LowRule: 26, HighRule: 27},
 	},
 	MultiRules: []grammar.MultiRule{},
 	Rules: []grammar.Rule{
@@ -289,9 +289,7 @@ func (s *Section) RemoveEntry(name string) {
 
 func Parse(name string, src *[]byte) (*Conf, error) {
 	source.NormalizeNls(src)
-	if len(*src) > 0 && (*src)[len(*src)-1] != '\n' {
-		*src = append(*src, '\n')
-	}
+	source.EnsureFinalNewline(src)
 
 	queue := source.NewQueue().Append(source.New(name, *src))
 	p, e := parser.New(confGrammar)
@@ -366,23 +364,7 @@ func ParseFile(name string) (*Conf, error) {
 }
 
 func Serialize(root tree.Element, w io.Writer) (written int, err error) {
-	visitor := func(s tree.WalkStat) tree.WalkerFlags {
-		n := s.Element
-		if !n.IsNode() {
-			i, e := w.Write([]byte(n.Token().Text()))
-			if e == nil {
-				written += i
-			} else {
-				err = e
-				return tree.WalkerStop
-			}
-		}
-
-		return 0
-	}
-
-	tree.Walk(root, tree.WalkLtr, visitor)
-	return
+	return tree.Print(root, w)
 }
 
 func SaveFile(name string, root tree.Element) (int, error) {
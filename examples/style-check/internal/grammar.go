@@ -22,38 +22,38 @@ var cDataGrammar = &grammar.Grammar{
 		{Name: "]", Re: "", Group: 0, Flags: 1},
 	},
 	Nodes: []grammar.Node{
-		{Name: "cDataGrammar", FirstState: 0},
-		{Name: "var-def", FirstState: 2},
-		{Name: "type-def", FirstState: 8},
-		{Name: "type", FirstState: 12},
-		{Name: "name", FirstState: 13},
-		{Name: "size-def", FirstState: 14},
-		{Name: "simple-type", FirstState: 17},
-		{Name: "struct-type", FirstState: 18},
+		{Name: "cDataGrammar", FirstState: 0, FirstTokens: grammar.BitSet{0x310}},
+		{Name: "var-def", FirstState: 2, FirstTokens: grammar.BitSet{0x210}},
+		{Name: "type-def", FirstState: 8, FirstTokens: grammar.BitSet{0x100}},
+		{Name: "type", FirstState: 12, FirstTokens: grammar.BitSet{0x210}},
+		{Name: "name", FirstState: 13, FirstTokens: grammar.BitSet{0x10}},
+		{Name: "size-def", FirstState: 14, FirstTokens: grammar.BitSet{0x1000}},
+		{Name: "simple-type", FirstState: 17, FirstTokens: grammar.BitSet{0x10}},
+		{Name: "struct-type", FirstState: 18, FirstTokens: grammar.BitSet{0x200}},
 	},
 	States: []grammar.State{
-		{23, 0, 0, 0, 3},
-		{23, 0, 0, 3, 7},
-		{23, 0, 0, 7, 9},
-		{23, 0, 0, 9, 10},
-		{39, 0, 0, 10, 13},
-		{39, 0, 0, 13, 15},
-		{23, 0, 0, 15, 16},
-		{39, 0, 0, 16, 18},
-		{23, 0, 0, 18, 19},
-		{23, 0, 0, 19, 21},
-		{23, 0, 0, 21, 22},
-		{39, 0, 0, 22, 24},
-		{23, 0, 0, 24, 26},
-		{23, 0, 0, 26, 27},
-		{39, 0, 0, 27, 28},
-		{15, 0, 0, 28, 29},
-		{39, 0, 0, 29, 30},
-		{23, 0, 0, 30, 31},
-		{23, 0, 0, 31, 32},
-		{39, 0, 0, 32, 33},
-		{23, 0, 0, 33, 35},
-		{55, 0, 0, 35, 38},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 0, HighRule: 3},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 3, HighRule: 7},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 7, HighRule: 9},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 9, HighRule: 10},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 10, HighRule: 13},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 13, HighRule: 15},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 15, HighRule: 16},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 16, HighRule: 18},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 18, HighRule: 19},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 19, HighRule: 21},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 21, HighRule: 22},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 22, HighRule: 24},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 24, HighRule: 26},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 26, HighRule: 27},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 27, HighRule: 28},
+		{TokenTypes: grammar.BitSet{0xf}, LowRule: 28, HighRule: 29},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 29, HighRule: 30},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 30, HighRule: 31},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 31, HighRule: 32},
+		{TokenTypes: grammar.BitSet{0x27}, LowRule: 32, HighRule: 33},
+		{TokenTypes: grammar.BitSet{0x17}, LowRule: 33, HighRule: 35},
+		{TokenTypes: grammar.BitSet{0x37}, LowRule: 35, HighRule: 38},
 	},
 	MultiRules: []grammar.MultiRule{},
 	Rules: []grammar.Rule{
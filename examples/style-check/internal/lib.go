@@ -184,11 +184,9 @@ func inspectCode(st tree.Element) *reports {
 }
 
 func reportNoFinalNl(st tree.Element, rs *reports) {
-	lt := tree.LastTokenElement(st).Token()
-	if lt.TypeName() != indentType {
-		line := lt.Line()
-		col := lt.Col() + len(lt.Text())
-		rs.report(line, col, ErrNoEofNl)
+	if tree.LastTokenElement(st).TypeName() != indentType {
+		end := st.EndPos()
+		rs.report(end.Line(), end.Col(), ErrNoEofNl)
 	}
 }
 
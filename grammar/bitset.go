@@ -0,0 +1,103 @@
+package grammar
+
+const bitSetWordBits = 64
+
+// BitSet is a general bit set, where i-th bit of j-th word represents item with index j * 64 + i.
+// nil value is an empty set. With and Union never modify receivers or arguments, Add and AddSet modify
+// receiver in place and return the result the way append does.
+//
+// BitSet used to be uint64, so Go code generated by earlier llxgen versions does not compile.
+// Regenerate the code with llxgen or wrap each token type set value w in a literal: grammar.BitSet{w}.
+type BitSet []uint64
+
+// NewBitSet creates a set containing given non-negative items.
+func NewBitSet(items ...int) BitSet {
+	return BitSet(nil).With(items...)
+}
+
+// Contains returns true if the set contains given item.
+func (bs BitSet) Contains(item int) bool {
+	if item < 0 {
+		return false
+	}
+
+	i := item / bitSetWordBits
+	return i < len(bs) && bs[i]&(1<<(item%bitSetWordBits)) != 0
+}
+
+// IsEmpty returns true if the set contains no items.
+func (bs BitSet) IsEmpty() bool {
+	for _, w := range bs {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns new set containing all items of bs and given non-negative items.
+func (bs BitSet) With(items ...int) BitSet {
+	size := len(bs)
+	for _, item := range items {
+		if item >= 0 && item/bitSetWordBits >= size {
+			size = item/bitSetWordBits + 1
+		}
+	}
+
+	res := make(BitSet, size)
+	copy(res, bs)
+	for _, item := range items {
+		if item >= 0 {
+			res[item/bitSetWordBits] |= 1 << (item % bitSetWordBits)
+		}
+	}
+	return res
+}
+
+// Add adds given non-negative items to bs and returns the result, bs is reused unless it has to grow.
+func (bs BitSet) Add(items ...int) BitSet {
+	for _, item := range items {
+		if item < 0 {
+			continue
+		}
+
+		i := item / bitSetWordBits
+		for i >= len(bs) {
+			bs = append(bs, 0)
+		}
+		bs[i] |= 1 << (item % bitSetWordBits)
+	}
+	return bs
+}
+
+// AddSet adds items of other to bs and returns the result, bs is reused unless it has to grow.
+func (bs BitSet) AddSet(other BitSet) BitSet {
+	for len(bs) < len(other) {
+		bs = append(bs, 0)
+	}
+	for i, w := range other {
+		bs[i] |= w
+	}
+	return bs
+}
+
+// Union returns new set containing items of both bs and other.
+// Returns one of arguments if the other one is empty.
+func (bs BitSet) Union(other BitSet) BitSet {
+	if other.IsEmpty() {
+		return bs
+	}
+	if bs.IsEmpty() {
+		return other
+	}
+
+	if len(bs) < len(other) {
+		bs, other = other, bs
+	}
+	res := make(BitSet, len(bs))
+	copy(res, bs)
+	for i, w := range other {
+		res[i] |= w
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package grammar
+
+import (
+	"testing"
+)
+
+func TestBitSet(t *testing.T) {
+	var empty BitSet
+	if !empty.IsEmpty() || empty.Contains(0) || empty.Contains(-1) {
+		t.Fatalf("expecting empty set, got %v", empty)
+	}
+
+	s := NewBitSet(0, 63, 64, 200, -1)
+	for _, item := range []int{0, 63, 64, 200} {
+		if !s.Contains(item) {
+			t.Errorf("expecting %d in set %v", item, s)
+		}
+	}
+	for _, item := range []int{-1, 1, 62, 65, 199, 201, 1000} {
+		if s.Contains(item) {
+			t.Errorf("unexpected %d in set %v", item, s)
+		}
+	}
+
+	s2 := s.With(1)
+	if s.Contains(1) || !s2.Contains(1) || !s2.Contains(200) {
+		t.Errorf("With must not modify receiver, got %v and %v", s, s2)
+	}
+
+	a := NewBitSet(1, 2)
+	b := NewBitSet(2, 100)
+	u := a.Union(b)
+	for _, item := range []int{1, 2, 100} {
+		if !u.Contains(item) {
+			t.Errorf("expecting %d in union %v", item, u)
+		}
+	}
+	if a.Contains(100) || b.Contains(1) {
+		t.Errorf("Union must not modify arguments, got %v and %v", a, b)
+	}
+	if len(a.Union(nil)) != len(a) || len(empty.Union(b)) != len(b) {
+		t.Errorf("expecting union with empty set to be equal to the other set")
+	}
+
+	buf := make(BitSet, 0, 4)
+	in := buf.Add(3).AddSet(b)
+	if &in[0] != &buf[:1][0] || !in.Contains(3) || !in.Contains(100) || in.Contains(1) {
+		t.Errorf("expecting in-place union in reused buffer, got %v", in)
+	}
+	if b.Contains(3) {
+		t.Errorf("AddSet must not modify argument, got %v", b)
+	}
+}
@@ -0,0 +1,115 @@
+// Package export converts compiled grammars to other formats, e.g. for documenting grammars
+// or debugging state construction.
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ava12/llx/grammar"
+)
+
+// WriteDOT writes Graphviz DOT description of the grammar to w. Each grammar node is drawn as a cluster
+// of its states, the initial state is drawn as a box and the final state as a double circle.
+// Rules are drawn as edges labeled with rule keys (quoted literals, token type names prefixed with $,
+// or "any" for fallback rules) followed by the name of pushed node if any. Ambiguous rules are dashed.
+// Returns the first write error.
+func WriteDOT(w io.Writer, g *grammar.Grammar) error {
+	var b bytes.Buffer
+	b.WriteString("digraph grammar {\n\trankdir=LR;\n\tnode [shape=circle];\n")
+	for i := range g.Nodes {
+		writeNode(&b, g, i, "\t")
+	}
+	b.WriteString("}\n")
+	_, e := w.Write(b.Bytes())
+	return e
+}
+
+// WriteNodeDOT is same as WriteDOT, except it writes a graph for a single grammar node.
+// Returns an error if there is no such node.
+func WriteNodeDOT(w io.Writer, g *grammar.Grammar, nodeName string) error {
+	for i, n := range g.Nodes {
+		if n.Name == nodeName {
+			var b bytes.Buffer
+			b.WriteString("digraph " + quote(nodeName) + " {\n\trankdir=LR;\n\tnode [shape=circle];\n")
+			writeNode(&b, g, i, "\t")
+			b.WriteString("}\n")
+			_, e := w.Write(b.Bytes())
+			return e
+		}
+	}
+
+	return fmt.Errorf("unknown node %q", nodeName)
+}
+
+func writeNode(b *bytes.Buffer, g *grammar.Grammar, index int, indent string) {
+	n := g.Nodes[index]
+	high := len(g.States)
+	if index+1 < len(g.Nodes) {
+		high = g.Nodes[index+1].FirstState
+	}
+
+	final := "f" + strconv.Itoa(index)
+	inner := indent + "\t"
+	b.WriteString(indent + "subgraph " + quote("cluster_"+n.Name) + " {\n")
+	b.WriteString(inner + "label=" + quote(n.Name) + ";\n")
+	for si := n.FirstState; si < high; si++ {
+		attrs := "label=" + quote(strconv.Itoa(si))
+		if si == n.FirstState {
+			attrs += ", shape=box"
+		}
+		b.WriteString(inner + "s" + strconv.Itoa(si) + " [" + attrs + "];\n")
+	}
+	b.WriteString(inner + final + " [label=\"\", shape=doublecircle];\n")
+
+	for si := n.FirstState; si < high; si++ {
+		s := g.States[si]
+		for _, r := range g.Rules[s.LowRule:s.HighRule] {
+			writeRule(b, g, si, r, final, false, inner)
+		}
+		for _, mr := range g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+			for _, r := range g.Rules[mr.LowRule:mr.HighRule] {
+				writeRule(b, g, si, r, final, true, inner)
+			}
+		}
+	}
+	b.WriteString(indent + "}\n")
+}
+
+func writeRule(b *bytes.Buffer, g *grammar.Grammar, state int, r grammar.Rule, final string, ambiguous bool, indent string) {
+	target := final
+	if r.State != grammar.FinalState {
+		target = "s" + strconv.Itoa(r.State)
+	}
+
+	label := keyName(g, r.Token)
+	if r.Node != grammar.SameNode {
+		label += " / " + g.Nodes[r.Node].Name
+	}
+	attrs := "label=" + quote(label)
+	if ambiguous {
+		attrs += ", style=dashed"
+	}
+	b.WriteString(indent + "s" + strconv.Itoa(state) + " -> " + target + " [" + attrs + "];\n")
+}
+
+func keyName(g *grammar.Grammar, key int) string {
+	if key == grammar.AnyToken {
+		return "any"
+	}
+
+	t := g.Tokens[key]
+	if t.Flags&grammar.LiteralToken != 0 {
+		return strconv.Quote(t.Name)
+	}
+	return "$" + t.Name
+}
+
+var dotReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func quote(s string) string {
+	return "\"" + dotReplacer.Replace(s) + "\""
+}
@@ -0,0 +1,52 @@
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx/langdef"
+)
+
+func TestWriteDOT(t *testing.T) {
+	g, e := langdef.ParseString("", "$num = /\\d+/; $op = /[-+()]/; "+
+		"expr = item, {('+' | '-'), item}; item = $num | ('(', expr, ')');")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var b strings.Builder
+	e = WriteDOT(&b, g)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	dot := b.String()
+	expected := []string{
+		"digraph grammar {",
+		"\tsubgraph \"cluster_expr\" {",
+		"\t\tlabel=\"expr\";",
+		"\t\ts0 [label=\"0\", shape=box];",
+		"\t\tf0 [label=\"\", shape=doublecircle];",
+		"\t\ts0 -> s1 [label=\"$num / item\"];",
+		"\t\ts1 -> f0 [label=\"any\"];",
+		"\tsubgraph \"cluster_item\" {",
+		"[label=\"\\\"(\\\"\"];",
+		"[label=\"$num\"];",
+	}
+	for _, x := range expected {
+		if !strings.Contains(dot, x) {
+			t.Errorf("expecting %q in:\n%s", x, dot)
+		}
+	}
+
+	b.Reset()
+	e = WriteNodeDOT(&b, g, "item")
+	if e != nil || !strings.HasPrefix(b.String(), "digraph \"item\" {") || strings.Contains(b.String(), "cluster_expr") {
+		t.Errorf("unexpected result: %s\n%s", e, b.String())
+	}
+
+	e = WriteNodeDOT(&b, g, "foo")
+	if e == nil {
+		t.Error("expecting error")
+	}
+}
@@ -0,0 +1,440 @@
+// Package gen generates random sentences of a grammar, e.g. for property testing that every generated
+// sentence is accepted by parser:
+//
+//	g, e := gen.New(calcGrammar, gen.WithSeed(42))
+//	if e != nil {
+//		t.Fatal(e)
+//	}
+//	gen.Check(t, calcParser, g, 1000)
+//
+// Sentences are generated by random walk of grammar state machine. Token texts are generated from token type
+// regular expressions (or by functions set with WithTokenText) and separated with a separator string.
+// Generated texts that would be lexed as another token type or as a literal are regenerated several times,
+// but the result is not guaranteed to be valid for grammars relying on lexer groups, hooks, or external tokens.
+package gen
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/ava12/llx/grammar"
+)
+
+const (
+	defaultMaxDepth  = 10
+	defaultMaxRepeat = 3
+	maxTokens        = 1000
+	maxRetries       = 10
+	infinity         = int(^uint(0) >> 1)
+)
+
+// Option tunes generator.
+type Option func(g *Generator)
+
+// WithSeed sets the seed of random number generator, the default seed is 1.
+func WithSeed(seed int64) Option {
+	return func(g *Generator) {
+		g.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithMaxDepth sets the depth of nested nodes after which generator chooses the shortest ways to finish nodes,
+// the default depth is 10.
+func WithMaxDepth(depth int) Option {
+	return func(g *Generator) {
+		g.maxDepth = depth
+	}
+}
+
+// WithMaxRepeat sets the maximum number of extra repetitions for unbounded regular expression operators
+// (*, +, {n,}) used to generate token texts, the default number is 3.
+func WithMaxRepeat(n int) Option {
+	return func(g *Generator) {
+		g.maxRepeat = n
+	}
+}
+
+// WithSeparator sets the string placed between tokens. The default separator is a space
+// if some aside token type matches it, otherwise empty string.
+func WithSeparator(sep string) Option {
+	return func(g *Generator) {
+		g.sep = &sep
+	}
+}
+
+// WithTokenText sets a function generating texts for given token type instead of its regular expression,
+// e.g. to generate meaningful identifiers or texts of external tokens.
+func WithTokenText(typeName string, f func(r *rand.Rand) string) Option {
+	return func(g *Generator) {
+		if g.texts == nil {
+			g.texts = make(map[string]func(r *rand.Rand) string)
+		}
+		g.texts[typeName] = f
+	}
+}
+
+// Generator generates random sentences of a grammar. Not safe for concurrent use.
+type Generator struct {
+	g         *grammar.Grammar
+	rand      *rand.Rand
+	maxDepth  int
+	maxRepeat int
+	sep       *string
+	texts     map[string]func(r *rand.Rand) string
+	res       []*syntax.Regexp
+	full      []*regexp.Regexp
+	groups    map[int]*regexp.Regexp
+	literals  map[string]bool
+	folded    map[string]bool
+	costs     []int
+	tokens    []string
+}
+
+// New creates a generator for given grammar.
+// Returns an error if some token type regular expression cannot be parsed or WithTokenText refers
+// to unknown token type.
+func New(g *grammar.Grammar, opts ...Option) (*Generator, error) {
+	gen := &Generator{
+		g:         g,
+		maxDepth:  defaultMaxDepth,
+		maxRepeat: defaultMaxRepeat,
+		res:       make([]*syntax.Regexp, len(g.Tokens)),
+		full:      make([]*regexp.Regexp, len(g.Tokens)),
+		groups:    make(map[int]*regexp.Regexp),
+		literals:  make(map[string]bool),
+		folded:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(gen)
+		}
+	}
+	if gen.rand == nil {
+		gen.rand = rand.New(rand.NewSource(1))
+	}
+
+	known := make(map[string]bool)
+	for i, t := range g.Tokens {
+		if t.Flags&grammar.LiteralToken != 0 {
+			gen.literals[t.Name] = true
+			gen.folded[strings.ToLower(t.Name)] = true
+			continue
+		}
+
+		known[t.Name] = true
+		if t.Re == "" {
+			continue
+		}
+
+		re, e := syntax.Parse(t.Re, syntax.Perl)
+		if e != nil {
+			return nil, fmt.Errorf("token type %q: %s", t.Name, e)
+		}
+		gen.res[i] = re.Simplify()
+		gen.full[i], e = regexp.Compile("^(?:" + t.Re + ")$")
+		if e != nil {
+			return nil, fmt.Errorf("token type %q: %s", t.Name, e)
+		}
+	}
+	for name := range gen.texts {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown token type %q", name)
+		}
+	}
+	if gen.sep == nil {
+		gen.sep = new(string)
+		for i, t := range g.Tokens {
+			if t.Flags&grammar.AsideToken != 0 && gen.full[i] != nil && gen.full[i].MatchString(" ") {
+				*gen.sep = " "
+				break
+			}
+		}
+	}
+
+	e := gen.initGroups()
+	if e != nil {
+		return nil, e
+	}
+
+	gen.initCosts()
+	return gen, nil
+}
+
+// Generate returns a random sentence of the grammar.
+func (gen *Generator) Generate() string {
+	gen.tokens = gen.tokens[:0]
+	gen.node(grammar.RootNode, -1, 0)
+	return strings.Join(gen.tokens, *gen.sep)
+}
+
+// initGroups compiles lexer-like regular expressions used to check generated token texts.
+func (gen *Generator) initGroups() error {
+	parts := make(map[int][]string)
+	for _, t := range gen.g.Tokens {
+		if t.Flags&(grammar.LiteralToken|grammar.ExternalToken) == 0 && t.Re != "" {
+			parts[t.Group] = append(parts[t.Group], "("+t.Re+")")
+		}
+	}
+	for group, ps := range parts {
+		re, e := regexp.Compile("^(?:" + strings.Join(ps, "|") + ")")
+		if e != nil {
+			return e
+		}
+		gen.groups[group] = re
+	}
+	return nil
+}
+
+// initCosts calculates the minimal number of tokens needed to reach the final state from each state.
+func (gen *Generator) initCosts() {
+	gen.costs = make([]int, len(gen.g.States))
+	for i := range gen.costs {
+		gen.costs[i] = infinity
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := range gen.g.States {
+			for _, r := range gen.rules(i) {
+				c := gen.ruleCost(r)
+				if c < gen.costs[i] {
+					gen.costs[i] = c
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func (gen *Generator) ruleCost(r grammar.Rule) int {
+	var c int
+	switch {
+	case r.Node != grammar.SameNode:
+		c = gen.costs[gen.g.Nodes[r.Node].FirstState]
+	case r.Token != grammar.AnyToken:
+		c = 1
+	}
+	if c == infinity {
+		return c
+	}
+
+	if r.State != grammar.FinalState {
+		next := gen.costs[r.State]
+		if next == infinity {
+			return next
+		}
+		c += next
+	}
+	return c
+}
+
+func (gen *Generator) rules(state int) []grammar.Rule {
+	g := gen.g
+	s := g.States[state]
+	res := g.Rules[s.LowRule:s.HighRule:s.HighRule]
+	for _, mr := range g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+		res = append(res, g.Rules[mr.LowRule:mr.HighRule]...)
+	}
+	return res
+}
+
+// node generates tokens for a node, first is the required key of the first token or -1.
+// Returns true if at least one token was generated.
+func (gen *Generator) node(index, first, depth int) bool {
+	state := gen.g.Nodes[index].FirstState
+	consumed := false
+	for state != grammar.FinalState {
+		var rules []grammar.Rule
+		for _, r := range gen.rules(state) {
+			if first < 0 || r.Token == grammar.AnyToken || gen.matches(r.Token, first) {
+				rules = append(rules, r)
+			}
+		}
+		if len(rules) == 0 {
+			return consumed
+		}
+
+		var r grammar.Rule
+		if depth >= gen.maxDepth || len(gen.tokens) >= maxTokens {
+			r = gen.shortest(rules, state)
+		} else {
+			r = rules[gen.rand.Intn(len(rules))]
+		}
+
+		switch {
+		case r.Node != grammar.SameNode:
+			key := first
+			if r.Token != grammar.AnyToken {
+				key = r.Token
+			}
+			if gen.node(r.Node, key, depth+1) {
+				consumed = true
+				first = -1
+			}
+		case r.Token != grammar.AnyToken:
+			gen.tokens = append(gen.tokens, gen.token(r.Token, first))
+			consumed = true
+			first = -1
+		}
+		state = r.State
+	}
+	return consumed
+}
+
+// shortest returns the rule leading to the final state with the minimal number of tokens.
+func (gen *Generator) shortest(rules []grammar.Rule, state int) grammar.Rule {
+	res := rules[0]
+	cost := infinity
+	for _, r := range rules {
+		if r.Node == grammar.SameNode && r.Token == grammar.AnyToken && r.State == state {
+			continue
+		}
+
+		c := gen.ruleCost(r)
+		if c < cost || c == cost && r.State == grammar.FinalState {
+			res, cost = r, c
+		}
+	}
+	return res
+}
+
+// matches checks if the rule key accepts the token required by the first key.
+func (gen *Generator) matches(key, first int) bool {
+	if key == first {
+		return true
+	}
+
+	tokens := gen.g.Tokens
+	if tokens[first].Flags&grammar.LiteralToken == 0 || gen.full[key] == nil {
+		return false
+	}
+
+	return gen.full[key].MatchString(tokens[first].Name)
+}
+
+// token returns text of a token of given type, first is the required key of the token or -1.
+func (gen *Generator) token(key, first int) string {
+	tokens := gen.g.Tokens
+	if first >= 0 && tokens[first].Flags&grammar.LiteralToken != 0 {
+		return tokens[first].Name
+	}
+
+	t := tokens[key]
+	if t.Flags&grammar.LiteralToken != 0 {
+		return t.Name
+	}
+
+	if f := gen.texts[t.Name]; f != nil {
+		return f(gen.rand)
+	}
+
+	if gen.res[key] == nil {
+		return ""
+	}
+
+	var text string
+	for i := 0; i < maxRetries; i++ {
+		var b strings.Builder
+		gen.regexp(&b, gen.res[key])
+		text = b.String()
+		if gen.valid(key, text) {
+			break
+		}
+	}
+	return text
+}
+
+// valid checks if text would be lexed as a single token of given type and not as a literal.
+func (gen *Generator) valid(key int, text string) bool {
+	t := gen.g.Tokens[key]
+	if t.Flags&grammar.NoLiteralsToken == 0 && (gen.literals[text] || t.Flags&grammar.CaselessToken != 0 && gen.folded[strings.ToLower(text)]) {
+		return false
+	}
+
+	re := gen.groups[t.Group]
+	if re == nil {
+		return true
+	}
+
+	m := re.FindStringSubmatchIndex(text)
+	if m == nil || m[1] != len(text) {
+		return false
+	}
+
+	index := 0
+	for i, tt := range gen.g.Tokens {
+		if tt.Group != t.Group || tt.Flags&(grammar.LiteralToken|grammar.ExternalToken) != 0 || tt.Re == "" {
+			continue
+		}
+
+		index++
+		if m[index*2] >= 0 {
+			return i == key
+		}
+	}
+	return false
+}
+
+func (gen *Generator) regexp(b *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		if len(re.Rune) > 0 {
+			b.WriteRune(gen.classRune(re.Rune))
+		}
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		b.WriteRune(rune(' ' + 1 + gen.rand.Intn('~'-' ')))
+	case syntax.OpCapture:
+		gen.regexp(b, re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			gen.regexp(b, sub)
+		}
+	case syntax.OpAlternate:
+		gen.regexp(b, re.Sub[gen.rand.Intn(len(re.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		low, high := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			low, high = 0, -1
+		case syntax.OpPlus:
+			low, high = 1, -1
+		case syntax.OpQuest:
+			low, high = 0, 1
+		}
+		if high < 0 {
+			high = low + gen.maxRepeat
+		}
+		n := low + gen.rand.Intn(high-low+1)
+		for i := 0; i < n; i++ {
+			gen.regexp(b, re.Sub[0])
+		}
+	}
+}
+
+// classRune returns a random rune of character class, preferring printable ASCII runes.
+func (gen *Generator) classRune(ranges []rune) rune {
+	var ascii []rune
+	for i := 0; i < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1] && r <= '~'; r++ {
+			if r >= ' ' {
+				ascii = append(ascii, r)
+			}
+		}
+	}
+	if len(ascii) > 0 && gen.rand.Intn(10) > 0 {
+		return ascii[gen.rand.Intn(len(ascii))]
+	}
+
+	for {
+		i := gen.rand.Intn(len(ranges)/2) * 2
+		r := ranges[i] + rune(gen.rand.Int63n(int64(ranges[i+1]-ranges[i]+1)))
+		if r < 0xd800 || r > 0xdfff {
+			return r
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package gen
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx/langdef"
+	"github.com/ava12/llx/parser"
+)
+
+var grammars = []string{
+	"!aside $space; $space = /\\s+/; $num = /\\d+(?:\\.\\d+)?/; $name = /[a-z]\\w*/; $op = /[-+*\\/^()=,;]/; " +
+		"g = {stmt}; stmt = ['let', $name, '='], expr, ';'; expr = ['-'], pro, {('+' | '-'), pro}; pro = pow, {('*' | '/'), pow}; " +
+		"pow = value, ['^', pow]; value = $num | ($name, ['(', [expr, {',', expr}], ')']) | ('(', expr, ')');",
+	"!aside $space; $space = /\\s+/; $name = /[a-z]+/; $string = /\"[^\"\\n]*\"/; $op = /[{}:;]/; " +
+		"!reserved 'if' 'else'; g = {stmt}; stmt = block | cond | decl; block = '{', {stmt}, '}'; " +
+		"cond = 'if', $name, stmt, ['else', stmt]; decl = $name, ':', ($name | $string), ';';",
+	"!aside $space; $space = /\\s+/; $name = /[a-zA-Z]+/; !caseless $name; $op = /[;]/; " +
+		"g = {stmt}; stmt = ('SELECT', $name, {$name}) | ('DROP', $name), ';';",
+}
+
+func TestGenerate(t *testing.T) {
+	for i, src := range grammars {
+		g, e := langdef.ParseString("", src)
+		if e != nil {
+			t.Fatalf("grammar #%d: %s", i, e)
+		}
+
+		p, e := parser.New(g)
+		if e != nil {
+			t.Fatalf("grammar #%d: %s", i, e)
+		}
+
+		gen, e := New(g, WithSeed(int64(i)), WithMaxDepth(6))
+		if e != nil {
+			t.Fatalf("grammar #%d: %s", i, e)
+		}
+
+		Check(t, p, gen, 300)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	g, e := langdef.ParseString("", "$name = /[a-z]+/; $op = /;/; g = {$name, ';'};")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	gen := func(opts ...Option) *Generator {
+		res, e := New(g, opts...)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return res
+	}
+
+	a, b := gen(WithSeed(5)), gen(WithSeed(5))
+	for i := 0; i < 10; i++ {
+		if x, y := a.Generate(), b.Generate(); x != y {
+			t.Errorf("sentence #%d: same seed produced %q and %q", i, x, y)
+		}
+	}
+
+	text := gen(WithSeparator("|"), WithTokenText("name", func(r *rand.Rand) string {
+		return "x"
+	})).Generate()
+	if text != "" && strings.Trim(strings.ReplaceAll(text, "x|;", ""), "|") != "" {
+		t.Errorf("unexpected sentence %q", text)
+	}
+
+	_, e = New(g, WithTokenText("foo", nil))
+	if e == nil {
+		t.Error("expecting error for unknown token type")
+	}
+}
+
+func FuzzGenerated(f *testing.F) {
+	g, e := langdef.ParseString("", grammars[1])
+	if e != nil {
+		f.Fatal(e)
+	}
+
+	p, _ := parser.New(g)
+	gen, _ := New(g)
+	AddSeeds(f, gen, 20)
+	f.Fuzz(func(t *testing.T, text string) {
+		p.ParseString("", text, nil)
+	})
+}
@@ -0,0 +1,28 @@
+package gen
+
+import (
+	"testing"
+
+	"github.com/ava12/llx/parser"
+)
+
+// Check parses n generated sentences with given parser and reports sentences that cause errors.
+// Parser must be created for the same grammar as generator, no hooks are used.
+func Check(t testing.TB, p *parser.Parser, gen *Generator, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		text := gen.Generate()
+		_, e := p.ParseString("generated", text, nil)
+		if e != nil {
+			t.Errorf("sentence #%d %q: %s", i, text, e)
+		}
+	}
+}
+
+// AddSeeds adds n generated sentences to the seed corpus of a fuzz test, the fuzz target takes a single string.
+func AddSeeds(f *testing.F, gen *Generator, n int) {
+	f.Helper()
+	for i := 0; i < n; i++ {
+		f.Add(gen.Generate())
+	}
+}
@@ -31,11 +31,8 @@ const (
 	RootNode = 0
 )
 
-// BitSet is a general bit set, where i-th bit represents item with index i.
-type BitSet = uint64
-
 // MaxTokenType is a maximum allowed token type, not counting literals.
-const MaxTokenType = 63
+const MaxTokenType = 1023
 
 // TokenFlags contain information about token type.
 type TokenFlags int
@@ -55,6 +52,10 @@ type Token struct {
 
 	// Flags contain information about token type.
 	Flags TokenFlags
+
+	// Decoder is the name of decoder converting token text to token value (see !decode directive),
+	// empty string if token value is not decoded.
+	Decoder string `json:",omitempty"`
 }
 
 const (
@@ -83,6 +84,19 @@ const (
 
 	// NoLiteralsToken marks token type that cannot match any literal, e.g. raw text in HTML.
 	NoLiteralsToken
+
+	// PreambleToken marks token type that may only be matched at the very beginning of a source,
+	// e.g. shebang line, byte order mark, or front matter.
+	PreambleToken
+)
+
+// NodeFlags contain information about node.
+type NodeFlags int
+
+const (
+	// InlineNode marks node listed in !inline directive. Parser may pass its tokens and nested nodes
+	// directly to the enclosing node (see parser.WithInlineNodes).
+	InlineNode NodeFlags = 1 << iota
 )
 
 // Node contains information about some syntax tree node.
@@ -92,6 +106,12 @@ type Node struct {
 
 	// FirstState is an index of initial state for this node.
 	FirstState int
+
+	// FirstTokens is the set of indexes in Grammar.Tokens (token types and literals) that may start this node.
+	FirstTokens BitSet `json:",omitempty"`
+
+	// Flags contain information about node.
+	Flags NodeFlags `json:",omitempty"`
 }
 
 const (
@@ -131,6 +151,8 @@ type MultiRule struct {
 }
 
 // State represents a parsing state.
+// Fields may be added in future versions, so use keyed literals; Go code generated by llxgen versions
+// emitting unkeyed State literals (before Caseless field was added) must be regenerated.
 type State struct {
 	// TokenTypes is the set of all token types acceptable at this point.
 	TokenTypes BitSet `json:",omitempty"`
@@ -150,6 +172,64 @@ type State struct {
 	// HighRule is the high index of the rule sub-slice for this state.
 	// 0 if not used.
 	HighRule int `json:",omitempty"`
+
+	// Caseless is set if literals are matched case-insensitively at this point,
+	// i.e. the state belongs to a node listed in !caseless directive or to a node nested in it.
+	Caseless bool `json:",omitempty"`
+}
+
+// Action is a named position in node definition, e.g. "@add" in "expr = term, {'+', term @add};".
+// Parser calls a callback bound to action name each time it leaves action state.
+type Action struct {
+	// Name is the action name without leading "@".
+	Name string
+
+	// State is the index of action state. Action state has a single AnyToken rule leading
+	// to the state following the action position.
+	State int
+}
+
+// ErrorProduction marks an element of node definition that matches erroneous input,
+// e.g. '=' in: cond = $name, ('==' | !err("use == to compare values") '='), $name;
+// Parser reports an error with given message each time it leaves error production state.
+type ErrorProduction struct {
+	// Message is the error message.
+	Message string
+
+	// State is the index of error production state. Error production state has a single AnyToken rule leading
+	// to the state following the marked element.
+	State int
+}
+
+// NestedToken describes a token type matching balanced nested delimiters, e.g. nested comments: /* a /* b */ c */.
+// Lexer extends each match of token regexp (which matches the opening delimiter) up to the balanced closing delimiter.
+type NestedToken struct {
+	// Token is the index of token type.
+	Token int
+
+	// Open and Close are non-empty distinct opening and closing delimiters.
+	Open, Close string
+}
+
+// NodeGroup makes parser fetch tokens using only the lexer of given token group while the node is the innermost one,
+// e.g. for string parts of an interpolated string: "a ${x + 1} b". Nested nodes use usual lexers unless they have
+// their own entries.
+type NodeGroup struct {
+	// Node is the index of node.
+	Node int
+
+	// Group is the token group number.
+	Group int
+}
+
+// Label is a name given to an element of node definition, e.g. "typ" in "var-def = typ:type, name:$name;".
+// Parser reports the label for each child token or node matched by a labeled rule.
+type Label struct {
+	// Name is the label name.
+	Name string
+
+	// Rule is the index of labeled rule.
+	Rule int
 }
 
 // Grammar holds all information required to make a parser.
@@ -171,4 +251,24 @@ type Grammar struct {
 	// Rules is a list of all parsing rules for all states.
 	// Grouped by state, entries in a group are sorted by Token field.
 	Rules []Rule
+
+	// Actions is a list of actions sorted by State field, at most one action per state.
+	Actions []Action `json:",omitempty"`
+
+	// Labels is a list of rule labels sorted by Rule field, at most one label per rule.
+	Labels []Label `json:",omitempty"`
+
+	// Adjacent is an ascending list of adjacency state indexes. Adjacency state follows an element that must not
+	// be separated from the next token, e.g. the first '>' in "shift = '>' ~ '>';", and has a single AnyToken rule.
+	// When parser leaves adjacency state, the next token must start right where the last consumed token ends.
+	Adjacent []int `json:",omitempty"`
+
+	// ErrorProductions is a list of error productions sorted by State field, at most one entry per state.
+	ErrorProductions []ErrorProduction `json:",omitempty"`
+
+	// NestedTokens is a list of nested token types sorted by Token field, at most one entry per token type.
+	NestedTokens []NestedToken `json:",omitempty"`
+
+	// NodeGroups is a list of node lexer groups sorted by Node field, at most one entry per node.
+	NodeGroups []NodeGroup `json:",omitempty"`
 }
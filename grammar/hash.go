@@ -0,0 +1,18 @@
+package grammar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hash returns hex-encoded SHA-256 digest of grammar content (tokens, nodes, states, rules, actions, labels, adjacency states,
+// error productions, nested tokens, and node groups).
+// Grammars having equal content produce equal digests regardless of how they were built or loaded,
+// e.g. an application may check that a grammar generated by llxgen matches its current description.
+// The digest does not depend on serialization format version.
+func Hash(g *Grammar) string {
+	w := &binaryWriter{}
+	w.grammar(g)
+	sum := sha256.Sum256(w.buf)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+package grammar
+
+import (
+	"encoding/json"
+)
+
+// CanonicalJSON returns stable indented JSON representation of the grammar suitable for version control:
+// fields go in fixed order, nil slices are written as empty arrays, output ends with a newline.
+// Grammars having equal content produce identical bytes.
+func CanonicalJSON(g *Grammar) ([]byte, error) {
+	cg := Grammar{
+		Tokens:           g.Tokens,
+		Nodes:            g.Nodes,
+		States:           g.States,
+		MultiRules:       g.MultiRules,
+		Rules:            g.Rules,
+		Actions:          g.Actions,
+		Labels:           g.Labels,
+		Adjacent:         g.Adjacent,
+		ErrorProductions: g.ErrorProductions,
+		NestedTokens:     g.NestedTokens,
+		NodeGroups:       g.NodeGroups,
+	}
+	if cg.Tokens == nil {
+		cg.Tokens = []Token{}
+	}
+	if cg.Nodes == nil {
+		cg.Nodes = []Node{}
+	}
+	if cg.States == nil {
+		cg.States = []State{}
+	}
+	if cg.MultiRules == nil {
+		cg.MultiRules = []MultiRule{}
+	}
+	if cg.Rules == nil {
+		cg.Rules = []Rule{}
+	}
+
+	result, e := json.MarshalIndent(&cg, "", "  ")
+	if e != nil {
+		return nil, e
+	}
+
+	return append(result, '\n'), nil
+}
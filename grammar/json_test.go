@@ -0,0 +1,43 @@
+package grammar
+
+import (
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	g1 := &Grammar{
+		Tokens: []Token{{Name: "name", Re: "\\w+"}},
+		Nodes:  []Node{{Name: "root"}},
+		States: []State{{TokenTypes: BitSet{1}, HighRule: 1}},
+		Rules:  []Rule{{0, FinalState, SameNode}},
+	}
+	g2 := &Grammar{
+		Tokens:     []Token{{Name: "name", Re: "\\w+"}},
+		Nodes:      []Node{{Name: "root"}},
+		States:     []State{{TokenTypes: BitSet{1}, HighRule: 1}},
+		MultiRules: []MultiRule{},
+		Rules:      []Rule{{0, FinalState, SameNode}},
+	}
+
+	j1, e := CanonicalJSON(g1)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	j2, e := CanonicalJSON(g2)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if string(j1) != string(j2) {
+		t.Fatalf("expecting equal results, got:\n%s\n%s", j1, j2)
+	}
+	if j1[len(j1)-1] != '\n' {
+		t.Errorf("expecting trailing newline")
+	}
+
+	j3, _ := CanonicalJSON(&Grammar{})
+	expected := "{\n  \"Tokens\": [],\n  \"Nodes\": [],\n  \"States\": [],\n  \"MultiRules\": [],\n  \"Rules\": []\n}\n"
+	if string(j3) != expected {
+		t.Errorf("expecting:\n%s\ngot:\n%s", expected, j3)
+	}
+}
@@ -0,0 +1,529 @@
+package grammar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"regexp"
+
+	"github.com/ava12/llx"
+)
+
+// Error codes used by grammar:
+const (
+	// serialized data is malformed or has unknown format
+	WrongFormatError = llx.GrammarErrors + iota
+	// serialized data has unsupported format version
+	UnsupportedVersionError
+	// grammar structure is inconsistent
+	InvalidGrammarError
+)
+
+// Format is a grammar serialization format.
+type Format int
+
+// Serialization formats:
+const (
+	// JSONFormat is a compact JSON object holding format version and grammar structure.
+	JSONFormat Format = iota
+	// BinaryFormat is a compact binary format using variable-length integers.
+	BinaryFormat
+)
+
+// FormatVersion is the current version of serialization formats.
+// Unmarshal rejects data having any other version.
+const FormatVersion = 1
+
+var binaryMagic = []byte("LLXG")
+
+type jsonEnvelope struct {
+	Version int
+	Grammar *Grammar
+}
+
+// Marshal serializes grammar using given format, so that it can be cached or embedded
+// and then loaded with Unmarshal.
+func Marshal(g *Grammar, f Format) ([]byte, error) {
+	switch f {
+	case JSONFormat:
+		return json.Marshal(jsonEnvelope{FormatVersion, g})
+	case BinaryFormat:
+		return marshalBinary(g), nil
+	default:
+		return nil, llx.FormatError(WrongFormatError, "unknown serialization format %d", f)
+	}
+}
+
+// Unmarshal deserializes grammar written by Marshal, the format is detected automatically.
+// Loaded grammar is checked with Validate.
+func Unmarshal(data []byte) (*Grammar, error) {
+	var (
+		g *Grammar
+		e error
+	)
+	if bytes.HasPrefix(data, binaryMagic) {
+		g, e = unmarshalBinary(data[len(binaryMagic):])
+	} else {
+		g, e = unmarshalJSON(data)
+	}
+	if e == nil {
+		e = Validate(g)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	return g, nil
+}
+
+func unmarshalJSON(data []byte) (*Grammar, error) {
+	var env struct {
+		Version *int
+		Grammar *Grammar
+	}
+	if json.Unmarshal(data, &env) != nil || env.Version == nil || env.Grammar == nil {
+		return nil, wrongFormatError("malformed JSON data")
+	}
+	if *env.Version != FormatVersion {
+		return nil, unsupportedVersionError(*env.Version)
+	}
+
+	return env.Grammar, nil
+}
+
+// Validate checks that all indexes stored in grammar are in range, so that parser can use it safely,
+// and that token type regular expressions are valid.
+func Validate(g *Grammar) error {
+	if len(g.Nodes) == 0 {
+		return invalidGrammarError("no nodes defined")
+	}
+
+	maxGroup := 0
+	for i, t := range g.Tokens {
+		if t.Name == "" {
+			return invalidGrammarError("token #%d has empty name", i)
+		}
+		if t.Group < 0 {
+			return invalidGrammarError("token %q has negative group %d", t.Name, t.Group)
+		}
+		if t.Group > maxGroup {
+			maxGroup = t.Group
+		}
+		if t.Re != "" && t.Flags&LiteralToken == 0 {
+			if _, e := regexp.Compile(t.Re); e != nil {
+				return invalidGrammarError("token %q has invalid regular expression: %s", t.Name, e)
+			}
+		}
+	}
+
+	for _, n := range g.Nodes {
+		if !inRange(n.FirstState, len(g.States)) {
+			return invalidGrammarError("node %q has wrong first state %d", n.Name, n.FirstState)
+		}
+	}
+
+	for i, s := range g.States {
+		if !isSubRange(s.LowMultiRule, s.HighMultiRule, len(g.MultiRules)) {
+			return invalidGrammarError("state #%d has wrong multi-rule range %d:%d", i, s.LowMultiRule, s.HighMultiRule)
+		}
+		if !isSubRange(s.LowRule, s.HighRule, len(g.Rules)) {
+			return invalidGrammarError("state #%d has wrong rule range %d:%d", i, s.LowRule, s.HighRule)
+		}
+	}
+
+	for i, mr := range g.MultiRules {
+		if !inRange(mr.Token, len(g.Tokens)) {
+			return invalidGrammarError("multi-rule #%d has wrong token %d", i, mr.Token)
+		}
+		if !isSubRange(mr.LowRule, mr.HighRule, len(g.Rules)) {
+			return invalidGrammarError("multi-rule #%d has wrong rule range %d:%d", i, mr.LowRule, mr.HighRule)
+		}
+	}
+
+	for i, r := range g.Rules {
+		if r.Token != AnyToken && !inRange(r.Token, len(g.Tokens)) {
+			return invalidGrammarError("rule #%d has wrong token %d", i, r.Token)
+		}
+		if r.State != FinalState && !inRange(r.State, len(g.States)) {
+			return invalidGrammarError("rule #%d has wrong state %d", i, r.State)
+		}
+		if r.Node != SameNode && !inRange(r.Node, len(g.Nodes)) {
+			return invalidGrammarError("rule #%d has wrong node %d", i, r.Node)
+		}
+	}
+
+	for i, a := range g.Actions {
+		if a.Name == "" {
+			return invalidGrammarError("action #%d has empty name", i)
+		}
+		if !inRange(a.State, len(g.States)) || (i > 0 && a.State <= g.Actions[i-1].State) {
+			return invalidGrammarError("action %q has wrong state %d", a.Name, a.State)
+		}
+	}
+
+	for i, l := range g.Labels {
+		if l.Name == "" {
+			return invalidGrammarError("label #%d has empty name", i)
+		}
+		if !inRange(l.Rule, len(g.Rules)) || (i > 0 && l.Rule <= g.Labels[i-1].Rule) {
+			return invalidGrammarError("label %q has wrong rule %d", l.Name, l.Rule)
+		}
+	}
+
+	for i, si := range g.Adjacent {
+		if !inRange(si, len(g.States)) || (i > 0 && si <= g.Adjacent[i-1]) {
+			return invalidGrammarError("adjacency entry #%d has wrong state %d", i, si)
+		}
+	}
+
+	for i, ep := range g.ErrorProductions {
+		if ep.Message == "" {
+			return invalidGrammarError("error production #%d has empty message", i)
+		}
+		if !inRange(ep.State, len(g.States)) || (i > 0 && ep.State <= g.ErrorProductions[i-1].State) {
+			return invalidGrammarError("error production #%d has wrong state %d", i, ep.State)
+		}
+	}
+
+	for i, nt := range g.NestedTokens {
+		if !inRange(nt.Token, len(g.Tokens)) || (i > 0 && nt.Token <= g.NestedTokens[i-1].Token) ||
+			g.Tokens[nt.Token].Flags&LiteralToken != 0 {
+			return invalidGrammarError("nested token #%d has wrong token %d", i, nt.Token)
+		}
+		if nt.Open == "" || nt.Close == "" || nt.Open == nt.Close {
+			return invalidGrammarError("nested token #%d has wrong delimiters %q and %q", i, nt.Open, nt.Close)
+		}
+	}
+
+	for i, ng := range g.NodeGroups {
+		if !inRange(ng.Node, len(g.Nodes)) || (i > 0 && ng.Node <= g.NodeGroups[i-1].Node) {
+			return invalidGrammarError("node group #%d has wrong node %d", i, ng.Node)
+		}
+		if ng.Group < 0 || ng.Group > maxGroup {
+			return invalidGrammarError("node group #%d has wrong group %d", i, ng.Group)
+		}
+	}
+
+	return nil
+}
+
+func inRange(i, size int) bool {
+	return i >= 0 && i < size
+}
+
+func isSubRange(low, high, size int) bool {
+	return low >= 0 && low <= high && high <= size
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) int(v int) {
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *binaryWriter) count(n int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(n))
+}
+
+func (w *binaryWriter) string(s string) {
+	w.count(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) bitSet(bs BitSet) {
+	w.count(len(bs))
+	for _, word := range bs {
+		w.buf = binary.AppendUvarint(w.buf, word)
+	}
+}
+
+func (w *binaryWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func marshalBinary(g *Grammar) []byte {
+	w := &binaryWriter{append([]byte(nil), binaryMagic...)}
+	w.count(FormatVersion)
+	w.grammar(g)
+	return w.buf
+}
+
+func (w *binaryWriter) grammar(g *Grammar) {
+	w.count(len(g.Tokens))
+	for _, t := range g.Tokens {
+		w.string(t.Name)
+		w.string(t.Re)
+		w.int(t.Group)
+		w.int(int(t.Flags))
+		w.string(t.Decoder)
+	}
+
+	w.count(len(g.Nodes))
+	for _, n := range g.Nodes {
+		w.string(n.Name)
+		w.int(n.FirstState)
+		w.bitSet(n.FirstTokens)
+		w.int(int(n.Flags))
+	}
+
+	w.count(len(g.States))
+	for _, s := range g.States {
+		w.bitSet(s.TokenTypes)
+		w.int(s.LowMultiRule)
+		w.int(s.HighMultiRule)
+		w.int(s.LowRule)
+		w.int(s.HighRule)
+		w.bool(s.Caseless)
+	}
+
+	w.count(len(g.MultiRules))
+	for _, mr := range g.MultiRules {
+		w.int(mr.Token)
+		w.int(mr.LowRule)
+		w.int(mr.HighRule)
+	}
+
+	w.count(len(g.Rules))
+	for _, r := range g.Rules {
+		w.int(r.Token)
+		w.int(r.State)
+		w.int(r.Node)
+	}
+
+	w.count(len(g.Actions))
+	for _, a := range g.Actions {
+		w.string(a.Name)
+		w.int(a.State)
+	}
+
+	w.count(len(g.Labels))
+	for _, l := range g.Labels {
+		w.string(l.Name)
+		w.int(l.Rule)
+	}
+
+	w.count(len(g.Adjacent))
+	for _, si := range g.Adjacent {
+		w.int(si)
+	}
+
+	w.count(len(g.ErrorProductions))
+	for _, ep := range g.ErrorProductions {
+		w.string(ep.Message)
+		w.int(ep.State)
+	}
+
+	w.count(len(g.NestedTokens))
+	for _, nt := range g.NestedTokens {
+		w.int(nt.Token)
+		w.string(nt.Open)
+		w.string(nt.Close)
+	}
+
+	w.count(len(g.NodeGroups))
+	for _, ng := range g.NodeGroups {
+		w.int(ng.Node)
+		w.int(ng.Group)
+	}
+}
+
+type binaryReader struct {
+	data   []byte
+	failed bool
+}
+
+func (r *binaryReader) uint() uint64 {
+	if r.failed {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.failed = true
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) int() int {
+	if r.failed {
+		return 0
+	}
+
+	v, n := binary.Varint(r.data)
+	if n <= 0 || int64(int(v)) != v {
+		r.failed = true
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return int(v)
+}
+
+// count reads the number of following items, each item taking at least one byte.
+func (r *binaryReader) count() int {
+	v := r.uint()
+	if v > uint64(len(r.data)) {
+		r.failed = true
+		return 0
+	}
+
+	return int(v)
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	if r.failed {
+		return ""
+	}
+
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *binaryReader) bitSet() BitSet {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+
+	bs := make(BitSet, n)
+	for i := range bs {
+		bs[i] = r.uint()
+	}
+	return bs
+}
+
+func (r *binaryReader) bool() bool {
+	v := r.uint()
+	if v > 1 {
+		r.failed = true
+	}
+	return v == 1
+}
+
+func unmarshalBinary(data []byte) (*Grammar, error) {
+	r := &binaryReader{data: data}
+	version := r.uint()
+	if r.failed {
+		return nil, wrongFormatError("truncated binary data")
+	}
+	if version != FormatVersion {
+		return nil, unsupportedVersionError(int(version))
+	}
+
+	g := &Grammar{}
+	if n := r.count(); n > 0 {
+		g.Tokens = make([]Token, n)
+		for i := range g.Tokens {
+			g.Tokens[i] = Token{r.string(), r.string(), r.int(), TokenFlags(r.int()), r.string()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.Nodes = make([]Node, n)
+		for i := range g.Nodes {
+			g.Nodes[i] = Node{r.string(), r.int(), r.bitSet(), NodeFlags(r.int())}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.States = make([]State, n)
+		for i := range g.States {
+			g.States[i] = State{
+				TokenTypes:    r.bitSet(),
+				LowMultiRule:  r.int(),
+				HighMultiRule: r.int(),
+				LowRule:       r.int(),
+				HighRule:      r.int(),
+				Caseless:      r.bool(),
+			}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.MultiRules = make([]MultiRule, n)
+		for i := range g.MultiRules {
+			g.MultiRules[i] = MultiRule{r.int(), r.int(), r.int()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.Rules = make([]Rule, n)
+		for i := range g.Rules {
+			g.Rules[i] = Rule{r.int(), r.int(), r.int()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.Actions = make([]Action, n)
+		for i := range g.Actions {
+			g.Actions[i] = Action{r.string(), r.int()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.Labels = make([]Label, n)
+		for i := range g.Labels {
+			g.Labels[i] = Label{r.string(), r.int()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.Adjacent = make([]int, n)
+		for i := range g.Adjacent {
+			g.Adjacent[i] = r.int()
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.ErrorProductions = make([]ErrorProduction, n)
+		for i := range g.ErrorProductions {
+			g.ErrorProductions[i] = ErrorProduction{r.string(), r.int()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.NestedTokens = make([]NestedToken, n)
+		for i := range g.NestedTokens {
+			g.NestedTokens[i] = NestedToken{r.int(), r.string(), r.string()}
+		}
+	}
+
+	if n := r.count(); n > 0 {
+		g.NodeGroups = make([]NodeGroup, n)
+		for i := range g.NodeGroups {
+			g.NodeGroups[i] = NodeGroup{r.int(), r.int()}
+		}
+	}
+
+	if r.failed {
+		return nil, wrongFormatError("truncated or malformed binary data")
+	}
+	if len(r.data) > 0 {
+		return nil, wrongFormatError("%d extra bytes after binary data", len(r.data))
+	}
+
+	return g, nil
+}
+
+func wrongFormatError(msg string, params ...any) *llx.Error {
+	return llx.FormatError(WrongFormatError, "cannot load grammar: "+msg, params...)
+}
+
+func unsupportedVersionError(version int) *llx.Error {
+	return llx.FormatError(UnsupportedVersionError, "cannot load grammar: unsupported format version %d, expecting %d", version, FormatVersion)
+}
+
+func invalidGrammarError(msg string, params ...any) *llx.Error {
+	return llx.FormatError(InvalidGrammarError, "invalid grammar: "+msg, params...)
+}
@@ -0,0 +1,149 @@
+package grammar
+
+import (
+	"testing"
+
+	"github.com/ava12/llx"
+)
+
+func testGrammar() *Grammar {
+	return &Grammar{
+		Tokens: []Token{
+			{Name: "space", Re: "\\s+", Flags: AsideToken},
+			{Name: "name", Re: "\\w+", Group: 1, Flags: CaselessToken, Decoder: "int"},
+			{Name: "end", Flags: ExternalToken},
+			{Name: "foo", Flags: LiteralToken | ReservedToken},
+		},
+		Nodes: []Node{{"root", 0, NewBitSet(1, 3), 0}, {"item", 1, NewBitSet(1, 70), InlineNode}},
+		States: []State{
+			{NewBitSet(1, 3), 0, 1, 1, 2, false},
+			{NewBitSet(2), 0, 0, 2, 3, true},
+		},
+		MultiRules:       []MultiRule{{1, 0, 1}},
+		Rules:            []Rule{{1, FinalState, 1}, {AnyToken, FinalState, SameNode}, {2, FinalState, SameNode}},
+		Actions:          []Action{{"done", 1}},
+		Labels:           []Label{{"item", 0}},
+		Adjacent:         []int{0},
+		ErrorProductions: []ErrorProduction{{"oops", 1}},
+		NestedTokens:     []NestedToken{{0, "(*", "*)"}},
+		NodeGroups:       []NodeGroup{{1, 1}},
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	expected, _ := CanonicalJSON(testGrammar())
+	for _, f := range []Format{JSONFormat, BinaryFormat} {
+		data, e := Marshal(testGrammar(), f)
+		if e != nil {
+			t.Errorf("format %d: unexpected error: %s", f, e)
+			continue
+		}
+
+		g, e := Unmarshal(data)
+		if e != nil {
+			t.Errorf("format %d: unexpected error: %s", f, e)
+			continue
+		}
+
+		got, _ := CanonicalJSON(g)
+		if string(got) != string(expected) {
+			t.Errorf("format %d: expecting:\n%s\ngot:\n%s", f, expected, got)
+		}
+	}
+
+	_, e := Marshal(testGrammar(), Format(10))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != WrongFormatError {
+		t.Errorf("expecting WrongFormatError, got %v", e)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	bin, _ := Marshal(testGrammar(), BinaryFormat)
+	samples := []struct {
+		data string
+		err  int
+	}{
+		{"", WrongFormatError},
+		{"{}", WrongFormatError},
+		{"{\"Version\": 1}", WrongFormatError},
+		{"{\"Version\": 2, \"Grammar\": {}}", UnsupportedVersionError},
+		{"{\"Version\": 1, \"Grammar\": {}}", InvalidGrammarError},
+		{"{\"Version\": 1, \"Grammar\": {\"Nodes\": [{\"Name\": \"g\", \"FirstState\": 0}]}}", InvalidGrammarError},
+		{"{\"Version\": 1, \"Grammar\": {\"Tokens\": [{\"Name\": \"t\", \"Re\": \"(\"}], \"Nodes\": [{\"Name\": \"g\"}], \"States\": [{}]}}", InvalidGrammarError},
+		{"LLXG", WrongFormatError},
+		{"LLXG\x02", UnsupportedVersionError},
+		{string(bin[:len(bin)-1]), WrongFormatError},
+		{string(bin) + "\x00", WrongFormatError},
+		{"LLXG\x01\x00\x01\x01g\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", InvalidGrammarError},
+	}
+
+	for i, sample := range samples {
+		g, e := Unmarshal([]byte(sample.data))
+		ee, valid := e.(*llx.Error)
+		if g != nil || !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if e := Validate(testGrammar()); e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	breakers := []func(g *Grammar){
+		func(g *Grammar) { g.Tokens[0].Name = "" },
+		func(g *Grammar) { g.Tokens[1].Group = -1 },
+		func(g *Grammar) { g.Nodes[1].FirstState = 2 },
+		func(g *Grammar) { g.States[0].HighMultiRule = 2 },
+		func(g *Grammar) { g.States[1].HighRule = 4 },
+		func(g *Grammar) { g.MultiRules[0].Token = AnyToken },
+		func(g *Grammar) { g.MultiRules[0].HighRule = 4 },
+		func(g *Grammar) { g.Rules[0].Token = 4 },
+		func(g *Grammar) { g.Rules[1].State = 2 },
+		func(g *Grammar) { g.Rules[2].Node = -2 },
+		func(g *Grammar) { g.Actions[0].Name = "" },
+		func(g *Grammar) { g.Actions[0].State = 2 },
+		func(g *Grammar) { g.Actions = append(g.Actions, Action{"again", 1}) },
+		func(g *Grammar) { g.Labels[0].Name = "" },
+		func(g *Grammar) { g.Labels[0].Rule = 3 },
+		func(g *Grammar) { g.Labels = append(g.Labels, Label{"again", 0}) },
+		func(g *Grammar) { g.Adjacent[0] = 2 },
+		func(g *Grammar) { g.Adjacent = append(g.Adjacent, 0) },
+		func(g *Grammar) { g.ErrorProductions[0].Message = "" },
+		func(g *Grammar) { g.ErrorProductions[0].State = 2 },
+		func(g *Grammar) { g.NestedTokens[0].Token = 3 },
+		func(g *Grammar) { g.NestedTokens[0].Close = "(*" },
+		func(g *Grammar) { g.NestedTokens = append(g.NestedTokens, NestedToken{0, "{", "}"}) },
+		func(g *Grammar) { g.NodeGroups[0].Node = 2 },
+		func(g *Grammar) { g.NodeGroups[0].Group = 2 },
+		func(g *Grammar) { g.NodeGroups = append(g.NodeGroups, NodeGroup{1, 0}) },
+	}
+	for i, breaker := range breakers {
+		g := testGrammar()
+		breaker(g)
+		e := Validate(g)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != InvalidGrammarError {
+			t.Errorf("sample #%d: expecting InvalidGrammarError, got %v", i, e)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	h := Hash(testGrammar())
+	if len(h) != 64 {
+		t.Fatalf("unexpected hash: %q", h)
+	}
+
+	data, _ := Marshal(testGrammar(), JSONFormat)
+	g, _ := Unmarshal(data)
+	if Hash(g) != h {
+		t.Errorf("expecting %s for unmarshalled grammar, got %s", h, Hash(g))
+	}
+
+	g.Rules[0].State = 1
+	if Hash(g) == h {
+		t.Error("expecting different hash for modified grammar")
+	}
+}
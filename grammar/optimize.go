@@ -0,0 +1,296 @@
+package grammar
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stats contains sizes of grammar tables.
+type Stats struct {
+	States, MultiRules, Rules int
+}
+
+// StatsOf returns sizes of grammar tables.
+func StatsOf(g *Grammar) Stats {
+	return Stats{len(g.States), len(g.MultiRules), len(g.Rules)}
+}
+
+// OptimizeStats contains sizes of grammar tables before and after optimization.
+type OptimizeStats struct {
+	Before, After Stats
+}
+
+// Optimize returns a grammar equivalent to g having smaller tables: states unreachable from initial node states
+// are removed, equivalent states of the same node (i.e. states having the same token types, caseless flag,
+// action, adjacency flag, error production message, and rules having the same labels and leading to equivalent states) are merged, and identical rule and multi-rule sub-slices are shared
+// among states. Tokens and nodes keep their indexes, states stay grouped by node.
+// Source grammar is not modified, the result shares no slices with it.
+func Optimize(g *Grammar) (*Grammar, OptimizeStats) {
+	o := &optimizer{g: g, actions: make(map[int]string), labels: make(map[int]string)}
+	for _, a := range g.Actions {
+		o.actions[a.State] = a.Name
+	}
+	for _, l := range g.Labels {
+		o.labels[l.Rule] = l.Name
+	}
+	o.adjacent = make(map[int]bool, len(g.Adjacent))
+	for _, si := range g.Adjacent {
+		o.adjacent[si] = true
+	}
+	o.errors = make(map[int]string, len(g.ErrorProductions))
+	for _, ep := range g.ErrorProductions {
+		o.errors[ep.State] = ep.Message
+	}
+	o.findOwners()
+	o.findReachable()
+	o.mergeStates()
+	res := o.build()
+	return res, OptimizeStats{StatsOf(g), StatsOf(res)}
+}
+
+type optimizer struct {
+	g         *Grammar
+	owners    []int
+	reachable []bool
+	classes   []int
+	actions   map[int]string
+	labels    map[int]string
+	adjacent  map[int]bool
+	errors    map[int]string
+}
+
+// findOwners assigns each state to the node whose state group contains it.
+func (o *optimizer) findOwners() {
+	nodes := make([]int, len(o.g.Nodes))
+	for i := range nodes {
+		nodes[i] = i
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return o.g.Nodes[nodes[i]].FirstState < o.g.Nodes[nodes[j]].FirstState
+	})
+
+	o.owners = make([]int, len(o.g.States))
+	owner := -1
+	for si := range o.owners {
+		for len(nodes) > 0 && o.g.Nodes[nodes[0]].FirstState <= si {
+			owner = nodes[0]
+			nodes = nodes[1:]
+		}
+		o.owners[si] = owner
+	}
+}
+
+// rules returns all rules of a state: single rules go first, then rules of multi-rules.
+func (o *optimizer) rules(si int) []Rule {
+	s := o.g.States[si]
+	res := append([]Rule{}, o.g.Rules[s.LowRule:s.HighRule]...)
+	for _, mr := range o.g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+		res = append(res, o.g.Rules[mr.LowRule:mr.HighRule]...)
+	}
+	return res
+}
+
+func (o *optimizer) findReachable() {
+	o.reachable = make([]bool, len(o.g.States))
+	var queue []int
+	for _, n := range o.g.Nodes {
+		if !o.reachable[n.FirstState] {
+			o.reachable[n.FirstState] = true
+			queue = append(queue, n.FirstState)
+		}
+	}
+
+	for len(queue) > 0 {
+		si := queue[0]
+		queue = queue[1:]
+		for _, r := range o.rules(si) {
+			if r.State >= 0 && !o.reachable[r.State] {
+				o.reachable[r.State] = true
+				queue = append(queue, r.State)
+			}
+		}
+	}
+}
+
+// stateKey describes state using current classes of target states, classes == nil means a single class.
+func (o *optimizer) stateKey(si int, classes []int) string {
+	var b strings.Builder
+	s := o.g.States[si]
+	b.WriteString(strconv.Itoa(o.owners[si]))
+	if classes != nil {
+		b.WriteString(" " + strconv.Itoa(classes[si]))
+	}
+	b.WriteString(" " + strconv.FormatBool(s.Caseless) + " " + strconv.Quote(o.actions[si]) + " " +
+		strconv.FormatBool(o.adjacent[si]) + " " + strconv.Quote(o.errors[si]) + " [")
+	for _, w := range s.TokenTypes {
+		b.WriteString(strconv.FormatUint(w, 16) + " ")
+	}
+	b.WriteString("]")
+
+	writeRules := func(low, high int) {
+		for ri, r := range o.g.Rules[low:high] {
+			state := r.State
+			if state >= 0 {
+				state = 0
+				if classes != nil {
+					state = classes[r.State]
+				}
+			}
+			b.WriteString(" " + strconv.Itoa(r.Token) + ":" + strconv.Itoa(state) + ":" + strconv.Itoa(r.Node))
+			if name, has := o.labels[low+ri]; has {
+				b.WriteString(":" + strconv.Quote(name))
+			}
+		}
+	}
+	writeRules(s.LowRule, s.HighRule)
+	for _, mr := range o.g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+		b.WriteString(" {" + strconv.Itoa(mr.Token))
+		writeRules(mr.LowRule, mr.HighRule)
+		b.WriteString("}")
+	}
+	return b.String()
+}
+
+// mergeStates splits reachable states into classes of equivalent states refining partition until it is stable.
+func (o *optimizer) mergeStates() {
+	var classes []int
+	count := -1
+	for {
+		keys := make(map[string]int)
+		next := make([]int, len(o.g.States))
+		for si := range next {
+			next[si] = -1
+			if !o.reachable[si] {
+				continue
+			}
+
+			key := o.stateKey(si, classes)
+			class, has := keys[key]
+			if !has {
+				class = len(keys)
+				keys[key] = class
+			}
+			next[si] = class
+		}
+
+		classes = next
+		if len(keys) == count {
+			break
+		}
+		count = len(keys)
+	}
+	o.classes = classes
+}
+
+func (o *optimizer) build() *Grammar {
+	g := o.g
+	res := &Grammar{
+		Tokens:       append([]Token{}, g.Tokens...),
+		Nodes:        append([]Node{}, g.Nodes...),
+		NestedTokens: append([]NestedToken(nil), g.NestedTokens...),
+		NodeGroups:   append([]NodeGroup(nil), g.NodeGroups...),
+	}
+
+	classStates := make(map[int]int)
+	newIndexes := make([]int, len(g.States))
+	var reprs []int
+	for si, class := range o.classes {
+		if class < 0 {
+			continue
+		}
+
+		ni, has := classStates[class]
+		if !has {
+			ni = len(reprs)
+			classStates[class] = ni
+			reprs = append(reprs, si)
+		}
+		newIndexes[si] = ni
+	}
+
+	for i, n := range res.Nodes {
+		res.Nodes[i].FirstState = newIndexes[n.FirstState]
+		res.Nodes[i].FirstTokens = append(BitSet(nil), n.FirstTokens...)
+	}
+
+	ruleSlices := make(map[string]int)
+	labels := make(map[int]string)
+	addRules := func(oldLow, oldHigh int) (low, high int) {
+		rs := g.Rules[oldLow:oldHigh]
+		if len(rs) == 0 {
+			return 0, 0
+		}
+
+		mapped := make([]Rule, len(rs))
+		key := make([]string, len(rs))
+		for i, r := range rs {
+			if r.State >= 0 {
+				r.State = newIndexes[r.State]
+			}
+			mapped[i] = r
+			key[i] = strconv.Itoa(r.Token) + ":" + strconv.Itoa(r.State) + ":" + strconv.Itoa(r.Node)
+			if name, has := o.labels[oldLow+i]; has {
+				key[i] += ":" + strconv.Quote(name)
+			}
+		}
+		k := strings.Join(key, " ")
+		if low, has := ruleSlices[k]; has {
+			return low, low + len(rs)
+		}
+
+		low = len(res.Rules)
+		res.Rules = append(res.Rules, mapped...)
+		ruleSlices[k] = low
+		for i := range rs {
+			if name, has := o.labels[oldLow+i]; has {
+				labels[low+i] = name
+			}
+		}
+		return low, low + len(rs)
+	}
+
+	multiSlices := make(map[string]int)
+	res.States = make([]State, len(reprs))
+	for i, si := range reprs {
+		s := g.States[si]
+		ns := State{TokenTypes: append(BitSet(nil), s.TokenTypes...), Caseless: s.Caseless}
+		ns.LowRule, ns.HighRule = addRules(s.LowRule, s.HighRule)
+
+		mrs := make([]MultiRule, 0, s.HighMultiRule-s.LowMultiRule)
+		key := make([]string, 0, cap(mrs))
+		for _, mr := range g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+			low, high := addRules(mr.LowRule, mr.HighRule)
+			mrs = append(mrs, MultiRule{mr.Token, low, high})
+			key = append(key, strconv.Itoa(mr.Token)+":"+strconv.Itoa(low)+":"+strconv.Itoa(high))
+		}
+		if len(mrs) > 0 {
+			k := strings.Join(key, " ")
+			low, has := multiSlices[k]
+			if !has {
+				low = len(res.MultiRules)
+				res.MultiRules = append(res.MultiRules, mrs...)
+				multiSlices[k] = low
+			}
+			ns.LowMultiRule, ns.HighMultiRule = low, low+len(mrs)
+		}
+
+		res.States[i] = ns
+		if name, has := o.actions[si]; has {
+			res.Actions = append(res.Actions, Action{name, i})
+		}
+		if o.adjacent[si] {
+			res.Adjacent = append(res.Adjacent, i)
+		}
+		if msg, has := o.errors[si]; has {
+			res.ErrorProductions = append(res.ErrorProductions, ErrorProduction{msg, i})
+		}
+	}
+
+	for ri := range res.Rules {
+		if name, has := labels[ri]; has {
+			res.Labels = append(res.Labels, Label{name, ri})
+		}
+	}
+	return res
+}
@@ -0,0 +1,124 @@
+package grammar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	g := &Grammar{
+		Tokens: []Token{{Name: "a", Re: "a"}, {Name: "b", Re: "b"}},
+		Nodes:  []Node{{Name: "root", FirstState: 0}},
+		States: []State{
+			{NewBitSet(0, 1), 0, 0, 0, 2, false},
+			{NewBitSet(1), 0, 0, 2, 3, false},
+			{NewBitSet(1), 0, 0, 3, 4, false},
+			{nil, 0, 0, 4, 5, false},
+			{nil, 0, 0, 5, 6, false},
+			{NewBitSet(0), 0, 0, 6, 7, false},
+		},
+		Rules: []Rule{
+			{0, 1, SameNode}, {1, 2, SameNode},
+			{1, 3, SameNode},
+			{1, 4, SameNode},
+			{AnyToken, FinalState, SameNode},
+			{AnyToken, FinalState, SameNode},
+			{0, 5, SameNode},
+		},
+	}
+
+	res, stats := Optimize(g)
+	expected := &Grammar{
+		Tokens: g.Tokens,
+		Nodes:  []Node{{Name: "root", FirstState: 0}},
+		States: []State{
+			{NewBitSet(0, 1), 0, 0, 0, 2, false},
+			{NewBitSet(1), 0, 0, 2, 3, false},
+			{nil, 0, 0, 3, 4, false},
+		},
+		Rules: []Rule{
+			{0, 1, SameNode}, {1, 1, SameNode},
+			{1, 2, SameNode},
+			{AnyToken, FinalState, SameNode},
+		},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expecting %v, got %v", expected, res)
+	}
+
+	expectedStats := OptimizeStats{Stats{6, 0, 7}, Stats{3, 0, 4}}
+	if stats != expectedStats {
+		t.Errorf("expecting stats %v, got %v", expectedStats, stats)
+	}
+	if e := Validate(res); e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+}
+
+func TestOptimizeActions(t *testing.T) {
+	g := &Grammar{
+		Tokens: []Token{{Name: "a", Re: "a"}, {Name: "b", Re: "b"}},
+		Nodes:  []Node{{Name: "root", FirstState: 0}},
+		States: []State{
+			{NewBitSet(0, 1), 0, 0, 0, 2, false},
+			{NewBitSet(1), 0, 0, 2, 3, false},
+			{NewBitSet(1), 0, 0, 3, 4, false},
+			{nil, 0, 0, 4, 5, false},
+			{nil, 0, 0, 5, 6, false},
+			{nil, 0, 0, 6, 7, false},
+		},
+		Rules: []Rule{
+			{0, 1, SameNode}, {1, 2, SameNode},
+			{1, 3, SameNode},
+			{1, 4, SameNode},
+			{AnyToken, FinalState, SameNode},
+			{AnyToken, FinalState, SameNode},
+			{AnyToken, FinalState, SameNode},
+		},
+		Actions: []Action{{"x", 4}, {"y", 5}},
+	}
+
+	res, _ := Optimize(g)
+	if len(res.States) != 5 {
+		t.Errorf("expecting 5 states, got %d", len(res.States))
+	}
+	expected := []Action{{"x", 4}}
+	if !reflect.DeepEqual(res.Actions, expected) {
+		t.Errorf("expecting actions %v, got %v", expected, res.Actions)
+	}
+	if e := Validate(res); e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+}
+
+func TestOptimizeLabels(t *testing.T) {
+	g := &Grammar{
+		Tokens: []Token{{Name: "a", Re: "a"}, {Name: "b", Re: "b"}, {Name: "c", Re: "c"}},
+		Nodes:  []Node{{Name: "root", FirstState: 0}},
+		States: []State{
+			{NewBitSet(0, 1, 2), 0, 0, 0, 3, false},
+			{NewBitSet(1), 0, 0, 3, 4, false},
+			{NewBitSet(1), 0, 0, 4, 5, false},
+			{NewBitSet(1), 0, 0, 5, 6, false},
+		},
+		Rules: []Rule{
+			{0, 1, SameNode}, {1, 2, SameNode}, {2, 3, SameNode},
+			{1, FinalState, SameNode},
+			{1, FinalState, SameNode},
+			{1, FinalState, SameNode},
+		},
+		Labels: []Label{{"x", 3}, {"x", 4}},
+	}
+
+	res, _ := Optimize(g)
+	if len(res.States) != 3 {
+		t.Errorf("expecting 3 states, got %d", len(res.States))
+	}
+	expected := []Label{{"x", 3}}
+	if !reflect.DeepEqual(res.Labels, expected) {
+		t.Errorf("expecting labels %v, got %v", expected, res.Labels)
+	}
+	if e := Validate(res); e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+}
@@ -6,6 +6,7 @@ type Queue[T any] struct {
 	items      []T
 	size       int
 	head, tail int
+	reserved   int
 	zero       T
 }
 
@@ -62,8 +63,11 @@ func (q *Queue[T]) First() (T, bool) {
 	q.items[q.head] = q.zero
 	q.head = (q.head + 1) & q.size
 
-	if q.head == 0 && q.size > minSize && (q.tail<<2) <= q.size {
+	if q.head == 0 && q.size > minSize && q.size > q.reserved && (q.tail<<2) <= q.size {
 		q.size = computeSize(q.tail << 1)
+		if q.size < q.reserved {
+			q.size = q.reserved
+		}
 		items := make([]T, q.size+1)
 		copy(items, q.items[:q.tail])
 		q.items = items
@@ -88,10 +92,32 @@ func (q *Queue[T]) Fill(items []T) {
 	q.head = 0
 	q.tail = l
 	q.size = computeSize(l)
+	if q.size < q.reserved {
+		q.size = q.reserved
+	}
 	q.items = make([]T, q.size+1)
 	copy(q.items, items)
 }
 
+func (q *Queue[T]) Reserve(capacity int) *Queue[T] {
+	if capacity <= 0 {
+		q.reserved = 0
+		return q
+	}
+
+	q.reserved = computeSize(capacity)
+	if q.reserved > q.size {
+		l := q.Len()
+		items := make([]T, q.reserved+1)
+		copy(items, q.Items())
+		q.items = items
+		q.head = 0
+		q.tail = l
+		q.size = q.reserved
+	}
+	return q
+}
+
 func (q *Queue[T]) Clear() {
 	q.Fill(make([]T, 0))
 }
@@ -311,3 +311,29 @@ func TestFill(t *testing.T) {
 		})
 	}
 }
+
+func TestReserve(t *testing.T) {
+	q := New[int](1, 2)
+	q.First()
+	q.Reserve(10)
+	ExpectInt(t, 15, q.size)
+	ExpectInt(t, 1, q.Len())
+	v, _ := q.First()
+	ExpectInt(t, 2, v)
+
+	for i := 0; i < 15; i++ {
+		q.Append(i)
+	}
+	ExpectInt(t, 15, q.size)
+	for i := 0; i < 15; i++ {
+		q.First()
+	}
+	ExpectInt(t, 15, q.size)
+
+	q.Clear()
+	ExpectInt(t, 15, q.size)
+
+	q.Reserve(0)
+	q.Clear()
+	ExpectInt(t, minSize, q.size)
+}
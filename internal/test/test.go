@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"testing"
@@ -38,3 +39,26 @@ func ExpectBool(t *testing.T, expected, got bool) {
 func ExpectInt(t *testing.T, expected, got int) {
 	Expect(t, expected == got, expected, got)
 }
+
+// CountdownContext is a context that becomes canceled after its Err method is called n times.
+type CountdownContext struct {
+	context.Context
+	n    int
+	done chan struct{}
+}
+
+func NewCountdownContext(n int) *CountdownContext {
+	return &CountdownContext{context.Background(), n, make(chan struct{})}
+}
+
+func (c *CountdownContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *CountdownContext) Err() error {
+	c.n--
+	if c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package langdef
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/internal/ints"
+	"github.com/ava12/llx/source"
+)
+
+// DiagnosticKind classifies grammar diagnostics reported by Analyze.
+type DiagnosticKind int
+
+const (
+	// UnreachableNode: node cannot be reached from the root node.
+	UnreachableNode DiagnosticKind = iota + 1
+	// AmbiguousRules: several rules match the same token at some state of a node,
+	// parser has to look ahead to choose one of them.
+	AmbiguousRules
+	// ShadowedToken: token type regexp matches text that is captured by some preceding token type of the same group.
+	ShadowedToken
+	// AmbiguousLiteral: string literal matches regexps of several token types.
+	AmbiguousLiteral
+	// FirstFollowConflict: optional or repeated part of a node may start with a token that may also follow it,
+	// parser always prefers the optional part.
+	FirstFollowConflict
+)
+
+// Diagnostic describes possible problem in grammar description.
+type Diagnostic struct {
+	// Kind is the diagnostic kind.
+	Kind DiagnosticKind
+
+	// Name is the node name, token type name prefixed with $, or quoted literal the diagnostic is related to.
+	Name string
+
+	// Message describes the problem.
+	Message string
+
+	// Pos is the position of node or token type definition, zero value for literals.
+	Pos source.Pos
+}
+
+// String returns diagnostic message including source name and position if known.
+func (d Diagnostic) String() string {
+	if d.Pos.Source() == nil {
+		return d.Message
+	}
+	return fmt.Sprintf("%s in %s at line %d col %d", d.Message, d.Pos.SourceName(), d.Pos.Line(), d.Pos.Col())
+}
+
+// Report contains the results of grammar analysis.
+type Report struct {
+	// Grammar is the resulting grammar, nil if grammar contains unreachable nodes.
+	Grammar *grammar.Grammar
+
+	// SourceMap lists token type and node definitions.
+	SourceMap *SourceMap
+
+	// Diagnostics lists found problems (soft warnings), ordered by kind.
+	Diagnostics []Diagnostic
+}
+
+// Analyze parses grammar description and looks for possible problems that do not prevent building a grammar:
+// ambiguous rules, shadowed token types, literals matching several token types, and FIRST/FOLLOW conflicts.
+// Unreachable nodes are reported as diagnostics too, in this case no other checks are performed.
+// Returns nil and llx.Error if grammar description contains errors.
+func Analyze(s *source.Source) (*Report, error) {
+	result, e := parseLangDef(s)
+	if e != nil {
+		return nil, e
+	}
+
+	e = prepareNodes(result, e)
+	if e != nil {
+		return nil, e
+	}
+
+	a := &analyzer{report: &Report{SourceMap: result.SMap}, defs: make(map[string]source.Pos)}
+	for _, d := range result.SMap.Nodes {
+		a.defs[d.Name] = d.Pos
+	}
+	for _, d := range result.SMap.Tokens {
+		a.defs["$"+d.Name] = d.Pos
+	}
+
+	unreached := unreachableNodes(result.Nodes, result.NIndex)
+	if !unreached.IsEmpty() {
+		for _, name := range nodeNames(result.Nodes, unreached) {
+			a.add(UnreachableNode, name, "node %q cannot be reached from root node", name)
+		}
+		return a.report, nil
+	}
+
+	g, e := completeGrammar(result, e)
+	if e != nil {
+		return nil, e
+	}
+
+	a.g = g
+	a.report.Grammar = g
+	a.findShadowedTokens()
+	a.findAmbiguousLiterals()
+	a.findAmbiguousRules()
+	a.findFirstFollowConflicts()
+	sort.SliceStable(a.report.Diagnostics, func(i, j int) bool {
+		return a.report.Diagnostics[i].Kind < a.report.Diagnostics[j].Kind
+	})
+	return a.report, nil
+}
+
+type analyzer struct {
+	g      *grammar.Grammar
+	report *Report
+	defs   map[string]source.Pos
+	follow []*ints.Set
+}
+
+func (a *analyzer) add(kind DiagnosticKind, name, msg string, params ...any) {
+	a.report.Diagnostics = append(a.report.Diagnostics, Diagnostic{kind, name, fmt.Sprintf(msg, params...), a.defs[name]})
+}
+
+func (a *analyzer) tokenName(i int) string {
+	t := a.g.Tokens[i]
+	if t.Flags&grammar.LiteralToken != 0 {
+		return strconv.Quote(t.Name)
+	}
+	return "$" + t.Name
+}
+
+func (a *analyzer) tokenNames(ts *ints.Set) string {
+	names := make([]string, 0)
+	for _, t := range ts.ToSlice() {
+		names = append(names, a.tokenName(t))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (a *analyzer) isLexeme(t grammar.Token) bool {
+	return t.Re != "" && t.Flags&(grammar.LiteralToken|grammar.ExternalToken|grammar.ErrorToken) == 0
+}
+
+func (a *analyzer) findShadowedTokens() {
+	res := make([]*regexp.Regexp, len(a.g.Tokens))
+	for i, t := range a.g.Tokens {
+		if a.isLexeme(t) {
+			res[i], _ = regexp.Compile("^(?:" + t.Re + ")")
+		}
+	}
+
+	for i, t := range a.g.Tokens {
+		if res[i] == nil {
+			continue
+		}
+
+		re, e := syntax.Parse(t.Re, syntax.Perl)
+		if e != nil {
+			continue
+		}
+
+		sample, valid := sampleText(re)
+		if !valid || sample == "" {
+			continue
+		}
+
+		for j := 0; j < i; j++ {
+			if res[j] != nil && a.g.Tokens[j].Group == t.Group && len(res[j].FindString(sample)) > 0 {
+				a.add(ShadowedToken, "$"+t.Name, "token type $%s may be shadowed by $%s (e.g. %q)", t.Name, a.g.Tokens[j].Name, sample)
+				break
+			}
+		}
+	}
+}
+
+// sampleText returns some shortest text matching regular expression. Returns false if there is no such text.
+func sampleText(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return "", false
+
+	case syntax.OpLiteral:
+		return string(re.Rune), true
+
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return "", false
+		}
+		return string(re.Rune[0]), true
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "x", true
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return sampleText(re.Sub[0])
+
+	case syntax.OpRepeat:
+		sub, valid := sampleText(re.Sub[0])
+		return strings.Repeat(sub, re.Min), valid
+
+	case syntax.OpConcat:
+		var b strings.Builder
+		for _, sub := range re.Sub {
+			s, valid := sampleText(sub)
+			if !valid {
+				return "", false
+			}
+			b.WriteString(s)
+		}
+		return b.String(), true
+
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			s, valid := sampleText(sub)
+			if valid {
+				return s, true
+			}
+		}
+		return "", false
+	}
+
+	return "", true
+}
+
+func (a *analyzer) findAmbiguousLiterals() {
+	res := make([]*regexp.Regexp, len(a.g.Tokens))
+	for i, t := range a.g.Tokens {
+		if a.isLexeme(t) && t.Flags&(grammar.AsideToken|grammar.NoLiteralsToken) == 0 {
+			res[i], _ = regexp.Compile("^(?:" + t.Re + ")$")
+		}
+	}
+
+	for _, t := range a.g.Tokens {
+		if t.Flags&grammar.LiteralToken == 0 {
+			continue
+		}
+
+		types := ints.NewSet()
+		for i, re := range res {
+			if re != nil && re.MatchString(t.Name) {
+				types.Add(i)
+			}
+		}
+		if len(types.ToSlice()) > 1 {
+			a.add(AmbiguousLiteral, strconv.Quote(t.Name), "literal %q is matched by token types %s", t.Name, a.tokenNames(types))
+		}
+	}
+}
+
+// nodeStates returns the index of node owning each state.
+func (a *analyzer) nodeStates() []int {
+	res := make([]int, len(a.g.States))
+	for i, n := range a.g.Nodes {
+		high := len(a.g.States)
+		if i+1 < len(a.g.Nodes) {
+			high = a.g.Nodes[i+1].FirstState
+		}
+		for s := n.FirstState; s < high; s++ {
+			res[s] = i
+		}
+	}
+	return res
+}
+
+// stateRules returns all rules (including ambiguous ones) for given state.
+func (a *analyzer) stateRules(si int) []grammar.Rule {
+	s := a.g.States[si]
+	res := append([]grammar.Rule{}, a.g.Rules[s.LowRule:s.HighRule]...)
+	for _, mr := range a.g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+		res = append(res, a.g.Rules[mr.LowRule:mr.HighRule]...)
+	}
+	return res
+}
+
+func (a *analyzer) findAmbiguousRules() {
+	owners := a.nodeStates()
+	tokens := make(map[int]*ints.Set)
+	for si, s := range a.g.States {
+		for _, mr := range a.g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+			n := owners[si]
+			if tokens[n] == nil {
+				tokens[n] = ints.NewSet()
+			}
+			tokens[n].Add(mr.Token)
+		}
+	}
+
+	for i, n := range a.g.Nodes {
+		if tokens[i] != nil {
+			a.add(AmbiguousRules, n.Name, "node %q has ambiguous rules for %s", n.Name, a.tokenNames(tokens[i]))
+		}
+	}
+}
+
+// firstTokens returns the set of tokens that may be accepted at given state of given node.
+func (a *analyzer) firstTokens(node, state int, visited *ints.Set) *ints.Set {
+	if state == grammar.FinalState {
+		return a.follow[node].Copy()
+	}
+
+	res := ints.NewSet()
+	if visited.Contains(state) {
+		return res
+	}
+
+	visited.Add(state)
+	for _, r := range a.stateRules(state) {
+		if r.Token == grammar.AnyToken {
+			res.Union(a.firstTokens(node, r.State, visited))
+		} else {
+			res.Add(r.Token)
+		}
+	}
+	return res
+}
+
+func (a *analyzer) findFirstFollowConflicts() {
+	owners := a.nodeStates()
+	a.follow = make([]*ints.Set, len(a.g.Nodes))
+	for i := range a.follow {
+		a.follow[i] = ints.NewSet()
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for si, node := range owners {
+			for _, r := range a.stateRules(si) {
+				if r.Node == grammar.SameNode {
+					continue
+				}
+
+				follow := ints.Union(a.follow[r.Node], a.firstTokens(node, r.State, ints.NewSet()))
+				if !follow.IsEqual(a.follow[r.Node]) {
+					a.follow[r.Node] = follow
+					changed = true
+				}
+			}
+		}
+	}
+
+	conflicts := make(map[int]*ints.Set)
+	for si, node := range owners {
+		explicit := ints.NewSet()
+		bypass := ints.NewSet()
+		for _, r := range a.stateRules(si) {
+			if r.Token == grammar.AnyToken {
+				bypass.Union(a.firstTokens(node, r.State, ints.NewSet()))
+			} else {
+				explicit.Add(r.Token)
+			}
+		}
+
+		common := ints.Intersect(explicit, bypass)
+		if !common.IsEmpty() {
+			if conflicts[node] == nil {
+				conflicts[node] = ints.NewSet()
+			}
+			conflicts[node].Union(common)
+		}
+	}
+
+	for i, n := range a.g.Nodes {
+		if conflicts[i] != nil {
+			a.add(FirstFollowConflict, n.Name, "optional part of node %q conflicts with following tokens %s", n.Name, a.tokenNames(conflicts[i]))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package langdef
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx/source"
+)
+
+func TestAnalyze(t *testing.T) {
+	samples := []struct {
+		src      string
+		expected []string
+	}{
+		{
+			"$name = /[a-z]+/; g = {$name};",
+			nil,
+		},
+		{
+			"$name = /[a-z]+/; g = a; a = $name; b = $name; c = d; d = c;",
+			[]string{
+				`1: node "b" cannot be reached from root node in sample at line 1 col 37`,
+				`1: node "c" cannot be reached from root node in sample at line 1 col 48`,
+				`1: node "d" cannot be reached from root node in sample at line 1 col 55`,
+			},
+		},
+		{
+			"$name = /\\w+/; $num = /\\d+/; $kw = /if|else/; $op = /[;]/; " +
+				"g = {a | b}; a = $name, $num, ';'; b = $name, $name, ';';",
+			[]string{
+				`2: node "g" has ambiguous rules for $name in sample at line 1 col 60`,
+				`3: token type $num may be shadowed by $name (e.g. "0") in sample at line 1 col 16`,
+				`3: token type $kw may be shadowed by $name (e.g. "if") in sample at line 1 col 30`,
+			},
+		},
+		{
+			"!group $str; $name = /[a-z]+/; $id = /[a-z]\\w*/; $str = /[a-z]+/; g = {$name | 'if' | $id | $str};",
+			[]string{
+				`3: token type $id may be shadowed by $name (e.g. "a") in sample at line 1 col 32`,
+				`4: literal "if" is matched by token types $name, $id, $str`,
+			},
+		},
+		{
+			"$name = /[a-z]+/; $op = /[,;]/; g = {a, ';'}; a = $name, [',', $name];",
+			nil,
+		},
+		{
+			"$name = /[a-z]+/; $op = /[,;]/; g = {a, ',', $name}; a = $name, [',', $name];",
+			[]string{
+				`5: optional part of node "a" conflicts with following tokens "," in sample at line 1 col 54`,
+			},
+		},
+	}
+
+	for i, sample := range samples {
+		r, e := Analyze(source.New("sample", []byte(sample.src)))
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		got := make([]string, len(r.Diagnostics))
+		for j, d := range r.Diagnostics {
+			got[j] = string(rune('0'+d.Kind)) + ": " + d.String()
+		}
+		if strings.Join(got, "\n") != strings.Join(sample.expected, "\n") {
+			t.Errorf("sample #%d: expecting:\n%s\ngot:\n%s", i, strings.Join(sample.expected, "\n"), strings.Join(got, "\n"))
+		}
+		if (r.Grammar == nil) != (len(got) > 0 && r.Diagnostics[0].Kind == UnreachableNode) {
+			t.Errorf("sample #%d: unexpected grammar %v", i, r.Grammar)
+		}
+	}
+
+	_, e := Analyze(source.New("sample", []byte("g = $name;")))
+	if e == nil {
+		t.Errorf("expecting error")
+	}
+}
@@ -0,0 +1,955 @@
+/*
+Package antlr converts ANTLR4 grammars to llx grammar descriptions (see langdef package).
+
+Lexer rules become token types, parser rules become nodes, the first parser rule is the root one.
+Fragment rules are inlined, rules skipped or sent to another channel become aside tokens,
+names listed in tokens {...} section become external tokens. String literals used in parser rules become literals,
+additional $literal token type is defined after all lexer rules to match literals not matched by any lexer rule.
+Labels, actions, options, and EOF references are dropped.
+
+Some ANTLR features cannot be represented and are reported with actionable errors, e.g. semantic predicates,
+left recursion, lexer modes, rule arguments, and lexer commands other than skip and channel.
+Note also that ANTLR lexer chooses the longest match while llx lexer chooses the first defined token type
+that matches, so token types defined earlier must not match prefixes of later ones.
+*/
+package antlr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/langdef"
+	"github.com/ava12/llx/source"
+)
+
+const literalTokenName = "literal"
+
+type exprKind int
+
+const (
+	altExpr exprKind = iota
+	seqExpr
+	optExpr
+	repExpr
+	rep1Expr
+	refExpr
+	stringExpr
+	rangeExpr
+	setExpr
+	notExpr
+	anyExpr
+)
+
+type expr struct {
+	kind  exprKind
+	text  string
+	to    string
+	items []*expr
+	lazy  bool
+	tok   *token
+}
+
+type rule struct {
+	name     string
+	fragment bool
+	aside    bool
+	body     *expr
+	tok      *token
+}
+
+func isLexerRule(name string) bool {
+	return unicode.IsUpper(rune(name[0]))
+}
+
+// Parse converts ANTLR4 grammar sources (e.g. a combined grammar or a lexer grammar and a parser grammar)
+// to grammar.Grammar. Returns nil and llx.Error on error.
+func Parse(srcs ...*source.Source) (*grammar.Grammar, error) {
+	def, e := Convert(srcs...)
+	if e != nil {
+		return nil, e
+	}
+
+	name := ""
+	if len(srcs) > 0 {
+		name = srcs[0].Name()
+	}
+	return langdef.ParseString(name, def)
+}
+
+// Convert converts ANTLR4 grammar sources (e.g. a combined grammar or a lexer grammar and a parser grammar)
+// to llx grammar description. Rules of all sources are merged. Returns empty string and llx.Error on error.
+func Convert(srcs ...*source.Source) (string, error) {
+	c := &converter{index: make(map[string]int), regexps: make(map[string]string), expanding: make(map[string]bool),
+		literals: make(map[string]bool)}
+	for _, s := range srcs {
+		p := &parser{tokens: scan(s)}
+		e := p.parseGrammar(c)
+		if e != nil {
+			return "", e
+		}
+	}
+
+	return c.convert()
+}
+
+type parser struct {
+	tokens []*token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() *token {
+	t := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != opTok {
+		return false
+	}
+
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) isId(text string) bool {
+	t := p.peek()
+	return t.kind == idTok && t.text == text
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return syntaxError(p.peek(), strconv.Quote(op))
+	}
+
+	p.next()
+	return nil
+}
+
+func (p *parser) expectId() (*token, error) {
+	t := p.next()
+	if t.kind != idTok {
+		return nil, syntaxError(t, "name")
+	}
+	return t, nil
+}
+
+func (p *parser) parseGrammar(c *converter) error {
+	if p.isId("lexer") || p.isId("parser") {
+		p.next()
+	}
+	if !p.isId("grammar") {
+		return syntaxError(p.peek(), "grammar declaration")
+	}
+
+	p.next()
+	_, e := p.expectId()
+	if e == nil {
+		e = p.expectOp(";")
+	}
+
+	for e == nil && p.peek().kind != eofTok {
+		t := p.peek()
+		switch {
+		case p.isId("options") || p.isId("channels"):
+			p.next()
+			if p.next().kind != actionTok {
+				e = syntaxError(t, "{...} block")
+			}
+
+		case p.isId("tokens"):
+			p.next()
+			e = p.parseTokens(c)
+
+		case p.isId("import"):
+			e = unsupportedError(t, "grammar import", "pass imported grammar sources to Convert instead")
+
+		case p.isId("mode"):
+			e = unsupportedError(t, "lexer mode", "use token groups (!group directive) or token hooks instead")
+
+		case p.isOp("@"):
+			p.next()
+			_, e = p.expectId()
+			if e == nil && p.isOp("::") {
+				p.next()
+				_, e = p.expectId()
+			}
+			if e == nil && p.next().kind != actionTok {
+				e = syntaxError(t, "{...} block")
+			}
+
+		default:
+			e = p.parseRule(c)
+		}
+	}
+
+	return e
+}
+
+func (p *parser) parseTokens(c *converter) error {
+	t := p.next()
+	if t.kind != actionTok {
+		return syntaxError(t, "{...} block")
+	}
+
+	for _, name := range strings.Split(t.text[1:len(t.text)-1], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			c.externs = append(c.externs, name)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseRule(c *converter) error {
+	r := &rule{}
+	if p.isId("fragment") {
+		p.next()
+		r.fragment = true
+	}
+
+	t, e := p.expectId()
+	if e != nil {
+		return e
+	}
+
+	r.name = t.text
+	r.tok = t
+	if p.peek().kind == setTok {
+		return unsupportedError(p.peek(), "rule arguments", "use hooks to pass data between nodes")
+	}
+	if p.isId("returns") || p.isId("locals") || p.isId("throws") {
+		return unsupportedError(p.peek(), "rule "+p.peek().text+" clause", "use node hooks to compute node results")
+	}
+	if p.isId("options") {
+		p.next()
+		if p.next().kind != actionTok {
+			return syntaxError(t, "{...} block")
+		}
+	}
+	for p.isOp("@") {
+		p.next()
+		_, e = p.expectId()
+		if e == nil && p.next().kind != actionTok {
+			e = syntaxError(t, "{...} block")
+		}
+		if e != nil {
+			return e
+		}
+	}
+
+	e = p.expectOp(":")
+	if e == nil {
+		r.body, e = p.parseAlts(r)
+	}
+	if e == nil {
+		e = p.expectOp(";")
+	}
+	if e == nil && (p.isId("catch") || p.isId("finally")) {
+		e = unsupportedError(p.peek(), "exception handler", "use error recovery parser options instead")
+	}
+	if e != nil {
+		return e
+	}
+
+	return c.addRule(r)
+}
+
+func (p *parser) parseAlts(r *rule) (*expr, error) {
+	first := p.peek()
+	var items []*expr
+	for {
+		item, e := p.parseAlt(r)
+		if e != nil {
+			return nil, e
+		}
+
+		items = append(items, item)
+		if !p.isOp("|") {
+			break
+		}
+		p.next()
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &expr{kind: altExpr, items: items, tok: first}, nil
+}
+
+func (p *parser) parseAlt(r *rule) (*expr, error) {
+	result := &expr{kind: seqExpr, tok: p.peek()}
+	for !p.isOp("|", ";", ")", "#", "->") && p.peek().kind != eofTok {
+		t := p.peek()
+		switch t.kind {
+		case actionTok:
+			p.next()
+			continue
+		case predicateTok:
+			return nil, unsupportedError(t, "semantic predicate", "remove it and resolve ambiguity in grammar rules or hooks")
+		}
+		if p.isOp("<") {
+			return nil, unsupportedError(t, "element option", "remove it")
+		}
+
+		item, e := p.parseElement(r)
+		if e != nil {
+			return nil, e
+		}
+		if item != nil {
+			result.items = append(result.items, item)
+		}
+	}
+
+	if p.isOp("#") {
+		p.next()
+		_, e := p.expectId()
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	if p.isOp("->") {
+		e := p.parseCommands(r)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	if len(result.items) == 1 {
+		return result.items[0], nil
+	}
+	return result, nil
+}
+
+func (p *parser) parseCommands(r *rule) error {
+	for {
+		p.next()
+		t, e := p.expectId()
+		if e != nil {
+			return e
+		}
+
+		switch t.text {
+		case "skip":
+			r.aside = true
+
+		case "channel":
+			r.aside = true
+			e = p.expectOp("(")
+			if e == nil {
+				_, e = p.expectId()
+			}
+			if e == nil {
+				e = p.expectOp(")")
+			}
+			if e != nil {
+				return e
+			}
+
+		default:
+			return unsupportedError(t, "lexer command "+strconv.Quote(t.text), "only skip and channel commands are supported")
+		}
+
+		if !p.isOp(",") {
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseElement(r *rule) (*expr, error) {
+	t := p.peek()
+	if t.kind == idTok && p.tokens[p.pos+1].kind == opTok {
+		op := p.tokens[p.pos+1].text
+		if op == "=" || op == "+=" {
+			p.next()
+			p.next()
+		}
+	}
+
+	item, e := p.parseAtom(r)
+	if e != nil {
+		return nil, e
+	}
+
+	for p.isOp("?", "*", "+") {
+		st := p.next()
+		kind := map[string]exprKind{"?": optExpr, "*": repExpr, "+": rep1Expr}[st.text]
+		item = &expr{kind: kind, items: []*expr{item}, tok: st}
+		if p.isOp("?") {
+			p.next()
+			item.lazy = true
+		}
+	}
+	return item, nil
+}
+
+func (p *parser) parseAtom(r *rule) (*expr, error) {
+	t := p.next()
+	switch t.kind {
+	case idTok:
+		return &expr{kind: refExpr, text: t.text, tok: t}, nil
+
+	case stringTok:
+		text, e := unquote(t)
+		if e != nil {
+			return nil, e
+		}
+
+		if !p.isOp("..") {
+			return &expr{kind: stringExpr, text: text, tok: t}, nil
+		}
+
+		p.next()
+		tt := p.next()
+		if tt.kind != stringTok {
+			return nil, syntaxError(tt, "string")
+		}
+		to, e := unquote(tt)
+		if e != nil {
+			return nil, e
+		}
+		return &expr{kind: rangeExpr, text: text, to: to, tok: t}, nil
+
+	case setTok:
+		return &expr{kind: setExpr, text: t.text[1 : len(t.text)-1], tok: t}, nil
+
+	case opTok:
+		switch t.text {
+		case ".":
+			return &expr{kind: anyExpr, tok: t}, nil
+
+		case "~":
+			item, e := p.parseAtom(r)
+			if e != nil {
+				return nil, e
+			}
+			return &expr{kind: notExpr, items: []*expr{item}, tok: t}, nil
+
+		case "(":
+			if p.isId("options") {
+				return nil, unsupportedError(p.peek(), "subrule options", "remove them")
+			}
+
+			item, e := p.parseAlts(r)
+			if e == nil {
+				e = p.expectOp(")")
+			}
+			return item, e
+		}
+	}
+
+	if t.kind == errorTok {
+		return nil, syntaxError(t, "valid symbol")
+	}
+	return nil, syntaxError(t, "rule element")
+}
+
+// unquote decodes ANTLR string literal.
+func unquote(t *token) (string, error) {
+	s := t.text[1 : len(t.text)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", syntaxError(t, "escape sequence")
+		}
+
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			r, size := unicodeEscape(s[i+1:])
+			if size == 0 {
+				return "", syntaxError(t, "unicode escape sequence")
+			}
+			b.WriteRune(r)
+			i += size
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// unicodeEscape decodes XXXX or {X...} part of \u escape sequence, returns size 0 on error.
+func unicodeEscape(s string) (r rune, size int) {
+	digits := ""
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return 0, 0
+		}
+		digits = s[1:end]
+		size = end + 1
+	} else if len(s) >= 4 {
+		digits = s[:4]
+		size = 4
+	}
+
+	v, e := strconv.ParseUint(digits, 16, 32)
+	if e != nil {
+		return 0, 0
+	}
+	return rune(v), size
+}
+
+type converter struct {
+	rules     []*rule
+	index     map[string]int
+	externs   []string
+	regexps   map[string]string
+	expanding map[string]bool
+	nodes     []string
+	literals  map[string]bool
+}
+
+func (c *converter) addRule(r *rule) error {
+	if _, has := c.index[r.name]; has {
+		return duplicateRuleError(r.tok, r.name)
+	}
+	if !isLexerRule(r.name) && (r.fragment || r.aside) {
+		return unsupportedError(r.tok, "parser rule "+strconv.Quote(r.name), "fragments and commands are allowed only in lexer rules")
+	}
+
+	c.index[r.name] = len(c.rules)
+	c.rules = append(c.rules, r)
+	return nil
+}
+
+func (c *converter) convert() (string, error) {
+	var root *rule
+	for _, r := range c.rules {
+		if !isLexerRule(r.name) {
+			root = r
+			break
+		}
+	}
+	if root == nil {
+		return "", noParserRulesError()
+	}
+
+	e := c.collect(root)
+	if e != nil {
+		return "", e
+	}
+
+	var b strings.Builder
+	var aside []string
+	for _, r := range c.rules {
+		if r.aside {
+			aside = append(aside, "$"+r.name)
+		}
+	}
+	if len(aside) > 0 {
+		b.WriteString("!aside " + strings.Join(aside, " ") + ";\n")
+	}
+	if len(c.externs) > 0 {
+		b.WriteString("!extern $" + strings.Join(c.externs, " $") + ";\n")
+	}
+
+	for _, r := range c.rules {
+		if !isLexerRule(r.name) || r.fragment {
+			continue
+		}
+
+		re, e := c.tokenRegexp(r)
+		if e != nil {
+			return "", e
+		}
+		b.WriteString("$" + r.name + " = /" + strings.ReplaceAll(re, "/", "\\/") + "/;\n")
+	}
+
+	if len(c.literals) > 0 {
+		lits := make([]string, 0, len(c.literals))
+		for lit := range c.literals {
+			lits = append(lits, lit)
+		}
+		sort.Slice(lits, func(i, j int) bool {
+			if len(lits[i]) != len(lits[j]) {
+				return len(lits[i]) > len(lits[j])
+			}
+			return lits[i] < lits[j]
+		})
+		for i, lit := range lits {
+			lits[i] = regexp.QuoteMeta(lit)
+		}
+		b.WriteString("$" + literalTokenName + " = /" + strings.ReplaceAll(strings.Join(lits, "|"), "/", "\\/") + "/;\n")
+	}
+
+	for _, name := range c.nodes {
+		body, e := c.nodeBody(c.rules[c.index[name]].body)
+		if e != nil {
+			return "", e
+		}
+		if body == "" {
+			return "", unsupportedError(c.rules[c.index[name]].tok, "empty rule "+strconv.Quote(name), "remove it")
+		}
+
+		b.WriteString(name + " = " + body + ";\n")
+	}
+
+	return b.String(), nil
+}
+
+func (c *converter) collect(r *rule) error {
+	for _, n := range c.nodes {
+		if n == r.name {
+			return nil
+		}
+	}
+
+	if startsWith(r.body, r.name) {
+		return leftRecursionError(r.tok, r.name)
+	}
+
+	c.nodes = append(c.nodes, r.name)
+	return c.collectExpr(r.body)
+}
+
+// startsWith reports whether some alternative of x starts with reference to given rule.
+func startsWith(x *expr, name string) bool {
+	switch x.kind {
+	case refExpr:
+		return x.text == name
+	case altExpr:
+		for _, item := range x.items {
+			if startsWith(item, name) {
+				return true
+			}
+		}
+	case seqExpr:
+		for _, item := range x.items {
+			if startsWith(item, name) {
+				return true
+			}
+			if item.kind != optExpr && item.kind != repExpr {
+				break
+			}
+		}
+	case optExpr, repExpr, rep1Expr:
+		return startsWith(x.items[0], name)
+	}
+	return false
+}
+
+func (c *converter) collectExpr(x *expr) error {
+	switch x.kind {
+	case refExpr:
+		if x.text == "EOF" {
+			return nil
+		}
+
+		i, has := c.index[x.text]
+		if !has {
+			for _, name := range c.externs {
+				if name == x.text {
+					return nil
+				}
+			}
+			return undefinedRuleError(x.tok, x.text)
+		}
+
+		r := c.rules[i]
+		if r.fragment {
+			return unsupportedError(x.tok, "fragment rule "+strconv.Quote(x.text)+" in parser rule", "use non-fragment lexer rule")
+		}
+		if isLexerRule(r.name) {
+			return nil
+		}
+		return c.collect(r)
+
+	case stringExpr:
+		if x.text == "" {
+			return unsupportedError(x.tok, "empty literal", "remove it")
+		}
+		if strings.Contains(x.text, "'") && strings.Contains(x.text, "\"") {
+			return unsupportedError(x.tok, "literal containing both quote signs", "define a lexer rule for it")
+		}
+
+		c.literals[x.text] = true
+		return nil
+
+	case rangeExpr, setExpr, notExpr, anyExpr:
+		return unsupportedError(x.tok, "character set, range, negation, or wildcard in parser rule", "define a lexer rule for it")
+	}
+
+	for _, item := range x.items {
+		e := c.collectExpr(item)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *converter) nodeBody(x *expr) (string, error) {
+	switch x.kind {
+	case refExpr:
+		switch {
+		case x.text == "EOF":
+			return "", nil
+		case isLexerRule(x.text):
+			return "$" + x.text, nil
+		default:
+			return x.text, nil
+		}
+
+	case stringExpr:
+		if strings.Contains(x.text, "'") {
+			return "\"" + x.text + "\"", nil
+		}
+		return "'" + x.text + "'", nil
+	}
+
+	var parts []string
+	hasEmpty := false
+	for _, item := range x.items {
+		body, e := c.nodeBody(item)
+		if e != nil {
+			return "", e
+		}
+
+		if body == "" {
+			hasEmpty = true
+		} else if x.kind == altExpr && item.kind == seqExpr && len(item.items) > 1 {
+			parts = append(parts, "("+body+")")
+		} else {
+			parts = append(parts, body)
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	switch x.kind {
+	case altExpr:
+		body := strings.Join(parts, " | ")
+		if hasEmpty {
+			return "[" + body + "]", nil
+		}
+		return "(" + body + ")", nil
+	case seqExpr:
+		return strings.Join(parts, ", "), nil
+	case optExpr:
+		return "[" + parts[0] + "]", nil
+	case repExpr:
+		return "{" + parts[0] + "}", nil
+	default:
+		return "{+ " + parts[0] + "}", nil
+	}
+}
+
+func (c *converter) tokenRegexp(r *rule) (string, error) {
+	re, has := c.regexps[r.name]
+	if has {
+		return re, nil
+	}
+	if c.expanding[r.name] {
+		return "", unsupportedError(r.tok, "recursive lexer rule "+strconv.Quote(r.name), "use token hooks to match nested constructs")
+	}
+
+	c.expanding[r.name] = true
+	re, e := c.regexp(r.body)
+	c.expanding[r.name] = false
+	if e != nil {
+		return "", e
+	}
+
+	c.regexps[r.name] = re
+	return re, nil
+}
+
+func (c *converter) lexerRule(x *expr) (*rule, error) {
+	i, has := c.index[x.text]
+	if !has {
+		return nil, undefinedRuleError(x.tok, x.text)
+	}
+
+	r := c.rules[i]
+	if !isLexerRule(r.name) {
+		return nil, unsupportedError(x.tok, "parser rule "+strconv.Quote(x.text)+" in lexer rule", "use lexer rules only")
+	}
+	return r, nil
+}
+
+func (c *converter) regexp(x *expr) (string, error) {
+	switch x.kind {
+	case stringExpr:
+		return regexp.QuoteMeta(x.text), nil
+
+	case rangeExpr, setExpr, notExpr:
+		body, e := c.classBody(x)
+		if e != nil {
+			return "", e
+		}
+		if x.kind == notExpr {
+			return "[^" + body + "]", nil
+		}
+		return "[" + body + "]", nil
+
+	case anyExpr:
+		return ".", nil
+
+	case refExpr:
+		if x.text == "EOF" {
+			return "", unsupportedError(x.tok, "EOF in lexer rule", "remove it")
+		}
+
+		r, e := c.lexerRule(x)
+		if e != nil {
+			return "", e
+		}
+
+		re, e := c.tokenRegexp(r)
+		return "(?:" + re + ")", e
+	}
+
+	parts := make([]string, len(x.items))
+	for i, item := range x.items {
+		re, e := c.regexp(item)
+		if e != nil {
+			return "", e
+		}
+		parts[i] = re
+	}
+
+	suffix := ""
+	if x.lazy {
+		suffix = "?"
+	}
+	switch x.kind {
+	case altExpr:
+		return "(?:" + strings.Join(parts, "|") + ")", nil
+	case seqExpr:
+		return strings.Join(parts, ""), nil
+	case optExpr:
+		return "(?:" + parts[0] + ")?" + suffix, nil
+	case repExpr:
+		return "(?:" + parts[0] + ")*" + suffix, nil
+	default:
+		return "(?:" + parts[0] + ")+" + suffix, nil
+	}
+}
+
+func runeClass(r rune) string {
+	return fmt.Sprintf("\\x{%x}", r)
+}
+
+// classBody converts expression to RE2 character class contents (without square brackets).
+func (c *converter) classBody(x *expr) (string, error) {
+	switch x.kind {
+	case stringExpr:
+		rs := []rune(x.text)
+		if len(rs) == 1 {
+			return runeClass(rs[0]), nil
+		}
+
+	case rangeExpr:
+		from, to := []rune(x.text), []rune(x.to)
+		if len(from) == 1 && len(to) == 1 {
+			return runeClass(from[0]) + "-" + runeClass(to[0]), nil
+		}
+
+	case setExpr:
+		return convertSet(x)
+
+	case notExpr:
+		return c.classBody(x.items[0])
+
+	case altExpr:
+		var b strings.Builder
+		for _, item := range x.items {
+			body, e := c.classBody(item)
+			if e != nil {
+				return "", e
+			}
+			b.WriteString(body)
+		}
+		return b.String(), nil
+
+	case refExpr:
+		r, e := c.lexerRule(x)
+		if e != nil {
+			return "", e
+		}
+		return c.classBody(r.body)
+	}
+
+	return "", unsupportedError(x.tok, "negation of non-character expression", "use character sets, ranges, or single characters only")
+}
+
+// convertSet converts ANTLR character set contents to RE2 character class contents.
+func convertSet(x *expr) (string, error) {
+	s := x.text
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch != '\\' {
+			if strings.IndexByte("[]^\\", ch) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(ch)
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", syntaxError(x.tok, "escape sequence")
+		}
+
+		switch s[i] {
+		case 'n', 'r', 't', 'f', '\\', ']', '-':
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		case 'b':
+			b.WriteString(runeClass('\b'))
+		case 'u':
+			r, size := unicodeEscape(s[i+1:])
+			if size == 0 {
+				return "", syntaxError(x.tok, "unicode escape sequence")
+			}
+			b.WriteString(runeClass(r))
+			i += size
+		case 'p', 'P':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", syntaxError(x.tok, "unicode property")
+			}
+			b.WriteString("\\" + s[i:i+end+1])
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		}
+	}
+	return b.String(), nil
+}
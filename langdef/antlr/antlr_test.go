@@ -0,0 +1,123 @@
+package antlr
+
+import (
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/langdef"
+	"github.com/ava12/llx/source"
+)
+
+func TestConvert(t *testing.T) {
+	samples := []struct {
+		srcs     []string
+		expected string
+	}{
+		{
+			[]string{`
+grammar Expr;
+options { language = Go; }
+@header { import "fmt" }
+
+prog: stat+ EOF;
+stat: ID '=' e=expr ';' # assign
+    | expr ';'          # print
+    |                   # empty
+    ;
+expr: term (('+' | '-') term)*;
+term: INT | ID | '(' expr ')' {fmt.Println("group")};
+
+ID: [a-zA-Z_] [a-zA-Z_0-9]*;
+INT: DIGIT+;
+fragment DIGIT: '0'..'9';
+STR: '"' ~["\\\r\n]*? '"';
+WS: [ \t\r\n]+ -> skip;
+COMMENT: '//' ~'\n'* -> channel(HIDDEN);
+`},
+			"!aside $WS $COMMENT;\n" +
+				"$ID = /[a-zA-Z_](?:[a-zA-Z_0-9])*/;\n" +
+				"$INT = /(?:(?:[\\x{30}-\\x{39}]))+/;\n" +
+				"$STR = /\"(?:[^\"\\\\\\r\\n])*?\"/;\n" +
+				"$WS = /(?:[ \\t\\r\\n])+/;\n" +
+				"$COMMENT = /\\/\\/(?:[^\\x{a}])*/;\n" +
+				"$literal = /\\(|\\)|\\+|-|;|=/;\n" +
+				"prog = {+ stat};\n" +
+				"stat = [($ID, '=', expr, ';') | (expr, ';')];\n" +
+				"expr = term, {('+' | '-'), term};\n" +
+				"term = ($INT | $ID | ('(', expr, ')'));\n",
+		},
+		{
+			[]string{
+				"parser grammar P; tokens { INDENT, DEDENT } block: INDENT NAME+ DEDENT;",
+				"lexer grammar L; NAME: [a-z]+ ; SPACE: ' '+ -> skip;",
+			},
+			"!aside $SPACE;\n!extern $INDENT $DEDENT;\n" +
+				"$NAME = /(?:[a-z])+/;\n$SPACE = /(?: )+/;\n" +
+				"block = $INDENT, {+ $NAME}, $DEDENT;\n",
+		},
+	}
+
+	for i, sample := range samples {
+		srcs := make([]*source.Source, len(sample.srcs))
+		for j, src := range sample.srcs {
+			srcs[j] = source.New("sample", []byte(src))
+		}
+
+		got, e := Convert(srcs...)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+		if got != sample.expected {
+			t.Errorf("sample #%d: expecting:\n%s\ngot:\n%s", i, sample.expected, got)
+			continue
+		}
+
+		_, e = langdef.ParseString("sample", got)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	samples := []struct {
+		src string
+		err int
+	}{
+		{"", SyntaxError},
+		{"grammar G", SyntaxError},
+		{"grammar G; a: 'x'", SyntaxError},
+		{"grammar G; a: ('x';", SyntaxError},
+		{"grammar G; a: 'x\\u12';", SyntaxError},
+		{"grammar G; a: 'x' ^;", SyntaxError},
+		{"grammar G; A: 'x';", UndefinedRuleError},
+		{"grammar G; a: b;", UndefinedRuleError},
+		{"grammar G; a: 'x'; a: 'y';", DuplicateRuleError},
+		{"grammar G; a: a 'x' | 'y';", LeftRecursionError},
+		{"grammar G; a: 'x'* a 'y';", LeftRecursionError},
+		{"grammar G; a: {p()}? 'x';", UnsupportedError},
+		{"grammar G; a[int x]: 'x';", UnsupportedError},
+		{"grammar G; a returns [int x]: 'x';", UnsupportedError},
+		{"grammar G; a: 'x'; catch [E e] {}", UnsupportedError},
+		{"grammar G; import H; a: 'x';", UnsupportedError},
+		{"lexer grammar G; mode M;", UnsupportedError},
+		{"grammar G; a: A; A: 'x' -> pushMode(M);", UnsupportedError},
+		{"grammar G; a: A; A: 'x' A?;", UnsupportedError},
+		{"grammar G; a: A; A: ~('x' | 'yz');", UnsupportedError},
+		{"grammar G; a: A; A: b; b: 'x';", UnsupportedError},
+		{"grammar G; a: B; fragment B: 'x';", UnsupportedError},
+		{"grammar G; a: 'x'..'z';", UnsupportedError},
+		{"grammar G; a: '';", UnsupportedError},
+		{"grammar G; a: EOF;", UnsupportedError},
+		{"grammar G; a: <assoc=right> 'x';", UnsupportedError},
+	}
+
+	for i, sample := range samples {
+		_, e := Convert(source.New("sample", []byte(sample.src)))
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package antlr
+
+import (
+	"github.com/ava12/llx"
+)
+
+// Error codes used by antlr converter:
+const (
+	// malformed ANTLR grammar
+	SyntaxError = llx.ConverterErrors + iota
+	// grammar uses construct that cannot be represented in llx grammar
+	UnsupportedError
+	// rule is directly left-recursive
+	LeftRecursionError
+	// rule is used but never defined
+	UndefinedRuleError
+	// rule is defined more than once
+	DuplicateRuleError
+)
+
+func syntaxError(t *token, msg string) *llx.Error {
+	if t.kind == eofTok {
+		return llx.FormatErrorPos(t, SyntaxError, "unexpected end of grammar, expecting %s", msg)
+	}
+	return llx.FormatErrorPos(t, SyntaxError, "unexpected %q, expecting %s", t.text, msg)
+}
+
+func unsupportedError(t *token, what, hint string) *llx.Error {
+	return llx.FormatErrorPos(t, UnsupportedError, "%s cannot be converted: %s", what, hint)
+}
+
+func leftRecursionError(t *token, name string) *llx.Error {
+	return llx.FormatErrorPos(t, LeftRecursionError,
+		"rule %q is left-recursive: rewrite it using repetition, e.g. expr: term (op term)*", name)
+}
+
+func undefinedRuleError(t *token, name string) *llx.Error {
+	return llx.FormatErrorPos(t, UndefinedRuleError, "rule %q is not defined", name)
+}
+
+func duplicateRuleError(t *token, name string) *llx.Error {
+	return llx.FormatErrorPos(t, DuplicateRuleError, "rule %q is already defined", name)
+}
+
+func noParserRulesError() *llx.Error {
+	return llx.FormatError(UndefinedRuleError, "no parser rules defined")
+}
@@ -0,0 +1,206 @@
+package antlr
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ava12/llx/source"
+)
+
+type tokenKind int
+
+const (
+	eofTok tokenKind = iota
+	idTok
+	stringTok
+	setTok
+	actionTok
+	predicateTok
+	opTok
+	errorTok
+)
+
+type token struct {
+	kind      tokenKind
+	text      string
+	name      string
+	line, col int
+}
+
+func (t *token) SourceName() string {
+	return t.name
+}
+
+func (t *token) Line() int {
+	return t.line
+}
+
+func (t *token) Col() int {
+	return t.col
+}
+
+var multiCharOps = []string{"->", "..", "+=", "::"}
+
+const singleCharOps = ":;|()?*+~.,=#@<>"
+
+// scanner splits ANTLR grammar into tokens. Actions are enclosed in possibly nested curly braces,
+// so regular expressions are of no use here.
+type scanner struct {
+	name      string
+	src       string
+	pos       int
+	line, col int
+}
+
+func scan(s *source.Source) []*token {
+	sc := &scanner{name: s.Name(), src: string(s.Content()), line: 1, col: 1}
+	var result []*token
+	for {
+		t := sc.next()
+		result = append(result, t)
+		if t.kind == eofTok || t.kind == errorTok {
+			return result
+		}
+	}
+}
+
+func (sc *scanner) advance(n int) {
+	for _, r := range sc.src[sc.pos : sc.pos+n] {
+		if r == '\n' {
+			sc.line++
+			sc.col = 1
+		} else {
+			sc.col++
+		}
+	}
+	sc.pos += n
+}
+
+func (sc *scanner) skipSpace() {
+	for sc.pos < len(sc.src) {
+		rest := sc.src[sc.pos:]
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case unicode.IsSpace(r):
+			sc.advance(size)
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			sc.advance(end)
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				sc.advance(len(rest))
+			} else {
+				sc.advance(end + 4)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (sc *scanner) next() *token {
+	sc.skipSpace()
+	t := &token{name: sc.name, line: sc.line, col: sc.col}
+	if sc.pos >= len(sc.src) {
+		t.kind = eofTok
+		return t
+	}
+
+	rest := sc.src[sc.pos:]
+	size := 0
+	switch c := rest[0]; {
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		t.kind = idTok
+		size = strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if size < 0 {
+			size = len(rest)
+		}
+
+	case c == '\'':
+		t.kind = stringTok
+		size = quotedLen(rest, '\'')
+
+	case c == '[':
+		t.kind = setTok
+		size = quotedLen(rest, ']')
+
+	case c == '{':
+		t.kind = actionTok
+		size = actionLen(rest)
+		if size > 0 && size < len(rest) && rest[size] == '?' {
+			t.kind = predicateTok
+			size++
+		}
+
+	default:
+		t.kind = opTok
+		for _, op := range multiCharOps {
+			if strings.HasPrefix(rest, op) {
+				size = len(op)
+				break
+			}
+		}
+		if size == 0 && strings.IndexByte(singleCharOps, c) >= 0 {
+			size = 1
+		}
+	}
+
+	if size <= 0 {
+		t.kind = errorTok
+		r, _ := utf8.DecodeRuneInString(rest)
+		t.text = string(r)
+		return t
+	}
+
+	t.text = rest[:size]
+	sc.advance(size)
+	return t
+}
+
+// quotedLen returns the length of string or set starting with opening symbol, 0 if it is unterminated.
+func quotedLen(s string, closing byte) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\n':
+			if closing == '\'' {
+				return 0
+			}
+		case closing:
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// actionLen returns the length of action enclosed in curly braces, 0 if it is unterminated.
+// Quoted strings inside action are skipped.
+func actionLen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case '\'', '"':
+			n := quotedLen(s[i:], s[i])
+			if n == 0 {
+				return 0
+			}
+			i += n - 1
+		}
+	}
+	return 0
+}
@@ -114,6 +114,15 @@ func (c *groupChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
 	}
 }
 
+// newOneOrMoreChunk creates a chunk matching given non-repeated group one or more times, i.e. "group, {group}".
+// Both parts share nested chunks, so no definitions are duplicated.
+func newOneOrMoreChunk(group *groupChunk) *groupChunk {
+	result := newGroupChunk(false, false)
+	result.Append(group)
+	result.Append(&groupChunk{group.chunks, true, true})
+	return result
+}
+
 type tokenChunk int
 
 func newTokenChunk(t int) tokenChunk {
@@ -153,3 +162,107 @@ func (c *nodeChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
 	firstTokens := c.FirstTokens().ToSlice()
 	g.States[stateIndex].AddRule(nextIndex, c.item.Index, firstTokens...)
 }
+
+// actionChunk wraps a chunk followed by an action. The action gets its own state
+// having a single bypass rule, so parser leaves this state only after wrapped chunk is matched.
+type actionChunk struct {
+	name  string
+	chunk chunk
+}
+
+func newActionChunk(name string, ch chunk) *actionChunk {
+	return &actionChunk{name, ch}
+}
+
+func (c *actionChunk) FirstTokens() *ints.Set {
+	return c.chunk.FirstTokens()
+}
+
+func (c *actionChunk) IsOptional() bool {
+	return c.chunk.IsOptional()
+}
+
+func (c *actionChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
+	actionState, se := g.AddState()
+	se.Action = c.name
+	c.chunk.BuildStates(g, stateIndex, actionState)
+	se.BypassRule(nextIndex)
+}
+
+// labeledChunk wraps a token or node chunk, rules built for wrapped chunk get the label.
+type labeledChunk struct {
+	label string
+	chunk chunk
+}
+
+func newLabeledChunk(label string, ch chunk) *labeledChunk {
+	return &labeledChunk{label, ch}
+}
+
+func (c *labeledChunk) FirstTokens() *ints.Set {
+	return c.chunk.FirstTokens()
+}
+
+func (c *labeledChunk) IsOptional() bool {
+	return false
+}
+
+func (c *labeledChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
+	switch ch := c.chunk.(type) {
+	case tokenChunk:
+		g.States[stateIndex].AddLabeledRule(c.label, nextIndex, grammar.SameNode, int(ch))
+	case *nodeChunk:
+		g.States[stateIndex].AddLabeledRule(c.label, nextIndex, ch.item.Index, ch.FirstTokens().ToSlice()...)
+	}
+}
+
+// adjacentChunk wraps a chunk that must not be separated from the next token. Like actionChunk,
+// it adds a state having a single bypass rule, parser checks token positions when leaving this state.
+type adjacentChunk struct {
+	chunk chunk
+}
+
+func newAdjacentChunk(ch chunk) *adjacentChunk {
+	return &adjacentChunk{ch}
+}
+
+func (c *adjacentChunk) FirstTokens() *ints.Set {
+	return c.chunk.FirstTokens()
+}
+
+func (c *adjacentChunk) IsOptional() bool {
+	return c.chunk.IsOptional()
+}
+
+func (c *adjacentChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
+	adjacentState, se := g.AddState()
+	se.Adjacent = true
+	c.chunk.BuildStates(g, stateIndex, adjacentState)
+	se.BypassRule(nextIndex)
+}
+
+// errorChunk wraps an element matching erroneous input. Like actionChunk,
+// it adds a state having a single bypass rule, parser reports the error when leaving this state.
+type errorChunk struct {
+	message string
+	chunk   chunk
+}
+
+func newErrorChunk(message string, ch chunk) *errorChunk {
+	return &errorChunk{message, ch}
+}
+
+func (c *errorChunk) FirstTokens() *ints.Set {
+	return c.chunk.FirstTokens()
+}
+
+func (c *errorChunk) IsOptional() bool {
+	return c.chunk.IsOptional()
+}
+
+func (c *errorChunk) BuildStates(g *parseResult, stateIndex, nextIndex int) {
+	errorState, se := g.AddState()
+	se.Error = c.message
+	c.chunk.BuildStates(g, stateIndex, errorState)
+	se.BypassRule(nextIndex)
+}
@@ -6,31 +6,48 @@ Grammar is described using language that resembles EBNF. Self-definition of this
 //  $space = /[ \r\n\t\f]+/; $comment = /#[^\n]*/;
 //  $string = /(?:".*?")|(?:'.*?')/;
 //  $name = /[a-zA-z_][a-zA-Z_0-9-]*/;
-//  $type-dir = /!(?:aside|caseless|error|extern|group)\b/;
-//  $literal-dir = /!reserved\b/;
+//  $type-dir = /!(?:aside|caseless-literals|caseless|error|extern|group|inline|preamble|unicode)\b/;
+//  $decode-dir = /!decode\b/;
+//  $lexer-dir = /!lexer\b/;
+//  $literal-dir = /!(?:reserved|left|right|prec)\b/;
 //  $mixed-dir = /!literal\b/;
 //  $token-name = /\$[a-zA-z_][a-zA-Z_0-9-]*/;
 //  $regexp = /\/(?:[^\\\/]|\\.)+\//;
-//  $op = /[(){}\[\]=|,;]/;
+//  $op = /[(){}\[\]=|,;+<>:~]/;
+//  $action = /@[a-zA-Z_][a-zA-Z_0-9-]*/;
+//  $error-mark = /!err\b/;
+//  $pattern-name = /\$\$[a-z]+(?:-[a-z]+)*/;
 //  $error = /["'!].{0,10}/;
 //
 //  !aside $space $comment; !error $error;
 //
 //  # first node is the root one
 //  # no further token definitions or directives allowed after this point
-//  langdef = {directive | token-definition}, node-definition, {node-definition};
-//  directive = type-directive | literal-directive | mixed-directive;
-//  type-directive = $type-dir, {$token-name}, ';';
+//  langdef = {directive | token-definition | pattern-definition}, {template-definition}, node-definition,
+//            {node-definition | template-definition};
+//  directive = type-directive | decode-directive | lexer-directive | literal-directive | mixed-directive;
+//  type-directive = $type-dir, {$token-name | $name}, ';'; # node names are allowed in !caseless and !inline only,
+//                                                         # !inline takes node names only,
+//                                                         # !caseless-literals takes no arguments
+//  decode-directive = $decode-dir, {$token-name}, $name, ';';
+//  lexer-directive = $lexer-dir, $token-name, {$name}, ';';
 //  literal-directive = $literal-dir, {$string}, ';';
 //  mixed-directive = $mixed-dir, {$token-name | $string}, ';';
-//  token-definition = $token-name, '=', $regexp, ';';
+//  token-definition = $token-name, '=', ($regexp | nested), ';';
+//  nested = 'nested', '(', $string, ',', $string, ')';
+//  pattern-definition = $pattern-name, '=', $regexp, ';';
 //  node-definition = $name, '=', sequence, ';';
-//  sequence = item, {',', item};
+//  template-definition = $name, '<', $name, {',', $name}, '>', '=', sequence, ';';
+//  sequence = item, {(',' | '~'), item}; # '~' means no space allowed between items
 //  item = variant, {'|', variant}; # NB!: foo | bar, baz is equal to (foo|bar), baz
-//  variant = $name | $token-name | $string | group | optional | repeat;
+//  variant = [$error-mark, '(', $string, ')'], (labeled | node | $token-name | $string | group | optional | repeat),
+//            [$action];
+//  labeled = $name, ':', (node | $token-name | $string);
+//  node = $name, ['<', argument, {',', argument}, '>'];
+//  argument = node | $token-name | $string;
 //  group = '(', sequence, ')';
 //  optional = '[', sequence, ']'; # match 0 or 1 time
-//  repeat = '{', sequence, '}';   # match 0 or more times
+//  repeat = '{', ['+'], sequence, '}';   # match 0 or more times, 1 or more times with +
 /*
 Description must be a valid UTF-8 text (no BOM!). Valid space symbols are whitespace (U+0020),
 horizontal tabulation (U+0009), line feed (U+000A), form feed (U+000C), and carriage return (U+000D).
@@ -50,13 +67,13 @@ Regular expression literal is a RE2 regular expression delimited with slashes (/
 escape them with backslashes (\).
 
 Operator is one of symbols:
-   (){}[]=|,;
+   (){}[]=|,;+<>:~
 
 All other symbols not contained in comments or string literals are forbidden.
 
-Grammar description contains three types of records: token type definition, node definition, and directive.
-There must be at least one node definition. All token type definitions and directives
-must precede node definitions.
+Grammar description contains four types of records: token type definition, regexp template definition,
+node definition, and directive. There must be at least one node definition. All token type definitions,
+template definitions, and directives must precede node definitions.
 
 Token type definition has a form:
    $type-name = /regexp/ ;
@@ -66,6 +83,38 @@ of lexer), use non-capturing groups instead (e.g. /(?:foo|bar)+/).
 By default, token regular expressions use s flag (let . match \n), to override use non-capturing group
 with flags (e.g. /"(?U-s:.*)"/).
 
+Regular expression may contain templates of the form $$name, most templates stand for character classes.
+Character class templates may be used both outside and inside bracketed character classes,
+e.g. /[_$$id-start][-$$id-continue]+/. Available character class templates are:
+   $$unicode-letter, $$unicode-upper, $$unicode-lower: letters (\p{L}, \p{Lu}, \p{Ll});
+   $$unicode-digit: decimal digits (\p{Nd});
+   $$unicode-space: ASCII and Unicode white space (\s, \p{Z});
+   $$unicode-punct: punctuation (\p{P});
+   $$id-start, $$id-continue: identifier characters as defined by Unicode Standard Annex #31.
+
+Some templates stand for complete patterns, they cannot be used inside bracketed character classes:
+   $$ident: identifier, an underscore or $$id-start character followed by underscores and $$id-continue characters;
+   $$int: decimal digits (\d+);
+   $$float: decimal number with a dot or an exponent or both, e.g. 1.5, .5, 1., 1e3, 1.5E-3;
+   $$ws: white space (\s+);
+   $$string-dq: double-quoted string without line feeds, backslash escapes any character, e.g. "a \"b\"".
+E.g. $num = /$$float|$$int/; defines token type matching both integer and floating point numbers.
+
+Grammar may define its own templates, each definition has a form:
+   $$template-name = /regexp/ ;
+Template name consists of lowercase latin letters and hyphens. Regular expression may use built-in templates
+and templates defined before it. Template must be defined before it is used and cannot be redefined,
+but it overrides built-in template of the same name, e.g.
+   $$ws = /[ \t]+/; $space = /$$ws/; # does not match line feeds
+Defined templates stand for complete patterns.
+
+A token type may match balanced nested delimiters instead of a regexp, e.g. nested comments:
+   $comment = nested('(*', '*)');   # matches "(* a (* b *) c *)" as a single token
+The first string is the opening delimiter, the second one is the closing delimiter, they must be non-empty
+and distinct. The token starts with the opening delimiter and ends with the closing delimiter balancing it,
+nested pairs of delimiters in between are skipped. Missing closing delimiter is a lexical error
+(lexer.UnclosedTokenError).
+
 Token definition order is important, lexer returns the first defined token type it can match.
 E.g. lexer for grammar definition language will match $error token type only if it sees a quote or exclamation sign,
 but cannot match neither string literal, nor correct directive name.
@@ -77,15 +126,58 @@ Node definition has a form:
 A list consists of one or more comma-separated items. An item is one or more variants separated by pipe (|) symbol.
 A variant is either a node name, a token type, a string literal, or a nested list enclosed in round, square,
 or curly braces. Square braces denote optional lists (matched 0 or 1 time), curly braces denote repeated lists
-(matched 0 or more times).
+(matched 0 or more times). Curly braces with plus sign after the opening one denote lists matched 1 or more
+times, e.g. {+ foo} is the same as foo, {foo}.
 NB: foo | bar, baz is the same as (foo | bar), baz.
 
+A variant may be followed by an action name prefixed with @ symbol, e.g.
+   expr = term, {('+', term @add) | ('-', term @sub)};
+Actions do not affect syntax. Each time parser matches a variant followed by an action, it calls a callback
+bound to the action name (see parser.WithAction), this way simple interpreters can be built without node hooks.
+An action following optional or repeated list is not triggered if the list is skipped.
+
+A node name, a token type, or a string literal may be preceded by a label name and a colon, e.g.
+   var-def = typ:type, name:$name, ';';
+Labels do not affect syntax. Parser reports the label of each matched child (see parser.LabelHandler),
+and tree nodes allow to find children by their labels (see tree.Child), so applications need not rely
+on child positions or token types. Different elements may share the same label.
+
+Items may be separated by tilde (~) instead of comma, this means the next token must immediately follow
+the last token of preceding item, with no spaces, comments, or other aside tokens in between, e.g.
+   shift = '>' ~ '>';   # ">>" is a shift, "> >" is an error
+   call = $name ~ '(', [args], ')';   # "foo()" is a call, "foo ()" is an error
+Parser checks token positions and reports NotAdjacentError if the constraint is broken.
+Adjacency is not taken into account while resolving ambiguities. If the item following tilde is optional and skipped,
+the constraint applies to whatever token comes next.
+
+A variant may be preceded by an error production mark !err("message"), e.g.
+   cond = $name, ('==' | !err("use == to compare values") '='), $name;
+Marked variant is matched as usual, but each time parser matches it, it reports parser.ErrorProductionError
+with given message at the token following the variant. This way grammar controls messages for typical mistakes.
+If errors are collected (see parser.WithErrorCollection), parsing continues, otherwise it stops with the error.
+Message must not be empty.
+
 The first node definition is the root one.
 Order of other nodes does not matter, definitions may contain names of nodes that are defined later.
 Each node must be defined exactly once, e.g.
    foo = bar, baz; foo = qux; # error: foo already defined
    foo = (bar, baz) | qux; # correct
 
+Node template is a parameterized node definition having a form:
+   template-name<param-name, ...> = list ;
+
+Template is instantiated when it is used with arguments (node names, token types, string literals,
+or other template instances), e.g.
+   list<item, sep> = item, {sep, item};
+   args = '(', [list<expr, ','>], ')';
+   path = list<$name, '/'>;
+Each distinct instance becomes a node named after template and its arguments, e.g. list<expr,','>
+is the same as
+   list<expr,','> = expr, {',', expr};
+Template parameters are substituted with arguments in template body, so a template may use other templates
+(or itself, as long as recursion is finite). Templates may be defined anywhere among node definitions,
+but cannot be the root node. Unused templates are ignored.
+
 Directive has a form:
    !name {$token-name | 'string' | "string"} ;
 
@@ -102,11 +194,45 @@ case-insensitive token types must be uppercase, e.g.
    block-start = 'Begin'; # same error
    block-start = 'BEGIN'; # correct
 
+!caseless directive may also list node names. All literals inside listed nodes and all nodes nested in them
+are matched case-insensitively regardless of token type, literals may be written in any case, e.g.
+   $name = /[A-Za-z]+/; !caseless select;
+   query = select, from; select = 'select', $name; from = 'from', $name;
+   # matches "select foo from bar" and "SELECT foo from bar", but not "select foo FROM bar"
+Note that a node nested in both caseless and case-sensitive nodes becomes caseless everywhere.
+
+!caseless-literals directive takes no arguments and makes all nodes caseless, so that all literals
+are matched case-insensitively and may be written in any case, even if they belong to caseless token types, e.g.
+   !caseless-literals; !caseless $name; $name = /[A-Za-z]+/;
+   query = 'select', $name, 'from', $name; # matches "select foo from bar" and "SELECT foo From bar"
+
 !error directive lists error token types. Lexer returns error containing token text when it matches error token.
 
 !extern directive lists token types that are not defined in grammar description, but may be emitted by hooks.
 E.g. $indent and $dedent tokens emitted by hooks when source text indentation level changes.
 
+!preamble directive lists token types that are matched only at the very beginning of a source file,
+so that such token can only be the first one, e.g.
+   !preamble $shebang; $shebang = /#![^\n]*\n/;
+Elsewhere the text is matched against other token types.
+
+!inline directive lists nodes that only group parts of other nodes and are not needed in syntax trees,
+a template name stands for all its instances, e.g.
+   !inline list; list<item, sep> = item, {sep, item};
+Inlining does not affect syntax, parser passes tokens and nested nodes of inline nodes directly
+to the enclosing node when requested, see parser.WithInlineNodes.
+
+!unicode directive lists token types whose regular expressions use Unicode-aware Perl character classes:
+\d, \w, and \s (and their negations) match Unicode digits, word characters, and spaces
+instead of ASCII ones, e.g.
+   !unicode $name; $name = /\w+/; # matches "имя" and "名前"
+Negated classes (\W, \S) cannot be used inside bracketed classes. \b is not affected and stays ASCII-only.
+
+!decode directive lists token types followed by the name of decoder that converts token text to token value
+once when the token is fetched, so that hooks can use Token.Value() instead of decoding the text themselves, e.g.
+   !decode $int $hex int; !decode $string unquote;
+Decoders are provided by parser, see parser.WithDecoder.
+
 !group directive lists token types that must be placed in a separate group. Each token type may be separated
 no more than once. Each group effectively defines a separate lexer.
 When parser needs to fetch a token it tries all suitable lexers (based on expected token types)
@@ -117,6 +243,22 @@ In this case "shorter" token type ("-" operator) must be placed in a separate gr
 leaving "longer" type (number) in default group.
 Another case is a "general" type (e.g. raw text) that can be mistaken for less general type (e.g. name).
 "General" token type must be placed in its own group.
+If the choice depends on parser state rather than on expected token types (e.g. a division operator
+or a regular expression literal), token hooks may make parser try some group first, see parser.ParseContext.SetGroup.
+
+!lexer directive takes a token type followed by node names, a template name stands for all its instances.
+While a listed node is the innermost one, parser fetches tokens using only the lexer of the group
+containing given token type. Nested nodes use all lexers as usual unless they are listed too.
+If a node is listed more than once, the last directive is used.
+This allows lexing text embedded in other text, e.g. interpolated strings:
+   $space = /\s+/; $name = /\w+/; $op = /[+"{}]|\$\{/; !aside $space;
+   !group $part $delim; $part = /(?:[^"$\\]|\\.|\$[^{"])+/; $delim = /"|\$\{/;
+   !lexer $part str;
+   expr = operand, {'+', operand}; operand = $name | str;
+   str = '"', {$part | interp}, '"'; interp = '${', expr, '}';
+Here "a ${x + 1} b" is lexed as '"', "a " part, '${', x, '+', 1, '}', " b" part, and '"',
+spaces inside the string are not skipped. Note that tokens fetched as lookahead are not refetched
+when the innermost node changes, so a listed node should be entered by a literal.
 
 !literal directive lists allowed token types for literals and/or string literals allowed in node definitions.
 By default, all defined token types and any literals are allowed, i.e. langdef parser accepts any literal
@@ -131,5 +273,22 @@ Using directive !literal $op; solves this problem.
 If token text is a reserved word it can be matched as literal, but not as token type,
 e.g. if parser expects $name token type and lexer fetches a "for" reserved word, it is a syntax error.
 
+!left, !right, and !prec directives list binary operator literals having the same precedence
+and left, right, or no associativity respectively. Each directive defines a precedence level,
+the first one binds weakest. Node having variants of the form (node, operator, node) is expanded
+to a cascade of nodes, one for each precedence level used, so that expression grammars can be written flat:
+   !left '+' '-'; !left '*' '/'; !right '^';
+   expr = (expr, ('+' | '-' | '*' | '/' | '^'), expr) | $num | ('(', expr, ')');
+is the same as
+   expr = expr-2, {('+' | '-'), expr-2};
+   expr-2 = expr-3, {('*' | '/'), expr-3};
+   expr-3 = expr-4, ['^', expr-3];
+   expr-4 = $num | ('(', expr, ')');
+A token type in operator position stands for all literals listed in precedence directives.
+Non-associative operator cannot be chained, e.g. a < b < c is a syntax error if '<' is listed in !prec directive.
+
+Grammars written in standard W3C or ISO EBNF can be converted with ParseEBNF or ConvertEBNF.
+Rules with names starting with uppercase letter become token types, other rules become nodes.
+
 */
 package langdef
@@ -0,0 +1,621 @@
+package langdef
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+const (
+	ebnfStringTok = "string"
+	ebnfNameTok   = "name"
+	ebnfCharTok   = "char"
+	ebnfClassTok  = "class"
+	ebnfDefTok    = "def"
+	ebnfOpTok     = "op"
+)
+
+const ebnfLiteralTokenName = "literal"
+
+type ebnfKind int
+
+const (
+	ebnfAlt ebnfKind = iota
+	ebnfSeq
+	ebnfOpt
+	ebnfRep
+	ebnfRep1
+	ebnfDiff
+	ebnfRef
+	ebnfString
+	ebnfChar
+	ebnfClass
+)
+
+type ebnfExpr struct {
+	kind  ebnfKind
+	text  string
+	items []*ebnfExpr
+	token *lexer.Token
+}
+
+type ebnfRule struct {
+	name  string
+	expr  *ebnfExpr
+	token *lexer.Token
+}
+
+type ebnfParser struct {
+	tokens []*lexer.Token
+	pos    int
+	w3c    bool
+}
+
+// ParseEBNF converts W3C or ISO EBNF grammar to grammar.Grammar, see ConvertEBNF for details.
+// Returns nil and llx.Error on error.
+func ParseEBNF(name string, content []byte, aside ...string) (*grammar.Grammar, error) {
+	src, e := ConvertEBNF(name, content, aside...)
+	if e != nil {
+		return nil, e
+	}
+
+	return ParseString(name, src)
+}
+
+// ConvertEBNF converts W3C or ISO EBNF grammar to grammar description accepted by Parse* functions.
+// The dialect is detected by definition symbol:
+//
+//	W3C (XML spec) style:  name ::= item item* ('a' | 'b')? [a-z]+ #x20
+//	ISO 14977 style:       name = item, {item}, [('a' | 'b')], (item - 'x');
+//
+// Both dialects allow names containing latin letters, digits, underscores, and hyphens, quoted terminals,
+// round braces, alternatives, exceptions (-), and postfix ?, *, + operators; comments are enclosed in (* *) or /* */.
+// ISO rules are terminated with ; or . symbol, square and curly braces denote optional and repeated items.
+// W3C rules are not terminated, square braces denote character classes, #xN denotes character code.
+//
+// Rules with names starting with uppercase letter (as in ANTLR) are lexical ones, they are converted to token types
+// and must consist of terminals, character classes and codes, and other lexical rules; exceptions are not supported.
+// Lexical rules not used by other rules directly are inlined. All other rules are converted to nodes,
+// the first one becomes the root node, rules not reachable from it are dropped.
+// Terminals used in non-lexical rules become literals, additional $literal token type is defined
+// to match them when no lexical rule does.
+// aside contains names of lexical rules that must become aside tokens (e.g. whitespace and comments).
+//
+// Returns empty string and llx.Error on error,
+// UnsupportedEBNFError means that some EBNF construct cannot be represented in llx grammar.
+func ConvertEBNF(name string, content []byte, aside ...string) (string, error) {
+	ep := &ebnfParser{w3c: bytes.Contains(content, []byte("::="))}
+	e := ep.tokenize(source.New(name, content))
+	if e != nil {
+		return "", e
+	}
+
+	rules, e := ep.parseRules()
+	if e != nil {
+		return "", e
+	}
+
+	return newEbnfConverter(rules, aside).convert()
+}
+
+func (ep *ebnfParser) tokenize(s *source.Source) error {
+	reSrc := "\\s+|(?s:\\(\\*.*?\\*\\))|(?s:/\\*.*?\\*/)|" +
+		"((?:\"[^\"]*\")|(?:'[^']*'))|" +
+		"([a-zA-Z_][a-zA-Z_0-9-]*)|" +
+		"(#x[0-9a-fA-F]+)|"
+	types := []lexer.TokenType{{1, ebnfStringTok}, {2, ebnfNameTok}, {3, ebnfCharTok}}
+	if ep.w3c {
+		reSrc += "(\\[\\^?[^\\]]+\\])|(::=)|([|()?*+-])|(.)"
+		types = append(types, lexer.TokenType{4, ebnfClassTok}, lexer.TokenType{5, ebnfDefTok}, lexer.TokenType{6, ebnfOpTok})
+	} else {
+		reSrc += "(=)|([|()?*+,;.\\[\\]{}-])|(.)"
+		types = append(types, lexer.TokenType{4, ebnfDefTok}, lexer.TokenType{5, ebnfOpTok})
+	}
+	types = append(types, lexer.TokenType{lexer.ErrorTokenType, wrongTok})
+
+	l := lexer.New(regexp.MustCompile(reSrc), types)
+	q := source.NewQueue().Append(s)
+	for {
+		t, e := l.Next(q)
+		if e != nil {
+			return e
+		}
+
+		ep.tokens = append(ep.tokens, t)
+		if isEof(t) {
+			return nil
+		}
+	}
+}
+
+func (ep *ebnfParser) peek(offset int) *lexer.Token {
+	i := ep.pos + offset
+	if i >= len(ep.tokens) {
+		i = len(ep.tokens) - 1
+	}
+	return ep.tokens[i]
+}
+
+func (ep *ebnfParser) next() *lexer.Token {
+	t := ep.peek(0)
+	if ep.pos < len(ep.tokens)-1 {
+		ep.pos++
+	}
+	return t
+}
+
+func (ep *ebnfParser) isOp(t *lexer.Token, ops string) bool {
+	return t.TypeName() == ebnfOpTok && strings.Contains(ops, t.Text())
+}
+
+func (ep *ebnfParser) expect(typ, text string) (*lexer.Token, error) {
+	t := ep.next()
+	if isEof(t) {
+		return nil, eofError(t)
+	}
+	if t.TypeName() != typ || text != "" && t.Text() != text {
+		return nil, unexpectedTokenError(t)
+	}
+	return t, nil
+}
+
+func (ep *ebnfParser) parseRules() ([]ebnfRule, error) {
+	var rules []ebnfRule
+	for !isEof(ep.peek(0)) {
+		t, e := ep.expect(ebnfNameTok, "")
+		if e == nil {
+			_, e = ep.expect(ebnfDefTok, "")
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		expr, e := ep.parseAlt()
+		if e != nil {
+			return nil, e
+		}
+
+		if !ep.w3c {
+			t := ep.next()
+			if isEof(t) {
+				return nil, eofError(t)
+			}
+			if !ep.isOp(t, ";.") {
+				return nil, unexpectedTokenError(t)
+			}
+		}
+
+		rules = append(rules, ebnfRule{t.Text(), expr, t})
+	}
+
+	if len(rules) == 0 {
+		return nil, eofError(ep.peek(0))
+	}
+
+	return rules, nil
+}
+
+func (ep *ebnfParser) parseAlt() (*ebnfExpr, error) {
+	first := ep.peek(0)
+	items := make([]*ebnfExpr, 0, 1)
+	for {
+		item, e := ep.parseSeq()
+		if e != nil {
+			return nil, e
+		}
+
+		items = append(items, item)
+		if !ep.isOp(ep.peek(0), "|") {
+			break
+		}
+		ep.next()
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &ebnfExpr{kind: ebnfAlt, items: items, token: first}, nil
+}
+
+func (ep *ebnfParser) atSeqEnd() bool {
+	t := ep.peek(0)
+	if isEof(t) || ep.isOp(t, "|)]};.") {
+		return true
+	}
+	return ep.w3c && t.TypeName() == ebnfNameTok && ep.peek(1).TypeName() == ebnfDefTok
+}
+
+func (ep *ebnfParser) parseSeq() (*ebnfExpr, error) {
+	first := ep.peek(0)
+	items := make([]*ebnfExpr, 0, 1)
+	for {
+		item, e := ep.parseDiff()
+		if e != nil {
+			return nil, e
+		}
+
+		items = append(items, item)
+		if !ep.w3c {
+			if !ep.isOp(ep.peek(0), ",") {
+				break
+			}
+			ep.next()
+		} else if ep.atSeqEnd() {
+			break
+		}
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &ebnfExpr{kind: ebnfSeq, items: items, token: first}, nil
+}
+
+func (ep *ebnfParser) parseDiff() (*ebnfExpr, error) {
+	item, e := ep.parsePostfix()
+	if e != nil || !ep.isOp(ep.peek(0), "-") {
+		return item, e
+	}
+
+	t := ep.next()
+	other, e := ep.parsePostfix()
+	if e != nil {
+		return nil, e
+	}
+
+	return &ebnfExpr{kind: ebnfDiff, items: []*ebnfExpr{item, other}, token: t}, nil
+}
+
+func (ep *ebnfParser) parsePostfix() (*ebnfExpr, error) {
+	item, e := ep.parsePrimary()
+	if e != nil {
+		return nil, e
+	}
+
+	for ep.isOp(ep.peek(0), "?*+") {
+		t := ep.next()
+		kind := map[string]ebnfKind{"?": ebnfOpt, "*": ebnfRep, "+": ebnfRep1}[t.Text()]
+		item = &ebnfExpr{kind: kind, items: []*ebnfExpr{item}, token: t}
+	}
+	return item, nil
+}
+
+func (ep *ebnfParser) parsePrimary() (*ebnfExpr, error) {
+	t := ep.next()
+	switch t.TypeName() {
+	case ebnfNameTok:
+		return &ebnfExpr{kind: ebnfRef, text: t.Text(), token: t}, nil
+
+	case ebnfStringTok:
+		return &ebnfExpr{kind: ebnfString, text: t.Text()[1 : len(t.Text())-1], token: t}, nil
+
+	case ebnfCharTok:
+		return &ebnfExpr{kind: ebnfChar, text: t.Text(), token: t}, nil
+
+	case ebnfClassTok:
+		return &ebnfExpr{kind: ebnfClass, text: t.Text(), token: t}, nil
+
+	case ebnfOpTok:
+		closing := map[string]string{"(": ")", "[": "]", "{": "}"}[t.Text()]
+		if closing == "" {
+			break
+		}
+
+		item, e := ep.parseAlt()
+		if e == nil {
+			_, e = ep.expect(ebnfOpTok, closing)
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		switch t.Text() {
+		case "[":
+			item = &ebnfExpr{kind: ebnfOpt, items: []*ebnfExpr{item}, token: t}
+		case "{":
+			item = &ebnfExpr{kind: ebnfRep, items: []*ebnfExpr{item}, token: t}
+		}
+		return item, nil
+	}
+
+	if isEof(t) {
+		return nil, eofError(t)
+	}
+	return nil, unexpectedTokenError(t)
+}
+
+type ebnfConverter struct {
+	rules     []ebnfRule
+	index     map[string]int
+	aside     []string
+	regexps   map[string]string
+	expanding map[string]bool
+	nodes     []string
+	tokens    []string
+	literals  map[string]bool
+}
+
+func newEbnfConverter(rules []ebnfRule, aside []string) *ebnfConverter {
+	return &ebnfConverter{
+		rules:     rules,
+		index:     make(map[string]int),
+		aside:     aside,
+		regexps:   make(map[string]string),
+		expanding: make(map[string]bool),
+		literals:  make(map[string]bool),
+	}
+}
+
+func isLexicalRule(name string) bool {
+	return name != "" && unicode.IsUpper(rune(name[0]))
+}
+
+func (c *ebnfConverter) convert() (string, error) {
+	root := -1
+	for i, r := range c.rules {
+		if _, has := c.index[r.name]; has {
+			return "", defNodeError(r.token)
+		}
+
+		c.index[r.name] = i
+		if root < 0 && !isLexicalRule(r.name) {
+			root = i
+		}
+	}
+	if root < 0 {
+		return "", unsupportedEBNFError(c.rules[0].token, "no non-lexical rules defined")
+	}
+
+	for _, name := range c.aside {
+		if !isLexicalRule(name) {
+			return "", unsupportedEBNFError(nil, "aside rule %q is not a lexical one", name)
+		}
+		c.useToken(name)
+	}
+
+	e := c.collect(c.rules[root].name)
+	if e != nil {
+		return "", e
+	}
+
+	var b strings.Builder
+	if len(c.aside) > 0 {
+		b.WriteString("!aside")
+		for _, name := range c.aside {
+			b.WriteString(" $" + name)
+		}
+		b.WriteString(";\n")
+	}
+
+	for _, name := range c.tokens {
+		re, e := c.tokenRegexp(name, c.rules[c.index[name]].token)
+		if e != nil {
+			return "", e
+		}
+
+		b.WriteString("$" + name + " = /" + escapeSlashes(re) + "/;\n")
+	}
+
+	if len(c.literals) > 0 {
+		lits := make([]string, 0, len(c.literals))
+		for lit := range c.literals {
+			lits = append(lits, lit)
+		}
+		sort.Slice(lits, func(i, j int) bool {
+			if len(lits[i]) != len(lits[j]) {
+				return len(lits[i]) > len(lits[j])
+			}
+			return lits[i] < lits[j]
+		})
+		for i, lit := range lits {
+			lits[i] = regexp.QuoteMeta(lit)
+		}
+		b.WriteString("$" + ebnfLiteralTokenName + " = /" + escapeSlashes(strings.Join(lits, "|")) + "/;\n")
+	}
+
+	for _, name := range c.nodes {
+		body, e := c.nodeBody(c.rules[c.index[name]].expr)
+		if e != nil {
+			return "", e
+		}
+
+		b.WriteString(name + " = " + body + ";\n")
+	}
+
+	return b.String(), nil
+}
+
+func (c *ebnfConverter) useToken(name string) {
+	for _, t := range c.tokens {
+		if t == name {
+			return
+		}
+	}
+	c.tokens = append(c.tokens, name)
+}
+
+func (c *ebnfConverter) collect(name string) error {
+	for _, n := range c.nodes {
+		if n == name {
+			return nil
+		}
+	}
+
+	i, has := c.index[name]
+	if !has {
+		return unknownNodeError([]string{name})
+	}
+
+	c.nodes = append(c.nodes, name)
+	return c.collectExpr(c.rules[i].expr)
+}
+
+func (c *ebnfConverter) collectExpr(x *ebnfExpr) error {
+	switch x.kind {
+	case ebnfRef:
+		if isLexicalRule(x.text) {
+			if _, has := c.index[x.text]; !has {
+				return unknownNodeError([]string{x.text})
+			}
+
+			c.useToken(x.text)
+			return nil
+		}
+		return c.collect(x.text)
+
+	case ebnfString:
+		if x.text == "" {
+			return unsupportedEBNFError(x.token, "empty terminal")
+		}
+		if strings.Contains(x.text, "'") && strings.Contains(x.text, "\"") {
+			return unsupportedEBNFError(x.token, "terminal containing both quote signs")
+		}
+
+		c.literals[x.text] = true
+		return nil
+
+	case ebnfChar, ebnfClass, ebnfDiff:
+		return unsupportedEBNFError(x.token, "character codes, classes, and exceptions are allowed only in lexical rules")
+	}
+
+	for _, item := range x.items {
+		e := c.collectExpr(item)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *ebnfConverter) tokenRegexp(name string, t *lexer.Token) (string, error) {
+	re, has := c.regexps[name]
+	if has {
+		return re, nil
+	}
+
+	i, has := c.index[name]
+	if !has {
+		return "", unknownNodeError([]string{name})
+	}
+	if c.expanding[name] {
+		return "", unsupportedEBNFError(t, "recursive lexical rule %q", name)
+	}
+
+	c.expanding[name] = true
+	re, e := c.regexp(c.rules[i].expr)
+	c.expanding[name] = false
+	if e != nil {
+		return "", e
+	}
+
+	c.regexps[name] = re
+	return re, nil
+}
+
+var ebnfCharCodeRe = regexp.MustCompile("#x([0-9a-fA-F]+)")
+
+func (c *ebnfConverter) regexp(x *ebnfExpr) (string, error) {
+	switch x.kind {
+	case ebnfString:
+		return regexp.QuoteMeta(x.text), nil
+
+	case ebnfChar:
+		return "\\x{" + x.text[2:] + "}", nil
+
+	case ebnfClass:
+		body := x.text[1 : len(x.text)-1]
+		prefix := ""
+		if strings.HasPrefix(body, "^") {
+			prefix = "^"
+			body = body[1:]
+		}
+		body = strings.NewReplacer("\\", "\\\\", "[", "\\[").Replace(body)
+		return "[" + prefix + ebnfCharCodeRe.ReplaceAllString(body, "\\x{$1}") + "]", nil
+
+	case ebnfRef:
+		if !isLexicalRule(x.text) {
+			return "", unsupportedEBNFError(x.token, "lexical rule cannot use non-lexical rule %q", x.text)
+		}
+
+		re, e := c.tokenRegexp(x.text, x.token)
+		return "(?:" + re + ")", e
+
+	case ebnfDiff:
+		return "", unsupportedEBNFError(x.token, "exceptions cannot be converted to regular expressions")
+	}
+
+	parts := make([]string, len(x.items))
+	for i, item := range x.items {
+		re, e := c.regexp(item)
+		if e != nil {
+			return "", e
+		}
+		parts[i] = re
+	}
+
+	switch x.kind {
+	case ebnfAlt:
+		return "(?:" + strings.Join(parts, "|") + ")", nil
+	case ebnfSeq:
+		return strings.Join(parts, ""), nil
+	case ebnfOpt:
+		return "(?:" + parts[0] + ")?", nil
+	case ebnfRep:
+		return "(?:" + parts[0] + ")*", nil
+	default:
+		return "(?:" + parts[0] + ")+", nil
+	}
+}
+
+func (c *ebnfConverter) nodeBody(x *ebnfExpr) (string, error) {
+	switch x.kind {
+	case ebnfRef:
+		if isLexicalRule(x.text) {
+			return "$" + x.text, nil
+		}
+		return x.text, nil
+
+	case ebnfString:
+		if strings.Contains(x.text, "'") {
+			return "\"" + x.text + "\"", nil
+		}
+		return "'" + x.text + "'", nil
+	}
+
+	parts := make([]string, len(x.items))
+	for i, item := range x.items {
+		body, e := c.nodeBody(item)
+		if e != nil {
+			return "", e
+		}
+		parts[i] = body
+	}
+
+	switch x.kind {
+	case ebnfAlt:
+		for i, item := range x.items {
+			if item.kind == ebnfSeq {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		return "(" + strings.Join(parts, " | ") + ")", nil
+	case ebnfSeq:
+		return strings.Join(parts, ", "), nil
+	case ebnfOpt:
+		return "[" + parts[0] + "]", nil
+	case ebnfRep:
+		return "{" + parts[0] + "}", nil
+	default:
+		return "{+ " + parts[0] + "}", nil
+	}
+}
+
+func escapeSlashes(re string) string {
+	return strings.ReplaceAll(re, "/", "\\/")
+}
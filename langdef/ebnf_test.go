@@ -0,0 +1,91 @@
+package langdef
+
+import (
+	"testing"
+
+	"github.com/ava12/llx"
+)
+
+func TestConvertEBNF(t *testing.T) {
+	samples := []struct {
+		src, aside, root, expected string
+	}{
+		{
+			"/* W3C */ list ::= '(' item* ')' item ::= Name | Number | list " +
+				"Name ::= [a-zA-Z_] [a-zA-Z_0-9]* Number ::= Digit+ ('.' Digit+)? Digit ::= [0-9] S ::= (#x20 | #x9)+",
+			"S",
+			"list",
+			"!aside $S;\n$S = /(?:(?:\\x{20}|\\x{9}))+/;\n$Name = /[a-zA-Z_](?:[a-zA-Z_0-9])*/;\n" +
+				"$Number = /(?:(?:[0-9]))+(?:\\.(?:(?:[0-9]))+)?/;\n$literal = /\\(|\\)/;\n" +
+				"list = '(', {item}, ')';\nitem = ($Name | $Number | list);\n",
+		},
+		{
+			"(* ISO *) assign = Name, '=', value, {',', value}; value = Name | \"'\", Name, \"'\" | ['-'], Number.\n" +
+				"unused = Name; Name = 'a' | 'b'; Number = '1' | '2';",
+			"",
+			"assign",
+			"$Name = /(?:a|b)/;\n$Number = /(?:1|2)/;\n$literal = /'|,|-|=/;\n" +
+				"assign = $Name, '=', value, {',', value};\nvalue = ($Name | (\"'\", $Name, \"'\") | (['-'], $Number));\n",
+		},
+	}
+
+	for i, sample := range samples {
+		var aside []string
+		if sample.aside != "" {
+			aside = []string{sample.aside}
+		}
+		got, e := ConvertEBNF("sample", []byte(sample.src), aside...)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		if got != sample.expected {
+			t.Errorf("sample #%d: expecting:\n%s\ngot:\n%s", i, sample.expected, got)
+			continue
+		}
+
+		g, e := ParseEBNF("sample", []byte(sample.src), aside...)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		} else if g.Nodes[0].Name != sample.root {
+			t.Errorf("sample #%d: expecting %q root node, got %q", i, sample.root, g.Nodes[0].Name)
+		}
+	}
+}
+
+func TestConvertEBNFErrors(t *testing.T) {
+	samples := []struct {
+		src  string
+		err  int
+		name string
+	}{
+		{"", UnexpectedEofError, ""},
+		{"a = 'x'", UnexpectedEofError, ""},
+		{"a = 'x' 'y';", UnexpectedTokenError, ""},
+		{"a ::= 'x' )", UnexpectedTokenError, ""},
+		{"a = b; a = 'x';", NodeDefinedError, ""},
+		{"a = b;", UnknownNodeError, ""},
+		{"a = B;", UnknownNodeError, ""},
+		{"A = 'x';", UnsupportedEBNFError, ""},
+		{"a = '';", UnsupportedEBNFError, ""},
+		{"a = 'x' - 'y';", UnsupportedEBNFError, ""},
+		{"a ::= [a-z]", UnsupportedEBNFError, ""},
+		{"a ::= A A ::= 'x' A?", UnsupportedEBNFError, ""},
+		{"a ::= A A ::= b b ::= 'x'", UnsupportedEBNFError, ""},
+		{"a ::= A A ::= [a-z] - 'x'", UnsupportedEBNFError, ""},
+		{"a ::= 'x'", UnsupportedEBNFError, "a"},
+	}
+
+	for i, sample := range samples {
+		var aside []string
+		if sample.name != "" {
+			aside = []string{sample.name}
+		}
+		_, e := ConvertEBNF("sample", []byte(sample.src), aside...)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
@@ -17,7 +17,7 @@ const (
 	UnknownTokenError
 	// node definition uses aside or error token
 	WrongTokenError
-	// redefining already defined token type
+	// redefining already defined token type or regexp template
 	TokenDefinedError
 	// redefining already defined node
 	NodeDefinedError
@@ -41,6 +41,12 @@ const (
 	UnknownLiteralError
 	// trying to move token to new group more than once
 	ReassignedGroupError
+	// EBNF construct cannot be represented in llx grammar
+	UnsupportedEBNFError
+	// cannot expand node using operator precedence directives
+	PrecedenceError
+	// undefined node template, wrong number of template arguments, or too many template instances
+	TemplateError
 )
 
 func eofError(token *lexer.Token) *llx.Error {
@@ -67,6 +73,21 @@ func defNodeError(token *lexer.Token) *llx.Error {
 	return llx.FormatErrorPos(token, NodeDefinedError, "node %q already defined", token.Text())
 }
 
+// withPreviousDef adds the position of the first definition of given name to related locations of e.
+func withPreviousDef(e *llx.Error, defs []Definition, name string) *llx.Error {
+	for _, d := range defs {
+		if d.Name == name && d.Pos.Line() != 0 {
+			p := d.Pos
+			e.Related = append(e.Related, llx.RelatedInfo{
+				Span:    llx.Span{SourceName: p.SourceName(), Line: p.Line(), Col: p.Col()},
+				Message: "previously defined here",
+			})
+			break
+		}
+	}
+	return e
+}
+
 func regexpError(token *lexer.Token, e error) *llx.Error {
 	return llx.FormatErrorPos(token, WrongRegexpError, "incorrect RegExp %s (%s)", token.Text(), e.Error())
 }
@@ -106,3 +127,18 @@ func unknownLiteralError(text string) *llx.Error {
 func reassignedGroupError(name string) *llx.Error {
 	return llx.FormatError(ReassignedGroupError, "cannot move %q token to another group again", name)
 }
+
+func unsupportedEBNFError(token *lexer.Token, msg string, params ...any) *llx.Error {
+	if token == nil {
+		return llx.FormatError(UnsupportedEBNFError, "unsupported EBNF: "+msg, params...)
+	}
+	return llx.FormatErrorPos(token, UnsupportedEBNFError, "unsupported EBNF: "+msg, params...)
+}
+
+func precedenceError(node, msg string, params ...any) *llx.Error {
+	return llx.FormatError(PrecedenceError, "cannot expand %q node: "+msg, append([]any{node}, params...)...)
+}
+
+func templateError(token *lexer.Token, msg string, params ...any) *llx.Error {
+	return llx.FormatErrorPos(token, TemplateError, msg, params...)
+}
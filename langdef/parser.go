@@ -51,19 +51,43 @@ func ParseBytes(name string, content []byte) (*grammar.Grammar, error) {
 // Parse parses grammar description and returns grammar on success.
 // Returns nil and llx.Error on error.
 func Parse(s *source.Source) (*grammar.Grammar, error) {
+	g, _, e := ParseOrdered(s)
+	return g, e
+}
+
+// ParseOrdered is same as Parse, but also returns the map of token type and node definitions
+// in order of their appearance in grammar description.
+// Returns nil, nil, and llx.Error on error.
+func ParseOrdered(s *source.Source) (*grammar.Grammar, *SourceMap, error) {
 	result, e := parseLangDef(s)
 	if e != nil {
-		return nil, e
+		return nil, nil, e
 	}
 
-	e = assignTokenGroups(result, e)
-	e = findUndefinedNodes(result.NIndex, e)
+	e = prepareNodes(result, e)
 	e = findUnusedNodes(result.Nodes, result.NIndex, e)
+	g, e := completeGrammar(result, e)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	return g, result.SMap, nil
+}
+
+func prepareNodes(result *parseResult, e error) error {
+	e = expandPrecedence(result, e)
+	e = assignTokenGroups(result, e)
+	return findUndefinedNodes(result.NIndex, e)
+}
+
+func completeGrammar(result *parseResult, e error) (*grammar.Grammar, error) {
 	e = resolveDependencies(result.Nodes, result.NIndex, e)
 	e = buildStates(result, e)
+	e = markCaselessStates(result, e)
+	e = markInlineNodes(result, e)
+	e = assignNodeGroups(result, e)
 	e = findRecursions(result, e)
 	e = assignStateTokenTypes(result, e)
-
 	return buildGrammar(result, e)
 }
 
@@ -77,6 +101,9 @@ const (
 	tokenNameTok  = "token-name"
 	regexpTok     = "regexp"
 	opTok         = "op"
+	actionTok     = "action"
+	errMarkTok    = "error-mark"
+	patternTok    = "pattern-name"
 	wrongTok      = ""
 )
 
@@ -91,6 +118,9 @@ const (
 	rSquareTok   = "]"
 	lCurlyTok    = "{"
 	rCurlyTok    = "}"
+	plusTok      = "+"
+	colonTok     = ":"
+	tildeTok     = "~"
 )
 
 var (
@@ -98,9 +128,10 @@ var (
 )
 
 type extraToken struct {
-	name  string
-	group int
-	flags grammar.TokenFlags
+	name    string
+	group   int
+	flags   grammar.TokenFlags
+	decoder string
 }
 
 type literalToken struct {
@@ -119,6 +150,12 @@ type parseContext struct {
 	currentGroup int
 	restrictLtts bool
 	restrictLs   bool
+	unicode      []*lexer.Token
+	regexps      map[string]*lexer.Token
+	templates    map[string]*nodeTemplate
+	instances    []templateInstance
+	instanced    map[string]bool
+	patterns     map[string]string
 }
 
 func init() {
@@ -132,6 +169,9 @@ func init() {
 		{7, tokenNameTok},
 		{8, regexpTok},
 		{9, opTok},
+		{10, actionTok},
+		{11, errMarkTok},
+		{12, patternTok},
 		{lexer.ErrorTokenType, wrongTok},
 	}
 }
@@ -143,13 +183,16 @@ func parseLangDef(s *source.Source) (*parseResult, error) {
 		"\\s+|#[^\\n]*|" +
 			"((?:\".*?\")|(?:'.*?'))|" +
 			"([a-zA-Z_][a-zA-Z_0-9-]*)|" +
-			"(!(?:aside|caseless|error|extern)\\b)|" +
-			"(!reserved\\b)|" +
+			"(!(?:aside|caseless-literals|caseless|decode|error|extern|inline|lexer|preamble|unicode)\\b)|" +
+			"(!(?:reserved|left|right|prec)\\b)|" +
 			"(!literal\\b)|" +
 			"(!group\\b)|" +
 			"(\\$[a-zA-Z_][a-zA-Z_0-9-]*)|" +
 			"(/(?:[^\\\\/]|\\\\.)+/)|" +
-			"([(){}\\[\\]=|,;])|" +
+			"([(){}\\[\\]=|,;+<>:~])|" +
+			"(@[a-zA-Z_][a-zA-Z_0-9-]*)|" +
+			"(!err\\b)|" +
+			"(\\$\\$[a-z]+(?:-[a-z]+)*)|" +
 			"(['\"/!].{0,10})")
 
 	q := source.NewQueue().Append(s)
@@ -159,11 +202,12 @@ func parseLangDef(s *source.Source) (*parseResult, error) {
 	ti := tokenIndex{}
 	lti := tokenIndex{}
 	g := newParseResult()
-	c := &parseContext{q, l, g, make([]literalToken, 0), ti, lti, ets, eti, 0, false, false}
+	c := &parseContext{q, l, g, make([]literalToken, 0), ti, lti, ets, eti, 0, false, false, nil, make(map[string]*lexer.Token),
+		make(map[string]*nodeTemplate), nil, make(map[string]bool), make(map[string]string)}
 
 	var t *lexer.Token
 	for e == nil {
-		t, e = fetch(q, l, []string{nameTok, dirTok, literalDirTok, mixedDirTok, groupDirTok, tokenNameTok}, true, nil)
+		t, e = fetch(q, l, []string{nameTok, dirTok, literalDirTok, mixedDirTok, groupDirTok, tokenNameTok, patternTok}, true, nil)
 		if e != nil {
 			return nil, e
 		}
@@ -185,20 +229,29 @@ func parseLangDef(s *source.Source) (*parseResult, error) {
 		case mixedDirTok:
 			e = parseMixedDir(t.Text(), c)
 
+		case patternTok:
+			e = parsePatternDef(t, c)
+
 		case tokenNameTok:
 			name := t.Text()[1:]
 			i, has := ti[name]
 			if has && g.Tokens[i].Re != "" {
-				return nil, defTokenError(t)
+				return nil, withPreviousDef(defTokenError(t), g.SMap.Tokens, name)
 			}
 
 			e = parseTokenDef(name, c)
+			g.SMap.Tokens = append(g.SMap.Tokens, Definition{name, t.Pos()})
 		}
 	}
 	if e != nil {
 		return nil, e
 	}
 
+	e = applyUnicode(c)
+	if e != nil {
+		return nil, e
+	}
+
 	if len(c.g.Tokens)+len(c.ets) >= grammar.MaxTokenType {
 		return nil, tokenTypeNumberError(t)
 	}
@@ -232,16 +285,26 @@ func parseLangDef(s *source.Source) (*parseResult, error) {
 	nti := g.NIndex
 	for e == nil && t != nil && !isEof(t) {
 		_, has := nti[t.Text()]
-		if has && nti[t.Text()].Chunk != nil {
-			return nil, defNodeError(t)
+		if has && nti[t.Text()].Chunk != nil || c.templates[t.Text()] != nil {
+			return nil, withPreviousDef(defNodeError(t), g.SMap.Nodes, t.Text())
 		}
 
-		e = parseNodeDef(t.Text(), c)
+		var lt *lexer.Token
+		lt, e = fetchOne(q, l, lAngleTok, false, nil)
+		if lt != nil {
+			e = parseTemplateDef(t, c)
+		} else if e == nil {
+			e = parseNodeDef(t.Text(), c)
+			g.SMap.Nodes = append(g.SMap.Nodes, Definition{t.Text(), t.Pos()})
+		}
 		if e == nil {
 			t, e = fetch(q, l, []string{nameTok, lexer.EofTokenName, lexer.EoiTokenName}, true, nil)
 		}
 	}
 
+	if e == nil {
+		e = defineInstances(c)
+	}
 	return g, e
 }
 
@@ -344,7 +407,7 @@ func addToken(name, re string, flags grammar.TokenFlags, c *parseContext) int {
 		t = c.ets[i]
 		delete(c.eti, name)
 	}
-	c.g.Tokens = append(c.g.Tokens, grammar.Token{name, re, t.group, flags | t.flags})
+	c.g.Tokens = append(c.g.Tokens, grammar.Token{name, re, t.group, flags | t.flags, t.decoder})
 	index := len(c.g.Tokens) - 1
 	c.ti[name] = index
 	return index
@@ -365,7 +428,7 @@ func useLiteralToken(name string, flags grammar.TokenFlags, c *parseContext) int
 	}
 
 	i = len(c.g.Tokens)
-	c.g.Tokens = append(c.g.Tokens, grammar.Token{name, "", 0, flags | grammar.LiteralToken})
+	c.g.Tokens = append(c.g.Tokens, grammar.Token{name, "", 0, flags | grammar.LiteralToken, ""})
 	c.lti[name] = i
 	return i
 }
@@ -391,12 +454,43 @@ func addTokenFlag(name string, flag grammar.TokenFlags, c *parseContext) {
 }
 
 func parseDir(name string, c *parseContext) error {
-	tokens, e := fetchAll(c.q, c.l, []string{tokenNameTok}, nil)
+	if name == "!caseless-literals" {
+		c.g.AllCaseless = true
+		return skipOne(c.q, c.l, semicolonTok, nil)
+	}
+
+	if name == "!decode" {
+		return parseDecodeDir(c)
+	}
+
+	if name == "!lexer" {
+		return parseLexerDir(c)
+	}
+
+	types := []string{tokenNameTok}
+	if name == "!caseless" {
+		types = append(types, nameTok)
+	} else if name == "!inline" {
+		types = []string{nameTok}
+	}
+	tokens, e := fetchAll(c.q, c.l, types, nil)
 	e = skipOne(c.q, c.l, semicolonTok, e)
 	if e != nil {
 		return e
 	}
 
+	if name == "!unicode" {
+		c.unicode = append(c.unicode, tokens...)
+		return nil
+	}
+
+	if name == "!inline" {
+		for _, token := range tokens {
+			c.g.Inline = append(c.g.Inline, token.Text())
+		}
+		return nil
+	}
+
 	var flag grammar.TokenFlags = 0
 	switch name {
 	case "!aside":
@@ -407,11 +501,52 @@ func parseDir(name string, c *parseContext) error {
 		flag = grammar.ExternalToken
 	case "!error":
 		flag = grammar.ErrorToken
+	case "!preamble":
+		flag = grammar.PreambleToken
 	}
 	for _, token := range tokens {
-		addTokenFlag(token.Text()[1:], flag, c)
+		if token.TypeName() == nameTok {
+			c.g.Caseless = append(c.g.Caseless, token.Text())
+		} else {
+			addTokenFlag(token.Text()[1:], flag, c)
+		}
+	}
+
+	return nil
+}
+
+func parseDecodeDir(c *parseContext) error {
+	tokens, e := fetchAll(c.q, c.l, []string{tokenNameTok}, nil)
+	decoder, e := fetchOne(c.q, c.l, nameTok, true, e)
+	e = skipOne(c.q, c.l, semicolonTok, e)
+	if e != nil {
+		return e
+	}
+
+	for _, token := range tokens {
+		name := token.Text()[1:]
+		i, has := c.ti[name]
+		if has {
+			c.g.Tokens[i].Decoder = decoder.Text()
+		} else {
+			i = addExtraToken(name, c)
+			c.ets[i].decoder = decoder.Text()
+		}
+	}
+	return nil
+}
+
+func parseLexerDir(c *parseContext) error {
+	token, e := fetchOne(c.q, c.l, tokenNameTok, true, nil)
+	nodes, e := fetchAll(c.q, c.l, []string{nameTok}, e)
+	e = skipOne(c.q, c.l, semicolonTok, e)
+	if e != nil {
+		return e
 	}
 
+	for _, node := range nodes {
+		c.g.NodeLexers = append(c.g.NodeLexers, nodeLexer{node.Text(), token.Text()[1:]})
+	}
 	return nil
 }
 
@@ -451,9 +586,25 @@ func parseLiteralDir(dir string, c *parseContext) error {
 		return e
 	}
 
+	var level *precLevel
+	switch dir {
+	case "!left":
+		level = &precLevel{Assoc: leftAssoc}
+	case "!right":
+		level = &precLevel{Assoc: rightAssoc}
+	case "!prec":
+		level = &precLevel{Assoc: nonAssoc}
+	}
+
 	for _, t := range tokens {
 		text := t.Text()
 		addLiteralToken(text[1:len(text)-1], flags, c)
+		if level != nil {
+			level.Literals = append(level.Literals, text[1:len(text)-1])
+		}
+	}
+	if level != nil {
+		c.g.Precedence = append(c.g.Precedence, *level)
 	}
 	return nil
 }
@@ -480,23 +631,88 @@ func parseMixedDir(dir string, c *parseContext) error {
 
 func parseTokenDef(name string, c *parseContext) error {
 	e := skipOne(c.q, c.l, equTok, nil)
-	token, e := fetchOne(c.q, c.l, regexpTok, true, e)
+	token, e := fetch(c.q, c.l, []string{regexpTok, nameTok}, true, e)
+	if e == nil && token.TypeName() == nameTok {
+		return parseNestedTokenDef(name, token, c)
+	}
+
 	e = skipOne(c.q, c.l, semicolonTok, e)
 	if e != nil {
 		return e
 	}
 
-	re := token.Text()[1 : len(token.Text())-1]
-	_, e = regexp.Compile(re)
+	re, e := expandRegexp(token.Text()[1:len(token.Text())-1], c.patterns, false)
+	if e == nil {
+		_, e = regexp.Compile(re)
+	}
 	if e != nil {
 		return regexpError(token, e)
 	}
 
+	c.regexps[name] = token
+
 	addToken(name, re, 0, c)
 
 	return nil
 }
 
+// parsePatternDef parses the rest of "$$name = /regexp/;" definition.
+func parsePatternDef(nameToken *lexer.Token, c *parseContext) error {
+	name := nameToken.Text()[2:]
+	if _, has := c.patterns[name]; has {
+		return defTokenError(nameToken)
+	}
+
+	e := skipOne(c.q, c.l, equTok, nil)
+	token, e := fetchOne(c.q, c.l, regexpTok, true, e)
+	e = skipOne(c.q, c.l, semicolonTok, e)
+	if e != nil {
+		return e
+	}
+
+	re, e := expandRegexp(token.Text()[1:len(token.Text())-1], c.patterns, false)
+	if e == nil {
+		_, e = regexp.Compile(re)
+	}
+	if e != nil {
+		return regexpError(token, e)
+	}
+
+	c.patterns[name] = re
+	return nil
+}
+
+// parseNestedTokenDef parses the rest of "$name = nested('open', 'close');" definition.
+func parseNestedTokenDef(name string, token *lexer.Token, c *parseContext) error {
+	if token.Text() != "nested" {
+		return unexpectedTokenError(token)
+	}
+
+	e := skipOne(c.q, c.l, lBraceTok, nil)
+	ot, e := fetchOne(c.q, c.l, stringTok, true, e)
+	e = skipOne(c.q, c.l, commaTok, e)
+	ct, e := fetchOne(c.q, c.l, stringTok, true, e)
+	e = skipOne(c.q, c.l, rBraceTok, e)
+	e = skipOne(c.q, c.l, semicolonTok, e)
+	if e != nil {
+		return e
+	}
+
+	open := ot.Text()[1 : len(ot.Text())-1]
+	close := ct.Text()[1 : len(ct.Text())-1]
+	if open == "" {
+		return unexpectedTokenError(ot)
+	}
+	if close == "" || close == open {
+		return unexpectedTokenError(ct)
+	}
+
+	c.regexps[name] = ot
+	i := addToken(name, regexp.QuoteMeta(open), 0, c)
+	c.g.Nested = append(c.g.Nested, grammar.NestedToken{Token: i, Open: open, Close: close})
+	return nil
+}
+
 func addNode(name string, c *parseContext, define bool) *nodeItem {
 	var group *groupChunk = nil
 	if define {
@@ -512,14 +728,22 @@ func addNode(name string, c *parseContext, define bool) *nodeItem {
 
 	result = &nodeItem{len(c.g.Nodes), ints.NewSet(), ints.NewSet(), group}
 	c.g.NIndex[name] = result
-	c.g.Nodes = append(c.g.Nodes, grammar.Node{name, 0})
+	c.g.Nodes = append(c.g.Nodes, grammar.Node{Name: name})
 	return result
 }
 
 func parseNodeDef(name string, c *parseContext) error {
-	nt := addNode(name, c, true)
 	e := skipOne(c.q, c.l, equTok, nil)
-	e = parseGroup(name, nt.Chunk, c, e)
+	return parseNodeBody(name, c, e)
+}
+
+func parseNodeBody(name string, c *parseContext, e error) error {
+	if e != nil {
+		return e
+	}
+
+	nt := addNode(name, c, true)
+	e = parseGroup(name, nt.Chunk, c, nil)
 	e = skipOne(c.q, c.l, semicolonTok, e)
 	return e
 }
@@ -535,8 +759,11 @@ func parseGroup(name string, group complexChunk, c *parseContext, e error) error
 			return e
 		}
 
+		t, e := fetch(c.q, c.l, []string{commaTok, tildeTok}, false, nil)
+		if t != nil && t.Text() == tildeTok {
+			item = newAdjacentChunk(item)
+		}
 		group.Append(item)
-		t, e := fetchOne(c.q, c.l, commaTok, false, nil)
 		if t == nil {
 			return e
 		}
@@ -572,6 +799,72 @@ func parseVariants(name string, c *parseContext) (chunk, error) {
 }
 
 func parseVariant(name string, c *parseContext) (chunk, error) {
+	message, e := parseErrorMark(c)
+	if e != nil {
+		return nil, e
+	}
+
+	ch, e := parseElement(name, c)
+	if e != nil {
+		return nil, e
+	}
+
+	if message != "" {
+		ch = newErrorChunk(message, ch)
+	}
+
+	t, e := fetchOne(c.q, c.l, actionTok, false, nil)
+	if e != nil {
+		return nil, e
+	} else if t != nil && !isEof(t) {
+		return newActionChunk(t.Text()[1:], ch), nil
+	}
+
+	return ch, nil
+}
+
+// parseErrorMark parses optional error production mark, i.e. !err("message"), returns the message.
+func parseErrorMark(c *parseContext) (string, error) {
+	t, e := fetchOne(c.q, c.l, errMarkTok, false, nil)
+	if e != nil || t == nil || isEof(t) {
+		return "", e
+	}
+
+	e = skipOne(c.q, c.l, lBraceTok, nil)
+	mt, e := fetchOne(c.q, c.l, stringTok, true, e)
+	e = skipOne(c.q, c.l, rBraceTok, e)
+	if e != nil {
+		return "", e
+	}
+
+	message := mt.Text()[1 : len(mt.Text())-1]
+	if message == "" {
+		return "", unexpectedTokenError(mt)
+	}
+	return message, nil
+}
+
+// parseLabeledElement parses a single token or node following "label:".
+func parseLabeledElement(label, name string, c *parseContext) (chunk, error) {
+	t, e := fetch(c.q, c.l, []string{nameTok, tokenNameTok, stringTok}, true, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	put(t)
+	ch, e := parseElement(name, c)
+	if e != nil {
+		return nil, e
+	}
+
+	if _, nested := ch.(*labeledChunk); nested {
+		return nil, unexpectedTokenError(t)
+	}
+
+	return newLabeledChunk(label, ch), nil
+}
+
+func parseElement(name string, c *parseContext) (chunk, error) {
 	variantHeads := []string{nameTok, tokenNameTok, stringTok, lBraceTok, lSquareTok, lCurlyTok}
 	t, e := fetch(c.q, c.l, variantHeads, true, nil)
 	if e != nil {
@@ -584,9 +877,25 @@ func parseVariant(name string, c *parseContext) (chunk, error) {
 	)
 	switch t.TypeName() {
 	case nameTok:
-		nt := addNode(t.Text(), c, false)
+		ct, e := fetchOne(c.q, c.l, colonTok, false, nil)
+		if e != nil {
+			return nil, e
+		} else if ct != nil && !isEof(ct) {
+			return parseLabeledElement(t.Text(), name, c)
+		}
+
+		nodeName := t.Text()
+		lt, e := fetchOne(c.q, c.l, lAngleTok, false, nil)
+		if lt != nil {
+			nodeName, e = parseTemplateArgs(t, c, 0)
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		nt := addNode(nodeName, c, false)
 		c.g.NIndex[name].DependsOn.Add(nt.Index)
-		return newNodeChunk(t.Text(), nt), nil
+		return newNodeChunk(nodeName, nt), nil
 
 	case tokenNameTok:
 		index, f = c.ti[t.Text()[1:]]
@@ -624,13 +933,27 @@ func parseVariant(name string, c *parseContext) (chunk, error) {
 		lastToken = rBraceTok
 	}
 
-	result := newGroupChunk(optional, repeated)
+	atLeastOnce := false
+	if repeated {
+		pt, e := fetchOne(c.q, c.l, plusTok, false, nil)
+		if e != nil {
+			return nil, e
+		}
+
+		atLeastOnce = (pt != nil)
+	}
+
+	result := newGroupChunk(optional && !atLeastOnce, repeated && !atLeastOnce)
 	e = parseGroup(name, result, c, nil)
 	e = skipOne(c.q, c.l, lastToken, e)
 	if e != nil {
 		return nil, e
 	}
 
+	if atLeastOnce {
+		return newOneOrMoreChunk(result), nil
+	}
+
 	return result, nil
 }
 
@@ -658,6 +981,16 @@ func findUnusedNodes(nts []grammar.Node, nti nodeIndex, e error) error {
 		return e
 	}
 
+	unreachedNts := unreachableNodes(nts, nti)
+	if unreachedNts.IsEmpty() {
+		return nil
+	} else {
+		return unusedNodeError(nodeNames(nts, unreachedNts))
+	}
+}
+
+// unreachableNodes returns the set of indexes of nodes that cannot be reached from the root node.
+func unreachableNodes(nts []grammar.Node, nti nodeIndex) *ints.Set {
 	unreachedNts := ints.NewSet()
 	for i := 0; i < len(nts); i++ {
 		unreachedNts.Add(i)
@@ -679,11 +1012,7 @@ func findUnusedNodes(nts []grammar.Node, nti nodeIndex, e error) error {
 		}
 	}
 
-	if unreachedNts.IsEmpty() {
-		return nil
-	} else {
-		return unusedNodeError(nodeNames(nts, unreachedNts))
-	}
+	return unreachedNts
 }
 
 func resolveDependencies(nts []grammar.Node, nti nodeIndex, e error) error {
@@ -782,6 +1111,119 @@ func buildStates(g *parseResult, e error) error {
 	return nil
 }
 
+// markInlineNodes flags nodes listed in !inline directive, a template name stands for all its instances.
+func markInlineNodes(g *parseResult, e error) error {
+	if e != nil {
+		return e
+	}
+
+	for _, name := range g.Inline {
+		found := false
+		for i, nt := range g.Nodes {
+			if nt.Name == name || strings.HasPrefix(nt.Name, name+"<") {
+				g.Nodes[i].Flags |= grammar.InlineNode
+				found = true
+			}
+		}
+		if !found {
+			return unknownNodeError([]string{name})
+		}
+	}
+
+	return nil
+}
+
+// assignNodeGroups resolves nodes listed in !lexer directives, a template name stands for all its instances.
+// If a node is listed more than once, the last entry is used.
+func assignNodeGroups(g *parseResult, e error) error {
+	if e != nil || len(g.NodeLexers) == 0 {
+		return e
+	}
+
+	groups := make(map[int]int)
+	for _, nl := range g.NodeLexers {
+		group := -1
+		for _, t := range g.Tokens {
+			if t.Name == nl.token && t.Flags&grammar.LiteralToken == 0 {
+				group = t.Group
+				break
+			}
+		}
+		if group < 0 {
+			return undefinedTokenError(nl.token)
+		}
+
+		found := false
+		for i, nt := range g.Nodes {
+			if nt.Name == nl.node || strings.HasPrefix(nt.Name, nl.node+"<") {
+				groups[i] = group
+				found = true
+			}
+		}
+		if !found {
+			return unknownNodeError([]string{nl.node})
+		}
+	}
+
+	for i := range g.Nodes {
+		if group, has := groups[i]; has {
+			g.NodeGroups = append(g.NodeGroups, grammar.NodeGroup{Node: i, Group: group})
+		}
+	}
+	return nil
+}
+
+func markCaselessStates(g *parseResult, e error) error {
+	if e != nil {
+		return e
+	}
+
+	marked := ints.NewSet()
+	markQueue := queue.New[int]()
+	if g.AllCaseless {
+		for i := range g.Nodes {
+			markQueue.Append(i)
+		}
+	}
+	for _, name := range g.Caseless {
+		item := g.NIndex[name]
+		if item == nil {
+			return unknownNodeError([]string{name})
+		}
+
+		markQueue.Append(item.Index)
+	}
+
+	for {
+		index, fetched := markQueue.First()
+		if !fetched {
+			break
+		}
+
+		if marked.Contains(index) {
+			continue
+		}
+
+		marked.Add(index)
+		lastState := len(g.States)
+		if index+1 < len(g.Nodes) {
+			lastState = g.Nodes[index+1].FirstState
+		}
+		for _, se := range g.States[g.Nodes[index].FirstState:lastState] {
+			se.Caseless = true
+			for _, rs := range se.Rules {
+				for _, r := range rs {
+					if r.Node != grammar.SameNode {
+						markQueue.Append(r.Node)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func findRecursions(g *parseResult, e error) error {
 	if e != nil {
 		return e
@@ -825,7 +1267,7 @@ func assignTokenGroups(g *parseResult, e error) error {
 	)
 	res := make(map[int]*regexp.Regexp)
 	ts := g.Tokens
-	g.TTypes = make([]grammar.BitSet, len(ts))
+	g.TTypes = make([]*ints.Set, len(ts))
 
 	for rcnt, t = range ts {
 		if t.Re == "" {
@@ -843,18 +1285,19 @@ func assignTokenGroups(g *parseResult, e error) error {
 	}
 	lts := ts[rcnt:]
 	for i := 0; i < rcnt; i++ {
-		g.TTypes[i] = 1 << i
+		g.TTypes[i] = ints.NewSet(i)
 	}
 
 	for i, lt := range lts {
-		caseless := (lt.Name == strings.ToUpper(lt.Name))
+		caseless := g.AllCaseless || (lt.Name == strings.ToUpper(lt.Name))
+		g.TTypes[rcnt+i] = ints.NewSet()
 		for j, re := range res {
 			rt := rts[j]
 			if (rt.Flags&grammar.CaselessToken == 0 || caseless) && re.FindString(lt.Name) == lt.Name {
-				g.TTypes[rcnt+i] |= 1 << j
+				g.TTypes[rcnt+i].Add(j)
 			}
 		}
-		if g.TTypes[rcnt+i] == 0 {
+		if g.TTypes[rcnt+i].IsEmpty() {
 			return unresolvedTokenTypesError(lt.Name)
 		}
 	}
@@ -867,10 +1310,10 @@ func assignStateTokenTypes(g *parseResult, e error) error {
 		return e
 	}
 
-	var defaultTypes grammar.BitSet
+	defaultTypes := ints.NewSet()
 	for i, t := range g.Tokens {
 		if t.Flags&grammar.AsideToken != 0 {
-			defaultTypes |= 1 << i
+			defaultTypes.Add(i)
 		}
 	}
 
@@ -885,20 +1328,40 @@ func assignStateTokenTypes(g *parseResult, e error) error {
 
 		for j := nt.FirstState; j < lastState; j++ {
 			st := g.States[j]
-			types := defaultTypes
+			types := defaultTypes.Copy()
 			for k := range st.Rules {
 				if k >= 0 {
-					types |= g.TTypes[k]
+					types.Union(g.TTypes[k])
 				}
 			}
 
-			g.States[j].Types = types
+			g.States[j].Types = grammar.NewBitSet(types.ToSlice()...)
+		}
+	}
+
+	for _, st := range g.States {
+		if st.IsMarker() {
+			st.Types = markerStateTypes(g, st)
 		}
 	}
 
 	return nil
 }
 
+// markerStateTypes returns token types acceptable after action or adjacency state, so that parser (and branches
+// resolving ambiguity) fetch the same tokens as after the preceding element without marker.
+func markerStateTypes(g *parseResult, st *stateEntry) grammar.BitSet {
+	for st.IsMarker() {
+		next := st.Rules[grammar.AnyToken][0].State
+		if next == grammar.FinalState {
+			break
+		}
+
+		st = g.States[next]
+	}
+	return st.Types
+}
+
 func nodeNames(nts []grammar.Node, ntis *ints.Set) []string {
 	indexes := ntis.ToSlice()
 	names := make([]string, len(indexes))
@@ -913,5 +1376,8 @@ func buildGrammar(pr *parseResult, e error) (*grammar.Grammar, error) {
 		return nil, e
 	}
 
+	for i, nt := range pr.Nodes {
+		pr.Nodes[i].FirstTokens = grammar.NewBitSet(pr.NIndex[nt.Name].FirstTokens.ToSlice()...)
+	}
 	return pr.BuildGrammar(), nil
 }
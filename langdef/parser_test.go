@@ -3,6 +3,8 @@ package langdef
 import (
 	"fmt"
 	gr "github.com/ava12/llx/grammar"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -94,8 +96,26 @@ func TestNodeDefined(t *testing.T) {
 	checkErrorCode(t, samples, NodeDefinedError)
 }
 
+func TestPreviousDefinition(t *testing.T) {
+	samples := []struct {
+		src       string
+		line, col int
+	}{
+		{"$foo = /a/;\n$bar = /b/;\n$foo = /c/;", 1, 1},
+		{"g = foo | bar;\nfoo = 'foo';\nbar = 'bar';\nfoo = 'baz';", 2, 1},
+	}
+
+	for i, s := range samples {
+		_, e := ParseString("", s.src)
+		ee, valid := e.(*llx.Error)
+		if !valid || len(ee.Related) != 1 || ee.Related[0].Span.Line != s.line || ee.Related[0].Span.Col != s.col {
+			t.Errorf("sample #%d: expecting previous definition at %d:%d, got %#v", i, s.line, s.col, e)
+		}
+	}
+}
+
 func TestWrongRe(t *testing.T) {
-	res := []string{"\x80", "(foo", "foo)", "[foo", "\\C"}
+	res := []string{"\x80", "(foo", "foo)", "[foo", "\\C", "$$foo"}
 	for _, re := range res {
 		s := source.New("", []byte("$foo = /"+re+"/;"))
 		_, e := Parse(s)
@@ -116,6 +136,7 @@ func TestWrongRe(t *testing.T) {
 func TestUnknownNode(t *testing.T) {
 	samples := []string{
 		"$name = /\\w+/; foo = 'foo' | bar;",
+		"!caseless bar; $name = /\\w+/; foo = 'foo';",
 	}
 	checkErrorCode(t, samples, UnknownNodeError)
 }
@@ -163,6 +184,7 @@ func TestUnresolvedGroupsError(t *testing.T) {
 func TestUndefinedTokenError(t *testing.T) {
 	samples := []string{
 		"!caseless $foo; g = $foo;",
+		"!unicode $foo; !extern $foo; g = $foo;",
 	}
 	checkErrorCode(t, samples, UndefinedTokenError)
 }
@@ -181,6 +203,32 @@ func TestReassignedGroupError(t *testing.T) {
 	checkErrorCode(t, samples, ReassignedGroupError)
 }
 
+func TestPrecedenceError(t *testing.T) {
+	samples := []string{
+		"!left '+'; $op = /[-+]/; $num = /\\d+/; e = (e, ('+' | '-'), e) | $num;",
+		"!left '+'; $op = /[-+]/; e = (e, '+', e);",
+		"!left '+'; $op = /[-+]/; $num = /\\d+/; e = (e, '+', e) | e-2; e-2 = $num;",
+	}
+	checkErrorCode(t, samples, PrecedenceError)
+}
+
+func TestPrecedenceNodes(t *testing.T) {
+	g, e := ParseString("", "!left '+' '-'; !right '^'; $op = /[-+^]/; $num = /\\d+/; "+
+		"g = e, f; e = (e, $op, e) | $num; f = (f, '+', f) | $num;")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	names := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		names[i] = n.Name
+	}
+	expected := "g e f e-2 e-3 f-2"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expecting %q nodes, got %q", expected, names)
+	}
+}
+
 func TestNoError(t *testing.T) {
 	samples := []string{
 		toks + "foo = 'foo' | bar; bar = 'bar' | 'baz';",
@@ -248,6 +296,7 @@ func TestTokenFlags(t *testing.T) {
 		{nd + "!caseless $name; g = 'FOO';", "name", gr.CaselessToken},
 		{nd + "!error $e; $e = /\\W/;" + gd, "e", gr.ErrorToken},
 		{nd + "!extern $foo;" + gd, "foo", gr.ExternalToken},
+		{nd + "!preamble $bom; $bom = /\\x{feff}/;" + gd, "bom", gr.PreambleToken},
 		{nd + "!literal 'foo';" + gd, "foo", gr.LiteralToken},
 		{nd + "!reserved 'foo';" + gd, "foo", gr.LiteralToken | gr.ReservedToken},
 	}
@@ -272,3 +321,430 @@ sampleLoop:
 		t.Errorf("sample #%d: %q token not found", i, s.name)
 	}
 }
+
+func TestDecodeDirective(t *testing.T) {
+	src := "!decode $num int; $num = /\\d+/; $str = /\"[^\"]*\"/; $name = /\\w+/; " +
+		"!decode $str $ext unquote; !extern $ext; g = {$num | $str | $name};"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := map[string]string{"num": "int", "str": "unquote", "name": "", "ext": "unquote"}
+	for _, tok := range g.Tokens {
+		if tok.Decoder != expected[tok.Name] {
+			t.Errorf("%q token: expecting %q decoder, got %q", tok.Name, expected[tok.Name], tok.Decoder)
+		}
+	}
+
+	samples := []struct {
+		src string
+		err int
+	}{
+		{"!decode $num; $num = /\\d+/; g = $num;", UnexpectedTokenError},
+		{"!decode $num 'int'; $num = /\\d+/; g = $num;", UnexpectedTokenError},
+		{"!decode $foo int; $num = /\\d+/; g = $num;", UndefinedTokenError},
+	}
+	for i, s := range samples {
+		_, e := ParseString("", s.src)
+		le, valid := e.(*llx.Error)
+		if !valid || le.Code != s.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.err, e)
+		}
+	}
+}
+
+func TestParseOrdered(t *testing.T) {
+	src := "!aside $space;\n$space = /\\s+/;\n$name = /\\w+/;\n\ng = {b | a};\n  a = $name;\nb = 'b';"
+	g, sm, e := ParseOrdered(source.New("", []byte(src)))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if g == nil || sm == nil {
+		t.Fatalf("expecting grammar and source map, got %v, %v", g, sm)
+	}
+
+	type sample struct {
+		name      string
+		line, col int
+	}
+	check := func(kind string, defs []Definition, expected []sample) {
+		if len(defs) != len(expected) {
+			t.Fatalf("expecting %d %s definitions, got %d", len(expected), kind, len(defs))
+		}
+		for i, s := range expected {
+			d := defs[i]
+			if d.Name != s.name || d.Pos.Line() != s.line || d.Pos.Col() != s.col {
+				t.Errorf("%s #%d: expecting %s at %d:%d, got %s at %d:%d", kind, i, s.name, s.line, s.col, d.Name, d.Pos.Line(), d.Pos.Col())
+			}
+		}
+	}
+	check("token", sm.Tokens, []sample{{"space", 2, 1}, {"name", 3, 1}})
+	check("node", sm.Nodes, []sample{{"g", 5, 1}, {"a", 6, 3}, {"b", 7, 1}})
+
+	_, sm, e = ParseOrdered(source.New("", []byte("g = $unknown;")))
+	if e == nil || sm != nil {
+		t.Errorf("expecting error and no source map, got %v, %v", e, sm)
+	}
+}
+
+func TestCaselessNodes(t *testing.T) {
+	src := "!caseless a; $name = /\\w+/; g = a, c; a = 'x', b; b = 'y'; c = 'z';"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := map[string]bool{"g": false, "a": true, "b": true, "c": false}
+	for i, nt := range g.Nodes {
+		lastState := len(g.States)
+		if i+1 < len(g.Nodes) {
+			lastState = g.Nodes[i+1].FirstState
+		}
+		for si := nt.FirstState; si < lastState; si++ {
+			if g.States[si].Caseless != expected[nt.Name] {
+				t.Errorf("node %s, state #%d: expecting caseless=%t", nt.Name, si, expected[nt.Name])
+			}
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	samples := []struct {
+		def, match, mismatch string
+	}{
+		{"$$id-start[$$id-continue]*", "имя_1", "1x"},
+		{"[$$unicode-upper-]+", "ÆØ-Å", "æ"},
+		{"$$unicode-digit+", "٣4", "x"},
+		{"\\w+", "abc_1", "имя"},
+		{"!unicode $t; $t = /\\w+/", "имя_1", "-"},
+		{"!unicode $t; $t = /[\\d.]+\\W/", "١.٢-", "1.2x"},
+		{"!unicode $t; $t = /\\S+\\s/", "名前　", "名前 x"},
+	}
+
+	for i, s := range samples {
+		def := s.def
+		if !strings.HasPrefix(def, "!") {
+			def = "$t = /" + def + "/"
+		}
+		g, e := ParseString("", def+"; g = $t;")
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		re := regexp.MustCompile("^(?:" + g.Tokens[0].Re + ")$")
+		if !re.MatchString(s.match) || re.MatchString(s.mismatch) {
+			t.Errorf("sample #%d: /%s/ must match %q and must not match %q", i, g.Tokens[0].Re, s.match, s.mismatch)
+		}
+	}
+
+	checkErrorCode(t, []string{"!unicode $t; $t = /[^\\W]/; g = $t;"}, WrongRegexpError)
+}
+
+func TestPatternTemplates(t *testing.T) {
+	samples := []struct {
+		def, match, mismatch string
+	}{
+		{"$$ident", "_имя1", "1x"},
+		{"$$int", "0123", "1.5"},
+		{"$$float", "1.5e-3", "15"},
+		{"$$float", ".5", "1e"},
+		{"$$ws", " \t\n", "x"},
+		{"$$string-dq", "\"a\\\"b\"", "\"a\"b\""},
+		{"$$int, $$ident", "1, x", "1,x"},
+		{"$$int = /[0-9]+(?:_[0-9]+)*/; $t = /$$int/", "1_000", "1_"},
+		{"$$ws = /[ ]+/; $t = /x$$ws/", "x  ", "x\t"},
+		{"$$ident = /$$ident|\\$[a-z]+/; $t = /$$ident/", "$var", "$1"},
+		{"$$id-start = /[a-z]/; $t = /$$id-start+/", "abc", "Abc"},
+		{"$$sign = /[-+]/; $$num = /$$sign?$$int/; $t = /$$num/", "-12", "+"},
+	}
+
+	for i, s := range samples {
+		def := s.def
+		if !strings.Contains(def, "$t") {
+			def = "$t = /" + def + "/"
+		}
+		g, e := ParseString("", def+"; g = $t;")
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		re := regexp.MustCompile("^(?:" + g.Tokens[0].Re + ")$")
+		if !re.MatchString(s.match) || re.MatchString(s.mismatch) {
+			t.Errorf("sample #%d: /%s/ must match %q and must not match %q", i, g.Tokens[0].Re, s.match, s.mismatch)
+		}
+	}
+
+	errSamples := []struct {
+		src  string
+		code int
+	}{
+		{"$t = /[$$int]/; g = $t;", WrongRegexpError},
+		{"$$num = /[$$ident]/; $t = /a/; g = $t;", WrongRegexpError},
+		{"$$num = /$$sign/; $$sign = /-/; $t = /a/; g = $t;", WrongRegexpError},
+		{"$$num = /\\d+/; $$num = /\\d/; $t = /a/; g = $t;", TokenDefinedError},
+		{"$$num = 'x'; $t = /a/; g = $t;", UnexpectedTokenError},
+	}
+	for i, s := range errSamples {
+		_, e := ParseString("", s.src)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != s.code {
+			t.Errorf("error sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	src := "$name = /\\w+/; $op = /[(),;]/; " +
+		"list<item, sep> = item, {sep, item}; " +
+		"g = {stmt, ';'}; stmt = $name, '(', [list<arg, ','>], ')'; " +
+		"arg = list<$name, pair<$name, ','>>; pair<x, sep> = '(', x, sep, x, ')';"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	names := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		names[i] = n.Name
+	}
+	expected := "g stmt list<arg,','> arg list<$name,pair<$name,','>> pair<$name,','>"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expecting nodes %q, got %q", expected, strings.Join(names, " "))
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"$name = /\\w+/; g = list<$name>; list<x> = x, {x}; list<x> = x;", NodeDefinedError},
+		{"$name = /\\w+/; g = list<$name>; list<x> = x; list = $name;", NodeDefinedError},
+		{"$name = /\\w+/; g = list<$name>;", TemplateError},
+		{"$name = /\\w+/; g = list<$name>; list<x, y> = x, y;", TemplateError},
+		{"$name = /\\w+/; g = list<$name>; list<x> = x, list<list<x>>;", TemplateError},
+		{"$name = /\\w+/; g = list<$name; list<x> = x;", UnexpectedTokenError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	src := "$num = /\\d+/; $op = /[+()]/; g = $num @first, {'+', ($num | ('(', g, ')')) @add}, ['+'] @end;"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	names := make([]string, len(g.Actions))
+	for i, a := range g.Actions {
+		names[i] = a.Name
+		s := g.States[a.State]
+		if s.HighRule-s.LowRule != 1 || s.HighMultiRule != s.LowMultiRule || g.Rules[s.LowRule].Token != gr.AnyToken {
+			t.Errorf("action %q: expecting single bypass rule in state %d", a.Name, a.State)
+		}
+	}
+	expected := "first add end"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expecting actions %q, got %q", expected, strings.Join(names, " "))
+	}
+
+	samples := []string{
+		"$num = /\\d+/; g = @a, $num;",
+		"$num = /\\d+/; g = $num @a @b;",
+		"$num = /\\d+/; g = $num, @a;",
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != UnexpectedTokenError {
+			t.Errorf("sample #%d: expecting UnexpectedTokenError, got %v", i, e)
+		}
+	}
+}
+
+func TestInlineDirective(t *testing.T) {
+	src := "$name = /\\w+/; $op = /[,;(]/; !inline item list; " +
+		"g = {item | listing, ';'}; item = list<$name, ','>; list<x, sep> = x, {sep, x}; listing = '(', $name;"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var inline []string
+	for _, n := range g.Nodes {
+		if n.Flags&gr.InlineNode != 0 {
+			inline = append(inline, n.Name)
+		}
+	}
+	expected := "item list<$name,','>"
+	if strings.Join(inline, " ") != expected {
+		t.Errorf("expecting inline nodes %q, got %q", expected, strings.Join(inline, " "))
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"$name = /\\w+/; !inline foo; g = $name;", UnknownNodeError},
+		{"$name = /\\w+/; !inline $name; g = $name;", UnexpectedTokenError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	src := "$name = /\\w+/; $op = /[;=]/; g = {v}; v = typ:t, name:$name, [eq:'=', val:$name], ';'; t = $name;"
+	g, e := ParseString("", src)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	labels := make(map[string]string)
+	for _, l := range g.Labels {
+		r := g.Rules[l.Rule]
+		target := g.Tokens[r.Token].Name
+		if r.Node != gr.SameNode {
+			target = g.Nodes[r.Node].Name
+		}
+		labels[l.Name] = target
+	}
+	expected := map[string]string{"typ": "t", "name": "name", "eq": "=", "val": "name"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expecting labels %v, got %v", expected, labels)
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"$name = /\\w+/; g = a:($name);", UnexpectedTokenError},
+		{"$name = /\\w+/; g = a:b:$name;", UnexpectedTokenError},
+		{"$name = /\\w+/; g = $name, a:", UnexpectedEofError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestAdjacency(t *testing.T) {
+	g, e := ParseString("", "$num = /\\d+/; $op = /[.]/; g = $num ~ '.' ~ $num, [$num];")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if len(g.Adjacent) != 2 {
+		t.Errorf("expecting 2 adjacency states, got %v", g.Adjacent)
+	}
+	for _, si := range g.Adjacent {
+		s := g.States[si]
+		if s.HighRule-s.LowRule != 1 || s.HighMultiRule != s.LowMultiRule || g.Rules[s.LowRule].Token != gr.AnyToken {
+			t.Errorf("expecting single bypass rule in adjacency state %d", si)
+		}
+	}
+
+	_, e = ParseString("", "$num = /\\d+/; g = $num ~;")
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnexpectedTokenError {
+		t.Errorf("expecting UnexpectedTokenError, got %v", e)
+	}
+}
+
+func TestErrorProductions(t *testing.T) {
+	g, e := ParseString("", "$num = /\\d+/; $op = /[;,]/; g = $num, (';' | !err(\"expecting semicolon\") ',');")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if len(g.ErrorProductions) != 1 || g.ErrorProductions[0].Message != "expecting semicolon" {
+		t.Fatalf("expecting single error production, got %v", g.ErrorProductions)
+	}
+	s := g.States[g.ErrorProductions[0].State]
+	if s.HighRule-s.LowRule != 1 || g.Rules[s.LowRule].Token != gr.AnyToken {
+		t.Errorf("expecting single bypass rule in error production state")
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"$num = /\\d+/; g = !err $num;", UnexpectedTokenError},
+		{"$num = /\\d+/; g = !err() $num;", UnexpectedTokenError},
+		{"$num = /\\d+/; g = !err('') $num;", UnexpectedTokenError},
+		{"$num = /\\d+/; g = !err('x')", UnexpectedEofError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestNestedTokens(t *testing.T) {
+	g, e := ParseString("", "!aside $comment; $comment = nested('(*', '*)'); $num = /\\d+/; g = {$num};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := []gr.NestedToken{{0, "(*", "*)"}}
+	if !reflect.DeepEqual(g.NestedTokens, expected) || g.Tokens[0].Re != "\\(\\*" || g.Tokens[0].Flags&gr.AsideToken == 0 {
+		t.Errorf("expecting nested aside token %v, got %v, %v", expected, g.NestedTokens, g.Tokens[0])
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"$c = nest('(*', '*)'); g = $c;", UnexpectedTokenError},
+		{"$c = nested('', '*)'); g = $c;", UnexpectedTokenError},
+		{"$c = nested('(*', ''); g = $c;", UnexpectedTokenError},
+		{"$c = nested('(*', '(*'); g = $c;", UnexpectedTokenError},
+		{"$c = nested('(*'); g = $c;", UnexpectedTokenError},
+		{"$c = nested('(*', '*)') g = $c;", UnexpectedTokenError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestNodeLexers(t *testing.T) {
+	g, e := ParseString("", "!group $part; $part = /[^\"]+/; $op = /\"/; !lexer $part str item; !lexer $op item; "+
+		"g = {str}; str = '\"', [item], '\"'; item = $part;")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := []gr.NodeGroup{{1, 1}, {2, 0}}
+	if !reflect.DeepEqual(g.NodeGroups, expected) {
+		t.Errorf("expecting %v, got %v", expected, g.NodeGroups)
+	}
+
+	samples := []struct {
+		src  string
+		code int
+	}{
+		{"!lexer $foo g; $op = /\"/; g = '\"';", UndefinedTokenError},
+		{"!lexer $op foo; $op = /\"/; g = '\"';", UnknownNodeError},
+		{"!lexer g; $op = /\"/; g = '\"';", UnexpectedTokenError},
+	}
+	for i, s := range samples {
+		_, e = ParseString("", s.src)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package langdef
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/internal/ints"
+)
+
+type precAssoc int
+
+const (
+	leftAssoc precAssoc = iota
+	rightAssoc
+	nonAssoc
+)
+
+type precLevel struct {
+	Assoc    precAssoc
+	Literals []string
+}
+
+func expandPrecedence(g *parseResult, e error) error {
+	if e != nil || len(g.Precedence) == 0 {
+		return e
+	}
+
+	levels := make(map[int]int)
+	var opTokens []int
+	for i, t := range g.Tokens {
+		if t.Flags&grammar.LiteralToken == 0 {
+			continue
+		}
+
+		for li, pl := range g.Precedence {
+			for _, lit := range pl.Literals {
+				if lit == t.Name {
+					levels[i] = li
+					opTokens = append(opTokens, i)
+				}
+			}
+		}
+	}
+
+	totalNodes := len(g.Nodes)
+	for i := 0; i < totalNodes; i++ {
+		e = expandNode(g, g.Nodes[i].Name, levels, opTokens)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func expandNode(g *parseResult, name string, levels map[int]int, opTokens []int) error {
+	item := g.NIndex[name]
+	if item.Chunk == nil || len(item.Chunk.chunks) != 1 {
+		return nil
+	}
+
+	variants := []chunk{unwrapGroup(item.Chunk.chunks[0])}
+	if vc, is := variants[0].(*variantChunk); is {
+		variants = vc.chunks
+	}
+
+	var operands []chunk
+	levelOps := make(map[int][]int)
+	for _, v := range variants {
+		ops, isBinary := binaryOps(v, item, g, opTokens)
+		if !isBinary {
+			operands = append(operands, v)
+			continue
+		}
+
+		for _, op := range ops {
+			level, has := levels[op]
+			if !has {
+				return precedenceError(name, "operator %q has no precedence", g.Tokens[op].Name)
+			}
+
+			if !containsInt(levelOps[level], op) {
+				levelOps[level] = append(levelOps[level], op)
+			}
+		}
+	}
+	if len(levelOps) == 0 {
+		return nil
+	}
+	if len(operands) == 0 {
+		return precedenceError(name, "no operands defined")
+	}
+
+	usedLevels := make([]int, 0, len(levelOps))
+	for level := range levelOps {
+		usedLevels = append(usedLevels, level)
+	}
+	sort.Ints(usedLevels)
+
+	items := []*nodeItem{item}
+	names := []string{name}
+	for i := 1; i <= len(usedLevels); i++ {
+		levelName := name + "-" + strconv.Itoa(i+1)
+		if g.NIndex[levelName] != nil {
+			return precedenceError(name, "node name %q is already used", levelName)
+		}
+
+		items = append(items, addGeneratedNode(g, levelName))
+		names = append(names, levelName)
+	}
+
+	operandItem := items[len(items)-1]
+	operandItem.DependsOn = item.DependsOn
+	if len(operands) == 1 {
+		operandItem.Chunk.Append(operands[0])
+	} else {
+		operandItem.Chunk.Append(&variantChunk{operands})
+	}
+
+	for i, level := range usedLevels {
+		current, next := items[i], items[i+1]
+		nextChunk := newNodeChunk(names[i+1], next)
+		var ops chunk = newTokenChunk(levelOps[level][0])
+		if len(levelOps[level]) > 1 {
+			vc := newVariantChunk()
+			for _, op := range levelOps[level] {
+				vc.Append(newTokenChunk(op))
+			}
+			ops = vc
+		}
+
+		current.DependsOn = ints.NewSet(next.Index)
+		current.Chunk = newGroupChunk(false, false)
+		current.Chunk.Append(nextChunk)
+		switch g.Precedence[level].Assoc {
+		case leftAssoc:
+			current.Chunk.Append(&groupChunk{[]chunk{ops, nextChunk}, true, true})
+		case rightAssoc:
+			current.Chunk.Append(&groupChunk{[]chunk{ops, newNodeChunk(names[i], current)}, true, false})
+			current.DependsOn.Add(current.Index)
+		default:
+			current.Chunk.Append(&groupChunk{[]chunk{ops, nextChunk}, true, false})
+		}
+	}
+
+	return nil
+}
+
+// binaryOps checks whether chunk has a form of (node, operator, node), where operator is either a token
+// or several tokens separated with pipes, and returns operator literals (a token type stands for all opTokens).
+func binaryOps(ch chunk, item *nodeItem, g *parseResult, opTokens []int) ([]int, bool) {
+	gc, is := ch.(*groupChunk)
+	if !is || gc.isOptional || gc.isRepeated || len(gc.chunks) != 3 {
+		return nil, false
+	}
+
+	for _, i := range []int{0, 2} {
+		nc, is := gc.chunks[i].(*nodeChunk)
+		if !is || nc.item != item {
+			return nil, false
+		}
+	}
+
+	tcs := []chunk{unwrapGroup(gc.chunks[1])}
+	if vc, is := tcs[0].(*variantChunk); is {
+		tcs = vc.chunks
+	}
+
+	var result []int
+	for _, tc := range tcs {
+		t, is := tc.(tokenChunk)
+		if !is {
+			return nil, false
+		}
+
+		if g.Tokens[t].Flags&grammar.LiteralToken == 0 {
+			result = append(result, opTokens...)
+		} else {
+			result = append(result, int(t))
+		}
+	}
+	return result, true
+}
+
+// unwrapGroup returns the only chunk of plain group (i.e. not optional and not repeated) or chunk itself.
+func unwrapGroup(ch chunk) chunk {
+	gc, is := ch.(*groupChunk)
+	for is && !gc.isOptional && !gc.isRepeated && len(gc.chunks) == 1 {
+		ch = gc.chunks[0]
+		gc, is = ch.(*groupChunk)
+	}
+	return ch
+}
+
+func addGeneratedNode(g *parseResult, name string) *nodeItem {
+	result := &nodeItem{len(g.Nodes), ints.NewSet(), ints.NewSet(), newGroupChunk(false, false)}
+	g.NIndex[name] = result
+	g.Nodes = append(g.Nodes, grammar.Node{Name: name})
+	return result
+}
+
+func containsInt(items []int, item int) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -8,16 +8,33 @@ import (
 )
 
 type stateEntry struct {
-	Types grammar.BitSet
-	Rules map[int][]grammar.Rule
+	Types    grammar.BitSet
+	Rules    map[int][]grammar.Rule
+	Caseless bool
+	Action   string
+	Labels   map[grammar.Rule]string
+	Adjacent bool
+	Error    string
 }
 
 type parseResult struct {
-	Tokens []grammar.Token
-	Nodes  []grammar.Node
-	States []*stateEntry
-	NIndex nodeIndex
-	TTypes []grammar.BitSet
+	Tokens      []grammar.Token
+	Nodes       []grammar.Node
+	States      []*stateEntry
+	NIndex      nodeIndex
+	TTypes      []*ints.Set
+	SMap        *SourceMap
+	Caseless    []string
+	Inline      []string
+	Precedence  []precLevel
+	AllCaseless bool
+	Nested      []grammar.NestedToken
+	NodeLexers  []nodeLexer
+	NodeGroups  []grammar.NodeGroup
+}
+
+type nodeLexer struct {
+	node, token string
 }
 
 func newParseResult() *parseResult {
@@ -27,21 +44,39 @@ func newParseResult() *parseResult {
 		make([]*stateEntry, 0),
 		make(nodeIndex),
 		nil,
+		newSourceMap(),
+		nil,
+		nil,
+		nil,
+		false,
+		nil,
+		nil,
+		nil,
 	}
 }
 
 func (pr *parseResult) AddState() (stateIndex int, st *stateEntry) {
 	stateIndex = len(pr.States)
-	st = &stateEntry{0, map[int][]grammar.Rule{}}
+	st = &stateEntry{nil, map[int][]grammar.Rule{}, false, "", nil, false, ""}
 	pr.States = append(pr.States, st)
 	return
 }
 
 func (pr *parseResult) BuildGrammar() *grammar.Grammar {
 	pr.dropUnusedStates()
-	g := &grammar.Grammar{Tokens: pr.Tokens, Nodes: pr.Nodes, States: make([]grammar.State, len(pr.States))}
+	g := &grammar.Grammar{Tokens: pr.Tokens, Nodes: pr.Nodes, States: make([]grammar.State, len(pr.States)),
+		NestedTokens: pr.Nested, NodeGroups: pr.NodeGroups}
 	for si, se := range pr.States {
 		se.BuildGrammarState(g, si)
+		if se.Action != "" {
+			g.Actions = append(g.Actions, grammar.Action{se.Action, si})
+		}
+		if se.Adjacent {
+			g.Adjacent = append(g.Adjacent, si)
+		}
+		if se.Error != "" {
+			g.ErrorProductions = append(g.ErrorProductions, grammar.ErrorProduction{se.Error, si})
+		}
 	}
 	return g
 }
@@ -80,11 +115,16 @@ func (pr *parseResult) dropUnusedStates() {
 		pr.Nodes[i].FirstState = newIndexes[nt.FirstState]
 	}
 	for _, se := range pr.States {
+		labels := se.Labels
+		se.Labels = nil
 		for _, rs := range se.Rules {
 			for i, r := range rs {
 				if r.State >= 0 {
 					rs[i].State = newIndexes[r.State]
 				}
+				if label, has := labels[r]; has {
+					se.setLabel(rs[i], label)
+				}
 			}
 		}
 	}
@@ -102,6 +142,28 @@ func (se *stateEntry) AddRule(state, nt int, tokens ...int) {
 	}
 }
 
+// IsMarker returns true for action, adjacency, and error production states, these states have a single bypass rule.
+func (se *stateEntry) IsMarker() bool {
+	return se.Action != "" || se.Adjacent || se.Error != ""
+}
+
+// AddLabeledRule is same as AddRule, except added rules get given label.
+func (se *stateEntry) AddLabeledRule(label string, state, nt int, tokens ...int) {
+	se.AddRule(state, nt, tokens...)
+	for _, k := range tokens {
+		se.setLabel(grammar.Rule{k, state, nt}, label)
+	}
+}
+
+func (se *stateEntry) setLabel(r grammar.Rule, label string) {
+	if se.Labels == nil {
+		se.Labels = make(map[grammar.Rule]string)
+	}
+	if _, has := se.Labels[r]; !has {
+		se.Labels[r] = label
+	}
+}
+
 func (se *stateEntry) BypassRule(nextState int) {
 	se.AddRule(nextState, grammar.SameNode, grammar.AnyToken)
 }
@@ -114,6 +176,9 @@ func (se *stateEntry) CopyRules(from *stateEntry) {
 			se.Rules[k] = rs
 		}
 	}
+	for r, label := range from.Labels {
+		se.setLabel(r, label)
+	}
 }
 
 func (se *stateEntry) BuildGrammarState(g *grammar.Grammar, si int) {
@@ -123,20 +188,28 @@ func (se *stateEntry) BuildGrammarState(g *grammar.Grammar, si int) {
 	se.writeGrammarState(g, si, erlen, emlen)
 
 	for _, k := range rkeys {
-		r := se.Rules[k][0]
-		g.Rules = append(g.Rules, r)
+		se.appendRules(g, se.Rules[k][:1])
 	}
 
 	rstart := len(g.Rules)
 	for _, k := range mkeys {
 		rs := se.Rules[k]
 		mrlen := len(rs)
-		g.Rules = append(g.Rules, rs...)
+		se.appendRules(g, rs)
 		g.MultiRules = append(g.MultiRules, grammar.MultiRule{k, rstart, rstart + mrlen})
 		rstart += mrlen
 	}
 }
 
+func (se *stateEntry) appendRules(g *grammar.Grammar, rs []grammar.Rule) {
+	for _, r := range rs {
+		if label, has := se.Labels[r]; has {
+			g.Labels = append(g.Labels, grammar.Label{label, len(g.Rules)})
+		}
+		g.Rules = append(g.Rules, r)
+	}
+}
+
 func (se *stateEntry) rmKeys() (rkeys, mkeys []int) {
 	ermlen := len(se.Rules)
 	rkeys = make([]int, 0, ermlen)
@@ -162,5 +235,12 @@ func (se *stateEntry) writeGrammarState(g *grammar.Grammar, si int, erlen, emlen
 	if emlen > 0 {
 		mstart = len(g.MultiRules)
 	}
-	g.States[si] = grammar.State{se.Types, mstart, mstart + emlen, rstart, rstart + erlen}
+	g.States[si] = grammar.State{
+		TokenTypes:    se.Types,
+		LowMultiRule:  mstart,
+		HighMultiRule: mstart + emlen,
+		LowRule:       rstart,
+		HighRule:      rstart + erlen,
+		Caseless:      se.Caseless,
+	}
 }
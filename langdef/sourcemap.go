@@ -0,0 +1,28 @@
+package langdef
+
+import (
+	"github.com/ava12/llx/source"
+)
+
+// Definition describes location of a token type or node definition in grammar description.
+type Definition struct {
+	// Name is either token type name (without leading $) or node name.
+	Name string
+
+	// Pos is the position of the first token of definition.
+	Pos source.Pos
+}
+
+// SourceMap lists token type and node definitions in order of their appearance in grammar description.
+// Does not affect resulting grammar, intended for tools preserving author ordering (formatters, documentation generators).
+type SourceMap struct {
+	// Tokens lists token type definitions. External tokens and literals are not included.
+	Tokens []Definition
+
+	// Nodes lists node definitions, the first one is the root node.
+	Nodes []Definition
+}
+
+func newSourceMap() *SourceMap {
+	return &SourceMap{make([]Definition, 0), make([]Definition, 0)}
+}
@@ -0,0 +1,167 @@
+package langdef
+
+import (
+	"strings"
+
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+const (
+	lAngleTok = "<"
+	rAngleTok = ">"
+)
+
+// Limits for the number of template instances and for nesting depth of template arguments,
+// exceeding them usually means infinitely recursive template.
+const (
+	maxInstances     = 1000
+	maxTemplateDepth = 32
+)
+
+// nodeTemplate is a parameterized node definition, e.g. list<x> = x, {',', x};
+type nodeTemplate struct {
+	params []string
+	body   []*lexer.Token
+	pos    source.Pos
+}
+
+// templateInstance is a node that must be defined by substituting arguments into template body.
+type templateInstance struct {
+	name  string
+	token *lexer.Token
+	args  []string
+}
+
+// parseTemplateDef parses parameter list and body of node template. Opening angle bracket is already fetched.
+func parseTemplateDef(nameToken *lexer.Token, c *parseContext) error {
+	name := nameToken.Text()
+	_, defined := c.templates[name]
+	if defined || (c.g.NIndex[name] != nil && c.g.NIndex[name].Chunk != nil) {
+		return defNodeError(nameToken)
+	}
+
+	t := &nodeTemplate{pos: nameToken.Pos()}
+	for {
+		param, e := fetchOne(c.q, c.l, nameTok, true, nil)
+		if e != nil {
+			return e
+		}
+
+		t.params = append(t.params, param.Text())
+		sep, e := fetch(c.q, c.l, []string{commaTok, rAngleTok}, true, nil)
+		if e != nil {
+			return e
+		}
+		if sep.Text() == rAngleTok {
+			break
+		}
+	}
+
+	e := skipOne(c.q, c.l, equTok, nil)
+	for e == nil {
+		var bt *lexer.Token
+		bt, e = fetch(c.q, c.l, []string{nameTok, tokenNameTok, stringTok, opTok}, true, nil)
+		if e == nil && bt.Text() == semicolonTok {
+			break
+		}
+		if e == nil {
+			t.body = append(t.body, bt)
+		}
+	}
+	if e != nil {
+		return e
+	}
+
+	c.templates[name] = t
+	return nil
+}
+
+// parseTemplateArgs parses argument list of template instance and returns instance name.
+// Opening angle bracket is already fetched.
+func parseTemplateArgs(nameToken *lexer.Token, c *parseContext, depth int) (string, error) {
+	if depth > maxTemplateDepth {
+		return "", templateError(nameToken, "template arguments are nested too deep, %q may be infinitely recursive", nameToken.Text())
+	}
+
+	var args []string
+	for {
+		arg, e := fetch(c.q, c.l, []string{nameTok, tokenNameTok, stringTok}, true, nil)
+		if e != nil {
+			return "", e
+		}
+
+		text := arg.Text()
+		if arg.TypeName() == nameTok {
+			lt, e := fetchOne(c.q, c.l, lAngleTok, false, nil)
+			if e != nil {
+				return "", e
+			}
+			if lt != nil {
+				text, e = parseTemplateArgs(arg, c, depth+1)
+				if e != nil {
+					return "", e
+				}
+			}
+		}
+		args = append(args, text)
+
+		sep, e := fetch(c.q, c.l, []string{commaTok, rAngleTok}, true, nil)
+		if e != nil {
+			return "", e
+		}
+		if sep.Text() == rAngleTok {
+			break
+		}
+	}
+
+	name := nameToken.Text() + lAngleTok + strings.Join(args, commaTok) + rAngleTok
+	if !c.instanced[name] {
+		if len(c.instances) >= maxInstances {
+			return "", templateError(nameToken, "too many template instances, %q may be infinitely recursive", nameToken.Text())
+		}
+
+		c.instanced[name] = true
+		c.instances = append(c.instances, templateInstance{name, nameToken, args})
+	}
+	return name, nil
+}
+
+// defineInstances parses node definitions for all used template instances.
+func defineInstances(c *parseContext) error {
+	for i := 0; i < len(c.instances); i++ {
+		inst := c.instances[i]
+		name := inst.token.Text()
+		t := c.templates[name]
+		if t == nil {
+			return templateError(inst.token, "template %q is not defined", name)
+		}
+		if len(t.params) != len(inst.args) {
+			return templateError(inst.token, "template %q expects %d arguments, got %d", name, len(t.params), len(inst.args))
+		}
+
+		args := make(map[string]string, len(t.params))
+		for j, param := range t.params {
+			args[param] = inst.args[j]
+		}
+
+		body := make([]string, len(t.body))
+		for j, tok := range t.body {
+			body[j] = tok.Text()
+			if tok.TypeName() == nameTok && args[body[j]] != "" {
+				body[j] = args[body[j]]
+			}
+		}
+
+		q := c.q
+		c.q = source.NewQueue().Append(source.New(inst.name, []byte(strings.Join(body, " ")+" ;")))
+		e := parseNodeBody(inst.name, c, nil)
+		c.q = q
+		if e != nil {
+			return e
+		}
+
+		c.g.SMap.Nodes = append(c.g.SMap.Nodes, Definition{inst.name, t.pos})
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package langdef
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Unicode properties of identifier characters as defined by UAX #31, without enclosing brackets.
+const (
+	idStartClass    = `\p{L}\p{Nl}\x{1885}\x{1886}\x{2118}\x{212E}\x{309B}\x{309C}`
+	idContinueClass = idStartClass + `\p{Mn}\p{Mc}\p{Nd}\p{Pc}\x{00B7}\x{0387}\x{1369}-\x{1371}\x{19DA}`
+	wordClass       = `\p{L}\p{Mn}\p{Nd}\p{Pc}`
+	spaceClass      = `\s\p{Z}`
+)
+
+// regexpTemplates contains character classes (without enclosing brackets) available as $$name in token regexps.
+var regexpTemplates = map[string]string{
+	"unicode-letter": `\p{L}`,
+	"unicode-upper":  `\p{Lu}`,
+	"unicode-lower":  `\p{Ll}`,
+	"unicode-digit":  `\p{Nd}`,
+	"unicode-space":  spaceClass,
+	"unicode-punct":  `\p{P}`,
+	"id-start":       idStartClass,
+	"id-continue":    idContinueClass,
+}
+
+// patternTemplates contains regexps available as $$name in token regexps outside of bracketed character classes.
+// Grammar may override them with its own definitions.
+var patternTemplates = map[string]string{
+	"ident":     `[_` + idStartClass + `][_` + idContinueClass + `]*`,
+	"int":       `\d+`,
+	"float":     `(?:\d+\.\d*|\.\d+)(?:[eE][-+]?\d+)?|\d+[eE][-+]?\d+`,
+	"ws":        `\s+`,
+	"string-dq": `"(?:[^"\\\n]|\\.)*"`,
+}
+
+// unicodeEscapes contains character classes replacing Perl classes in token types listed in !unicode directive.
+// Negated classes cannot be used inside bracketed classes.
+var unicodeEscapes = map[byte]struct {
+	class   string
+	negated bool
+}{
+	'd': {`\p{Nd}`, false},
+	'D': {`\P{Nd}`, false},
+	'w': {wordClass, false},
+	'W': {wordClass, true},
+	's': {spaceClass, false},
+	'S': {spaceClass, true},
+}
+
+var templateNameRe = regexp.MustCompile(`^\$\$[a-z]+(?:-[a-z]+)*`)
+
+// expandRegexp replaces $$name templates in token regexp and, if unicode is set,
+// makes \d, \w, \s and their negations match Unicode characters.
+// Templates defined in grammar (patterns) take precedence over built-in ones.
+func expandRegexp(re string, patterns map[string]string, unicode bool) (string, error) {
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(re); i++ {
+		c := re[i]
+		switch {
+		case c == '\\' && i+1 < len(re):
+			i++
+			esc, has := unicodeEscapes[re[i]]
+			switch {
+			case !unicode || !has:
+				b.WriteByte(c)
+				b.WriteByte(re[i])
+			case inClass && esc.negated:
+				return "", errors.New("cannot use \\" + re[i:i+1] + " inside character class of !unicode token")
+			case inClass:
+				b.WriteString(esc.class)
+			case esc.negated:
+				b.WriteString("[^" + esc.class + "]")
+			default:
+				b.WriteString("[" + esc.class + "]")
+			}
+
+		case c == '[' && inClass && strings.HasPrefix(re[i:], "[:"):
+			end := strings.Index(re[i:], ":]")
+			if end < 0 {
+				b.WriteString(re[i:])
+				i = len(re)
+			} else {
+				b.WriteString(re[i : i+end+2])
+				i += end + 1
+			}
+
+		case c == '[' && !inClass:
+			inClass = true
+			b.WriteByte(c)
+			if i+1 < len(re) && re[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+			if i+1 < len(re) && re[i+1] == ']' {
+				i++
+				b.WriteByte(']')
+			}
+
+		case c == ']' && inClass:
+			inClass = false
+			b.WriteByte(c)
+
+		case c == '$' && templateNameRe.MatchString(re[i:]):
+			name := templateNameRe.FindString(re[i:])
+			i += len(name) - 1
+			pattern, has := patterns[name[2:]]
+			if !has {
+				pattern, has = patternTemplates[name[2:]]
+			}
+			if has {
+				if inClass {
+					return "", errors.New("cannot use template " + name + " inside character class")
+				}
+
+				b.WriteString("(?:" + pattern + ")")
+				break
+			}
+
+			class, has := regexpTemplates[name[2:]]
+			if !has {
+				return "", errors.New("unknown template " + name)
+			}
+
+			if inClass {
+				b.WriteString(class)
+			} else {
+				b.WriteString("[" + class + "]")
+			}
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// applyUnicode rewrites regexps of token types listed in !unicode directives.
+func applyUnicode(c *parseContext) error {
+	for _, t := range c.unicode {
+		name := t.Text()[1:]
+		i, has := c.ti[name]
+		if !has {
+			return undefinedTokenError(name)
+		}
+
+		re, e := expandRegexp(c.g.Tokens[i].Re, nil, true)
+		if e == nil {
+			_, e = regexp.Compile(re)
+		}
+		if e != nil {
+			return regexpError(c.regexps[name], e)
+		}
+
+		c.g.Tokens[i].Re = re
+	}
+	return nil
+}
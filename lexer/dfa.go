@@ -0,0 +1,400 @@
+package lexer
+
+import (
+	"context"
+	"encoding/binary"
+	"regexp/syntax"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/ava12/llx/source"
+)
+
+// NewDFA creates new Lexer that uses deterministic finite automaton instead of regexp.Regexp.
+// Each n-th element of patterns is a regexp (in regexp/syntax format, dot matches newlines)
+// describing n-th element of types. Capturing groups in patterns are ignored.
+// Lexer produces the same tokens as a lexer created by New using regexp `^(?s:(p1)|(p2)|...)`:
+// the longest lexeme preferred by the first matching pattern (with respect to lazy quantifiers) is taken.
+// Automaton states are built lazily and cached, so lexing becomes faster as more source text is processed.
+// The cache holds at most dfaCacheLimit states and non-ASCII transitions, it is flushed once the limit is reached,
+// so memory used by the automaton is bounded whatever input is processed.
+// Returns error if some pattern is malformed.
+func NewDFA(patterns []string, types []TokenType) (*Lexer, error) {
+	d, e := newDFA(patterns)
+	if e != nil {
+		return nil, e
+	}
+
+	l := New(nil, types)
+	l.dfa = d
+	return l, nil
+}
+
+// WithPreambleDFA is same as WithPreamble, except it uses automaton built from given patterns
+// at the beginning of a source. patterns must describe the same token types as lexer patterns do.
+func (l *Lexer) WithPreambleDFA(patterns []string) (*Lexer, error) {
+	d, e := newDFA(patterns)
+	if e != nil {
+		return nil, e
+	}
+
+	res := *l
+	res.preambleRe = nil
+	res.preambleAutomaton = nil
+	res.preambleDFA = d
+	return &res, nil
+}
+
+// Context of the rune preceding current position, matters only for empty-width assertions.
+const (
+	dfaContextOther = iota
+	dfaContextBegin
+	dfaContextNewline
+	dfaContextWord
+)
+
+// dfaCacheLimit is the maximum number of cached states and non-ASCII transitions of an automaton.
+const dfaCacheLimit = 1 << 12
+
+// dfa is a lazily built automaton simulating leftmost-first matching of alternated patterns.
+// A state is an ordered (by priority) list of instructions waiting for the next rune,
+// a transition follows empty instructions in priority order, cutting threads of lower priority
+// than the first one reaching match instruction, and then consumes the rune.
+// Once cache size reaches the limit, all states are dropped and the initial one is rebuilt;
+// matching in progress keeps using dropped states until it finishes.
+type dfa struct {
+	insts          []syntax.Inst
+	owners         []int
+	hasEmpty       bool
+	starts         []uint32
+	initialContext int
+	initial        atomic.Pointer[dfaState]
+
+	lock   sync.Mutex
+	states map[string]*dfaState
+	size   int
+	limit  int
+}
+
+type dfaState struct {
+	pcs     []uint32
+	context int
+	ascii   [utf8.RuneSelf]atomic.Pointer[dfaTransition]
+	eof     atomic.Pointer[dfaTransition]
+	other   map[rune]*dfaTransition
+}
+
+type dfaTransition struct {
+	match int
+	next  *dfaState
+	more  bool
+}
+
+func newDFA(patterns []string) (*dfa, error) {
+	d := &dfa{limit: dfaCacheLimit}
+	starts := make([]uint32, len(patterns))
+	for i, p := range patterns {
+		re, e := syntax.Parse(p, syntax.Perl|syntax.DotNL)
+		if e != nil {
+			return nil, e
+		}
+
+		prog, e := syntax.Compile(re.Simplify())
+		if e != nil {
+			return nil, e
+		}
+
+		offset := uint32(len(d.insts))
+		starts[i] = uint32(prog.Start) + offset
+		for _, inst := range prog.Inst {
+			inst.Out += offset
+			switch inst.Op {
+			case syntax.InstAlt, syntax.InstAltMatch:
+				inst.Arg += offset
+			case syntax.InstEmptyWidth:
+				d.hasEmpty = true
+			}
+			d.insts = append(d.insts, inst)
+			d.owners = append(d.owners, i)
+		}
+	}
+
+	d.starts = starts
+	d.initialContext = dfaContextOther
+	if d.hasEmpty {
+		d.initialContext = dfaContextBegin
+	}
+	d.lock.Lock()
+	d.flush()
+	d.lock.Unlock()
+	return d, nil
+}
+
+func dfaKey(pcs []uint32, context int) string {
+	key := make([]byte, 1, len(pcs)*4+1)
+	key[0] = byte(context)
+	for _, pc := range pcs {
+		key = binary.LittleEndian.AppendUint32(key, pc)
+	}
+	return string(key)
+}
+
+// flush drops all cached states and creates new initial state. Must be called with lock held.
+func (d *dfa) flush() {
+	d.states = make(map[string]*dfaState)
+	d.size = 0
+	if len(d.starts) == 0 {
+		d.initial.Store(nil)
+		return
+	}
+
+	s := &dfaState{pcs: d.starts, context: d.initialContext}
+	d.states[dfaKey(d.starts, d.initialContext)] = s
+	d.size = 1
+	d.initial.Store(s)
+}
+
+// reserve counts new cache entry, flushing the cache if it is full. Must be called with lock held.
+func (d *dfa) reserve() {
+	if d.size >= d.limit {
+		d.flush()
+	}
+	d.size++
+}
+
+// state returns cached state for given instructions and context, nil if there are no instructions.
+func (d *dfa) state(pcs []uint32, context int) *dfaState {
+	if len(pcs) == 0 {
+		return nil
+	}
+
+	key := dfaKey(pcs, context)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s, has := d.states[key]
+	if !has {
+		d.reserve()
+		s = &dfaState{pcs: pcs, context: context}
+		d.states[key] = s
+	}
+	return s
+}
+
+// next returns transition from state s for rune r, r < 0 means end of input.
+func (d *dfa) next(s *dfaState, r rune) *dfaTransition {
+	var p *atomic.Pointer[dfaTransition]
+	switch {
+	case r < 0:
+		p = &s.eof
+	case r < utf8.RuneSelf:
+		p = &s.ascii[r]
+	}
+
+	if p != nil {
+		t := p.Load()
+		if t == nil {
+			t = d.transition(s, r)
+			p.Store(t)
+		}
+		return t
+	}
+
+	d.lock.Lock()
+	t := s.other[r]
+	d.lock.Unlock()
+	if t == nil {
+		t = d.transition(s, r)
+		d.lock.Lock()
+		if s.other == nil {
+			s.other = make(map[rune]*dfaTransition)
+		}
+		if _, has := s.other[r]; !has {
+			d.reserve()
+			s.other[r] = t
+		}
+		d.lock.Unlock()
+	}
+	return t
+}
+
+func (d *dfa) transition(s *dfaState, r rune) *dfaTransition {
+	flags := d.emptyFlags(s.context, r)
+	visited := make([]bool, len(d.insts))
+	var waiting []uint32
+	match := -1
+	hasEmpty := false
+
+	var follow func(pc uint32) bool
+	follow = func(pc uint32) bool {
+		if visited[pc] {
+			return false
+		}
+
+		visited[pc] = true
+		inst := &d.insts[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			return follow(inst.Out) || follow(inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			return follow(inst.Out)
+		case syntax.InstEmptyWidth:
+			hasEmpty = true
+			if syntax.EmptyOp(inst.Arg)&^flags == 0 {
+				return follow(inst.Out)
+			}
+		case syntax.InstMatch:
+			match = d.owners[pc]
+			return true
+		case syntax.InstFail:
+		default:
+			waiting = append(waiting, pc)
+		}
+		return false
+	}
+
+	for _, pc := range s.pcs {
+		if follow(pc) {
+			break
+		}
+	}
+
+	res := &dfaTransition{match: match}
+	if r < 0 {
+		res.more = len(waiting) > 0 || hasEmpty
+		return res
+	}
+
+	var pcs []uint32
+	added := make(map[uint32]bool)
+	for _, pc := range waiting {
+		inst := &d.insts[pc]
+		if matchRune(inst, r) && !added[inst.Out] {
+			added[inst.Out] = true
+			pcs = append(pcs, inst.Out)
+		}
+	}
+
+	context := dfaContextOther
+	if d.hasEmpty {
+		if r == '\n' {
+			context = dfaContextNewline
+		} else if syntax.IsWordChar(r) {
+			context = dfaContextWord
+		}
+	}
+	res.next = d.state(pcs, context)
+	return res
+}
+
+func matchRune(inst *syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	default:
+		return inst.MatchRune(r)
+	}
+}
+
+func (d *dfa) emptyFlags(context int, r rune) syntax.EmptyOp {
+	if !d.hasEmpty {
+		return 0
+	}
+
+	var res syntax.EmptyOp
+	switch context {
+	case dfaContextBegin:
+		res |= syntax.EmptyBeginText | syntax.EmptyBeginLine
+	case dfaContextNewline:
+		res |= syntax.EmptyBeginLine
+	}
+	if r < 0 {
+		res |= syntax.EmptyEndText | syntax.EmptyEndLine
+	} else if r == '\n' {
+		res |= syntax.EmptyEndLine
+	}
+	if (context == dfaContextWord) != (r >= 0 && syntax.IsWordChar(r)) {
+		res |= syntax.EmptyWordBoundary
+	} else {
+		res |= syntax.EmptyNoWordBoundary
+	}
+	return res
+}
+
+// match returns index of matched pattern and lexeme length, or -1 if there is no match.
+// hitEnd is true if more content could change the result, i.e. the end of content is reached by a thread
+// of higher priority than the match, waiting for a rune or depending on an empty-width assertion.
+// Returns error if ctx is done.
+func (d *dfa) match(ctx context.Context, content []byte) (index, length int, hitEnd bool, e error) {
+	index, length, _, hitEnd, e = d.scan(ctx, content)
+	return
+}
+
+// scan is same as match, but also returns the number of bytes scanned before reaching a dead state
+// or the end of content.
+func (d *dfa) scan(ctx context.Context, content []byte) (index, length, pos int, hitEnd bool, e error) {
+	index = -1
+	next := contextCheckInterval
+	for s := d.initial.Load(); s != nil; {
+		if e = checkContext(ctx, pos, &next); e != nil {
+			return
+		}
+
+		r, size := rune(-1), 0
+		if pos < len(content) {
+			r, size = utf8.DecodeRune(content[pos:])
+		}
+
+		t := d.next(s, r)
+		if t.match >= 0 {
+			index, length = t.match, pos
+		}
+		if r < 0 {
+			hitEnd = t.more
+			break
+		}
+
+		s = t.next
+		pos += size
+	}
+	return
+}
+
+func (l *Lexer) matchDFA(d *dfa, q *source.Queue, content []byte, pos int, tts TokenTypeSet, partial bool) (*Token, int, error) {
+	content = content[pos:]
+	index, length, hitEnd, e := d.match(l.ctx, content)
+	if e != nil {
+		return nil, 0, e
+	}
+	if partial && hitEnd {
+		return nil, 0, errIncomplete
+	}
+	if index < 0 || length == 0 {
+		line, col := q.Source().LineCol(pos)
+		return nil, 0, wrongCharError(q.Source(), content, line, col)
+	}
+
+	tokenType := ErrorTokenType
+	typeName := ErrorTokenName
+	if index < len(l.types) {
+		tokenType = l.types[index].Type
+		typeName = l.types[index].TypeName
+		if tokenType >= 0 && !tts.Contains(tokenType) {
+			return nil, 0, nil
+		}
+	}
+
+	length, e = l.extendNested(q, tokenType, content, 0, length, pos, partial)
+	if e != nil {
+		return nil, 0, e
+	}
+
+	token := l.arena.NewToken(tokenType, typeName, content[:length], q.PosAt(pos))
+	if tokenType == ErrorTokenType {
+		return nil, 0, wrongTokenError(token)
+	}
+
+	return token, length, nil
+}
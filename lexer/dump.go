@@ -0,0 +1,41 @@
+package lexer
+
+import (
+	"encoding/json"
+
+	"github.com/ava12/llx/source"
+)
+
+type dumpedToken struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"endLine"`
+	EndCol  int    `json:"endCol"`
+}
+
+// DumpTokens fetches all tokens from the queue until EoI and returns them as JSON array of objects
+// {"type", "text", "line", "col", "endLine", "endCol"}, where "type" is token type name
+// and end position marks the place right after the last rune of the token.
+// EoF tokens are included, EoI token is not. The queue is consumed.
+// Returns nil and llx.Error on lexical error.
+// Intended for debugging grammars.
+func DumpTokens(q *source.Queue, l *Lexer) ([]byte, error) {
+	tokens := make([]dumpedToken, 0)
+	for {
+		t, e := l.Next(q)
+		if e != nil {
+			return nil, e
+		}
+
+		if t.Type() == EoiTokenType {
+			break
+		}
+
+		ep := t.EndPos()
+		tokens = append(tokens, dumpedToken{t.TypeName(), t.Text(), t.Line(), t.Col(), ep.Line(), ep.Col()})
+	}
+
+	return json.Marshal(tokens)
+}
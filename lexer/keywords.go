@@ -0,0 +1,182 @@
+package lexer
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Keywords is an immutable table mapping a fixed set of keywords (e.g. grammar literals) to integer values.
+// It uses perfect hashing: a lookup computes a single hash of the text, finds the only possible slot,
+// and makes at most one comparison. Safe for concurrent use.
+type Keywords struct {
+	seed       uint64
+	disps      []uint32
+	slots      []keywordSlot
+	mask       uint32
+	bucketMask uint64
+	full       bool
+	length     int
+}
+
+type keywordSlot struct {
+	hash  uint64
+	key   string
+	value int
+	used  bool
+}
+
+const (
+	maxKeywordDisp  = 1 << 12
+	maxKeywordSeeds = 16
+)
+
+// NewKeywords builds a table where n-th keyword maps to values[n].
+// If a keyword is listed more than once, its first occurrence is used.
+func NewKeywords(keywords []string, values []int) *Keywords {
+	type entry struct {
+		key   string
+		value int
+	}
+	var entries []entry
+	seen := make(map[string]bool, len(keywords))
+	for i, k := range keywords {
+		if !seen[k] {
+			seen[k] = true
+			entries = append(entries, entry{k, values[i]})
+		}
+	}
+
+	size := 4 << bits.Len(uint(len(entries)))
+	full := false
+	for seed := uint64(1); ; seed++ {
+		if seed%maxKeywordSeeds == 0 {
+			size <<= 1
+			full = true
+		}
+
+		kt := &Keywords{
+			seed:       seed * 0x9e3779b97f4a7c15,
+			disps:      make([]uint32, size/4),
+			slots:      make([]keywordSlot, size),
+			mask:       uint32(size - 1),
+			bucketMask: uint64(size/4 - 1),
+			full:       full,
+			length:     len(entries),
+		}
+		buckets := make([][]int, len(kt.disps))
+		for i, en := range entries {
+			b := kt.bucket(kt.hash(en.key))
+			buckets[b] = append(buckets[b], i)
+		}
+		order := make([]int, len(buckets))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return len(buckets[order[i]]) > len(buckets[order[j]])
+		})
+
+		failed := false
+		for _, b := range order {
+			if len(buckets[b]) == 0 {
+				break
+			}
+
+			disp, found := kt.findDisp(buckets[b], func(i int) string { return entries[i].key })
+			if !found {
+				failed = true
+				break
+			}
+
+			kt.disps[b] = disp
+			for _, i := range buckets[b] {
+				s := &kt.slots[kt.slot(kt.hash(entries[i].key), disp)]
+				*s = keywordSlot{kt.hash(entries[i].key), entries[i].key, entries[i].value, true}
+			}
+		}
+		if !failed {
+			return kt
+		}
+	}
+}
+
+func (kt *Keywords) findDisp(bucket []int, key func(int) string) (uint32, bool) {
+	taken := make([]uint32, 0, len(bucket))
+	for disp := uint32(0); disp < maxKeywordDisp; disp++ {
+		taken = taken[:0]
+		ok := true
+		for _, i := range bucket {
+			s := kt.slot(kt.hash(key(i)), disp)
+			if kt.slots[s].used {
+				ok = false
+				break
+			}
+			for _, t := range taken {
+				if t == s {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				break
+			}
+			taken = append(taken, s)
+		}
+		if ok {
+			return disp, true
+		}
+	}
+	return 0, false
+}
+
+// hash mixes length and up to 8 leading and 8 trailing bytes of key, or all bytes if full is set.
+func (kt *Keywords) hash(key string) uint64 {
+	return kt.hashBytes([]byte(key))
+}
+
+func (kt *Keywords) hashBytes(key []byte) uint64 {
+	h := kt.seed ^ uint64(len(key))*0xff51afd7ed558ccd
+	if kt.full {
+		for _, c := range key {
+			h ^= uint64(c)
+			h *= 0x100000001b3
+		}
+	} else if len(key) >= 8 {
+		h ^= binary.LittleEndian.Uint64(key) * 0xc4ceb9fe1a85ec53
+		h ^= bits.RotateLeft64(binary.LittleEndian.Uint64(key[len(key)-8:]), 31) * 0x9e3779b97f4a7c15
+	} else {
+		var x uint64
+		for i, c := range key {
+			x |= uint64(c) << (i * 8)
+		}
+		h ^= x * 0xc4ceb9fe1a85ec53
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
+
+func (kt *Keywords) bucket(h uint64) uint64 {
+	return (h >> 32) & kt.bucketMask
+}
+
+func (kt *Keywords) slot(h uint64, disp uint32) uint32 {
+	return (uint32(h) + disp*(uint32(h>>48)|1)) & kt.mask
+}
+
+// Lookup returns the value for given keyword text and true, or 0 and false if the text is not a keyword.
+func (kt *Keywords) Lookup(text []byte) (int, bool) {
+	h := kt.hashBytes(text)
+	s := &kt.slots[kt.slot(h, kt.disps[kt.bucket(h)])]
+	if s.hash == h && s.used && s.key == string(text) {
+		return s.value, true
+	}
+	return 0, false
+}
+
+// Len returns the number of distinct keywords in the table.
+func (kt *Keywords) Len() int {
+	return kt.length
+}
@@ -2,11 +2,17 @@
 package lexer
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/ava12/llx"
+	"github.com/ava12/llx/grammar"
 	"github.com/ava12/llx/source"
 )
 
@@ -28,6 +34,9 @@ const (
 
 	// BadTokenError indicates that lexer has fetched a token of ErrorTokenType.
 	BadTokenError
+
+	// UnclosedTokenError indicates that a nested token (see Lexer.WithNested) lacks closing delimiter.
+	UnclosedTokenError
 )
 
 // TokenType describes token type for specific capturing group of regular expression.
@@ -39,10 +48,20 @@ type TokenType struct {
 	TypeName string
 }
 
-// TokenTypeSet represents a set of expected token types, each one is coded as 1 << type.
-type TokenTypeSet = uint64
+// TokenTypeSet represents a set of expected token types.
+type TokenTypeSet = grammar.BitSet
+
+// AllTokenTypes contains all token types from 0 to grammar.MaxTokenType.
+// Must not be modified.
+var AllTokenTypes = allTokenTypes()
 
-const AllTokenTypes = TokenTypeSet(1<<64 - 1)
+func allTokenTypes() TokenTypeSet {
+	res := make(TokenTypeSet, (grammar.MaxTokenType+64)/64)
+	for i := range res {
+		res[i] = ^uint64(0)
+	}
+	return res
+}
 
 // Lexer performs lexical analysis of current source in source.Queue using regexp.Regexp.
 // Lexer itself is immutable, stateless, and safe for concurrent use (i.e. the same Lexer instance
@@ -51,25 +70,216 @@ const AllTokenTypes = TokenTypeSet(1<<64 - 1)
 // A match containing no captured groups is treated as insignificant lexeme (e.g. whitespace),
 // in this case lexer tries to fetch a token again at new position.
 // Every byte of source file must belong to some lexeme.
+// A lexer created by NewDFA uses deterministic finite automaton instead of regexp.
 type Lexer struct {
-	types []TokenType
-	re    *regexp.Regexp
+	types       []TokenType
+	re          *regexp.Regexp
+	preambleRe  *regexp.Regexp
+	dfa         *dfa
+	preambleDFA *dfa
+	arena       *TokenArena
+	nested      map[int]nestedDelims
+	ctx         context.Context
+	group       int
+
+	reAutomaton       *reAutomaton
+	preambleAutomaton *reAutomaton
+}
+
+type nestedDelims struct {
+	open, close []byte
 }
 
 // New creates new Lexer.
 // Each n-th element of types describes token type for (n+1)-th regexp capturing group.
-// A group that has no description or that has token type < 0 or > 63 is treated as ErrorTokenType.
+// A group that has no description or that has token type < 0 or > grammar.MaxTokenType is treated as ErrorTokenType.
 func New(re *regexp.Regexp, types []TokenType) *Lexer {
 	ts := make([]TokenType, len(types))
 	for i, t := range types {
 		ts[i].TypeName = t.TypeName
-		if t.Type >= 0 && t.Type < 64 {
+		if t.Type >= 0 && t.Type <= grammar.MaxTokenType {
 			ts[i].Type = t.Type
 		} else {
 			ts[i].Type = ErrorTokenType
 		}
 	}
-	return &Lexer{types: ts, re: re}
+	return &Lexer{types: ts, re: re, reAutomaton: newReAutomaton(re)}
+}
+
+// WithPreamble returns a copy of lexer that uses given regexp at the beginning of a logical source
+// (see source.Queue.AtSourceStart) and its own regexp elsewhere. Both regexps must have the same capturing groups, typically lexer regexp
+// has never-matching groups (e.g. `([^\x00-\x{10ffff}])`) for token types allowed only in preamble
+// (like shebang line or byte order mark).
+func (l *Lexer) WithPreamble(re *regexp.Regexp) *Lexer {
+	res := *l
+	res.preambleRe = re
+	res.preambleAutomaton = newReAutomaton(re)
+	res.preambleDFA = nil
+	return &res
+}
+
+// WithTokenArena returns a copy of lexer that takes fetched tokens from given arena.
+// Since arena is not safe for concurrent use, neither is the returned lexer.
+func (l *Lexer) WithTokenArena(a *TokenArena) *Lexer {
+	res := *l
+	res.arena = a
+	return &res
+}
+
+// WithGroup returns a copy of lexer that records given group number in fetched tokens, see Token.Group.
+func (l *Lexer) WithGroup(group int) *Lexer {
+	res := *l
+	res.group = group
+	return &res
+}
+
+// contextCheckInterval is the number of bytes scanned between context checks, see Lexer.WithContext.
+const contextCheckInterval = 64 << 10
+
+// WithContext returns a copy of lexer that checks given context before fetching each token and
+// once per contextCheckInterval scanned bytes, so that matching a single huge lexeme can be interrupted:
+// fetching returns ctx.Err() as soon as the context is done. Regexp lexer first scans long remaining text
+// with an equivalent automaton, a lexeme longer than the interval is then matched using slower io.RuneReader interface.
+// A context that is never done (e.g. context.Background()) is ignored.
+func (l *Lexer) WithContext(ctx context.Context) *Lexer {
+	res := *l
+	res.ctx = ctx
+	if ctx != nil && ctx.Done() == nil {
+		res.ctx = nil
+	}
+	return &res
+}
+
+// checkContext returns ctx.Err() if ctx is not nil and pos has reached *next, advancing *next by contextCheckInterval.
+func checkContext(ctx context.Context, pos int, next *int) error {
+	if ctx == nil || pos < *next {
+		return nil
+	}
+
+	*next = pos + contextCheckInterval
+	return ctx.Err()
+}
+
+// contextReader feeds content to regexp checking context, reports the end of input once the context is done.
+type contextReader struct {
+	ctx     context.Context
+	content []byte
+	pos     int
+	next    int
+	err     error
+}
+
+func (r *contextReader) ReadRune() (rune, int, error) {
+	if r.err != nil {
+		return 0, 0, io.EOF
+	}
+	r.err = checkContext(r.ctx, r.pos, &r.next)
+	if r.err != nil || r.pos >= len(r.content) {
+		return 0, 0, io.EOF
+	}
+
+	c, size := utf8.DecodeRune(r.content[r.pos:])
+	r.pos += size
+	return c, size, nil
+}
+
+// WithNested returns a copy of lexer that extends each match of given token type (which should match
+// the opening delimiter) up to the balanced closing delimiter, so the token may contain nested pairs of delimiters,
+// e.g. nested comments. Delimiters must be non-empty and distinct. If a closing delimiter is a prefix
+// of source text, it takes precedence over opening one. Reaching the end of source before the balanced
+// closing delimiter is an error (UnclosedTokenError).
+func (l *Lexer) WithNested(tokenType int, open, close string) *Lexer {
+	res := *l
+	res.nested = make(map[int]nestedDelims, len(l.nested)+1)
+	for tt, nd := range l.nested {
+		res.nested[tt] = nd
+	}
+	res.nested[tokenType] = nestedDelims{[]byte(open), []byte(close)}
+	return &res
+}
+
+// nestedEnd returns the position right after the closing delimiter balancing the opening one preceding pos,
+// or -1 if there is no such delimiter. Returns error if ctx is done.
+func (nd nestedDelims) nestedEnd(ctx context.Context, content []byte, pos int) (int, error) {
+	depth := 1
+	next := pos + contextCheckInterval
+	for pos < len(content) {
+		if e := checkContext(ctx, pos, &next); e != nil {
+			return 0, e
+		}
+
+		switch {
+		case bytes.HasPrefix(content[pos:], nd.close):
+			pos += len(nd.close)
+			depth--
+			if depth == 0 {
+				return pos, nil
+			}
+		case bytes.HasPrefix(content[pos:], nd.open):
+			pos += len(nd.open)
+			depth++
+		default:
+			pos++
+		}
+	}
+	return -1, nil
+}
+
+// errIncomplete is returned by matching functions in partial mode if more content could change the lexeme.
+var errIncomplete = errors.New("incomplete lexeme")
+
+// reAutomaton lazily builds an automaton equivalent to a regexp, used to tell if more content
+// could change the match (see matchToken), since regexp.Regexp cannot tell that.
+type reAutomaton struct {
+	once sync.Once
+	re   *regexp.Regexp
+	dfa  *dfa
+}
+
+func newReAutomaton(re *regexp.Regexp) *reAutomaton {
+	if re == nil {
+		return nil
+	}
+	return &reAutomaton{re: re}
+}
+
+// scan returns the number of bytes regexp has to examine to match content and true if matching
+// reaches the end of content in a non-dead state. Reports the whole content if automaton cannot be built.
+func (ra *reAutomaton) scan(ctx context.Context, content []byte) (int, bool, error) {
+	ra.once.Do(func() {
+		ra.dfa, _ = newDFA([]string{"(?-s:" + ra.re.String() + ")"})
+	})
+	if ra.dfa == nil {
+		return len(content), false, nil
+	}
+
+	_, _, scanned, hitEnd, e := ra.dfa.scan(ctx, content)
+	return scanned, hitEnd, e
+}
+
+// extendNested returns the end of nested token starting at start and matched up to end, or end if the token
+// type is not nested. Returns error if closing delimiter is not found, errIncomplete in partial mode.
+func (l *Lexer) extendNested(q *source.Queue, tokenType int, content []byte, start, end, offset int, partial bool) (int, error) {
+	nd, has := l.nested[tokenType]
+	if !has {
+		return end, nil
+	}
+
+	res, e := nd.nestedEnd(l.ctx, content, end)
+	if e != nil {
+		return 0, e
+	}
+	if res < 0 && partial {
+		return 0, errIncomplete
+	}
+	if res < 0 {
+		return 0, unclosedTokenError(q.PosAt(offset+start), string(nd.open))
+	}
+	return res, nil
+}
+
+func unclosedTokenError(pos source.Pos, open string) *llx.Error {
+	return llx.FormatErrorPos(pos, UnclosedTokenError, "unclosed %q", open)
 }
 
 func wrongCharError(s *source.Source, content []byte, line, col int) *llx.Error {
@@ -82,34 +292,75 @@ func wrongTokenError(t *Token) *llx.Error {
 	return llx.FormatErrorPos(t, BadTokenError, "bad token %q", t.Text())
 }
 
-func (l *Lexer) matchToken(src *source.Source, content []byte, pos int, tts TokenTypeSet) (*Token, int, error) {
+// matchToken fetches token at given position of source content.
+// In partial mode (used for continued sources) it returns errIncomplete if more content could change the result.
+func (l *Lexer) matchToken(q *source.Queue, content []byte, pos int, tts TokenTypeSet, partial bool) (*Token, int, error) {
+	re, ra := l.re, l.reAutomaton
+	preamble := pos == 0 && q.AtSourceStart()
+	if preamble && l.preambleRe != nil {
+		re, ra = l.preambleRe, l.preambleAutomaton
+	} else if preamble && l.preambleDFA != nil {
+		return l.matchDFA(l.preambleDFA, q, content, pos, tts, partial)
+	} else if l.dfa != nil {
+		return l.matchDFA(l.dfa, q, content, pos, tts, partial)
+	}
 	content = content[pos:]
-	match := l.re.FindSubmatchIndex(content)
+	scanned := 0
+	if partial || (l.ctx != nil && len(content) > contextCheckInterval) {
+		var hitEnd bool
+		var e error
+		scanned, hitEnd, e = ra.scan(l.ctx, content)
+		if e != nil {
+			return nil, 0, e
+		}
+		if partial && hitEnd {
+			return nil, 0, errIncomplete
+		}
+	}
+
+	var match []int
+	if l.ctx != nil && scanned > contextCheckInterval {
+		r := &contextReader{ctx: l.ctx, content: content}
+		match = re.FindReaderSubmatchIndex(r)
+		if r.err != nil {
+			return nil, 0, r.err
+		}
+	} else {
+		match = re.FindSubmatchIndex(content)
+	}
 	if len(match) == 0 || match[0] != 0 || match[1] <= match[0] {
-		line, col := src.LineCol(pos)
-		return nil, 0, wrongCharError(src, content, line, col)
+		line, col := q.Source().LineCol(pos)
+		return nil, 0, wrongCharError(q.Source(), content, line, col)
 	}
 
 	subMaskMatched := false
 	for i := 2; i < len(match); i += 2 {
 		if match[i] >= 0 && match[i+1] >= 0 {
 			subMaskMatched = true
-			sp := source.NewPos(src, pos+match[i])
+			sp := q.PosAt(pos + match[i])
 			tokenType := ErrorTokenType
 			typeName := ErrorTokenName
 			if len(l.types) >= (i >> 1) {
 				tokenType = l.types[(i>>1)-1].Type
 				typeName = l.types[(i>>1)-1].TypeName
-				if tokenType >= 0 && tts&(1<<tokenType) == 0 {
+				if tokenType >= 0 && !tts.Contains(tokenType) {
 					continue
 				}
 			}
-			token := NewToken(tokenType, typeName, content[match[i]:match[i+1]], sp)
+			end, e := l.extendNested(q, tokenType, content, match[i], match[i+1], pos, partial)
+			if e != nil {
+				return nil, 0, e
+			}
+
+			token := l.arena.NewToken(tokenType, typeName, content[match[i]:end], sp)
 			if tokenType == ErrorTokenType {
 				return nil, 0, wrongTokenError(token)
 			}
 
-			return token, match[1], nil
+			if end < match[1] {
+				end = match[1]
+			}
+			return token, end, nil
 		}
 	}
 
@@ -121,18 +372,59 @@ func (l *Lexer) matchToken(src *source.Source, content []byte, pos int, tts Toke
 }
 
 func (l *Lexer) fetch(q *source.Queue, tSet TokenTypeSet) (*Token, bool, error) {
+	if l.ctx != nil {
+		if e := l.ctx.Err(); e != nil {
+			return nil, false, e
+		}
+	}
+
 	content, pos := q.ContentPos()
 	src := q.Source()
 	if len(content)-pos <= 0 {
 		if src == nil {
-			return EoiToken(), false, nil
-		} else {
-			q.NextSource()
-			return EofToken(src), false, nil
+			eoi := EoiToken()
+			eoi.group = l.group
+			return eoi, false, nil
+		}
+
+		if src.Continued() {
+			var more bool
+			var e error
+			if c := src.Content(); len(c) > 0 && c[len(c)-1] != '\n' {
+				more, e = q.JoinNext(l.ctx)
+			} else {
+				more, e = q.WaitNext(l.ctx)
+				if more {
+					q.NextSource()
+				}
+			}
+			if e != nil {
+				return nil, false, e
+			}
+			if more {
+				return nil, true, nil
+			}
 		}
+
+		eof := EofToken(src)
+		eof.pos = q.PosAt(src.Len())
+		eof.group = l.group
+		q.NextSource()
+		return eof, false, nil
 	}
 
-	tok, advance, e := l.matchToken(src, content, pos, tSet)
+	tok, advance, e := l.matchToken(q, content, pos, tSet, src.Continued())
+	if e == errIncomplete {
+		joined, je := q.JoinNext(l.ctx)
+		if joined || je != nil {
+			return nil, joined, je
+		}
+
+		tok, advance, e = l.matchToken(q, content, pos, tSet, false)
+	}
+	if tok != nil {
+		tok.group = l.group
+	}
 	q.Skip(advance)
 	return tok, advance > 0, e
 }
@@ -141,6 +433,9 @@ func (l *Lexer) fetch(q *source.Queue, tSet TokenTypeSet) (*Token, bool, error)
 // Returns nil token and llx.Error and does not make any changes if there is a lexical error.
 // Returns EoI token if queue is empty.
 // Returns EoF token and discards current source if current position is beyond the end of current source.
+// A continued source (see source.WithContinuation) is discarded without EoF token if it has a successor,
+// lexer may wait for one to be fed. The source is joined with its successor (see source.Queue.JoinNext)
+// if it does not end with line feed or if more content could change the lexeme matched at its end.
 func (l *Lexer) Next(q *source.Queue) (*Token, error) {
 	for {
 		t, _, e := l.fetch(q, AllTokenTypes)
@@ -155,6 +450,7 @@ func (l *Lexer) Next(q *source.Queue) (*Token, error) {
 // Returns nil token and llx.Error and does not make any changes if there is a lexical error.
 // Returns EoI token if queue is empty.
 // Returns EoF token and discards current source if current position is beyond the end of current source.
+// Continued sources are handled as Next does.
 func (l *Lexer) NextOf(q *source.Queue, tts TokenTypeSet) (*Token, error) {
 	for {
 		t, advanced, e := l.fetch(q, tts)
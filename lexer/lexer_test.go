@@ -1,12 +1,17 @@
 package lexer
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"unicode"
 
 	"github.com/ava12/llx"
+	"github.com/ava12/llx/internal/bmap"
+	"github.com/ava12/llx/internal/test"
 	"github.com/ava12/llx/source"
 )
 
@@ -191,11 +196,11 @@ func TestNextOf(t *testing.T) {
 		err, tokenType int
 		rescan         bool
 	}{
-		{"foo", 0b110, 0, 1, false},
-		{"bar", 0b1100, 0, 1, true},
+		{"foo", TokenTypeSet{0b110}, 0, 1, false},
+		{"bar", TokenTypeSet{0b1100}, 0, 1, true},
 		{"?", AllTokenTypes, WrongCharError, 0, false},
 		{`"broken`, AllTokenTypes, BadTokenError, 0, false},
-		{"-123", 0b11010, 0, 0, false},
+		{"-123", TokenTypeSet{0b11010}, 0, 0, false},
 		{"-123", AllTokenTypes, 0, 2, false},
 	}
 
@@ -237,3 +242,392 @@ func TestNextOf(t *testing.T) {
 		})
 	}
 }
+
+func TestDumpTokens(t *testing.T) {
+	l, q := lexer()
+	q.Append(source.New("", []byte("foo 'a\nbc' 12")))
+	dump, e := DumpTokens(q, l)
+	if e != nil {
+		t.Fatalf("unexpected error %s", e)
+	}
+
+	expected := `[{"type":"name","text":"foo","line":1,"col":1,"endLine":1,"endCol":4},` +
+		`{"type":"string","text":"'a\nbc'","line":1,"col":5,"endLine":2,"endCol":4},` +
+		`{"type":"number","text":"12","line":2,"col":5,"endLine":2,"endCol":7},` +
+		`{"type":"-end-of-file-","text":"","line":2,"col":7,"endLine":2,"endCol":7}]`
+	if string(dump) != expected {
+		t.Errorf("expecting %s, got %s", expected, dump)
+	}
+
+	l, q = lexer()
+	q.Append(source.New("", []byte("foo ?")))
+	_, e = DumpTokens(q, l)
+	if e == nil {
+		t.Errorf("expecting error, got success")
+	}
+}
+
+func TestPreamble(t *testing.T) {
+	re := regexp.MustCompile(`([^\x00-\x{10ffff}])|(#[^\n]*\n)|(\w+)|\s+`)
+	pre := regexp.MustCompile(`(#![^\n]*\n)|(#[^\n]*\n)|(\w+)|\s+`)
+	types := []TokenType{{0, "shebang"}, {1, "comment"}, {2, "word"}}
+	l := New(re, types).WithPreamble(pre)
+	samples := []struct {
+		src, expected string
+	}{
+		{"#!/bin/sh\nfoo", "shebang word"},
+		{"foo\n#!bar\n", "word comment"},
+		{" #!foo\n", "comment"},
+	}
+	for i, s := range samples {
+		q := source.NewQueue().Append(source.New("", []byte(s.src)))
+		var got []string
+		tok, e := l.Next(q)
+		for e == nil && tok.Type() >= 0 {
+			got = append(got, tok.TypeName())
+			tok, e = l.Next(q)
+		}
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		} else if strings.Join(got, " ") != s.expected {
+			t.Errorf("sample #%d: expecting %q, got %q", i, s.expected, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestDFA(t *testing.T) {
+	patterns := []string{
+		`\s+`, `-?\d+(?:\.\d+)?`, `(?i:begin|end)\b`, `[a-z_][a-z0-9_]*`, `'.*?'`, `".*"`,
+		`^#.*?$`, `(?m:^%.*?$)`, `\x{2200}+`, `'.{0,10}`, `[-+*/=<>]+|[();]`,
+	}
+	types := []TokenType{
+		{0, "space"}, {1, "number"}, {2, "keyword"}, {3, "name"}, {4, "string"}, {5, "greedy"},
+		{6, "shebang"}, {7, "comment"}, {8, "math"}, {-1, "bad"}, {9, "op"},
+	}
+	groups := make([]string, len(patterns))
+	for i, p := range patterns {
+		groups[i] = "(" + p + ")"
+	}
+	re := regexp.MustCompile("^(?s:" + strings.Join(groups, "|") + ")")
+	rl := New(re, types)
+	dl, e := NewDFA(patterns, types)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	samples := []string{
+		"#!/bin/sh\nfoo = -12.5 * (bar_1 - 3);",
+		"BEGIN beginning end End_ 'a' 'b' \"c\" \"d\"\n",
+		"x ∀∀ y\n% comment\n%\nz",
+		"12.x 1.2.3 'a'' 'bad",
+		"foo\x80bar",
+	}
+	for _, sample := range samples {
+		rq := source.NewQueue().Append(source.New("", []byte(sample)))
+		dq := source.NewQueue().Append(source.New("", []byte(sample)))
+		for {
+			rt, re := rl.Next(rq)
+			dt, de := dl.Next(dq)
+			if fmt.Sprint(re) != fmt.Sprint(de) {
+				t.Fatalf("sample %q: expecting error %v, got %v", sample, re, de)
+			}
+			if re != nil {
+				break
+			}
+
+			if rt.Type() != dt.Type() || rt.Text() != dt.Text() || rt.Line() != dt.Line() || rt.Col() != dt.Col() {
+				t.Fatalf("sample %q: expecting %s %q at %d:%d, got %s %q at %d:%d", sample,
+					rt.TypeName(), rt.Text(), rt.Line(), rt.Col(), dt.TypeName(), dt.Text(), dt.Line(), dt.Col())
+			}
+			if rt.Type() == EofTokenType {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkLexers(b *testing.B) {
+	patterns := []string{`\s+`, `-?\d+(?:\.\d+)?`, `[a-z_][a-z0-9_]*`, `'[^']*'`, `[-+*/=<>]+|[();]`}
+	types := []TokenType{{0, "space"}, {1, "number"}, {2, "name"}, {3, "string"}, {4, "op"}}
+	groups := make([]string, len(patterns))
+	for i, p := range patterns {
+		groups[i] = "(" + p + ")"
+	}
+	content := []byte(strings.Repeat("foo = -12.5 * (bar_1 - 'baz');\n", 1000))
+	dl, _ := NewDFA(patterns, types)
+	lexers := map[string]*Lexer{
+		"regexp": New(regexp.MustCompile("^(?s:"+strings.Join(groups, "|")+")"), types),
+		"dfa":    dl,
+	}
+
+	for name, l := range lexers {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := source.NewQueue().Append(source.New("", content))
+				for {
+					tok, e := l.Next(q)
+					if e != nil {
+						b.Fatal(e)
+					}
+					if tok.Type() == EofTokenType {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNested(t *testing.T) {
+	patterns := []string{`\s+`, `\(\*`, `\w+`, `[()*]`}
+	types := []TokenType{{0, "space"}, {1, "comment"}, {2, "word"}, {3, "op"}}
+	groups := make([]string, len(patterns))
+	for i, p := range patterns {
+		groups[i] = "(" + p + ")"
+	}
+	rl := New(regexp.MustCompile("^(?s:"+strings.Join(groups, "|")+")"), types).WithNested(1, "(*", "*)")
+	dl, e := NewDFA(patterns, types)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dl = dl.WithNested(1, "(*", "*)")
+
+	samples := []struct {
+		src    string
+		tokens []string
+		err    int
+	}{
+		{"a (* b *) c", []string{"a", " ", "(* b *)", " ", "c"}, 0},
+		{"(* (* x *) (**) *)*", []string{"(* (* x *) (**) *)", "*"}, 0},
+		{"(*)*)", []string{"(*)*)"}, 0},
+		{"a (* (* b *)", []string{"a", " "}, UnclosedTokenError},
+	}
+
+	for i, s := range samples {
+		for _, l := range []*Lexer{rl, dl} {
+			q := source.NewQueue().Append(source.New("", []byte(s.src)))
+			var got []string
+			var e error
+			for {
+				var tok *Token
+				tok, e = l.Next(q)
+				if e != nil || tok.Type() < 0 {
+					break
+				}
+				got = append(got, tok.Text())
+			}
+
+			if !reflect.DeepEqual(got, s.tokens) {
+				t.Errorf("sample #%d: expecting %q, got %q", i, s.tokens, got)
+			}
+			ee, _ := e.(*llx.Error)
+			if (s.err == 0 && e != nil) || (s.err != 0 && (ee == nil || ee.Code != s.err || ee.Col != 3)) {
+				t.Errorf("sample #%d: expecting error code %d, got %v", i, s.err, e)
+			}
+		}
+	}
+}
+
+func TestDFACacheLimit(t *testing.T) {
+	dl, e := NewDFA([]string{`\s+`, `\p{L}+`, `\d+`}, []TokenType{{0, "space"}, {1, "word"}, {2, "number"}})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	dl.dfa.limit = 8
+	var b strings.Builder
+	for r := rune(0x400); r < 0x500; r++ {
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	word := b.String()
+	content := word + " 12 " + word + " 345"
+	q := source.NewQueue().Append(source.New("", []byte(content)))
+	var got []string
+	for {
+		tok, e := dl.Next(q)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+		if tok.Type() < 0 {
+			break
+		}
+		got = append(got, tok.Text())
+		if dl.dfa.size > dl.dfa.limit {
+			t.Fatalf("cache size %d exceeds limit %d", dl.dfa.size, dl.dfa.limit)
+		}
+	}
+
+	expected := []string{word, " ", "12", " ", word, " ", "345"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	long := "'" + strings.Repeat("x", contextCheckInterval*4) + "'"
+	dfa, e := NewDFA([]string{"\\s+", "-?\\d+", "[a-z_][a-z0-9_]*", "'.*?'"}, append([]TokenType{{0, "space"}}, tokenTypes...))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	for i, l := range []*Lexer{New(tokenRe, tokenTypes), dfa} {
+		q := source.NewQueue().Append(source.New("", []byte("'short'")))
+		_, e = l.WithContext(ctx).Next(q)
+		if e != context.Canceled {
+			t.Errorf("lexer #%d: expecting canceled error, got %v", i, e)
+		}
+
+		tok, e := l.WithContext(context.Background()).Next(q)
+		if e != nil || tok.Text() != "'short'" {
+			t.Errorf("lexer #%d: expecting short token after error, got %v (error: %v)", i, tok, e)
+		}
+	}
+
+	samples := []struct {
+		l     *Lexer
+		count int
+	}{
+		{dfa, 2},
+		{New(tokenRe, tokenTypes), 2}, // canceled while scanning with automaton
+		{New(tokenRe, tokenTypes), 8}, // canceled while matching with regexp
+	}
+	for i, s := range samples {
+		q := source.NewQueue().Append(source.New("", []byte(long)))
+		_, e = s.l.WithContext(test.NewCountdownContext(s.count)).Next(q)
+		if e != context.Canceled {
+			t.Errorf("sample #%d: expecting lexer to be canceled while matching long token, got %v", i, e)
+		}
+
+		tok, e := s.l.Next(q)
+		if e != nil || tok.Text() != long {
+			t.Errorf("sample #%d: expecting long token after error, got error %v", i, e)
+		}
+	}
+}
+
+func TestTokenArena(t *testing.T) {
+	a := NewTokenArena(2)
+	l, q := lexer()
+	l = l.WithTokenArena(a)
+	q.Append(source.New("", []byte("1 foo 'bar' 2")))
+	var toks []*Token
+	for {
+		tok, e := l.Next(q)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+		if tok.Type() == EofTokenType {
+			break
+		}
+		toks = append(toks, tok)
+	}
+
+	expected := "1 foo 'bar' 2"
+	texts := make([]string, len(toks))
+	for i, tok := range toks {
+		texts[i] = tok.Text()
+	}
+	if strings.Join(texts, " ") != expected {
+		t.Fatalf("expecting %q, got %q", expected, strings.Join(texts, " "))
+	}
+
+	patterns := []string{`\s+`, `\d+`, `[a-z]+`}
+	types := []TokenType{{0, "space"}, {1, "number"}, {2, "name"}}
+	dl, _ := NewDFA(patterns, types)
+	dl = dl.WithTokenArena(a)
+	content := []byte(strings.Repeat("foo 12 ", 100))
+	q = source.NewQueue()
+	allocs := testing.AllocsPerRun(10, func() {
+		q.Append(source.New("", content))
+		for {
+			a.Reset()
+			tok, _ := dl.Next(q)
+			if tok == nil || tok.Type() == EofTokenType {
+				break
+			}
+		}
+	})
+	if allocs > 10 {
+		t.Errorf("expecting at most 10 allocations per source, got %v", allocs)
+	}
+}
+
+func keywordSamples(n int) []string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = fmt.Sprintf("kw%d", i*7)
+	}
+	return res
+}
+
+func TestKeywords(t *testing.T) {
+	for _, n := range []int{0, 1, 5, 100, 2000} {
+		keys := append(keywordSamples(n), "", "kw0")
+		values := make([]int, len(keys))
+		for i := range values {
+			values[i] = i + 1
+		}
+		kt := NewKeywords(keys, values)
+
+		if n > 0 && kt.Len() != n+1 {
+			t.Errorf("%d keys: expecting length %d, got %d", n, n+1, kt.Len())
+		}
+		for i, k := range keys[:n+1] {
+			v, found := kt.Lookup([]byte(k))
+			if !found || v != i+1 {
+				t.Errorf("%d keys: expecting %d for %q, got %d, %v", n, i+1, k, v, found)
+			}
+		}
+		for _, k := range []string{"kw1", "kw", "kw00", "KW7", "foo"} {
+			if v, found := kt.Lookup([]byte(k)); found {
+				t.Errorf("%d keys: unexpected value %d for %q", n, v, k)
+			}
+		}
+	}
+}
+
+func TestLongKeywords(t *testing.T) {
+	keys := make([]string, 50)
+	values := make([]int, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("common_prefix_%04d_common_suffix", i)
+		values[i] = i
+	}
+	kt := NewKeywords(keys, values)
+	for i, k := range keys {
+		v, found := kt.Lookup([]byte(k))
+		if !found || v != i {
+			t.Errorf("expecting %d for %q, got %d, %v", i, k, v, found)
+		}
+	}
+}
+
+func BenchmarkKeywords(b *testing.B) {
+	keys := keywordSamples(500)
+	values := make([]int, len(keys))
+	bm := bmap.New[int](len(keys))
+	for i, k := range keys {
+		values[i] = i
+		bm.Set([]byte(k), i)
+	}
+	kt := NewKeywords(keys, values)
+	texts := make([][]byte, 0, len(keys)*2)
+	for _, k := range keys {
+		texts = append(texts, []byte(k), []byte(k+"_"))
+	}
+
+	b.Run("bmap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bm.Get(texts[i%len(texts)])
+		}
+	})
+	b.Run("keywords", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			kt.Lookup(texts[i%len(texts)])
+		}
+	})
+}
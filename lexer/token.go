@@ -1,18 +1,21 @@
 package lexer
 
 import (
+	"github.com/ava12/llx"
 	"github.com/ava12/llx/source"
 )
 
 // Token represents a lexeme, either fetched from a source file or "external" one.
 // Contains token type, text, and source and starting position (if known).
-// Immutable.
+// Immutable, methods With* return modified copies.
 type Token struct {
 	tokenType int
 	typeName  string
 	content   []byte
 	text      string
 	pos       source.Pos
+	value     any
+	group     int
 }
 
 // Type returns token type.
@@ -39,11 +42,51 @@ func (t *Token) Text() string {
 	return t.text
 }
 
+// Value returns decoded token value, nil if token type has no decoder.
+func (t *Token) Value() any {
+	return t.value
+}
+
+// WithValue returns a copy of token having given decoded value.
+// Used by parser, hooks may use it for tokens they emit.
+func (t *Token) WithValue(v any) *Token {
+	res := *t
+	res.value = v
+	return &res
+}
+
+// Group returns the group number of lexer that fetched the token from source (0 is the default group).
+// 0 for tokens created by hooks.
+func (t *Token) Group() int {
+	return t.group
+}
+
+// WithGroup returns a copy of token having given lexer group number.
+func (t *Token) WithGroup(group int) *Token {
+	res := *t
+	res.group = group
+	return &res
+}
+
+// WithPos returns a copy of token captured at given source position,
+// token type, content, decoded value, and lexer group are kept.
+func (t *Token) WithPos(sp source.Pos) *Token {
+	res := *t
+	res.pos = sp
+	return &res
+}
+
 // Pos returns captured source position.
 func (t *Token) Pos() source.Pos {
 	return t.pos
 }
 
+// EndPos returns source position right after the last byte of the token.
+// Returns zero value if source is not known.
+func (t *Token) EndPos() source.Pos {
+	return t.pos.At(t.pos.Pos() + len(t.content))
+}
+
 // Source returns captured source. Returns nil if source is not known.
 func (t *Token) Source() *source.Source {
 	return t.pos.Source()
@@ -66,6 +109,23 @@ func (t *Token) Col() int {
 	return t.pos.Col()
 }
 
+// EndLine returns 1-based line number of position right after the token.
+// Returns 0 if source is not known.
+func (t *Token) EndLine() int {
+	return t.EndPos().Line()
+}
+
+// EndCol returns 1-based column number of position right after the token.
+// Returns 0 if source is not known.
+func (t *Token) EndCol() int {
+	return t.EndPos().Col()
+}
+
+// IncludeTrace returns include chain of token source, see source.Pos.Includes.
+func (t *Token) IncludeTrace() []llx.SourcePos {
+	return t.pos.IncludeTrace()
+}
+
 // NewToken creates a token.
 // Expects zero value for sp if token source is not known.
 func NewToken(tokenType int, typeName string, content []byte, sp source.Pos) *Token {
@@ -77,6 +137,54 @@ func NewToken(tokenType int, typeName string, content []byte, sp source.Pos) *To
 	}
 }
 
+// TokenArena allocates tokens in chunks instead of one by one, reducing the number of allocations
+// and GC load when a large number of tokens is created. A chunk is kept in memory while at least one
+// of its tokens is referenced. Arena is not safe for concurrent use.
+type TokenArena struct {
+	chunk []Token
+	next  int
+}
+
+// DefaultArenaChunk is the chunk size used by arenas created with non-positive chunk size.
+const DefaultArenaChunk = 256
+
+// NewTokenArena creates an arena allocating chunkSize tokens at once.
+func NewTokenArena(chunkSize int) *TokenArena {
+	if chunkSize <= 0 {
+		chunkSize = DefaultArenaChunk
+	}
+	return &TokenArena{chunk: make([]Token, chunkSize)}
+}
+
+// NewToken is same as package-level NewToken, except it takes token from arena.
+// Nil arena allocates each token separately.
+func (a *TokenArena) NewToken(tokenType int, typeName string, content []byte, sp source.Pos) *Token {
+	if a == nil {
+		return NewToken(tokenType, typeName, content, sp)
+	}
+
+	if a.next == len(a.chunk) {
+		a.chunk = make([]Token, len(a.chunk))
+		a.next = 0
+	}
+	t := &a.chunk[a.next]
+	a.next++
+	*t = Token{
+		tokenType: tokenType,
+		typeName:  typeName,
+		content:   content,
+		pos:       sp,
+	}
+	return t
+}
+
+// Reset makes arena overwrite tokens of the current chunk starting from the first one,
+// so that processing token stream of any length allocates no more than one chunk.
+// Must be called only when no token taken from the current chunk is used anymore.
+func (a *TokenArena) Reset() {
+	a.next = 0
+}
+
 const (
 	// EofTokenType is a fake token indicating the end of source file.
 	// Line and column (if present) mark the position right after the last rune of source file.
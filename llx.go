@@ -2,13 +2,20 @@
 Package llx is a general-purpose LL(*) parser library.
 
 Consists of subpackages:
+  - ast: maps syntax trees to user-defined Go structures using struct tags;
   - cmd/llxgen: console utility converting grammar description to Go source file containing grammar definition structure;
   - grammar: defines structure that contains definition of lexemes and finite state machine used by parser;
+  - grammar/export: exports compiled grammars to Graphviz DOT format;
+  - grammar/gen: generates random sentences of grammars for property testing;
   - langdef: converts grammar description (written in EBNF-like language) to grammar definition;
+  - langdef/antlr: converts ANTLR4 grammars to grammar descriptions;
   - lexer: lexical analyzer;
+  - llxtest: runs grammars against sample files and compares syntax trees with golden files;
   - parser: defines parser;
   - source: defines source file and source queue used by lexer;
-  - tree: types and functions to create, traverse, and modify syntax trees.
+  - source/lsp: converts source positions and errors to Language Server Protocol positions and diagnostics;
+  - tree: types and functions to create, traverse, and modify syntax trees;
+  - tree/query: XPath-like path expressions compiled to tree selectors.
 
 Typical usage is:
 
@@ -30,10 +37,13 @@ import (
 
 // Error classes used by subpackages, each class contains up to 99 error codes:
 const (
-	LangDefErrors = 1   // used by langdef
-	LexicalErrors = 101 // used by lexer
-	SyntaxErrors  = 201 // used by parser
-	ParserErrors  = 301 // used by parser
+	LangDefErrors   = 1   // used by langdef
+	LexicalErrors   = 101 // used by lexer
+	SyntaxErrors    = 201 // used by parser
+	ParserErrors    = 301 // used by parser
+	GrammarErrors   = 401 // used by grammar
+	ConverterErrors = 501 // used by langdef/antlr
+	MappingErrors   = 601 // used by ast
 )
 
 // Error is the error type used by llx subpackages.
@@ -44,6 +54,9 @@ type Error struct {
 	// Message contains non-empty error message including source name and position information if provided.
 	Message string
 
+	// Text contains error message without source name and position information.
+	Text string
+
 	// SourceName contains source name that caused this error or empty string.
 	SourceName string
 
@@ -52,6 +65,37 @@ type Error struct {
 
 	// Col contains column number in source file or 0.
 	Col int
+
+	// EndLine and EndCol contain position right after the text that caused this error (e.g. a token) or 0.
+	EndLine, EndCol int
+
+	// Expected lists token types (prefixed with $) and quoted literals acceptable at the position of syntax error,
+	// nil for other errors.
+	Expected []string
+
+	// Nodes lists names of nodes being parsed at the position of syntax error, from the root node
+	// to the innermost one, nil for other errors.
+	Nodes []string
+
+	// Includes lists positions the source was included from, starting from the innermost include,
+	// nil if the source was not included or position information is not provided.
+	Includes []IncludePos
+
+	// Related lists other source locations relevant to this error (e.g. an unclosed opening delimiter) or nil.
+	Related []RelatedInfo
+
+	// Fix contains suggested replacement for the text that caused this error or empty string.
+	Fix string
+
+	// Suggestions lists acceptable literals similar to unexpected token text, the closest first,
+	// nil for other errors. The first suggestion is also used as Fix.
+	Suggestions []string
+}
+
+// IncludePos is a position of included source in including one.
+type IncludePos struct {
+	SourceName string
+	Line, Col  int
 }
 
 // SourcePos is used to retrieve source name and position information when constructing an error;
@@ -65,13 +109,29 @@ type SourcePos interface {
 	Col() int
 }
 
+// SourceSpan may be implemented by SourcePos to report the end of text that caused an error;
+// lexer.Token implements this interface.
+type SourceSpan interface {
+	// EndLine returns line number of position right after the text or 0.
+	EndLine() int
+	// EndCol returns column number of position right after the text or 0.
+	EndCol() int
+}
+
+// IncludeTracer may be implemented by SourcePos to report include chain of the source, see source.Queue.Include.
+// IncludeTrace returns positions the source was included from, starting from the innermost include.
+type IncludeTracer interface {
+	IncludeTrace() []SourcePos
+}
+
 // NewError creates new Error structure.
 // name, line, and col will be added to error message if provided (non-zero).
 func NewError(code int, msg, name string, line, col int) *Error {
+	text := msg
 	if name != "" && line != 0 && col != 0 {
 		msg += fmt.Sprintf(" in %s at line %d col %d", name, line, col)
 	}
-	return &Error{code, msg, name, line, col}
+	return &Error{Code: code, Message: msg, Text: text, SourceName: name, Line: line, Col: col}
 }
 
 // Error simply returns Error.Message.
@@ -89,11 +149,22 @@ func FormatError(code int, msg string, params ...any) *Error {
 }
 
 // FormatErrorPos creates Error structure with source and position information.
-// pos must not be nil.
+// pos must not be nil. Include chain is added if pos implements IncludeTracer,
+// end position is added if pos implements SourceSpan.
 // params will be added to error message using fmt.Sprintf function.
 func FormatErrorPos(pos SourcePos, code int, msg string, params ...any) *Error {
 	if len(params) > 0 {
 		msg = fmt.Sprintf(msg, params...)
 	}
-	return NewError(code, msg, pos.SourceName(), pos.Line(), pos.Col())
+	e := NewError(code, msg, pos.SourceName(), pos.Line(), pos.Col())
+	if ss, valid := pos.(SourceSpan); valid && e.Line != 0 {
+		e.EndLine, e.EndCol = ss.EndLine(), ss.EndCol()
+	}
+	if it, valid := pos.(IncludeTracer); valid {
+		for _, p := range it.IncludeTrace() {
+			e.Includes = append(e.Includes, IncludePos{p.SourceName(), p.Line(), p.Col()})
+			e.Message += fmt.Sprintf(", included from %s at line %d col %d", p.SourceName(), p.Line(), p.Col())
+		}
+	}
+	return e
 }
@@ -0,0 +1,209 @@
+// Package llxtest runs grammars against sample files and compares resulting syntax trees with golden files.
+//
+// Each sample file in a directory has a golden file with the same name and ".golden" suffix added.
+// A golden file contains the syntax tree dump (see Dump) followed by parsing errors, one per line:
+//
+//	error <code>: <message>
+//
+// so a sample that must fail has a golden file containing only error lines.
+// Golden files are created or overwritten instead of being compared when tests are run
+// with -llxtest.update flag or WithUpdate option.
+package llxtest
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/tree"
+)
+
+// GoldenSuffix is appended to sample file names to get golden file names.
+const GoldenSuffix = ".golden"
+
+var update = flag.Bool("llxtest.update", false, "update golden files instead of comparing")
+
+type config struct {
+	ext       string
+	update    bool
+	asides    bool
+	parseOpts []parser.ParseOption
+}
+
+// Option tunes RunGolden.
+type Option func(c *config)
+
+// WithExt restricts sample files to those having given extension (e.g. ".txt"),
+// by default all files except golden ones are samples.
+func WithExt(ext string) Option {
+	return func(c *config) {
+		c.ext = ext
+	}
+}
+
+// WithUpdate makes RunGolden write golden files instead of comparing them, same as -llxtest.update flag.
+func WithUpdate() Option {
+	return func(c *config) {
+		c.update = true
+	}
+}
+
+// WithAsides makes parser keep aside tokens in syntax trees.
+func WithAsides() Option {
+	return func(c *config) {
+		c.asides = true
+	}
+}
+
+// WithParseOptions adds options used for parsing each sample.
+func WithParseOptions(opts ...parser.ParseOption) Option {
+	return func(c *config) {
+		c.parseOpts = append(c.parseOpts, opts...)
+	}
+}
+
+// RunGolden parses each sample file in dir (non-recursively) with given parser using tree hooks
+// and compares the result with the golden file, each sample is run as a subtest named after the file.
+// A missing golden file is reported as a failure unless golden files are being updated.
+func RunGolden(t *testing.T, p *parser.Parser, dir string, opts ...Option) {
+	t.Helper()
+	c := &config{update: *update}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	hs := &parser.Hooks{Nodes: parser.NodeHooks{parser.AnyNode: tree.NodeHook}}
+	if c.asides {
+		hs.Tokens = parser.TokenHooks{parser.AnyToken: func(token *parser.Token, pc *parser.ParseContext) (bool, error) {
+			return true, nil
+		}}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, GoldenSuffix) || c.ext != "" && filepath.Ext(name) != c.ext {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			content, e := os.ReadFile(path)
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			res, e := p.ParseString(name, string(content), hs, c.parseOpts...)
+			got := Result(res, e)
+			if c.update {
+				e = os.WriteFile(path+GoldenSuffix, []byte(got), 0o644)
+				if e != nil {
+					t.Fatal(e)
+				}
+				return
+			}
+
+			expected, e := os.ReadFile(path + GoldenSuffix)
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if got != string(expected) {
+				t.Errorf("result differs from %s:\n%s", name+GoldenSuffix, diff(string(expected), got))
+			}
+		})
+	}
+}
+
+// Result returns golden file content for parsing result and error: the dump of result if it is a syntax tree
+// followed by error lines.
+func Result(res any, e error) string {
+	var b strings.Builder
+	if el, valid := res.(tree.Element); valid && el != nil {
+		b.WriteString(Dump(el))
+	}
+
+	var errs []error
+	var el parser.ErrorList
+	if errors.As(e, &el) {
+		for _, le := range el {
+			errs = append(errs, le)
+		}
+	} else if e != nil {
+		errs = append(errs, e)
+	}
+	for _, e := range errs {
+		code := 0
+		var le *llx.Error
+		if errors.As(e, &le) {
+			code = le.Code
+		}
+		b.WriteString("error " + strconv.Itoa(code) + ": " + strings.ReplaceAll(e.Error(), "\n", " ") + "\n")
+	}
+	return b.String()
+}
+
+// Dump returns indented text representation of syntax tree: one line per element, nested elements
+// are indented with two spaces, nodes are represented with type names, tokens are represented
+// with type names prefixed with $ followed by quoted texts.
+func Dump(root tree.Element) string {
+	var b strings.Builder
+	tree.Walk(root, tree.WalkLtr, func(s tree.WalkStat) tree.WalkerFlags {
+		depth := 0
+		for p := s.Element.Parent(); p != nil && tree.Element(p) != root; p = p.Parent() {
+			depth++
+		}
+		if s.Element != root {
+			depth++
+		}
+
+		b.WriteString(strings.Repeat("  ", depth))
+		if s.Element.IsNode() {
+			b.WriteString(s.Element.TypeName())
+		} else {
+			b.WriteString("$" + s.Element.TypeName())
+			if s.Element.Token() != nil {
+				b.WriteString(" " + strconv.Quote(s.Element.Token().Text()))
+			}
+		}
+		b.WriteString("\n")
+		return 0
+	})
+	return b.String()
+}
+
+// diff returns differing lines of expected and actual texts.
+func diff(expected, got string) string {
+	el := strings.Split(expected, "\n")
+	gl := strings.Split(got, "\n")
+	var res []string
+	for i := 0; i < len(el) || i < len(gl); i++ {
+		var e, g string
+		if i < len(el) {
+			e = el[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if e != g {
+			res = append(res, "line "+strconv.Itoa(i+1)+":\n  expecting: "+e+"\n  got:       "+g)
+		}
+		if len(res) >= 10 {
+			res = append(res, "...")
+			break
+		}
+	}
+	return strings.Join(res, "\n")
+}
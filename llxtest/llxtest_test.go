@@ -0,0 +1,48 @@
+package llxtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ava12/llx/parser"
+)
+
+const grammar = "!aside $space $comment; $space = /\\s+/; $comment = /#[^\\n]*/; " +
+	"$name = /[a-z]+/; $num = /\\d+/; $op = /[=;]/; g = {stmt}; stmt = $name, '=', ($name | $num), ';';"
+
+func TestRunGolden(t *testing.T) {
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	RunGolden(t, p, "testdata", WithExt(".txt"))
+}
+
+func TestUpdate(t *testing.T) {
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	dir := t.TempDir()
+	e = os.WriteFile(filepath.Join(dir, "sample"), []byte("x = y; # comment\n"), 0o644)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	RunGolden(t, p, dir, WithUpdate(), WithAsides())
+	got, e := os.ReadFile(filepath.Join(dir, "sample"+GoldenSuffix))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	expected := "g\n  stmt\n    $name \"x\"\n    $space \" \"\n    $op \"=\"\n    $space \" \"\n    $name \"y\"\n    $op \";\"\n" +
+		"  $space \" \"\n  $comment \"# comment\"\n  $space \"\\n\"\n"
+	if string(got) != expected {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+
+	RunGolden(t, p, dir, WithAsides())
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"github.com/ava12/llx/grammar"
+)
+
+// Action is a callback bound to named action of grammar, e.g. "@add" in "expr = term, {'+', term @add};".
+// It is called each time parser passes action position, i.e. right after preceding element is matched
+// (all tokens of that element are already passed to token hooks and all its nodes are finished).
+// The node containing the action is the innermost one on parser stack (see ParseContext.ParentNode).
+// Returned error stops parsing.
+type Action = func(pc *ParseContext) error
+
+// WithAction binds callback to grammar action name (without leading "@"), replacing previous binding if any.
+// Actions that have no callbacks are ignored. Binding a name not used in grammar is an error
+// (UnknownActionError returned by New or Parse).
+func WithAction(name string, a Action) ParseOption {
+	return func(po *parseOptions) {
+		if po.actions == nil {
+			po.actions = make(map[string]Action)
+		}
+		po.actions[name] = a
+	}
+}
+
+type boundAction struct {
+	name string
+	f    Action
+}
+
+func checkActions(g *grammar.Grammar, actions map[string]Action) error {
+	for name := range actions {
+		found := false
+		for _, a := range g.Actions {
+			if a.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return unknownActionError(name)
+		}
+	}
+	return nil
+}
+
+func (pc *ParseContext) initActions() error {
+	e := checkActions(pc.parser.grammar, pc.options.actions)
+	if e != nil || len(pc.options.actions) == 0 {
+		return e
+	}
+
+	for _, a := range pc.parser.grammar.Actions {
+		f := pc.options.actions[a.Name]
+		if f == nil {
+			continue
+		}
+
+		if pc.actions == nil {
+			pc.actions = make(map[int]boundAction)
+		}
+		pc.actions[a.State] = boundAction{a.Name, f}
+	}
+	return nil
+}
+
+// runAction calls callback bound to current state if parser leaves it using rule r.
+func (pc *ParseContext) runAction(r grammar.Rule) error {
+	a, f := pc.actions[pc.node.state]
+	if !f || r.Token != grammar.AnyToken {
+		return nil
+	}
+
+	if pc.options.trace != nil {
+		pc.tracef("action @%s", a.name)
+	}
+	return a.f(pc)
+}
@@ -0,0 +1,23 @@
+package parser
+
+import "github.com/ava12/llx/lexer"
+
+// checkAdjacent returns error if token does not start right where the last token passed to node hooks ends.
+// Tokens having no source (e.g. emitted by hooks) and end-of-file tokens are not checked.
+// If errors are collected (see WithErrorCollection), the error is collected and the token is accepted.
+func (pc *ParseContext) checkAdjacent(tok *Token) error {
+	prev := pc.lastToken
+	if tok == nil || prev == nil || tok.Type() == lexer.EofTokenType || tok.Type() == lexer.EoiTokenType ||
+		tok.Source() == nil || prev.Source() == nil {
+		return nil
+	}
+
+	if tok.Source() == prev.Source() && tok.Pos().Pos() == prev.EndPos().Pos() {
+		return nil
+	}
+
+	if pc.options.trace != nil {
+		pc.tracef("token %s is not adjacent to %s", pc.traceToken(tok), pc.traceToken(prev))
+	}
+	return pc.softError(notAdjacentError(tok, prev))
+}
@@ -16,7 +16,7 @@ type branch struct {
 }
 
 func createBranches(pc *ParseContext, nt *nodeRec, ars []grammar.Rule) *branch {
-	ntCopy := &nodeRec{nil, nil, nil, nt.types, nt.index, nt.state}
+	ntCopy := &nodeRec{nil, nil, nil, nt.types, nt.index, nt.state, nil, ""}
 	result := &branch{nil, 1, pc, []grammar.Rule{ars[0]}, nil, ntCopy, false}
 	result.split(ars)
 	return result
@@ -35,7 +35,7 @@ func (b *branch) split(ars []grammar.Rule) {
 		} else {
 			nars[ruleCnt-1] = ars[i]
 		}
-		ntCopy := &nodeRec{nt.prev, nil, nil, nt.types, nt.index, nt.state}
+		ntCopy := &nodeRec{nt.prev, nil, nil, nt.types, nt.index, nt.state, nil, ""}
 		current := &branch{prev.next, b.index + i, b.pc, nars, b.ntTree, ntCopy, false}
 		prev.next = current
 		prev = current
@@ -93,7 +93,7 @@ func (b *branch) applyToken(tok *lexer.Token) (success bool) {
 				if ntr == nil {
 					b.node = nil
 				} else {
-					b.node = &nodeRec{ntr.prev, nil, nil, ntr.types, ntr.index, ntr.state}
+					b.node = &nodeRec{ntr.prev, nil, nil, ntr.types, ntr.index, ntr.state, nil, ""}
 					b.ntTree = ntr.prev
 				}
 				if isWildcardToken {
@@ -114,19 +114,32 @@ func (b *branch) applyToken(tok *lexer.Token) (success bool) {
 			gr := b.pc.parser.grammar
 			nt := gr.Nodes[ar.Node]
 			b.ntTree = b.node
-			b.node = &nodeRec{b.node, nil, nil, gr.States[nt.FirstState].TokenTypes, ar.Node, nt.FirstState}
+			b.node = &nodeRec{b.node, nil, nil, gr.States[nt.FirstState].TokenTypes, ar.Node, nt.FirstState, nil, ""}
 		}
 	}
 
 	return true
 }
 
-func (b *branch) nextTokenTypes() (result grammar.BitSet) {
-	if b.node != nil {
-		result |= b.node.types
+// nextTokenTypes collects token types acceptable by the branch and the following ones in buf
+// and returns the result, buf is reused unless it has to grow.
+func (b *branch) nextTokenTypes(buf grammar.BitSet) grammar.BitSet {
+	buf = buf[:0]
+	for ; b != nil; b = b.next {
+		if b.node != nil {
+			buf = buf.AddSet(b.node.types)
+		}
 	}
-	if b.next != nil {
-		result |= b.next.nextTokenTypes()
+	return buf
+}
+
+func (b *branch) firstRules() []grammar.Rule {
+	var res []grammar.Rule
+	for ; b != nil; b = b.next {
+		r := b.applied[0]
+		if len(res) == 0 || res[len(res)-1] != r {
+			res = append(res, r)
+		}
 	}
-	return
+	return res
 }
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"github.com/ava12/llx/grammar"
+)
+
+// LeadingComments returns comment tokens (see WithComments) preceding given token, e.g. the initial token
+// of a node passed to node hook. Returned comments are not returned again, so when several nested nodes
+// start with the same token the comments are attached to the outermost node asking for them.
+// Comments not claimed before the next token is passed to node hooks are discarded.
+func (pc *ParseContext) LeadingComments(tok *Token) []*Token {
+	var res []*Token
+	i := 0
+	for ; i < len(pc.comments) && isBefore(pc.comments[i], tok); i++ {
+		res = append(res, pc.comments[i])
+	}
+	pc.comments = pc.comments[i:]
+	return res
+}
+
+// TrailingComments returns comment tokens (see WithComments) following the last token passed to node hooks
+// and starting on the same line where that token ends, intended to be called from EndNode.
+// When comments are collected, parser fetches the token following finished node before calling EndNode,
+// so all trailing comments are available at that point. Returned comments are not returned again.
+func (pc *ParseContext) TrailingComments() []*Token {
+	if pc.lastToken == nil {
+		return nil
+	}
+
+	end := pc.lastToken.EndPos()
+	var res []*Token
+	i := 0
+	for ; i < len(pc.comments); i++ {
+		c := pc.comments[i]
+		if c.Source() != end.Source() || c.Line() != end.Line() {
+			break
+		}
+
+		res = append(res, c)
+	}
+	pc.comments = pc.comments[i:]
+	return res
+}
+
+// prefetchComments fetches the token following finished nodes (the one parser would fetch next anyway),
+// so that trailing comments are collected before EndNode is called.
+func (pc *ParseContext) prefetchComments() error {
+	if pc.commentTypes == nil {
+		return nil
+	}
+
+	ntr := pc.node
+	for ntr != nil && ntr.state == grammar.FinalState {
+		ntr = ntr.prev
+	}
+	if ntr == nil {
+		return nil
+	}
+
+	tok, e := pc.nextToken(ntr.types)
+	if e == nil {
+		pc.tokens.Prepend(tok)
+	}
+	return e
+}
+
+// consumeComments discards comments preceding the token passed to node hook.
+func (pc *ParseContext) consumeComments(tok *Token) {
+	pc.lastToken = tok
+	i := 0
+	for i < len(pc.comments) && isBefore(pc.comments[i], tok) {
+		i++
+	}
+	pc.comments = pc.comments[i:]
+}
+
+// isBefore returns true if token a is captured before token b.
+// Tokens of different sources are assumed to be captured in order of source processing.
+func isBefore(a, b *Token) bool {
+	if a.Source() != b.Source() {
+		return b.Source() != nil
+	}
+	return a.Pos().Pos() < b.Pos().Pos()
+}
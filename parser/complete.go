@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+// Expected describes a token acceptable by parser at some point.
+type Expected struct {
+	// Name is token type name (lexer.EoiTokenName means that input may end here) or literal text.
+	Name string
+
+	// Literal is true if Name contains literal text.
+	Literal bool
+}
+
+// Complete parses prefix and returns tokens acceptable right after its end, e.g. for auto-completion
+// in REPLs and editors. Token hooks are not used, so the grammar must not depend on external tokens
+// to parse the prefix. An incomplete last token of the prefix is lexed as is, so the caller may need to
+// cut it off first. Returns nil and an error if the prefix contains a syntax error before its end,
+// returns empty list if parser finished before reaching the end of prefix. If the prefix ends while parser
+// looks ahead to choose between alternatives, tokens acceptable by any of remaining alternatives are returned.
+// The context is used as if passed with WithContext option.
+func (p *Parser) Complete(ctx context.Context, prefix string, opts ...ParseOption) ([]Expected, error) {
+	q := source.NewQueue().Append(source.New("", []byte(prefix)))
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx), func(po *parseOptions) {
+		po.completion = true
+	})
+	pc, e := newParseContext(p, q, &Hooks{}, opts)
+	if e == nil {
+		_, e = pc.parse()
+	}
+	if pc != nil && pc.options.reuse {
+		defer p.contexts.Put(pc)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	res := make([]Expected, 0, len(pc.completion))
+	for _, k := range pc.completion {
+		if k == lexer.EoiTokenType {
+			res = append(res, Expected{Name: lexer.EoiTokenName})
+		} else {
+			t := p.grammar.Tokens[k]
+			res = append(res, Expected{t.Name, t.Flags&grammar.LiteralToken != 0})
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"strconv"
+)
+
+// Decoder converts token text to token value, see !decode directive in langdef package.
+type Decoder = func(token *Token) (any, error)
+
+var builtinDecoders = map[string]Decoder{
+	"int": func(token *Token) (any, error) {
+		return strconv.ParseInt(token.Text(), 0, 64)
+	},
+	"uint": func(token *Token) (any, error) {
+		return strconv.ParseUint(token.Text(), 0, 64)
+	},
+	"float": func(token *Token) (any, error) {
+		return strconv.ParseFloat(token.Text(), 64)
+	},
+	"bool": func(token *Token) (any, error) {
+		return strconv.ParseBool(token.Text())
+	},
+	"unquote": func(token *Token) (any, error) {
+		return strconv.Unquote(token.Text())
+	},
+}
+
+// WithDecoder registers decoder for !decode directive, replacing built-in decoder with the same name if any.
+// Built-in decoders are:
+//   - int: int64 value, base prefixes and underscores are allowed (see strconv.ParseInt);
+//   - uint: uint64 value, same syntax as for int;
+//   - float: float64 value;
+//   - bool: bool value (see strconv.ParseBool);
+//   - unquote: string value of Go string or character literal (see strconv.Unquote).
+func WithDecoder(name string, d Decoder) ParseOption {
+	return func(po *parseOptions) {
+		if po.decoders == nil {
+			po.decoders = make(map[string]Decoder)
+		}
+		po.decoders[name] = d
+	}
+}
+
+func (pc *ParseContext) initDecoders() error {
+	for i, t := range pc.parser.grammar.Tokens {
+		if t.Decoder == "" {
+			continue
+		}
+
+		d, f := pc.options.decoders[t.Decoder]
+		if !f {
+			d, f = builtinDecoders[t.Decoder]
+		}
+		if !f {
+			return unknownDecoderError(t.Name, t.Decoder)
+		}
+
+		if pc.decoders == nil {
+			pc.decoders = make(map[int]Decoder)
+		}
+		pc.decoders[i] = d
+	}
+	return nil
+}
+
+// decode returns a copy of token having decoded value, or the token itself if its type has no decoder.
+func (pc *ParseContext) decode(tok *Token) (*Token, error) {
+	d := pc.decoders[tok.Type()]
+	if d == nil || tok.Value() != nil {
+		return tok, nil
+	}
+
+	v, e := d(tok)
+	if e != nil {
+		return nil, tokenValueError(tok, e)
+	}
+
+	return tok.WithValue(v), nil
+}
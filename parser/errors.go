@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/ava12/llx"
 	"github.com/ava12/llx/lexer"
 )
@@ -11,6 +15,16 @@ const (
 	UnexpectedEoiError = llx.SyntaxErrors + iota
 	// cannot match grammar rule for incoming token
 	UnexpectedTokenError
+	// paired delimiters are not balanced, see WithPairs
+	UnbalancedPairError
+	// ambiguous rules cannot be resolved, see WithAmbiguityError
+	AmbiguityError
+	// token text cannot be decoded, see WithDecoder
+	TokenValueError
+	// token is separated from the previous one while grammar requires them to be adjacent
+	NotAdjacentError
+	// input matched grammar error production, message is defined by grammar
+	ErrorProductionError
 )
 
 // Other error codes used by parser:
@@ -23,18 +37,120 @@ const (
 	UnknownTokenLiteralError
 	// node hook for unknown node
 	UnknownNodeError
+	// MapHook got repeated key
+	DuplicateKeyError
+	// node result has unexpected type
+	WrongResultTypeError
+	// root node has not exactly one child node while WithRootUnwrap option is used
+	RootUnwrapError
+	// number of nodes exceeds limit set with WithMaxNodes option
+	TreeTooLargeError
+	// token content cannot be split by ParseContext.Split
+	SplitTokenError
+	// total input length exceeds limit set with WithMaxInputBytes option
+	InputTooLargeError
+	// token type uses decoder that is not registered with WithDecoder
+	UnknownDecoderError
+	// lexer group passed to ParseContext.SetGroup or WithTokenProvider or used as Hooks.Groups key does not exist
+	UnknownGroupError
+	// group token hook for token type that belongs to another group
+	WrongGroupError
+	// callback is bound to action name that is not used in grammar, see WithAction
+	UnknownActionError
+	// parsing is stopped by an error that is not llx.Error (e.g. returned by hook) while errors are collected,
+	// see WithErrorCollection
+	StopError
 )
 
+// ErrorList contains syntax errors collected while parsing with WithErrorCollection option.
+type ErrorList []*llx.Error
+
+// Error returns messages of all contained errors, one per line.
+func (el ErrorList) Error() string {
+	msgs := make([]string, len(el))
+	for i, e := range el {
+		msgs[i] = e.Message
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Diagnostics returns diagnostics converted from contained errors, implements llx.DiagnosticSource.
+func (el ErrorList) Diagnostics() []llx.Diagnostic {
+	res := make([]llx.Diagnostic, len(el))
+	for i, e := range el {
+		res[i] = e.Diagnostic()
+	}
+	return res
+}
+
+// IsIncomplete returns true if parsing failed only because input ended prematurely
+// (i.e. e is UnexpectedEoiError or ErrorList containing only such errors), so an interactive shell may ask for
+// continuation lines and parse the extended input instead of reporting an error.
+func IsIncomplete(e error) bool {
+	var el ErrorList
+	if errors.As(e, &el) && len(el) > 0 {
+		for _, ee := range el {
+			if ee.Code != UnexpectedEoiError {
+				return false
+			}
+		}
+		return true
+	}
+
+	var ee *llx.Error
+	return errors.As(e, &ee) && ee.Code == UnexpectedEoiError
+}
+
 func unexpectedEofError(t *lexer.Token, expected string) *llx.Error {
 	return llx.FormatErrorPos(t, UnexpectedEoiError, "unexpected end of input, expecting %s", expected)
 }
 
-func unexpectedTokenError(t *lexer.Token, expected string) *llx.Error {
+func unclosedPairError(opener, t *lexer.Token, closer string) *llx.Error {
+	if t == nil {
+		return llx.FormatErrorPos(opener, UnbalancedPairError, "unclosed %q", opener.Text())
+	}
+	e := llx.FormatErrorPos(opener, UnbalancedPairError, "unclosed %q, got %q at line %d col %d", opener.Text(), t.Text(), t.Line(), t.Col())
+	e.Related = []llx.RelatedInfo{{Span: tokenSpan(t), Message: fmt.Sprintf("expecting %q here", closer)}}
+	return e
+}
+
+func unopenedPairError(t *lexer.Token) *llx.Error {
+	return llx.FormatErrorPos(t, UnbalancedPairError, "unexpected %q, no matching opening delimiter", t.Text())
+}
+
+func ambiguityError(t *lexer.Token, rules, lookahead int) *llx.Error {
+	return llx.FormatErrorPos(t, AmbiguityError, "cannot choose one of %d rules for %q token (%q) using %d lookahead tokens",
+		rules, t.TypeName(), t.Text(), lookahead)
+}
+
+func unexpectedTokenError(t *lexer.Token, expected string, suggestions []string) *llx.Error {
 	text := t.Text()
 	if len(text) > 10 {
 		text = text[:7] + "..."
 	}
-	return llx.FormatErrorPos(t, UnexpectedTokenError, "unexpected %q token (%q), expecting %s", t.TypeName(), text, expected)
+	msg := fmt.Sprintf("unexpected %q token (%q), expecting %s", t.TypeName(), text, expected)
+	if len(suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %q?", suggestions[0])
+	}
+	e := llx.FormatErrorPos(t, UnexpectedTokenError, "%s", msg)
+	if len(suggestions) > 0 {
+		e.Suggestions = suggestions
+		e.Fix = suggestions[0]
+	}
+	return e
+}
+
+func notAdjacentError(t, prev *lexer.Token) *llx.Error {
+	e := llx.FormatErrorPos(t, NotAdjacentError, "%q token must immediately follow %q token", t.Text(), prev.Text())
+	e.Related = []llx.RelatedInfo{{Span: tokenSpan(prev), Message: "preceding token"}}
+	return e
+}
+
+func errorProductionError(pos llx.SourcePos, message string) *llx.Error {
+	if pos == nil {
+		return llx.FormatError(ErrorProductionError, "%s", message)
+	}
+	return llx.FormatErrorPos(pos, ErrorProductionError, "%s", message)
 }
 
 func emitWrongTokenError(t *lexer.Token) *llx.Error {
@@ -52,3 +168,62 @@ func unknownTokenLiteralError(text string) *llx.Error {
 func unknownNodeError(name string) *llx.Error {
 	return llx.FormatError(UnknownNodeError, "unknown node key: %q", name)
 }
+
+func duplicateKeyError(t *lexer.Token, key string) *llx.Error {
+	if t == nil {
+		return llx.FormatError(DuplicateKeyError, "duplicate key %q", key)
+	}
+	return llx.FormatErrorPos(t, DuplicateKeyError, "duplicate key %q", key)
+}
+
+func wrongResultTypeError(t *lexer.Token, name string, got, expected any) *llx.Error {
+	msg := "%s result is %T, expecting %T"
+	if t == nil {
+		return llx.FormatError(WrongResultTypeError, msg, name, got, expected)
+	}
+	return llx.FormatErrorPos(t, WrongResultTypeError, msg, name, got, expected)
+}
+
+func rootUnwrapError(t *lexer.Token, name string, count int) *llx.Error {
+	return llx.FormatErrorPos(t, RootUnwrapError, "cannot unwrap root node %q: got %d child nodes, expecting 1", name, count)
+}
+
+func treeTooLargeError(t *lexer.Token, limit int) *llx.Error {
+	return llx.FormatErrorPos(t, TreeTooLargeError, "syntax tree is too large: more than %d nodes", limit)
+}
+
+func splitTokenError(t *lexer.Token, offset int) *llx.Error {
+	return llx.FormatErrorPos(t, SplitTokenError, "cannot split %q token %q at offset %d", t.TypeName(), t.Text(), offset)
+}
+
+func inputTooLargeError(t *lexer.Token, limit int64) *llx.Error {
+	return llx.FormatErrorPos(t, InputTooLargeError, "input is too large: more than %d bytes", limit)
+}
+
+func stopError(e error) *llx.Error {
+	return llx.FormatError(StopError, "%s", e.Error())
+}
+
+func tokenValueError(t *lexer.Token, e error) *llx.Error {
+	return llx.FormatErrorPos(t, TokenValueError, "cannot decode %q token %q: %s", t.TypeName(), t.Text(), e.Error())
+}
+
+func unknownDecoderError(typeName, decoder string) *llx.Error {
+	return llx.FormatError(UnknownDecoderError, "unknown decoder %q for %q token type", decoder, typeName)
+}
+
+func unknownGroupError(group int) *llx.Error {
+	return llx.FormatError(UnknownGroupError, "unknown lexer group %d", group)
+}
+
+func wrongGroupError(typeName string, group int) *llx.Error {
+	return llx.FormatError(WrongGroupError, "token type %q does not belong to lexer group %d", typeName, group)
+}
+
+func unknownActionError(name string) *llx.Error {
+	return llx.FormatError(UnknownActionError, "unknown action: %q", name)
+}
+
+func tokenSpan(t *lexer.Token) llx.Span {
+	return llx.Span{SourceName: t.SourceName(), Line: t.Line(), Col: t.Col(), EndLine: t.EndLine(), EndCol: t.EndCol()}
+}
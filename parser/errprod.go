@@ -0,0 +1,24 @@
+package parser
+
+import "github.com/ava12/llx"
+
+// reportErrorProduction reports the error if parser leaves error production state.
+// The error is reported at the token following error production or at the last consumed token
+// if there is no next token. If errors are collected (see WithErrorCollection), parsing continues.
+func (pc *ParseContext) reportErrorProduction(tok *Token) error {
+	message, has := pc.parser.errProds[pc.node.state]
+	if !has {
+		return nil
+	}
+
+	var pos llx.SourcePos
+	if tok != nil {
+		pos = tok
+	} else if pc.lastToken != nil {
+		pos = pc.lastToken
+	}
+	if pc.options.trace != nil {
+		pc.tracef("error production %q", message)
+	}
+	return pc.softError(errorProductionError(pos, message))
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/ava12/llx/source"
+)
+
+// EventKind is a kind of parsing event sent by Parser.Events.
+type EventKind int
+
+const (
+	// EnterNodeEvent: a node is pushed on stack, Event.Node contains node name and Event.Token contains its first token.
+	EnterNodeEvent EventKind = iota + 1
+	// TokenEvent: a token is added to the current node, Event.Token contains the token.
+	TokenEvent
+	// LeaveNodeEvent: a node is dropped from stack, Event.Node contains node name.
+	LeaveNodeEvent
+	// ErrorEvent: parsing failed, Event.Error contains the error. Always the last event.
+	ErrorEvent
+)
+
+// Event describes a single parsing step, see Parser.Events.
+type Event struct {
+	Kind  EventKind
+	Node  string
+	Token *Token
+	Error error
+}
+
+// Events parses source queue in a separate goroutine and returns a channel of parsing events in source order,
+// so huge inputs can be processed without building a syntax tree. Tokens not added to the tree
+// (aside tokens, end-of-file tokens) are not reported, middlewares may be used to observe them.
+// The channel is closed when parsing is done. The caller must either read all events or cancel the context,
+// otherwise the goroutine is never released.
+// The context is used as if passed with WithContext option.
+func (p *Parser) Events(ctx context.Context, q *source.Queue, opts ...ParseOption) <-chan Event {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ch := make(chan Event)
+	send := func(ev Event) error {
+		select {
+		case ch <- ev:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	hs := &Hooks{Nodes: NodeHooks{AnyNode: func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		return &eventHookInstance{node, send}, send(Event{Kind: EnterNodeEvent, Node: node, Token: token})
+	}}}
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx))
+
+	go func() {
+		defer close(ch)
+		_, e := p.Parse(q, hs, opts...)
+		if e != nil && ctx.Err() == nil {
+			send(Event{Kind: ErrorEvent, Error: e})
+		}
+	}()
+	return ch
+}
+
+type eventHookInstance struct {
+	node string
+	send func(Event) error
+}
+
+func (ehi *eventHookInstance) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (ehi *eventHookInstance) HandleNode(node string, result any) error {
+	return nil
+}
+
+func (ehi *eventHookInstance) HandleToken(token *Token) error {
+	return ehi.send(Event{Kind: TokenEvent, Token: token})
+}
+
+func (ehi *eventHookInstance) EndNode() (result any, e error) {
+	return nil, ehi.send(Event{Kind: LeaveNodeEvent, Node: ehi.node})
+}
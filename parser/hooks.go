@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// DuplicateKeyPolicy defines how MapHook handles repeated keys.
+type DuplicateKeyPolicy int
+
+const (
+	KeepLastKey       DuplicateKeyPolicy = iota // later value replaces the earlier one
+	KeepFirstKey                                // later value is ignored
+	DuplicateKeyFails                           // repeated key causes DuplicateKeyError
+)
+
+type mapHookInstance[V any] struct {
+	keyNode, valueNode string
+	keyFn              func(any) string
+	policy             DuplicateKeyPolicy
+	items              map[string]V
+	key                string
+	lastToken          *Token
+}
+
+// MapHook creates node hook that collects results of child elements into a map[string]V.
+// keyNode and valueNode are either node names or token type names of child elements,
+// for token elements the tokens themselves are used as results.
+// Each value is stored using the closest preceding key (or empty string if there is none),
+// keyNode and valueNode may be the same, in this case the key is extracted from the value.
+// keyFn converts key element result to string, if nil then token text is used for tokens,
+// strings are used as is, and other values are formatted with fmt.Sprint.
+// Value element result must be either nil or of type V, otherwise WrongResultTypeError is returned.
+// The map is the result of hooked node.
+func MapHook[V any](keyNode, valueNode string, keyFn func(any) string, policy DuplicateKeyPolicy) NodeHook {
+	if keyFn == nil {
+		keyFn = defaultKey
+	}
+	return func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		return &mapHookInstance[V]{
+			keyNode:   keyNode,
+			valueNode: valueNode,
+			keyFn:     keyFn,
+			policy:    policy,
+			items:     make(map[string]V),
+			lastToken: token,
+		}, nil
+	}
+}
+
+func defaultKey(x any) string {
+	switch v := x.(type) {
+	case *Token:
+		return v.Text()
+	case string:
+		return v
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func (mhi *mapHookInstance[V]) NewNode(node string, token *Token) error {
+	mhi.lastToken = token
+	return nil
+}
+
+func (mhi *mapHookInstance[V]) HandleNode(node string, result any) error {
+	return mhi.handleItem(node, result, mhi.lastToken)
+}
+
+func (mhi *mapHookInstance[V]) HandleToken(token *Token) error {
+	return mhi.handleItem(token.TypeName(), token, token)
+}
+
+func (mhi *mapHookInstance[V]) EndNode() (result any, e error) {
+	return mhi.items, nil
+}
+
+func (mhi *mapHookInstance[V]) handleItem(name string, result any, token *Token) error {
+	if name == mhi.keyNode {
+		mhi.key = mhi.keyFn(result)
+	}
+	if name != mhi.valueNode {
+		return nil
+	}
+
+	value, valid := result.(V)
+	if !valid && result != nil {
+		return wrongResultTypeError(token, name, result, value)
+	}
+
+	_, has := mhi.items[mhi.key]
+	if has {
+		switch mhi.policy {
+		case KeepFirstKey:
+			return nil
+		case DuplicateKeyFails:
+			return duplicateKeyError(token, mhi.key)
+		}
+	}
+
+	mhi.items[mhi.key] = value
+	return nil
+}
+
+type rootUnwrapInstance struct {
+	hook   NodeHookInstance
+	node   string
+	token  *Token
+	count  int
+	result any
+}
+
+func (rui *rootUnwrapInstance) NewNode(node string, token *Token) error {
+	return rui.hook.NewNode(node, token)
+}
+
+func (rui *rootUnwrapInstance) HandleNode(node string, result any) error {
+	rui.count++
+	rui.result = result
+	return rui.hook.HandleNode(node, result)
+}
+
+func (rui *rootUnwrapInstance) HandleToken(token *Token) error {
+	return rui.hook.HandleToken(token)
+}
+
+func (rui *rootUnwrapInstance) HandleLabel(label string) error {
+	return handleLabel(rui.hook, label)
+}
+
+func (rui *rootUnwrapInstance) EndNode() (result any, e error) {
+	_, e = rui.hook.EndNode()
+	if e == nil && rui.count != 1 {
+		e = rootUnwrapError(rui.token, rui.node, rui.count)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	return rui.result, nil
+}
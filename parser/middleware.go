@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"github.com/ava12/llx/lexer"
+)
+
+// Middleware observes or alters parsing process independently of grammar and hooks,
+// e.g. for profiling, logging, or token rewriting. Both fields are optional.
+// Several middlewares are chained, the first one is the outermost.
+type Middleware struct {
+	// Token is called instead of the token hook for every token fetched from lexer (including aside tokens
+	// and end-of-file tokens). next performs the remaining processing (the next middleware, the matching
+	// token hook, or default action) and returns its emit flag. To replace a token, emit a new one
+	// with pc.EmitToken and return false. Emitted tokens bypass middlewares as they bypass hooks.
+	Token func(token *Token, pc *ParseContext, next TokenHook) (emit bool, e error)
+
+	// Node is called when a node is pushed on stack instead of the node hook. next returns the hook instance
+	// of the next middleware or the matching node hook (an instance doing nothing if there is no such hook).
+	// The returned instance may wrap the one returned by next to track node lifecycle.
+	Node func(node string, token *Token, pc *ParseContext, next NodeHook) (NodeHookInstance, error)
+}
+
+// WithMiddleware adds middlewares to parsing process, middlewares added by parser options are the outermost ones.
+func WithMiddleware(ms ...Middleware) ParseOption {
+	return func(po *parseOptions) {
+		po.middleware = append(po.middleware, ms...)
+	}
+}
+
+// defaultTokenHook performs default action for tokens without hooks: aside tokens and end-of-file tokens are dropped.
+func defaultTokenHook(token *Token, pc *ParseContext) (emit bool, e error) {
+	return !pc.isAsideToken(token) && token.Type() != lexer.EofTokenType, nil
+}
+
+// defaultNodeHook returns an instance doing nothing.
+func defaultNodeHook(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+	return &defaultHookInstance{}, nil
+}
+
+func (pc *ParseContext) wrapTokenHook(h TokenHook) TokenHook {
+	if h == nil {
+		h = defaultTokenHook
+	}
+
+	ms := pc.options.middleware
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i].Token == nil {
+			continue
+		}
+
+		wrap, next := ms[i].Token, h
+		h = func(token *Token, pc *ParseContext) (bool, error) {
+			return wrap(token, pc, next)
+		}
+	}
+	return h
+}
+
+func (pc *ParseContext) wrapNodeHook(h NodeHook) NodeHook {
+	if h == nil {
+		h = defaultNodeHook
+	}
+
+	ms := pc.options.middleware
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i].Node == nil {
+			continue
+		}
+
+		wrap, next := ms[i].Node, h
+		h = func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+			return wrap(node, token, pc, next)
+		}
+	}
+	return h
+}
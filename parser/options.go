@@ -0,0 +1,271 @@
+package parser
+
+import (
+	"context"
+	"io"
+
+	"github.com/ava12/llx/grammar"
+)
+
+// ParseOption tunes parser or parsing process.
+// Options passed to New (or FromString, FromSource) are used by every parsing process started by that parser,
+// options passed to Parse (or ParseString) affect only that process and are applied after parser options.
+type ParseOption func(po *parseOptions)
+
+type parseOptions struct {
+	resolveTrace   func(tokens []*Token, survivors []grammar.Rule)
+	reserveTokens  int
+	rootUnwrap     bool
+	ctx            context.Context
+	maxNodes       int
+	recoveryNode   string
+	maxInput       int64
+	progress       func(consumed, total int)
+	startNode      string
+	collectErrors  bool
+	errorLimit     int
+	syncTokens     []string
+	comments       []string
+	openers        []string
+	closers        []string
+	autoClose      bool
+	reuse          bool
+	middleware     []Middleware
+	trace          io.Writer
+	completion     bool
+	maxLookahead   int
+	ambiguityError bool
+	preferFirst    bool
+	decoders       map[string]Decoder
+	scopeNodes     []string
+	workers        int
+	dfaLexer       bool
+	tokenArena     bool
+	arenaChunk     int
+	actions        map[string]Action
+	inline         bool
+	inlineNodes    []string
+	skipPunct      bool
+	punctuation    []string
+	passThrough    bool
+	providers      map[int]TokenProvider
+	finalNewline   bool
+}
+
+func applyOptions(po *parseOptions, opts []ParseOption) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(po)
+		}
+	}
+}
+
+// WithResolveTrace sets a function called each time parser resolves ambiguity,
+// i.e. when more than one grammar rule matches current token.
+// The function receives lookahead tokens fetched while resolving (starting with current token)
+// and initial rules of branches that were still alive at the decision point, the first rule is the chosen one.
+// Intended for debugging grammars.
+func WithResolveTrace(f func(tokens []*Token, survivors []grammar.Rule)) ParseOption {
+	return func(po *parseOptions) {
+		po.resolveTrace = f
+	}
+}
+
+// WithMaxLookahead limits the number of tokens following the current one that parser fetches
+// to resolve ambiguity (i.e. when more than one grammar rule matches current token), 0 means no limit.
+// When the limit is reached, parser chooses the first rule of still alive branches (in grammar order)
+// or reports AmbiguityError if WithAmbiguityError option is set.
+func WithMaxLookahead(n int) ParseOption {
+	return func(po *parseOptions) {
+		po.maxLookahead = n
+	}
+}
+
+// WithAmbiguityError makes parser report AmbiguityError if it cannot choose a single rule
+// among ambiguous ones, e.g. because of lookahead limit set by WithMaxLookahead.
+func WithAmbiguityError() ParseOption {
+	return func(po *parseOptions) {
+		po.ambiguityError = true
+	}
+}
+
+// WithPreferFirstRule makes parser always choose the first of ambiguous rules (in grammar order)
+// without looking ahead, like a PEG parser choosing the first matching alternative.
+func WithPreferFirstRule() ParseOption {
+	return func(po *parseOptions) {
+		po.preferFirst = true
+	}
+}
+
+// WithReserveCapacity hints expected number of tokens, so that token queue is preallocated
+// and does not grow (and shrink) while parsing. Non-positive number means no preallocation.
+// Useful when parsing large documents of predictable size.
+func WithReserveCapacity(tokens int) ParseOption {
+	return func(po *parseOptions) {
+		po.reserveTokens = tokens
+	}
+}
+
+// WithReuse makes parser keep parse contexts (hook tables and token queues) in a pool after parsing is done
+// and reuse them for subsequent parsing processes, reducing allocations when parsing a lot of small inputs,
+// e.g. in high-throughput services. Pool is safe for concurrent use.
+// Hooks must not keep ParseContext after parsing is done when this option is used.
+func WithReuse() ParseOption {
+	return func(po *parseOptions) {
+		po.reuse = true
+	}
+}
+
+// WithRootUnwrap makes parser return the result of the only child node of the root node
+// instead of the root node result, so that wrapper root nodes need no forwarding hooks.
+// Root node hook (if any) is still called, but its result is discarded.
+// The root node must contain exactly one child node (any number of tokens is allowed),
+// otherwise RootUnwrapError is returned.
+func WithRootUnwrap() ParseOption {
+	return func(po *parseOptions) {
+		po.rootUnwrap = true
+	}
+}
+
+// WithContext makes parser check given context before fetching each token from lexer
+// (including aside tokens and tokens skipped by hooks), parsing stops with ctx.Err() as soon as the context is done.
+// Lexers also check the context while scanning long lexemes (see lexer.Lexer.WithContext),
+// so a single huge token does not delay cancellation;
+// custom token providers are not affected.
+// Use context.WithTimeout or context.WithDeadline to bound parsing time.
+func WithContext(ctx context.Context) ParseOption {
+	return func(po *parseOptions) {
+		po.ctx = ctx
+	}
+}
+
+// WithMaxNodes limits total number of syntax tree nodes (including the root one) created while parsing.
+// Parsing stops with TreeTooLargeError as soon as the limit is exceeded, regardless of tree shape.
+// Non-positive number means no limit.
+func WithMaxNodes(n int) ParseOption {
+	return func(po *parseOptions) {
+		po.maxNodes = n
+	}
+}
+
+// WithMaxInputBytes limits total length of token content fetched by lexer while parsing,
+// counted across all sources (including sources added to the queue by hooks) and all tokens (including aside ones).
+// Parsing stops with InputTooLargeError as soon as the limit is exceeded.
+// Tokens emitted by hooks are not counted. Non-positive number means no limit.
+func WithMaxInputBytes(n int64) ParseOption {
+	return func(po *parseOptions) {
+		po.maxInput = n
+	}
+}
+
+// WithErrorRecovery makes parser recover from syntax errors instead of aborting.
+// When incoming token matches no grammar rule, parser pushes a node with given name (it must be defined in grammar)
+// as a child of current node and feeds it with the unexpected token and all following tokens until it gets a token
+// that is expected by current node or one of its ancestors. Then the error node and all nodes nested in the node
+// expecting the token are finalized, and parsing continues. Node hooks receive the error node as usual,
+// so e.g. syntax tree contains error subtrees holding skipped tokens.
+// Unexpected end of file or end of input still causes syntax error.
+// Empty name disables recovery.
+func WithErrorRecovery(nodeName string) ParseOption {
+	return func(po *parseOptions) {
+		po.recoveryNode = nodeName
+	}
+}
+
+// WithErrorCollection makes parser report syntax errors fixed by error recovery (see WithErrorRecovery).
+// If there were such errors Parse returns the result along with ErrorList containing them in order of appearance.
+// Parsing stops with ErrorList as soon as the number of errors reaches the limit (non-positive limit means no limit).
+// If parsing stops due to unrecoverable error (including lexical errors, hook errors, and context errors),
+// the error is appended to the list, an error that is not llx.Error is converted to StopError having the same message.
+// Errors that need no recovery (broken token adjacency and matched error productions, see langdef)
+// are collected regardless of WithErrorRecovery, other syntax errors are collected only if error recovery is enabled.
+func WithErrorCollection(limit int) ParseOption {
+	return func(po *parseOptions) {
+		po.collectErrors = true
+		po.errorLimit = limit
+	}
+}
+
+// WithSyncTokens sets synchronization tokens for error recovery (see WithErrorRecovery).
+// Each key is either a token type name prefixed with $ or a literal text.
+// If sync tokens are set, error node captures tokens at least up to (and including) the first sync token,
+// and only after that it checks whether current or some ancestor node expects the next token.
+// E.g. ";" and "}" make a reasonable set for C-like languages. No keys means no synchronization tokens.
+func WithSyncTokens(keys ...string) ParseOption {
+	return func(po *parseOptions) {
+		po.syncTokens = keys
+	}
+}
+
+// WithProgress sets a function reporting parsing progress, intended for progress indicators.
+// The function is called after every 256 tokens fetched by lexer and once after parsing is finished.
+// It receives the number of processed bytes and the total length of sources, both counting all sources
+// ever added to the queue (so total may grow if hooks add sources).
+func WithProgress(f func(consumed, total int)) ParseOption {
+	return func(po *parseOptions) {
+		po.progress = f
+	}
+}
+
+// WithStartNode makes parser parse given node instead of the root one, e.g. to re-parse a part of edited source.
+// Parsing stops as soon as the node is finished, the rest of input is left in the queue.
+// Empty name means the root node.
+func WithStartNode(nodeName string) ParseOption {
+	return func(po *parseOptions) {
+		po.startNode = nodeName
+	}
+}
+
+// WithComments makes parser collect aside tokens of given types as comments,
+// node hooks may retrieve them using ParseContext.LeadingComments and ParseContext.TrailingComments.
+// No type names means no comments are collected.
+func WithComments(typeNames ...string) ParseOption {
+	return func(po *parseOptions) {
+		po.comments = typeNames
+	}
+}
+
+// WithPairs makes parser check that paired delimiters (literals, e.g. parentheses and brackets) are balanced
+// regardless of grammar rules, so that unmatched opening delimiter is reported at its own position instead of
+// some unexpected token far below. open[i] is paired with close[i], extra elements of the longer slice are ignored.
+// Unbalanced delimiters cause UnbalancedPairError. If autoClose is set, missing closing delimiters
+// are emitted at the end of each source instead (as tokens of the same type as corresponding opening delimiters),
+// which is useful for error-tolerant parsing of incomplete input.
+func WithPairs(open, close []string, autoClose bool) ParseOption {
+	return func(po *parseOptions) {
+		po.openers = open
+		po.closers = close
+		po.autoClose = autoClose
+	}
+}
+
+// WithDFALexer makes New build lexers using deterministic finite automata compiled from token regexps
+// instead of a single alternation regexp per token group. Lexers produce the same tokens,
+// but automata are usually several times faster on large sources. Has no effect on Parse options.
+func WithDFALexer() ParseOption {
+	return func(po *parseOptions) {
+		po.dfaLexer = true
+	}
+}
+
+// WithTokenArena makes parser take tokens fetched from source from an arena allocating chunkSize tokens at once
+// (lexer.DefaultArenaChunk if chunkSize <= 0) instead of allocating each token separately.
+// This reduces GC load when parsing large sources. Tokens stay valid as long as they are referenced,
+// but each referenced token keeps its whole chunk in memory. A parse context reused with WithReuse option
+// keeps its arena, so subsequent parsing processes continue to fill the same chunk.
+func WithTokenArena(chunkSize int) ParseOption {
+	return func(po *parseOptions) {
+		po.tokenArena = true
+		po.arenaChunk = chunkSize
+	}
+}
+
+// WithFinalNewline makes parser read every non-empty source as if it ended with "\n",
+// so grammars requiring every line to be terminated accept sources lacking final newline.
+// Parser reads the queue passed to Parse through Queue.WithFinalNewline view, affecting sources added by hooks
+// as well; neither the queue nor its sources are modified.
+func WithFinalNewline() ParseOption {
+	return func(po *parseOptions) {
+		po.finalNewline = true
+	}
+}
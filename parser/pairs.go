@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/lexer"
+)
+
+func (pc *ParseContext) initPairs() error {
+	open, close := pc.options.openers, pc.options.closers
+	n := len(open)
+	if len(close) < n {
+		n = len(close)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	pc.pairs = make(map[string]string)
+	pc.closers = make(map[string]bool)
+	for i := 0; i < n; i++ {
+		for _, lit := range []string{open[i], close[i]} {
+			if _, f := pc.parser.literals.Lookup([]byte(lit)); !f {
+				return unknownTokenLiteralError(lit)
+			}
+		}
+
+		pc.pairs[open[i]] = close[i]
+		pc.closers[close[i]] = true
+	}
+	return nil
+}
+
+// checkPairs tracks nesting of paired delimiters for incoming token.
+func (pc *ParseContext) checkPairs(tok *Token) error {
+	tt := tok.Type()
+	if tt == lexer.EofTokenType {
+		return pc.closePairs()
+	}
+	if tt < 0 || pc.parser.grammar.Tokens[tt].Flags&grammar.NoLiteralsToken != 0 {
+		return nil
+	}
+
+	text := tok.Text()
+	if _, isOpener := pc.pairs[text]; isOpener {
+		pc.openPairs = append(pc.openPairs, tok)
+		return nil
+	}
+	if !pc.closers[text] {
+		return nil
+	}
+
+	last := len(pc.openPairs) - 1
+	if last < 0 {
+		return unopenedPairError(tok)
+	}
+
+	opener := pc.openPairs[last]
+	if pc.pairs[opener.Text()] != text {
+		return unclosedPairError(opener, tok, pc.pairs[opener.Text()])
+	}
+
+	pc.openPairs = pc.openPairs[:last]
+	return nil
+}
+
+// closePairs either emits missing closing delimiters or reports the innermost unclosed one.
+func (pc *ParseContext) closePairs() error {
+	if len(pc.openPairs) == 0 {
+		return nil
+	}
+
+	if !pc.options.autoClose {
+		return unclosedPairError(pc.openPairs[len(pc.openPairs)-1], nil, "")
+	}
+
+	for i := len(pc.openPairs) - 1; i >= 0; i-- {
+		opener := pc.openPairs[i]
+		closer := pc.pairs[opener.Text()]
+		pc.tokens.Append(lexer.NewToken(opener.Type(), opener.TypeName(), []byte(closer), pc.currentPos()))
+	}
+	pc.openPairs = nil
+	return nil
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/ava12/llx/source"
+)
+
+// SourceResult contains the result of parsing a single source with ParseAll.
+type SourceResult struct {
+	// Source is the parsed source.
+	Source *source.Source
+
+	// Result is the result returned by Parse.
+	Result any
+
+	// Error is the error returned by Parse, ctx.Err() if parsing was not started because the context was done.
+	Error error
+}
+
+// WithWorkers limits the number of sources parsed concurrently by ParseAll, 0 means runtime.GOMAXPROCS(0).
+// Ignored by other methods.
+func WithWorkers(n int) ParseOption {
+	return func(po *parseOptions) {
+		po.workers = n
+	}
+}
+
+// ParseAll parses independent sources concurrently, each source is parsed as if it was the only source in a queue.
+// Returns results in the same order as sources. Parsing of one source does not stop on errors in other sources.
+// Hooks are shared by all parsing processes, so hook functions must be safe for concurrent use
+// (hook instances are created per node, so they need not be).
+// The context is used as if passed with WithContext option.
+func (p *Parser) ParseAll(ctx context.Context, sources []*source.Source, hs *Hooks, opts ...ParseOption) []SourceResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var po parseOptions
+	applyOptions(&po, p.options)
+	applyOptions(&po, opts)
+	workers := po.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx))
+	res := make([]SourceResult, len(sources))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := &res[i]
+				r.Source = sources[i]
+				if r.Error = ctx.Err(); r.Error == nil {
+					r.Result, r.Error = p.Parse(source.NewQueue().Append(sources[i]), hs, opts...)
+				}
+			}
+		}()
+	}
+
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return res
+}
@@ -3,13 +3,16 @@ package parser
 
 import (
 	"bytes"
-	"github.com/ava12/llx/internal/bmap"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/ava12/llx"
 	"github.com/ava12/llx/grammar"
 	"github.com/ava12/llx/internal/queue"
+	"github.com/ava12/llx/langdef"
 	"github.com/ava12/llx/lexer"
 	"github.com/ava12/llx/source"
 )
@@ -22,6 +25,12 @@ type Token = lexer.Token
 // false means that it must be skipped.
 type TokenHook = func(token *Token, pc *ParseContext) (emit bool, e error)
 
+// TokenPredicate allows to reject a token depending on runtime data (e.g. a name is valid only if it is declared).
+// Returning false means that the token does not match grammar rules expecting its token type,
+// so parser tries alternative rules (ones expecting matching literal or any token) or reports syntax error.
+// Predicates are also called while resolving ambiguities, so they must not have side effects.
+type TokenPredicate = func(token *Token, pc *ParseContext) bool
+
 // NodeHookInstance receives notifications for node being processed by parser.
 type NodeHookInstance interface {
 	// NewNode is called before a child node is pushed on stack.
@@ -66,6 +75,24 @@ func (dhi *defaultHookInstance) EndNode() (result any, e error) {
 	return dhi.result, nil
 }
 
+func (dhi *defaultHookInstance) PendingResult() any {
+	return dhi.result
+}
+
+// PendingResultHolder may be implemented by NodeHookInstance to expose the result it would return
+// if the node was finalized now, see ParseContext.ParentResult.
+type PendingResultHolder interface {
+	PendingResult() any
+}
+
+// LabelHandler may be implemented by NodeHookInstance to receive labels of child elements
+// (e.g. "typ" in "var-def = typ:type, name:$name;"). HandleLabel is called right before HandleNode or HandleToken
+// for each child matched by a labeled element of node definition. Labels of inline nodes and skipped punctuation
+// are dropped, a pass-through replacement gets the label of the node it replaces.
+type LabelHandler interface {
+	HandleLabel(label string) error
+}
+
 // Special token type names used by token hooks.
 const (
 	AnyToken = ""                 // any token type
@@ -79,6 +106,8 @@ const AnyNode = ""
 const anyOffset = -1
 
 type TokenHooks map[string]TokenHook
+type GroupTokenHooks map[int]TokenHooks
+type TokenPredicates map[string]TokenPredicate
 type NodeHooks map[string]NodeHook
 
 // Hooks contains all token and node hooks used in parsing process.
@@ -95,20 +124,43 @@ type Hooks struct {
 	// Nodes contains hooks for nodes. Key is either node name or AnyNode constant.
 	// AnyNode hook is a fallback.
 	Nodes NodeHooks
+
+	// TokenAccept contains predicates consulted when matching tokens against grammar rules.
+	// Key is either token type name or AnyToken constant. AnyToken predicate is a fallback.
+	TokenAccept TokenPredicates
+
+	// Groups contains hooks for tokens fetched by lexer of specific group (0 is the default group).
+	// Inner key is either token type name (the type must belong to the group) or AnyToken constant.
+	// Group hook for token type precedes Tokens hook for the same type,
+	// group AnyToken hook precedes AnyToken hook in Tokens.
+	Groups GroupTokenHooks
 }
 
 // Parser holds prepared data for some grammar.
 // Parser is immutable and reusable.
 type Parser struct {
-	grammar  *grammar.Grammar
-	names    map[string]int
-	literals *bmap.BMap[int]
-	lexers   []*lexer.Lexer
+	grammar    *grammar.Grammar
+	names      map[string]int
+	literals   *lexer.Keywords
+	caseless   *lexer.Keywords
+	lexers     []TokenProvider
+	options    []ParseOption
+	contexts   *sync.Pool
+	labels     map[ruleLabelKey]string
+	adjacent   map[int]bool
+	errProds   map[int]string
+	nodeGroups map[int]int
+}
+
+type ruleLabelKey struct {
+	state int
+	rule  grammar.Rule
 }
 
 // New constructs new parser for specific grammar.
 // Grammar must not be changed after this function is called.
-func New(g *grammar.Grammar) (*Parser, error) {
+// Options are used by every parsing process started by this parser.
+func New(g *grammar.Grammar, opts ...ParseOption) (*Parser, error) {
 	maxGroup := 0
 	literalsCnt := 0
 	for _, t := range g.Tokens {
@@ -119,14 +171,25 @@ func New(g *grammar.Grammar) (*Parser, error) {
 		}
 	}
 
-	type lexerRec struct {
-		patterns []string
-		types    []lexer.TokenType
+	var po parseOptions
+	applyOptions(&po, opts)
+	e := checkActions(g, po.actions)
+	if e != nil {
+		return nil, e
 	}
+
 	lrs := make([]lexerRec, maxGroup+1)
 
 	names := make(map[string]int)
-	literals := bmap.New[int](literalsCnt)
+	literals := make([]string, 0, literalsCnt)
+	literalIndexes := make([]int, 0, literalsCnt)
+	hasCaseless := false
+	for _, s := range g.States {
+		if s.Caseless {
+			hasCaseless = true
+			break
+		}
+	}
 
 	names[tokenKey(AnyToken)] = grammar.AnyToken
 	names[nodeKey(AnyNode)] = -1
@@ -135,7 +198,8 @@ func New(g *grammar.Grammar) (*Parser, error) {
 
 	for i, t := range g.Tokens {
 		if (t.Flags & grammar.LiteralToken) != 0 {
-			literals.Set([]byte(t.Name), i)
+			literals = append(literals, t.Name)
+			literalIndexes = append(literalIndexes, i)
 		} else if (t.Flags & grammar.ErrorToken) == 0 {
 			names[tokenKey(t.Name)] = i
 		}
@@ -146,24 +210,197 @@ func New(g *grammar.Grammar) (*Parser, error) {
 		lr := &lrs[t.Group]
 		pattern := "(" + t.Re + ")"
 		lr.types = append(lr.types, lexer.TokenType{i, t.Name})
+		lr.preamblePatterns = append(lr.preamblePatterns, pattern)
+		lr.preambleRes = append(lr.preambleRes, t.Re)
+		re := t.Re
+		if t.Flags&grammar.PreambleToken != 0 {
+			lr.hasPreamble = true
+			pattern = neverMatchingPattern
+			re = neverMatchingPattern
+		}
 		lr.patterns = append(lr.patterns, pattern)
+		lr.res = append(lr.res, re)
+	}
+
+	for group := range po.providers {
+		if group < 0 || group >= len(lrs) {
+			return nil, unknownGroupError(group)
+		}
+	}
+
+	for _, nt := range g.NestedTokens {
+		lr := &lrs[g.Tokens[nt.Token].Group]
+		lr.nested = append(lr.nested, nt)
 	}
 
-	ls := make([]*lexer.Lexer, len(lrs))
+	ls := make([]TokenProvider, len(lrs))
 	for i := range ls {
-		re, e := regexp.Compile("^(?s:" + strings.Join(lrs[i].patterns, "|") + ")")
+		if p, has := po.providers[i]; has {
+			ls[i] = p
+			continue
+		}
+
+		l, e := newLexer(&lrs[i], po.dfaLexer)
 		if e != nil {
 			return nil, e
 		}
 
-		ls[i] = lexer.New(re, lrs[i].types)
+		for _, nt := range lrs[i].nested {
+			l = l.WithNested(nt.Token, nt.Open, nt.Close)
+		}
+		ls[i] = l.WithGroup(i)
 	}
 
 	for i, nt := range g.Nodes {
 		names[nodeKey(nt.Name)] = i
 	}
 
-	return &Parser{g, names, literals, ls}, nil
+	var caseless *lexer.Keywords
+	if hasCaseless {
+		folded := make([]string, len(literals))
+		for i, l := range literals {
+			folded[i] = string(bytes.ToUpper([]byte(l)))
+		}
+		caseless = lexer.NewKeywords(folded, literalIndexes)
+	}
+
+	var labels map[ruleLabelKey]string
+	if len(g.Labels) > 0 {
+		labels = make(map[ruleLabelKey]string, len(g.Labels))
+		li := 0
+		for si, s := range g.States {
+			for ri := s.LowRule; ri < s.HighRule; ri++ {
+				li = addRuleLabel(labels, g, si, ri, li)
+			}
+			for _, mr := range g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+				for ri := mr.LowRule; ri < mr.HighRule; ri++ {
+					li = addRuleLabel(labels, g, si, ri, li)
+				}
+			}
+		}
+	}
+
+	var adjacent map[int]bool
+	if len(g.Adjacent) > 0 {
+		adjacent = make(map[int]bool, len(g.Adjacent))
+		for _, si := range g.Adjacent {
+			adjacent[si] = true
+		}
+	}
+
+	var errProds map[int]string
+	if len(g.ErrorProductions) > 0 {
+		errProds = make(map[int]string, len(g.ErrorProductions))
+		for _, ep := range g.ErrorProductions {
+			errProds[ep.State] = ep.Message
+		}
+	}
+
+	var nodeGroups map[int]int
+	if len(g.NodeGroups) > 0 {
+		nodeGroups = make(map[int]int, len(g.NodeGroups))
+		for _, ng := range g.NodeGroups {
+			if ng.Group < 0 || ng.Group >= len(ls) {
+				return nil, unknownGroupError(ng.Group)
+			}
+
+			nodeGroups[ng.Node] = ng.Group
+		}
+	}
+
+	return &Parser{g, names, lexer.NewKeywords(literals, literalIndexes), caseless, ls, opts, &sync.Pool{}, labels, adjacent,
+		errProds, nodeGroups}, nil
+}
+
+// lexerRec collects token definitions of a group.
+type lexerRec struct {
+	patterns         []string
+	preamblePatterns []string
+	res              []string
+	preambleRes      []string
+	types            []lexer.TokenType
+	hasPreamble      bool
+	nested           []grammar.NestedToken
+}
+
+func newLexer(lr *lexerRec, dfa bool) (*lexer.Lexer, error) {
+	if dfa {
+		l, e := lexer.NewDFA(lr.res, lr.types)
+		if e == nil && lr.hasPreamble {
+			l, e = l.WithPreambleDFA(lr.preambleRes)
+		}
+		return l, e
+	}
+
+	re, e := regexp.Compile("^(?s:" + strings.Join(lr.patterns, "|") + ")")
+	if e != nil {
+		return nil, e
+	}
+
+	l := lexer.New(re, lr.types)
+	if lr.hasPreamble {
+		re, e = regexp.Compile("^(?s:" + strings.Join(lr.preamblePatterns, "|") + ")")
+		if e != nil {
+			return nil, e
+		}
+
+		l = l.WithPreamble(re)
+	}
+	return l, nil
+}
+
+// FromString parses grammar description and constructs new parser for resulting grammar.
+// Returns nil and llx.Error if grammar description is incorrect.
+func FromString(name, grammarText string, opts ...ParseOption) (*Parser, error) {
+	return FromSource(source.New(name, []byte(grammarText)), opts...)
+}
+
+// FromSource is same as FromString, except it takes grammar description from given source.
+func FromSource(s *source.Source, opts ...ParseOption) (*Parser, error) {
+	g, e := langdef.Parse(s)
+	if e != nil {
+		return nil, e
+	}
+
+	return New(g, opts...)
+}
+
+// FirstTokens returns names of token types (prefixed with $) and literals that may start given node.
+// Returns nil if there is no such node or if grammar contains no information about first tokens.
+func (p *Parser) FirstTokens(nodeName string) []string {
+	i, f := p.names[nodeKey(nodeName)]
+	if !f || i < 0 {
+		return nil
+	}
+
+	var res []string
+	for j, t := range p.grammar.Tokens {
+		if !p.grammar.Nodes[i].FirstTokens.Contains(j) {
+			continue
+		}
+
+		if t.Flags&grammar.LiteralToken != 0 {
+			res = append(res, t.Name)
+		} else {
+			res = append(res, "$"+t.Name)
+		}
+	}
+	return res
+}
+
+// ValidateTokenTypes checks that all given token type names (without $ prefix) are defined in grammar
+// and can be emitted by hooks, i.e. are not special, literal, or error token types.
+// Intended to be called right after New by code that emits tokens (e.g. !extern ones) with configurable types,
+// so that misconfiguration is detected before parsing starts rather than by EmitToken.
+// Returns UnknownTokenTypeError for the first unsuitable name.
+func (p *Parser) ValidateTokenTypes(names ...string) error {
+	for _, name := range names {
+		i, f := p.names[tokenKey(name)]
+		if !f || i < 0 {
+			return unknownTokenTypeError(name)
+		}
+	}
+	return nil
 }
 
 func tokenKey(name string) string {
@@ -176,23 +413,26 @@ func nodeKey(text string) string {
 
 // Parse launches new parsing process with new ParseContext.
 // result is the value returned by root node hook or nil if no node hooks used.
-func (p *Parser) Parse(q *source.Queue, hs *Hooks) (result any, e error) {
+// Options are applied after the ones passed to New.
+func (p *Parser) Parse(q *source.Queue, hs *Hooks, opts ...ParseOption) (result any, e error) {
 	if hs == nil {
 		hs = &Hooks{}
 	}
-	pc, e := newParseContext(p, q, hs)
-	if e != nil {
-		return nil, e
+	pc, e := newParseContext(p, q, hs, opts)
+	if e == nil {
+		result, e = pc.parse()
 	}
-
-	return pc.parse()
+	if pc != nil && pc.options.reuse {
+		p.contexts.Put(pc)
+	}
+	return result, e
 }
 
 // ParseString is same as Parse, except it creates source queue containing single source having
 // provided content with provided name (name may be empty).
-func (p *Parser) ParseString(name, content string, hs *Hooks) (result any, e error) {
+func (p *Parser) ParseString(name, content string, hs *Hooks, opts ...ParseOption) (result any, e error) {
 	q := source.NewQueue().Append(source.New(name, []byte(content)))
-	return p.Parse(q, hs)
+	return p.Parse(q, hs, opts...)
 }
 
 type nodeRec struct {
@@ -202,6 +442,8 @@ type nodeRec struct {
 	types  grammar.BitSet
 	index  int
 	state  int
+	scope  *Scope
+	label  string
 }
 
 // ParseContext contains all context used in parsing process.
@@ -209,27 +451,151 @@ type ParseContext struct {
 	parser       *Parser
 	sources      *source.Queue
 	tokenHooks   []TokenHook
+	tokenAccepts []TokenPredicate
 	nodeHooks    []NodeHook
 	tokens       *queue.Queue[*Token]
 	appliedRules *queue.Queue[grammar.Rule]
 	tokenError   error
 	lastResult   any
 	node         *nodeRec
+	nodeCount    int
+	inputBytes   int64
+	fetched      int
+	recoveryNode int
+	syncTokens   map[int]bool
+	errors       ErrorList
+	hookToken    *Token
+	commentTypes map[int]bool
+	comments     []*Token
+	lastToken    *Token
+	pairs        map[string]string
+	closers      map[string]bool
+	openPairs    []*Token
+	completion   []int
+	decoders     map[int]Decoder
+	scopeNodes   map[int]bool
+	globalScope  *Scope
+	group        int
+	hasGroup     bool
+	lexers       []TokenProvider
+	arena        *lexer.TokenArena
+	groupHooks   []TokenHook
+	typeHooks    map[int]TokenHook
+	actions      map[int]boundAction
+	inlineNodes  map[int]bool
+	punctuation  map[int]bool
+	branchTypes  grammar.BitSet
+	options      parseOptions
 }
 
+// neverMatchingPattern replaces patterns of preamble token types in lexer regexp.
+const neverMatchingPattern = "([^\\x00-\\x{10ffff}])"
+
+// progressInterval is the number of fetched tokens between progress reports.
+const progressInterval = 256
+
 const (
 	tokenHooksOffset = -lexer.LowestTokenType
 	nodeHooksOffset  = -grammar.AnyToken
 )
 
-func newParseContext(p *Parser, q *source.Queue, hs *Hooks) (*ParseContext, error) {
-	result := &ParseContext{
-		parser:       p,
-		sources:      q,
-		tokenHooks:   make([]TokenHook, len(p.grammar.Tokens)+tokenHooksOffset),
-		nodeHooks:    make([]NodeHook, len(p.grammar.Nodes)+nodeHooksOffset),
-		tokens:       queue.New[*Token](),
-		appliedRules: queue.New[grammar.Rule](),
+func newParseContext(p *Parser, q *source.Queue, hs *Hooks, opts []ParseOption) (*ParseContext, error) {
+	var po parseOptions
+	applyOptions(&po, p.options)
+	applyOptions(&po, opts)
+	result := p.newContext(po.reuse)
+	if po.finalNewline {
+		q = q.WithFinalNewline()
+	}
+	result.parser = p
+	result.sources = q
+	result.options = po
+	if result.options.reserveTokens > 0 {
+		result.tokens.Reserve(result.options.reserveTokens)
+	}
+
+	result.lexers = p.lexers
+	if result.options.tokenArena || result.options.ctx != nil {
+		if result.options.tokenArena && result.arena == nil {
+			result.arena = lexer.NewTokenArena(result.options.arenaChunk)
+		}
+		result.lexers = make([]TokenProvider, len(p.lexers))
+		for i, l := range p.lexers {
+			if ll, valid := l.(*lexer.Lexer); valid {
+				if result.options.tokenArena {
+					ll = ll.WithTokenArena(result.arena)
+				}
+				if result.options.ctx != nil {
+					ll = ll.WithContext(result.options.ctx)
+				}
+				l = ll
+			}
+			result.lexers[i] = l
+		}
+	}
+
+	result.recoveryNode = -1
+	if result.options.recoveryNode != "" {
+		i, f := p.names[nodeKey(result.options.recoveryNode)]
+		if !f || i < 0 {
+			return nil, unknownNodeError(result.options.recoveryNode)
+		}
+
+		result.recoveryNode = i
+	}
+
+	if len(result.options.syncTokens) > 0 {
+		result.syncTokens = make(map[int]bool)
+	}
+	for _, k := range result.options.syncTokens {
+		var (
+			i int
+			f bool
+		)
+		if strings.HasPrefix(k, "$") {
+			i, f = p.names[k]
+			if !f || i < 0 {
+				return nil, unknownTokenTypeError(k[1:])
+			}
+		} else {
+			i, f = p.literals.Lookup([]byte(k))
+			if !f {
+				return nil, unknownTokenLiteralError(k)
+			}
+		}
+		result.syncTokens[i] = true
+	}
+
+	e := result.initPairs()
+	if e == nil {
+		e = result.initDecoders()
+	}
+	if e == nil {
+		e = result.initScopes()
+	}
+	if e == nil {
+		e = result.initActions()
+	}
+	if e == nil {
+		e = result.initInlineNodes()
+	}
+	if e == nil {
+		e = result.initPunctuation()
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	for _, name := range result.options.comments {
+		i, f := p.names[tokenKey(name)]
+		if !f || i < 0 {
+			return nil, unknownTokenTypeError(name)
+		}
+
+		if result.commentTypes == nil {
+			result.commentTypes = make(map[int]bool)
+		}
+		result.commentTypes[i] = true
 	}
 
 	for k, th := range hs.Tokens {
@@ -242,7 +608,7 @@ func newParseContext(p *Parser, q *source.Queue, hs *Hooks) (*ParseContext, erro
 	}
 
 	for k, th := range hs.Literals {
-		i, f := p.literals.Get([]byte(k))
+		i, f := p.literals.Lookup([]byte(k))
 		if !f {
 			return nil, unknownTokenLiteralError(k)
 		}
@@ -250,6 +616,44 @@ func newParseContext(p *Parser, q *source.Queue, hs *Hooks) (*ParseContext, erro
 		result.tokenHooks[i+tokenHooksOffset] = th
 	}
 
+	for group, ths := range hs.Groups {
+		if group < 0 || group >= len(p.lexers) {
+			return nil, unknownGroupError(group)
+		}
+
+		for k, th := range ths {
+			if k == AnyToken {
+				if result.groupHooks == nil {
+					result.groupHooks = make([]TokenHook, len(p.lexers))
+				}
+				result.groupHooks[group] = th
+				continue
+			}
+
+			i, f := p.names[tokenKey(k)]
+			if !f || i < 0 {
+				return nil, unknownTokenTypeError(k)
+			}
+			if p.grammar.Tokens[i].Group != group {
+				return nil, wrongGroupError(k, group)
+			}
+
+			if result.typeHooks == nil {
+				result.typeHooks = make(map[int]TokenHook)
+			}
+			result.typeHooks[i] = th
+		}
+	}
+
+	for k, tp := range hs.TokenAccept {
+		i, f := p.names[tokenKey(k)]
+		if !f {
+			return nil, unknownTokenTypeError(k)
+		}
+
+		result.tokenAccepts[i+tokenHooksOffset] = tp
+	}
+
 	for k, nth := range hs.Nodes {
 		i, f := p.names[nodeKey(k)]
 		if !f {
@@ -259,12 +663,241 @@ func newParseContext(p *Parser, q *source.Queue, hs *Hooks) (*ParseContext, erro
 		result.nodeHooks[i+nodeHooksOffset] = nth
 	}
 
-	e := result.pushNode(grammar.RootNode, lexer.NewToken(grammar.AnyToken, "", nil, q.SourcePos()))
+	startNode := grammar.RootNode
+	if result.options.startNode != "" {
+		i, f := p.names[nodeKey(result.options.startNode)]
+		if !f || i < 0 {
+			return nil, unknownNodeError(result.options.startNode)
+		}
+
+		startNode = i
+	}
+
+	e = result.pushNode(startNode, lexer.NewToken(grammar.AnyToken, "", nil, q.SourcePos()))
 	return result, e
 }
 
+// newContext returns a clean parse context, either a new one or a previously used one if reuse is set.
+func (p *Parser) newContext(reuse bool) *ParseContext {
+	if reuse {
+		if pc, valid := p.contexts.Get().(*ParseContext); valid {
+			return pc.reset()
+		}
+	}
+
+	return &ParseContext{
+		tokenHooks:   make([]TokenHook, len(p.grammar.Tokens)+tokenHooksOffset),
+		tokenAccepts: make([]TokenPredicate, len(p.grammar.Tokens)+tokenHooksOffset),
+		nodeHooks:    make([]NodeHook, len(p.grammar.Nodes)+nodeHooksOffset),
+		tokens:       queue.New[*Token](),
+		appliedRules: queue.New[grammar.Rule](),
+	}
+}
+
+// reset clears parse context keeping allocated hook tables and queues.
+func (pc *ParseContext) reset() *ParseContext {
+	for i := range pc.tokenHooks {
+		pc.tokenHooks[i] = nil
+		pc.tokenAccepts[i] = nil
+	}
+	for i := range pc.nodeHooks {
+		pc.nodeHooks[i] = nil
+	}
+	if !pc.tokens.IsEmpty() {
+		pc.tokens.Clear()
+	}
+	if !pc.appliedRules.IsEmpty() {
+		pc.appliedRules.Clear()
+	}
+	pc.tokens.Reserve(0)
+
+	*pc = ParseContext{
+		tokenHooks:   pc.tokenHooks,
+		tokenAccepts: pc.tokenAccepts,
+		nodeHooks:    pc.nodeHooks,
+		tokens:       pc.tokens,
+		appliedRules: pc.appliedRules,
+		arena:        pc.arena,
+	}
+	return pc
+}
+
+// LastTokenGroup returns the group number of lexer that fetched the token currently passed to token hook
+// (0 is the default group), same as Token.Group. Intended for debugging !group directives.
+// Returns 0 when called outside of token hooks, node hooks should use Token.Group instead,
+// since parser may fetch lookahead tokens before passing a token to node hooks.
+func (pc *ParseContext) LastTokenGroup() int {
+	if pc.hookToken == nil {
+		return 0
+	}
+	return pc.hookToken.Group()
+}
+
+// Depth returns the number of nodes on parser stack, i.e. the depth of the node receiving tokens
+// (1 for the root node). When called from a node hook, the new node is not on the stack yet.
+func (pc *ParseContext) Depth() int {
+	res := 0
+	for n := pc.node; n != nil; n = n.prev {
+		res++
+	}
+	return res
+}
+
+// ParentNode returns the name of the innermost node on parser stack: the parent of the new node
+// when called from a node hook, or the node receiving the token when called from a token hook.
+// Returns empty string if the stack is empty.
+func (pc *ParseContext) ParentNode() string {
+	if pc.node == nil {
+		return ""
+	}
+	return pc.parser.grammar.Nodes[pc.node.index].Name
+}
+
+// Ancestors returns names of all nodes on parser stack starting with the innermost one (see ParentNode),
+// e.g. a node hook may treat a node differently depending on the enclosing nodes.
+func (pc *ParseContext) Ancestors() []string {
+	var res []string
+	for n := pc.node; n != nil; n = n.prev {
+		res = append(res, pc.parser.grammar.Nodes[n.index].Name)
+	}
+	return res
+}
+
+// ParentInstance returns the hook instance of the innermost node on parser stack (see ParentNode),
+// nil if the stack is empty. A node hook may use it to inspect the state accumulated by the parent
+// (e.g. its pending result). Instance is either returned by a node hook, possibly wrapped by middleware,
+// or created by parser for unhooked nodes.
+func (pc *ParseContext) ParentInstance() NodeHookInstance {
+	if pc.node == nil {
+		return nil
+	}
+	return pc.node.hook
+}
+
+// ParentResult returns pending result of the innermost node on parser stack (see ParentNode) if its hook instance
+// implements PendingResultHolder, nil otherwise. Instances of unhooked nodes hold the result of their last
+// finished child node.
+func (pc *ParseContext) ParentResult() any {
+	if h, valid := pc.ParentInstance().(PendingResultHolder); valid {
+		return h.PendingResult()
+	}
+	return nil
+}
+
+// SetGroup makes parser try lexer of given group (0 is the default group) before other ones
+// when fetching subsequent tokens from source, other groups are tried in usual order.
+// Negative group restores the usual order. The group is kept until changed by another call.
+// This allows token hooks to handle context-sensitive tokens that grammar alone cannot disambiguate,
+// e.g. a regular expression literal or a division operator depending on the previous token.
+// Tokens that are already fetched (e.g. as lookahead) are not affected.
+// The group is ignored while the innermost node uses a single lexer group (see !lexer directive in langdef).
+// Returns UnknownGroupError if the grammar has no such group.
+func (pc *ParseContext) SetGroup(group int) error {
+	if group >= len(pc.parser.lexers) {
+		return unknownGroupError(group)
+	}
+
+	pc.group = group
+	pc.hasGroup = group >= 0
+	return nil
+}
+
+// SetTokenGroup calls SetGroup for the group containing given token type.
+// Returns UnknownTokenTypeError if the grammar has no such token type.
+func (pc *ParseContext) SetTokenGroup(typeName string) error {
+	i, f := pc.parser.names[tokenKey(typeName)]
+	if !f || i < 0 {
+		return unknownTokenTypeError(typeName)
+	}
+
+	return pc.SetGroup(pc.parser.grammar.Tokens[i].Group)
+}
+
+// Split splits token content into several tokens using given regular expression.
+// The expression is matched repeatedly starting at the beginning of content, each match must start
+// at current position and be non-empty (so it's better to anchor the expression with ^).
+// n-th capturing group corresponds to n-th token type name, the first matched group defines resulting token,
+// a match containing no captured groups is skipped. Resulting tokens keep source positions.
+// Returns UnknownTokenTypeError if some of type names is not defined in grammar
+// or SplitTokenError if content cannot be split.
+// Resulting tokens are not queued, use EmitToken for that.
+func (pc *ParseContext) Split(tok *Token, re *regexp.Regexp, typeNames ...string) ([]*Token, error) {
+	types := make([]int, len(typeNames))
+	for i, name := range typeNames {
+		tt, f := pc.parser.names[tokenKey(name)]
+		if !f || tt < 0 {
+			return nil, unknownTokenTypeError(name)
+		}
+
+		types[i] = tt
+	}
+
+	content := tok.Content()
+	res := make([]*Token, 0)
+	pos := 0
+	for pos < len(content) {
+		match := re.FindSubmatchIndex(content[pos:])
+		if len(match) == 0 || match[0] != 0 || match[1] == 0 {
+			return nil, splitTokenError(tok, pos)
+		}
+
+		for i := 2; i+1 < len(match) && (i>>1) <= len(types); i += 2 {
+			if match[i] >= 0 {
+				sp := tok.Pos().At(tok.Pos().Pos() + pos + match[i])
+				index := (i >> 1) - 1
+				res = append(res, lexer.NewToken(types[index], typeNames[index], content[pos+match[i]:pos+match[i+1]], sp))
+				break
+			}
+		}
+		pos += match[1]
+	}
+
+	return res, nil
+}
+
+// ReadUntil reads raw source content starting at current source position (i.e. right after the token
+// passed to token hook) up to the first match of terminator expression, bypassing the lexer.
+// This allows to capture heredocs, fenced code blocks, and raw strings having dynamic delimiters.
+// Resulting token of given type contains the text preceding the match, the matched text itself is skipped.
+// Tokens already fetched from source (e.g. while looking ahead) are not affected.
+// Returns UnknownTokenTypeError if token type is not defined in grammar
+// or UnexpectedEoiError if terminator is not found in current source.
+// A continued source (see source.WithContinuation) is joined with its successors until the terminator is found
+// and more content cannot extend the match, so the text may span several chunks fed to parser.
+// Resulting token is not queued, use EmitToken for that.
+func (pc *ParseContext) ReadUntil(typeName string, terminator *regexp.Regexp) (*Token, error) {
+	pos := pc.sources.SourcePos()
+	tok, e := pc.MakeTokenAt(typeName, nil, pos)
+	if e != nil {
+		return nil, e
+	}
+
+	content, start := pc.sources.ContentPos()
+	match := terminator.FindIndex(content[start:])
+	for (match == nil || match[1] == len(content)-start) && pc.sources.Source().Continued() {
+		joined, e := pc.sources.JoinNext(pc.options.ctx)
+		if e != nil {
+			return nil, e
+		}
+		if !joined {
+			break
+		}
+
+		pos = pc.sources.SourcePos()
+		content, start = pc.sources.ContentPos()
+		match = terminator.FindIndex(content[start:])
+	}
+	if match == nil {
+		return nil, unexpectedEofError(tok, strconv.Quote(terminator.String()))
+	}
+
+	pc.sources.Skip(match[1])
+	return lexer.NewToken(tok.Type(), typeName, content[start:start+match[0]], pos), nil
+}
+
 // EmitToken adds new element to the end of token queue.
 // Token's type must be defined in grammar, and it must not be a literal or an error token.
+// A token having no source position gets the position of current one (see MakeToken).
 func (pc *ParseContext) EmitToken(t *Token) error {
 	tt := t.Type()
 	if tt < 0 || tt >= len(pc.parser.grammar.Tokens) {
@@ -276,18 +909,66 @@ func (pc *ParseContext) EmitToken(t *Token) error {
 		return emitWrongTokenError(t)
 	}
 
+	if t.Source() == nil {
+		t = t.WithPos(pc.currentPos())
+	}
 	pc.tokens.Append(t)
 	return nil
 }
 
+// MakeToken creates a token of given type positioned at current token, i.e. the token passed to token hook
+// when called from token hook or the next unread byte of source otherwise.
+// Returns UnknownTokenTypeError if token type is not defined in grammar.
+// Resulting token is not queued, use EmitToken for that.
+func (pc *ParseContext) MakeToken(typeName string, content []byte) (*Token, error) {
+	return pc.MakeTokenAt(typeName, content, pc.currentPos())
+}
+
+// MakeTokenAt creates a token of given type captured at given source position.
+// Returns UnknownTokenTypeError if token type is not defined in grammar.
+// Resulting token is not queued, use EmitToken for that.
+func (pc *ParseContext) MakeTokenAt(typeName string, content []byte, pos source.Pos) (*Token, error) {
+	tt, f := pc.parser.names[tokenKey(typeName)]
+	if !f || tt < 0 {
+		return nil, unknownTokenTypeError(typeName)
+	}
+
+	return lexer.NewToken(tt, typeName, content, pos), nil
+}
+
+func (pc *ParseContext) currentPos() source.Pos {
+	if pc.hookToken != nil && pc.hookToken.Source() != nil {
+		return pc.hookToken.Pos()
+	}
+	return pc.sources.SourcePos()
+}
+
 func (pc *ParseContext) pushNode(index int, tok *Token) error {
 	e := pc.ntHandleAsides()
 	if e != nil {
 		return e
 	}
 
+	pc.nodeCount++
+	if pc.options.maxNodes > 0 && pc.nodeCount > pc.options.maxNodes {
+		return treeTooLargeError(tok, pc.options.maxNodes)
+	}
+
 	gr := pc.parser.grammar
 	nt := gr.Nodes[index]
+	var scope *Scope
+	if pc.scopeNodes[index] {
+		scope = &Scope{parent: pc.Scope()}
+	}
+
+	if pc.inlineNodes[index] && pc.node != nil {
+		pc.node = &nodeRec{pc.node, &inlineInstance{pc.node.hook}, nil, gr.States[nt.FirstState].TokenTypes, index, nt.FirstState, scope, ""}
+		if pc.options.trace != nil {
+			pc.tracef("push inline node %s", nt.Name)
+		}
+		return nil
+	}
+
 	if pc.node != nil {
 		e = pc.node.hook.NewNode(nt.Name, tok)
 		if e != nil {
@@ -300,7 +981,16 @@ func (pc *ParseContext) pushNode(index int, tok *Token) error {
 		return e
 	}
 
-	pc.node = &nodeRec{pc.node, hook, nil, gr.States[nt.FirstState].TokenTypes, index, nt.FirstState}
+	if pc.node == nil && pc.options.rootUnwrap {
+		hook = &rootUnwrapInstance{hook: hook, node: nt.Name, token: tok}
+	}
+	if pc.node != nil && pc.options.passThrough {
+		hook = &passThroughInstance{hook: hook}
+	}
+	pc.node = &nodeRec{pc.node, hook, nil, gr.States[nt.FirstState].TokenTypes, index, nt.FirstState, scope, ""}
+	if pc.options.trace != nil {
+		pc.tracef("push node %s", nt.Name)
+	}
 	return nil
 }
 
@@ -311,6 +1001,11 @@ func (pc *ParseContext) popNode() error {
 	)
 	nts := pc.parser.grammar.Nodes
 
+	e = pc.prefetchComments()
+	if e != nil {
+		return e
+	}
+
 	asides := pc.node.asides
 	pc.node.asides = nil
 
@@ -325,6 +1020,9 @@ func (pc *ParseContext) popNode() error {
 			}
 		}
 
+		if pc.options.trace != nil {
+			pc.tracef("drop node %s", nts[nt.index].Name)
+		}
 		pc.node = nt.prev
 		res, e = nt.hook.EndNode()
 		pc.lastResult = res
@@ -333,7 +1031,7 @@ func (pc *ParseContext) popNode() error {
 		}
 
 		if e == nil {
-			e = pc.node.hook.HandleNode(nts[nt.index].Name, res)
+			e = pc.passResult(nt, res)
 		}
 	}
 
@@ -347,6 +1045,14 @@ func (pc *ParseContext) popNode() error {
 const repeatState = -128
 
 func (pc *ParseContext) parse() (any, error) {
+	res, e := pc.parseTokens()
+	if e != nil {
+		e = pc.stopError(e)
+	}
+	return res, e
+}
+
+func (pc *ParseContext) parseTokens() (any, error) {
 	var (
 		tok           *Token
 		e             error
@@ -360,10 +1066,23 @@ func (pc *ParseContext) parse() (any, error) {
 		if e != nil {
 			return nil, e
 		}
+		if pc.options.completion && tok != nil && tok.Type() == lexer.EoiTokenType {
+			pc.completion = pc.expectedKeys()
+			return nil, nil
+		}
 
 		for !tokenConsumed && pc.node != nil {
 			nt := pc.node
-			rule, found := pc.nextRule(tok, gr.States[nt.state])
+			rule, found, e := pc.nextRule(tok, gr.States[nt.state])
+			if e != nil {
+				return nil, e
+			}
+			if pc.completion != nil {
+				return nil, nil
+			}
+			if pc.options.trace != nil {
+				pc.traceStep(tok, rule, found)
+			}
 			if !found {
 				if tok == nil {
 					tok, e = pc.nextToken(lexer.AllTokenTypes)
@@ -373,12 +1092,47 @@ func (pc *ParseContext) parse() (any, error) {
 				}
 
 				expected := pc.getExpectedToken(gr.States[nt.state])
+				if pc.recoveryNode >= 0 && tok.Type() >= 0 {
+					e = pc.recover(tok, expected)
+					if e != nil {
+						return nil, e
+					}
+
+					tokenConsumed = true
+					break
+				}
+
+				var ee *llx.Error
 				if tok.Type() == lexer.EoiTokenType {
-					e = unexpectedEofError(tok, expected)
+					ee = unexpectedEofError(tok, expected)
 				} else {
-					e = unexpectedTokenError(tok, expected)
+					ee = unexpectedTokenError(tok, expected, pc.suggestLiterals(tok))
 				}
-				return nil, e
+				return nil, pc.describeError(ee)
+			}
+
+			if pc.actions != nil {
+				e = pc.runAction(rule)
+				if e != nil {
+					return nil, e
+				}
+			}
+			if pc.parser.adjacent != nil && rule.Token == grammar.AnyToken && pc.parser.adjacent[nt.state] {
+				e = pc.checkAdjacent(tok)
+				if e != nil {
+					return nil, e
+				}
+			}
+			if pc.parser.errProds != nil && rule.Token == grammar.AnyToken {
+				e = pc.reportErrorProduction(tok)
+				if e != nil {
+					return nil, e
+				}
+			}
+
+			label := ""
+			if pc.parser.labels != nil && rule.Token != grammar.AnyToken {
+				label = pc.parser.labels[ruleLabelKey{nt.state, rule}]
 			}
 
 			sameNode := (rule.Node == grammar.SameNode)
@@ -392,8 +1146,11 @@ func (pc *ParseContext) parse() (any, error) {
 
 			if !sameNode {
 				e = pc.pushNode(rule.Node, tok)
+				if e == nil {
+					pc.node.label = label
+				}
 			} else if tokenConsumed {
-				e = pc.ntHandleToken(tok)
+				e = pc.ntHandleToken(tok, label)
 			}
 
 			if e == nil && pc.node.state == grammar.FinalState {
@@ -416,10 +1173,112 @@ func (pc *ParseContext) parse() (any, error) {
 		}
 	}
 
+	if pc.options.progress != nil {
+		pc.options.progress(pc.sources.Progress())
+	}
+	if len(pc.errors) > 0 {
+		return pc.lastResult, pc.errors
+	}
 	return pc.lastResult, nil
 }
 
-func (pc *ParseContext) resolve(tok *Token, ars []grammar.Rule) ([]*Token, []grammar.Rule) {
+// stopError returns error e that stops parsing, or ErrorList with e appended if errors are collected
+// (see WithErrorCollection) and there are collected errors. Errors other than llx.Error are appended as StopError.
+func (pc *ParseContext) stopError(e error) error {
+	if len(pc.errors) == 0 {
+		return e
+	}
+	if _, isList := e.(ErrorList); isList {
+		return e
+	}
+
+	ee, valid := e.(*llx.Error)
+	if !valid {
+		ee = stopError(e)
+	}
+	return append(pc.errors, ee)
+}
+
+// softError handles syntax error that does not break parsing: returns the error unless errors are collected
+// (see WithErrorCollection), otherwise collects it and returns nil or ErrorList if the limit is reached.
+func (pc *ParseContext) softError(ee *llx.Error) error {
+	ee = pc.describeError(ee)
+	if !pc.options.collectErrors {
+		return ee
+	}
+
+	pc.errors = append(pc.errors, ee)
+	if pc.options.errorLimit > 0 && len(pc.errors) >= pc.options.errorLimit {
+		return pc.errors
+	}
+	return nil
+}
+
+func (pc *ParseContext) recover(tok *Token, expected string) error {
+	if pc.options.collectErrors {
+		pc.errors = append(pc.errors, pc.describeError(unexpectedTokenError(tok, expected, pc.suggestLiterals(tok))))
+		if pc.options.errorLimit > 0 && len(pc.errors) >= pc.options.errorLimit {
+			return pc.errors
+		}
+	}
+
+	e := pc.pushNode(pc.recoveryNode, tok)
+	var level *nodeRec
+	synced := (pc.syncTokens == nil)
+	for e == nil {
+		synced = synced || pc.isSyncToken(tok)
+		e = pc.ntHandleToken(tok, "")
+		if e == nil {
+			tok, e = pc.nextToken(lexer.AllTokenTypes)
+		}
+		if e != nil {
+			return e
+		}
+
+		if pc.isAsideToken(tok) || !synced && tok.Type() >= 0 {
+			continue
+		}
+
+		level = pc.node.prev
+		for level != nil && !pc.expectsToken(tok, level.state) {
+			level = level.prev
+		}
+		if level != nil || tok.Type() < 0 {
+			break
+		}
+	}
+
+	pc.node.state = grammar.FinalState
+	if level != nil {
+		for n := pc.node.prev; n != level; n = n.prev {
+			n.state = grammar.FinalState
+		}
+	}
+	pc.tokens.Prepend(tok)
+	return pc.popNode()
+}
+
+func (pc *ParseContext) isSyncToken(tok *Token) bool {
+	if pc.syncTokens[tok.Type()] {
+		return true
+	}
+
+	i, f := pc.parser.literals.Lookup(tok.Content())
+	return f && pc.syncTokens[i]
+}
+
+func (pc *ParseContext) expectsToken(tok *Token, state int) bool {
+	if state == grammar.FinalState {
+		return false
+	}
+
+	rules := pc.findRules(tok, pc.parser.grammar.States[state])
+	return len(rules) > 0 && rules[0].Token != grammar.AnyToken
+}
+
+// resolve looks ahead to choose one of ambiguous rules, returns lookahead tokens (starting with tok),
+// the chosen branch, and false if several branches are still alive when lookahead stops.
+func (pc *ParseContext) resolve(tok *Token, ars []grammar.Rule) ([]*Token, *branch, bool) {
 	liveBranch := createBranches(pc, pc.node, ars)
 	tokens := make([]*Token, 0)
 	pc.tokens.Prepend(tok)
@@ -429,9 +1288,22 @@ func (pc *ParseContext) resolve(tok *Token, ars []grammar.Rule) ([]*Token, []gra
 		deadBranch = nil
 		lastDead = nil
 		survivors := 0
-		tok, e := pc.nextToken(liveBranch.nextTokenTypes())
-		if e != nil || tok == nil {
-			return tokens, liveBranch.applied
+		if pc.options.maxLookahead > 0 && len(tokens) > pc.options.maxLookahead {
+			return tokens, liveBranch, false
+		}
+
+		pc.branchTypes = liveBranch.nextTokenTypes(pc.branchTypes)
+		tok, e := pc.nextToken(pc.branchTypes)
+		if e != nil {
+			return tokens, liveBranch, true
+		}
+		if tok == nil {
+			return tokens, liveBranch, false
+		}
+
+		if pc.options.completion && tok.Type() == lexer.EoiTokenType {
+			pc.completion = pc.branchKeys(liveBranch)
+			return tokens, liveBranch, true
 		}
 
 		tokens = append(tokens, tok)
@@ -465,7 +1337,7 @@ func (pc *ParseContext) resolve(tok *Token, ars []grammar.Rule) ([]*Token, []gra
 				liveBranch = deadBranch
 			}
 
-			return tokens, liveBranch.applied
+			return tokens, liveBranch, true
 		}
 	}
 }
@@ -494,48 +1366,200 @@ func (pc *ParseContext) getExpectedToken(s grammar.State) string {
 	}
 }
 
+// describeError adds acceptable rule keys and node stack of current node to syntax error.
+func (pc *ParseContext) describeError(ee *llx.Error) *llx.Error {
+	for _, k := range pc.expectedKeys() {
+		ee.Expected = append(ee.Expected, pc.keyName(k))
+	}
+	for n := pc.node; n != nil; n = n.prev {
+		ee.Nodes = append(ee.Nodes, pc.parser.grammar.Nodes[n.index].Name)
+	}
+	for i, j := 0, len(ee.Nodes)-1; i < j; i, j = i+1, j-1 {
+		ee.Nodes[i], ee.Nodes[j] = ee.Nodes[j], ee.Nodes[i]
+	}
+	return ee
+}
+
+// expectedKeys returns sorted rule keys (except AnyToken) acceptable in current state,
+// including keys acceptable after current node and its ancestors are finalized.
+func (pc *ParseContext) expectedKeys() []int {
+	keys := make(map[int]bool)
+	if pc.collectStackKeys(pc.node, keys) {
+		keys[lexer.EoiTokenType] = true
+	}
+	return sortedKeys(keys)
+}
+
+// branchKeys returns sorted rule keys acceptable by any of live branches starting with b,
+// including keys acceptable after branch nodes and ancestors of current node are finalized.
+func (pc *ParseContext) branchKeys(b *branch) []int {
+	keys := make(map[int]bool)
+	for ; b != nil; b = b.next {
+		if pc.collectStackKeys(b.node, keys) && pc.collectStackKeys(pc.node.prev, keys) {
+			keys[lexer.EoiTokenType] = true
+		}
+	}
+	return sortedKeys(keys)
+}
+
+// collectStackKeys adds rule keys acceptable in node n and, while nodes may be finalized, in its ancestors.
+// Returns true if all nodes may be finalized.
+func (pc *ParseContext) collectStackKeys(n *nodeRec, keys map[int]bool) bool {
+	final := true
+	for ; n != nil && final; n = n.prev {
+		final = pc.collectKeys(n.state, keys, make(map[int]bool))
+	}
+	return final
+}
+
+func sortedKeys(keys map[int]bool) []int {
+	res := make([]int, 0, len(keys))
+	for k := range keys {
+		res = append(res, k)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// collectKeys adds rule keys acceptable in given state to keys, returns true if the state may be finalized
+// without consuming a token.
+func (pc *ParseContext) collectKeys(state int, keys, visited map[int]bool) bool {
+	if state == grammar.FinalState {
+		return true
+	}
+	if visited[state] {
+		return false
+	}
+
+	visited[state] = true
+	g := pc.parser.grammar
+	s := g.States[state]
+	rules := g.Rules[s.LowRule:s.HighRule]
+	for _, mr := range g.MultiRules[s.LowMultiRule:s.HighMultiRule] {
+		rules = append(rules[:len(rules):len(rules)], g.Rules[mr.LowRule:mr.HighRule]...)
+	}
+
+	final := false
+	for _, r := range rules {
+		if r.Token != grammar.AnyToken {
+			keys[r.Token] = true
+			continue
+		}
+
+		if r.Node == grammar.SameNode || pc.collectKeys(g.Nodes[r.Node].FirstState, keys, visited) {
+			final = pc.collectKeys(r.State, keys, visited) || final
+		}
+	}
+	return final
+}
+
+// keyName returns rule key name: quoted literal, token type name prefixed with $, or "any".
+func (pc *ParseContext) keyName(key int) string {
+	switch key {
+	case grammar.AnyToken:
+		return "any"
+	case lexer.EofTokenType:
+		return "$" + lexer.EofTokenName
+	case lexer.EoiTokenType:
+		return "$" + lexer.EoiTokenName
+	}
+
+	t := pc.parser.grammar.Tokens[key]
+	if t.Flags&grammar.LiteralToken != 0 {
+		return strconv.Quote(t.Name)
+	}
+	return "$" + t.Name
+}
+
 func (pc *ParseContext) findRules(t *Token, s grammar.State) []grammar.Rule {
 	if pc.isAsideToken(t) {
 		return []grammar.Rule{{t.Type(), repeatState, grammar.SameNode}}
 	}
 
-	keys := pc.possibleRuleKeys(t)
+	keys, folded := pc.possibleRuleKeys(t, s.Caseless)
+	for _, key := range keys {
+		if key >= 0 && key == t.Type() && !pc.acceptToken(t) {
+			continue
+		}
+
+		rules := pc.stateRules(s, key)
+		if folded >= 0 && key == folded && (len(rules) == 0 || !pc.entersCaseless(rules[0])) {
+			continue
+		}
+
+		if len(rules) > 0 {
+			return rules
+		}
+	}
+
+	return nil
+}
+
+func (pc *ParseContext) stateRules(s grammar.State, key int) []grammar.Rule {
 	g := pc.parser.grammar
 	rules := g.Rules[s.LowRule:s.HighRule]
 	multiRules := g.MultiRules[s.LowMultiRule:s.HighMultiRule]
 	rlen := len(rules)
 	mrlen := len(multiRules)
 
-	for _, key := range keys {
-		if key == grammar.AnyToken && rlen > 0 && rules[0].Token == key {
-			return rules[0:1]
-		}
+	if key == grammar.AnyToken && rlen > 0 && rules[0].Token == key {
+		return rules[0:1]
+	}
+
+	index := sort.Search(rlen, func(i int) bool {
+		return rules[i].Token >= key
+	})
+	if index < rlen && rules[index].Token == key {
+		return rules[index : index+1]
+	}
+
+	index = sort.Search(mrlen, func(i int) bool {
+		return multiRules[i].Token >= key
+	})
+	if index < mrlen && multiRules[index].Token == key {
+		mr := multiRules[index]
+		return g.Rules[mr.LowRule:mr.HighRule]
+	}
+
+	return nil
+}
 
-		index := sort.Search(rlen, func(i int) bool {
-			return rules[i].Token >= key
-		})
-		if index < rlen && rules[index].Token == key {
-			return rules[index : index+1]
+// entersCaseless checks if rule pushes (directly or via nested nodes' initial rules) a caseless node.
+func (pc *ParseContext) entersCaseless(r grammar.Rule) bool {
+	g := pc.parser.grammar
+	for r.Node != grammar.SameNode {
+		s := g.States[g.Nodes[r.Node].FirstState]
+		if s.Caseless {
+			return true
 		}
 
-		index = sort.Search(mrlen, func(i int) bool {
-			return multiRules[i].Token >= key
-		})
-		if index < mrlen && multiRules[index].Token == key {
-			mr := multiRules[index]
-			return g.Rules[mr.LowRule:mr.HighRule]
+		rules := pc.stateRules(s, r.Token)
+		if len(rules) == 0 {
+			return false
 		}
+
+		r = rules[0]
 	}
+	return false
+}
 
-	return nil
+func (pc *ParseContext) acceptToken(t *Token) bool {
+	tp := pc.tokenAccepts[t.Type()+tokenHooksOffset]
+	if tp == nil {
+		tp = pc.tokenAccepts[anyOffset+tokenHooksOffset]
+	}
+	return tp == nil || tp(t, pc)
 }
 
-func (pc *ParseContext) possibleRuleKeys(t *Token) []int {
+// possibleRuleKeys returns rule keys for token in order of priority and the index of case-folded literal
+// matching rules that enter caseless node (-1 if none).
+func (pc *ParseContext) possibleRuleKeys(t *Token, caseless bool) (keys []int, folded int) {
+	folded = -1
 	if t == nil {
-		return []int{grammar.AnyToken}
+		return []int{grammar.AnyToken}, folded
 	}
 
-	keys := make([]int, 0, 3)
+	keys = make([]int, 0, 4)
 	tt := t.Type()
 	var tf grammar.TokenFlags
 	tokens := pc.parser.grammar.Tokens
@@ -549,14 +1573,26 @@ func (pc *ParseContext) possibleRuleKeys(t *Token) []int {
 	literalIndex := 0
 	if (tf & grammar.NoLiteralsToken) == 0 {
 		literal := t.Content()
-		if tf&grammar.CaselessToken != 0 {
+		literals := pc.parser.literals
+		if caseless {
+			literal = bytes.ToUpper(literal)
+			literals = pc.parser.caseless
+		} else if tf&grammar.CaselessToken != 0 {
 			literal = bytes.ToUpper(literal)
 		}
-		literalIndex, literalFound = pc.parser.literals.Get(literal)
+		literalIndex, literalFound = literals.Lookup(literal)
 		literalFound = literalFound && (literalIndex >= 0)
 		if literalFound {
 			keys = append(keys, literalIndex)
 		}
+
+		if !caseless && tf&grammar.CaselessToken == 0 && pc.parser.caseless != nil {
+			fi, f := pc.parser.caseless.Lookup(bytes.ToUpper(literal))
+			if f && fi >= 0 && (!literalFound || fi != literalIndex) {
+				folded = fi
+				keys = append(keys, fi)
+			}
+		}
 	}
 
 	if !literalFound || literalIndex < 0 || (tokens[literalIndex].Flags&grammar.ReservedToken) == 0 {
@@ -564,7 +1600,7 @@ func (pc *ParseContext) possibleRuleKeys(t *Token) []int {
 	}
 	keys = append(keys, grammar.AnyToken)
 
-	return keys
+	return keys, folded
 }
 
 func (pc *ParseContext) getNodeHook(ntIndex int, tok *Token) (res NodeHookInstance, e error) {
@@ -572,6 +1608,9 @@ func (pc *ParseContext) getNodeHook(ntIndex int, tok *Token) (res NodeHookInstan
 	if h == nil {
 		h = pc.nodeHooks[anyOffset+nodeHooksOffset]
 	}
+	if len(pc.options.middleware) > 0 {
+		h = pc.wrapNodeHook(h)
+	}
 	if h != nil {
 		res, e = h(pc.parser.grammar.Nodes[ntIndex].Name, tok, pc)
 	} else {
@@ -597,7 +1636,7 @@ func (pc *ParseContext) nextToken(types grammar.BitSet) (result *Token, e error)
 	return
 }
 
-func (pc *ParseContext) nextRule(t *Token, s grammar.State) (r grammar.Rule, found bool) {
+func (pc *ParseContext) nextRule(t *Token, s grammar.State) (r grammar.Rule, found bool, e error) {
 	r, found = pc.appliedRules.First()
 	if found {
 		return
@@ -609,15 +1648,30 @@ func (pc *ParseContext) nextRule(t *Token, s grammar.State) (r grammar.Rule, fou
 	}
 
 	found = true
-	if len(rules) == 1 {
+	if len(rules) == 1 || pc.options.preferFirst {
 		r = rules[0]
 	} else {
-		tokens, rules := pc.resolve(t, rules)
-		r = rules[0]
+		tokens, live, resolved := pc.resolve(t, rules)
+		if pc.completion != nil {
+			found = false
+			return
+		}
+		if !resolved && pc.options.ambiguityError {
+			e = ambiguityError(t, len(rules), len(tokens)-1)
+			return
+		}
+		if pc.options.resolveTrace != nil {
+			pc.options.resolveTrace(tokens, live.firstRules())
+		}
+		applied := live.applied
+		r = applied[0]
+		if pc.options.trace != nil {
+			pc.traceResolve(rules, tokens, r)
+		}
 		for i := len(tokens) - 1; i >= 1; i-- {
 			pc.tokens.Prepend(tokens[i])
 		}
-		pc.appliedRules.Fill(rules[1:])
+		pc.appliedRules.Fill(applied[1:])
 	}
 
 	return
@@ -629,14 +1683,34 @@ func (pc *ParseContext) handleToken(tok *Token) error {
 		return nil
 	}
 
+	if pc.options.trace != nil {
+		pc.tracef("fetched token %s", pc.traceToken(tok))
+	}
+
 	tts := make([]int, 0, 3)
 	tt := tok.Type()
+	if tt >= 0 && pc.decoders != nil {
+		var e error
+		tok, e = pc.decode(tok)
+		if e != nil {
+			return e
+		}
+	}
+	if pc.commentTypes[tt] {
+		pc.comments = append(pc.comments, tok)
+	}
+	if pc.pairs != nil {
+		e := pc.checkPairs(tok)
+		if e != nil {
+			return e
+		}
+	}
 
 	if tt < 0 {
 		tts = append(tts, tt)
 	} else {
 		if pc.parser.grammar.Tokens[tt].Flags&grammar.NoLiteralsToken == 0 {
-			i, f := pc.parser.literals.Get(tok.Content())
+			i, f := pc.parser.literals.Lookup(tok.Content())
 			if f {
 				tts = append(tts, i)
 			}
@@ -646,11 +1720,21 @@ func (pc *ParseContext) handleToken(tok *Token) error {
 
 	var h TokenHook
 	for _, i := range tts {
-		h = pc.tokenHooks[i+tokenHooksOffset]
+		if i == tt && pc.typeHooks != nil {
+			h = pc.typeHooks[tt]
+		} else if i == anyOffset && pc.groupHooks != nil {
+			h = pc.groupHooks[pc.parser.grammar.Tokens[tt].Group]
+		}
+		if h == nil {
+			h = pc.tokenHooks[i+tokenHooksOffset]
+		}
 		if h != nil {
 			break
 		}
 	}
+	if len(pc.options.middleware) > 0 {
+		h = pc.wrapTokenHook(h)
+	}
 
 	if h == nil {
 		if pc.isAsideToken(tok) || tt == lexer.EofTokenType {
@@ -661,7 +1745,9 @@ func (pc *ParseContext) handleToken(tok *Token) error {
 		return nil
 	}
 
+	pc.hookToken = tok
 	emit, e := h(tok, pc)
+	pc.hookToken = nil
 	if tt == lexer.EofTokenType {
 		emit = false
 	}
@@ -685,17 +1771,55 @@ func (pc *ParseContext) fetchToken(types grammar.BitSet) (*Token, error) {
 	var e error
 
 	for pc.tokens.IsEmpty() {
-		for i, l := range pc.parser.lexers {
-			result, e = l.NextOf(pc.sources, types)
+		if pc.options.ctx != nil {
+			e = pc.options.ctx.Err()
+			if e != nil {
+				return nil, e
+			}
+		}
+
+		count := len(pc.lexers)
+		exclusive, hasExclusive := pc.nodeGroup()
+		if hasExclusive {
+			count = 1
+		}
+		for n := 0; n < count; n++ {
+			i := n
+			if hasExclusive {
+				i = exclusive
+			} else if pc.hasGroup {
+				if n == 0 {
+					i = pc.group
+				} else if n <= pc.group {
+					i = n - 1
+				}
+			}
+
+			result, e = pc.lexers[i].NextOf(pc.sources, types)
 			if e == nil && result != nil {
 				firstError = nil
+				if result.Group() != i {
+					result = result.WithGroup(i)
+				}
 				break
 			}
 
-			if e != nil && i == 0 {
+			if e != nil && n == 0 {
 				firstError = e
 			}
 		}
+		if firstError == nil && result != nil && pc.options.maxInput > 0 {
+			pc.inputBytes += int64(len(result.Content()))
+			if pc.inputBytes > pc.options.maxInput {
+				firstError = inputTooLargeError(result, pc.options.maxInput)
+			}
+		}
+		if firstError == nil && result != nil && pc.options.progress != nil {
+			pc.fetched++
+			if pc.fetched%progressInterval == 0 {
+				pc.options.progress(pc.sources.Progress())
+			}
+		}
 		if firstError == nil {
 			firstError = pc.handleToken(result)
 		}
@@ -709,6 +1833,16 @@ func (pc *ParseContext) fetchToken(types grammar.BitSet) (*Token, error) {
 	return result, nil
 }
 
+// nodeGroup returns the lexer group assigned to the innermost node by !lexer directive, if any.
+func (pc *ParseContext) nodeGroup() (int, bool) {
+	if pc.parser.nodeGroups == nil || pc.node == nil {
+		return 0, false
+	}
+
+	group, has := pc.parser.nodeGroups[pc.node.index]
+	return group, has
+}
+
 func (pc *ParseContext) isAsideToken(t *Token) bool {
 	if t == nil {
 		return false
@@ -737,7 +1871,7 @@ func (pc *ParseContext) ntHandleAsides() (res error) {
 	return
 }
 
-func (pc *ParseContext) ntHandleToken(tok *Token) (res error) {
+func (pc *ParseContext) ntHandleToken(tok *Token, label string) (res error) {
 	if tok == nil {
 		return nil
 	}
@@ -746,10 +1880,36 @@ func (pc *ParseContext) ntHandleToken(tok *Token) (res error) {
 	if pc.isAsideToken(tok) {
 		ntr.asides = append(ntr.asides, tok)
 	} else {
+		pc.consumeComments(tok)
 		res = pc.ntHandleAsides()
-		if res == nil {
-			res = ntr.hook.HandleToken(tok)
+		if res == nil && !(pc.punctuation != nil && pc.isPunctuation(tok)) {
+			res = handleLabel(ntr.hook, label)
+			if res == nil {
+				res = ntr.hook.HandleToken(tok)
+			}
 		}
 	}
 	return
 }
+
+// addRuleLabel adds label of ri-th rule of si-th state if it is the li-th label, returns index of the next label.
+func addRuleLabel(labels map[ruleLabelKey]string, g *grammar.Grammar, si, ri, li int) int {
+	for li < len(g.Labels) && g.Labels[li].Rule < ri {
+		li++
+	}
+	if li < len(g.Labels) && g.Labels[li].Rule == ri {
+		labels[ruleLabelKey{si, g.Rules[ri]}] = g.Labels[li].Name
+	}
+	return li
+}
+
+func handleLabel(h NodeHookInstance, label string) error {
+	if label == "" {
+		return nil
+	}
+
+	if lh, valid := h.(LabelHandler); valid {
+		return lh.HandleLabel(label)
+	}
+	return nil
+}
@@ -1,11 +1,23 @@
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/ava12/llx"
+	gr "github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/internal/test"
 	"github.com/ava12/llx/langdef"
 	"github.com/ava12/llx/lexer"
 	"github.com/ava12/llx/source"
@@ -117,9 +129,10 @@ func TestHandlerKeyErrors(t *testing.T) {
 		hooks Hooks
 		err   int
 	}{
-		{Hooks{TokenHooks{"space": nil}, nil, nil}, UnknownTokenTypeError},
-		{Hooks{nil, TokenHooks{"y": nil}, nil}, UnknownTokenLiteralError},
-		{Hooks{nil, nil, NodeHooks{"foo": nil}}, UnknownNodeError},
+		{Hooks{TokenHooks{"space": nil}, nil, nil, nil, nil}, UnknownTokenTypeError},
+		{Hooks{nil, TokenHooks{"y": nil}, nil, nil, nil}, UnknownTokenLiteralError},
+		{Hooks{nil, nil, NodeHooks{"foo": nil}, nil, nil}, UnknownNodeError},
+		{Hooks{nil, nil, nil, TokenPredicates{"space": nil}, nil}, UnknownTokenTypeError},
 	}
 
 	for i, sample := range samples {
@@ -152,6 +165,23 @@ func TestSimple(t *testing.T) {
 	testGrammarSamples(t, name, grammar, samples, false)
 }
 
+func TestOneOrMore(t *testing.T) {
+	name := "one-or-more"
+	grammar := "$char = /\\w/; s = {+ a | 'b'}, 'c'; a = 'a', {+ 'a'};"
+	samples := []srcExprSample{
+		{"aac", "(a a a) c"},
+		{"bc", "b c"},
+		{"baaabc", "b (a a a a) b c"},
+	}
+	testGrammarSamples(t, name, grammar, samples, false)
+
+	errSamples := []srcErrSample{
+		{"c", UnexpectedTokenError},
+		{"ac", UnexpectedTokenError},
+	}
+	testErrorSamples(t, name, grammar, errSamples)
+}
+
 func TestAside(t *testing.T) {
 	name := "aside"
 	grammar := "!aside $sep; $sep = /-/; $char = /\\w/; s = {'a' | 'b' | 'c'};"
@@ -437,3 +467,2598 @@ func TestNodeHooks(t *testing.T) {
 		t.Errorf("expecting %q, got %q", expected, got)
 	}
 }
+
+func TestFromString(t *testing.T) {
+	p, e := FromString("grammar", spaceDef+"$name = /\\w+/; g = {$name};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	_, e = p.ParseString("", "foo bar", nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	_, e = FromSource(source.New("grammar", []byte("g = $name;")))
+	le, f := e.(*llx.Error)
+	if !f || le.Code != langdef.UnknownTokenError {
+		t.Fatalf("expecting error code %d, got: %v", langdef.UnknownTokenError, e)
+	}
+}
+
+func TestResolveTrace(t *testing.T) {
+	grammar := "$name = /\\w+/; $op = /[()+]/; g = sum | call; sum = $name, ['+', $name]; call = $name, '(', $name, ')';"
+	g, e := langdef.ParseString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var (
+		traced    int
+		tokens    []*Token
+		survivors []gr.Rule
+	)
+	trace := func(ts []*Token, rs []gr.Rule) {
+		traced++
+		tokens = ts
+		survivors = rs
+	}
+	p, _ := New(g, WithResolveTrace(trace))
+	_, e = p.ParseString("", "foo(bar)", nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if traced != 1 {
+		t.Fatalf("expecting 1 trace call, got %d", traced)
+	}
+	if len(tokens) != 2 || tokens[0].Text() != "foo" || tokens[1].Text() != "(" {
+		t.Fatalf("expecting foo ( lookahead tokens, got %v", tokens)
+	}
+	if len(survivors) != 1 || g.Nodes[survivors[0].Node].Name != "call" {
+		t.Fatalf("expecting single call rule, got %v", survivors)
+	}
+}
+
+func TestMapHook(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;]/; conf = {$name, '=', $num, ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "foo = 1; bar = 2; foo = 3;"
+	samples := []struct {
+		policy   DuplicateKeyPolicy
+		foo, bar string
+		err      int
+	}{
+		{KeepLastKey, "3", "2", 0},
+		{KeepFirstKey, "1", "2", 0},
+		{DuplicateKeyFails, "", "", DuplicateKeyError},
+	}
+
+	for i, s := range samples {
+		hs := &Hooks{Nodes: NodeHooks{"conf": MapHook[*Token]("name", "num", nil, s.policy)}}
+		res, e := p.ParseString("", src, hs)
+		if s.err != 0 {
+			le, f := e.(*llx.Error)
+			if !f || le.Code != s.err {
+				t.Errorf("sample #%d: expecting error code %d, got: %v", i, s.err, e)
+			}
+			continue
+		}
+
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		m := res.(map[string]*Token)
+		if len(m) != 2 || m["foo"].Text() != s.foo || m["bar"].Text() != s.bar {
+			t.Errorf("sample #%d: expecting foo=%s bar=%s, got %v", i, s.foo, s.bar, m)
+		}
+	}
+}
+
+func TestReplBoundaries(t *testing.T) {
+	grammar := "!aside $space; !extern $end; $space = /\\s+/; $name = /\\w+/; line = {$name}, $end;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	names := 0
+	hs := &Hooks{Tokens: TokenHooks{
+		"name": func(t *Token, pc *ParseContext) (bool, error) {
+			names++
+			return true, nil
+		},
+		EofToken: func(t *Token, pc *ParseContext) (bool, error) {
+			end, e := pc.MakeTokenAt("end", nil, t.Pos())
+			if e != nil {
+				return false, e
+			}
+			return false, pc.EmitToken(end)
+		},
+	}}
+
+	inputs := []string{"foo bar", "", "baz", ""}
+	expected := []int{2, 0, 1, 0}
+	q := source.NewQueue()
+	for _, input := range inputs {
+		q.AppendWithBoundary(source.New("repl", []byte(input)))
+	}
+	for i, n := range expected {
+		names = 0
+		_, e = p.Parse(q, hs)
+		if e != nil {
+			t.Fatalf("input #%d: unexpected error: %s", i, e)
+		}
+		if names != n {
+			t.Fatalf("input #%d: expecting %d names, got %d", i, n, names)
+		}
+	}
+	if !q.IsEmpty() {
+		t.Fatalf("expecting empty queue")
+	}
+}
+
+type posRecorder struct {
+	positions *[]string
+}
+
+func (r *posRecorder) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (r *posRecorder) HandleNode(node string, result any) error {
+	return nil
+}
+
+func (r *posRecorder) HandleToken(t *Token) error {
+	*r.positions = append(*r.positions, fmt.Sprintf("%s %d:%d", t.TypeName(), t.Line(), t.Col()))
+	return nil
+}
+
+func (r *posRecorder) EndNode() (result any, e error) {
+	return nil, nil
+}
+
+func TestMakeToken(t *testing.T) {
+	grammar := "!aside $space; !extern $begin $end; $space = /\\s+/; $name = /\\w+/; $op = /[(]/; " +
+		"g = {$name | $op | $begin | $end};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var positions []string
+	hs := &Hooks{
+		Tokens: TokenHooks{
+			"op": func(t *Token, pc *ParseContext) (bool, error) {
+				tok, e := pc.MakeToken("begin", nil)
+				if e == nil {
+					e = pc.EmitToken(tok)
+				}
+				return false, e
+			},
+			"name": func(t *Token, pc *ParseContext) (bool, error) {
+				if t.Text() == "x" {
+					return false, pc.EmitToken(lexer.NewToken(3, "end", nil, source.Pos{}))
+				}
+				return true, nil
+			},
+			EofToken: func(t *Token, pc *ParseContext) (bool, error) {
+				tok, e := pc.MakeTokenAt("end", nil, source.Pos{})
+				if e == nil {
+					e = pc.EmitToken(tok)
+				}
+				return false, e
+			},
+		},
+		Nodes: NodeHooks{AnyNode: func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+			return &posRecorder{&positions}, nil
+		}},
+	}
+
+	_, e = p.ParseString("src", "a (\n x", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "name 1:1, begin 1:3, end 2:2, end 2:3"
+	got := strings.Join(positions, ", ")
+	if got != expected {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+
+	p.ParseString("src", "a", &Hooks{Tokens: TokenHooks{"name": func(t *Token, pc *ParseContext) (bool, error) {
+		_, e = pc.MakeToken("unknown", nil)
+		return true, nil
+	}}})
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownTokenTypeError {
+		t.Errorf("expecting UnknownTokenTypeError, got %v", e)
+	}
+}
+
+type commentRecorder struct {
+	name   string
+	pc     *ParseContext
+	result *[]string
+}
+
+func commentTexts(ts []*Token) string {
+	texts := make([]string, len(ts))
+	for i, t := range ts {
+		texts[i] = t.Text()
+	}
+	return strings.Join(texts, " ")
+}
+
+func (r *commentRecorder) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (r *commentRecorder) HandleNode(node string, result any) error {
+	return nil
+}
+
+func (r *commentRecorder) HandleToken(t *Token) error {
+	return nil
+}
+
+func (r *commentRecorder) EndNode() (result any, e error) {
+	if trailing := r.pc.TrailingComments(); len(trailing) > 0 {
+		*r.result = append(*r.result, r.name+" < "+commentTexts(trailing))
+	}
+	return nil, nil
+}
+
+func TestComments(t *testing.T) {
+	grammar := "!aside $space $comment; $space = /\\s+/; $comment = /#[^\\n]*/; $name = /\\w+/; $op = /[=;]/; " +
+		"g = {stmt}; stmt = $name, '=', val, ';'; val = $name;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	hs := &Hooks{Nodes: NodeHooks{AnyNode: func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+		if leading := pc.LeadingComments(tok); len(leading) > 0 {
+			got = append(got, node+" > "+commentTexts(leading))
+		}
+		return &commentRecorder{node, pc, &got}, nil
+	}}}
+
+	src := "#a\n#b\nx = y; #c\n#d\nz = #e\n w; #f #g\n"
+	_, e = p.ParseString("", src, hs, WithComments("comment"))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "stmt > #a #b, stmt < #c, stmt > #d, val > #e, stmt < #f #g"
+	if strings.Join(got, ", ") != expected {
+		t.Errorf("expecting %q, got %q", expected, strings.Join(got, ", "))
+	}
+
+	got = nil
+	_, e = p.ParseString("", src, hs)
+	if e != nil || len(got) != 0 {
+		t.Errorf("expecting no comments, got %v (error: %v)", got, e)
+	}
+
+	_, e = p.ParseString("", src, hs, WithComments("space", "unknown"))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownTokenTypeError {
+		t.Errorf("expecting UnknownTokenTypeError, got %v", e)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[<>\\[\\]]/; " +
+		"g = {item}; item = $name | ('[', {item}, ']') | ('<', {item}, '>');"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	open := []string{"[", "<"}
+	close := []string{"]", ">"}
+	samples := []struct {
+		src       string
+		autoClose bool
+		col       int
+	}{
+		{"a [b <c> d]", false, 0},
+		{"a [b <c] d", false, 6},
+		{"a [b <c", false, 6},
+		{"a b]", false, 4},
+		{"a [b <c", true, 0},
+		{"a [b <c] d", true, 6},
+	}
+
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, nil, WithPairs(open, close, s.autoClose))
+		if s.col == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != UnbalancedPairError || ee.Col != s.col {
+			t.Errorf("sample #%d: expecting UnbalancedPairError at col %d, got %v", i, s.col, e)
+		}
+	}
+
+	_, e = p.ParseString("", "a [b <c] d", nil, WithPairs(open, close, false))
+	if ee, valid := e.(*llx.Error); !valid || len(ee.Related) != 1 || ee.Related[0].Span.Col != 8 {
+		t.Errorf("expecting related location at col 8, got %#v", e)
+	}
+
+	res, e := p.ParseString("", "[a <b", &Hooks{Nodes: testNodeHooks}, WithPairs(open, close, true))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	e = newTreeValidator(res.(*treeNode), "(item [ (item a) (item < (item b) >) ])").validate()
+	if e != nil {
+		t.Errorf("validation error: %s", e)
+	}
+
+	_, e = p.ParseString("", "a", nil, WithPairs([]string{"{"}, []string{"}"}, false))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownTokenLiteralError {
+		t.Errorf("expecting UnknownTokenLiteralError, got %v", e)
+	}
+}
+
+func TestReadUntil(t *testing.T) {
+	grammar := "!aside $space; !extern $text; $space = /\\s+/; $name = /\\w+/; $heredoc = /<<\\w+\\n/; $op = /[=;]/; " +
+		"g = {assign}; assign = $name, '=', ($name | ($heredoc, $text)), ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	hs := &Hooks{
+		Tokens: TokenHooks{"heredoc": func(tok *Token, pc *ParseContext) (bool, error) {
+			tag := strings.TrimSpace(tok.Text()[2:])
+			text, e := pc.ReadUntil("text", regexp.MustCompile("(?m)^"+regexp.QuoteMeta(tag)+"$"))
+			if e == nil {
+				got = append(got, text.Text())
+				e = pc.EmitToken(tok)
+			}
+			if e == nil {
+				e = pc.EmitToken(text)
+			}
+			return false, e
+		}},
+	}
+
+	src := "a = <<EOT\nb = <<EOF\nEOF\nEOT\n;\nd = <<EOF\n\nEOF\n;"
+	_, e = p.ParseString("", src, hs)
+	expected := "b = <<EOF\nEOF\n|\n"
+	if e != nil || strings.Join(got, "|") != expected {
+		t.Errorf("expecting %q, got %q (error: %v)", expected, got, e)
+	}
+
+	_, e = p.ParseString("", "a = <<EOT\nb = c;\n", hs)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnexpectedEoiError {
+		t.Errorf("expecting UnexpectedEoiError, got %v", e)
+	}
+
+	got = nil
+	_, e = p.ParseReader(context.Background(), "", iotest.OneByteReader(strings.NewReader(src)), hs)
+	if e != nil || strings.Join(got, "|") != expected {
+		t.Errorf("reader: expecting %q, got %q (error: %v)", expected, got, e)
+	}
+
+	got = nil
+	_, e = p.ParseReader(context.Background(), "", iotest.OneByteReader(strings.NewReader("a = <<EOT\nb = c\nEOTX\nEOT\n;\n")), hs)
+	expected = "b = c\nEOTX\n"
+	if e != nil || strings.Join(got, "|") != expected {
+		t.Errorf("reader: expecting %q, got %q (error: %v)", expected, got, e)
+	}
+
+	_, e = p.ParseReader(context.Background(), "", iotest.OneByteReader(strings.NewReader("a = <<EOT\nb = c;\n")), hs)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnexpectedEoiError {
+		t.Errorf("reader: expecting UnexpectedEoiError, got %v", e)
+	}
+}
+
+func TestReuse(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[;]/; g = {$name, ';'};"
+	p, e := FromString("", grammar, WithReuse())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100 && errs[i] == nil; j++ {
+				src := strings.Repeat("foo; ", i+j)
+				hs := &Hooks{Nodes: testNodeHooks}
+				if j%2 == 0 {
+					src += "bar"
+					hs = nil
+				}
+
+				res, e := p.ParseString("", src, hs)
+				switch {
+				case j%2 == 0 && e == nil:
+					errs[i] = errors.New("expecting error")
+				case j%2 != 0 && e != nil:
+					errs[i] = e
+				case j%2 != 0 && len(res.(*treeNode).children) != (i+j)*2:
+					errs[i] = fmt.Errorf("expecting %d tokens, got %d", (i+j)*2, len(res.(*treeNode).children))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, e := range errs {
+		if e != nil {
+			t.Errorf("goroutine #%d: %s", i, e)
+		}
+	}
+}
+
+func BenchmarkReuse(b *testing.B) {
+	grammar := spaceDef + "$num = /\\d+/; $name = /\\w+/; $op = /[=;]/; g = {$name, '=', $num, ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		b.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "foo = 1; bar = 2;"
+	samples := []struct {
+		name string
+		opts []ParseOption
+	}{
+		{"default", nil},
+		{"reuse", []ParseOption{WithReuse()}},
+	}
+	for _, sample := range samples {
+		b.Run(sample.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, e := p.ParseString("", src, nil, sample.opts...)
+					if e != nil {
+						b.Errorf("unexpected error: %s", e)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkReserveCapacity(b *testing.B) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[;.]/; g = {a | b}; a = $name, {$name}, ';'; b = $name, {$name}, '.';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		b.Fatalf("unexpected error: %s", e)
+	}
+
+	src := strings.Repeat("foo ", 1000) + "."
+	samples := []struct {
+		name string
+		opts []ParseOption
+	}{
+		{"default", nil},
+		{"reserved", []ParseOption{WithReserveCapacity(1000)}},
+	}
+	for _, sample := range samples {
+		b.Run(sample.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, e := p.ParseString("", src, nil, sample.opts...)
+				if e != nil {
+					b.Fatalf("unexpected error: %s", e)
+				}
+			}
+		})
+	}
+}
+
+type rootResultHook struct {
+	defaultHookInstance
+}
+
+func (rootResultHook) EndNode() (any, error) {
+	return "root", nil
+}
+
+func TestRootUnwrap(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[;]/; g = {item}, [';']; item = $num;"
+	rootCalled := false
+	hs := &Hooks{Nodes: NodeHooks{
+		"g": func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+			rootCalled = true
+			return &rootResultHook{}, nil
+		},
+		"item": func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+			return &defaultHookInstance{tok}, nil
+		},
+	}}
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	r, e := p.ParseString("", "12;", hs)
+	if e != nil || r != "root" {
+		t.Fatalf("expecting root hook result, got %v (error: %v)", r, e)
+	}
+
+	p, e = FromString("", grammar, WithRootUnwrap())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	rootCalled = false
+	r, e = p.ParseString("", "12;", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	tok, valid := r.(*Token)
+	if !valid || tok.Text() != "12" {
+		t.Fatalf("expecting 12 token, got %v", r)
+	}
+	if !rootCalled {
+		t.Error("expecting root hook to be called")
+	}
+
+	samples := []string{"", ";", "1 2"}
+	for _, src := range samples {
+		_, e = p.ParseString("", src, hs)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != RootUnwrapError {
+			t.Errorf("sample %q: expecting RootUnwrapError, got %v", src, e)
+		}
+	}
+}
+
+func TestTokenAccept(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $op = /[;.]/; g = {decl}, {$name}, '.'; decl = 'var', $name, ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	declared := map[string]bool{}
+	hs := &Hooks{
+		TokenAccept: TokenPredicates{"name": func(tok *Token, pc *ParseContext) bool {
+			return declared[tok.Text()] || pc.node.prev != nil
+		}},
+		Nodes: NodeHooks{"decl": func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+			return &declHook{declared}, nil
+		}},
+	}
+
+	samples := []srcErrSample{
+		{"var a; var b; a b a.", 0},
+		{"var a; b.", UnexpectedTokenError},
+		{"var a; a var b; b.", UnexpectedTokenError},
+	}
+	for i, sample := range samples {
+		for k := range declared {
+			delete(declared, k)
+		}
+		_, e = p.ParseString("", sample.src, hs)
+		if sample.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
+
+type declHook struct {
+	declared map[string]bool
+}
+
+func (dh *declHook) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (dh *declHook) HandleNode(node string, result any) error {
+	return nil
+}
+
+func (dh *declHook) HandleToken(token *Token) error {
+	if token.TypeName() == "name" {
+		dh.declared[token.Text()] = true
+	}
+	return nil
+}
+
+func (dh *declHook) EndNode() (result any, e error) {
+	return nil, nil
+}
+
+func TestContext(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; g = {$name};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	huge := strings.Repeat("x", 4<<20)
+	_, e = p.ParseString("", huge, nil, WithContext(ctx))
+	if e != context.DeadlineExceeded {
+		t.Errorf("expecting deadline exceeded error, got %v", e)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	cnt := 0
+	hs := &Hooks{Tokens: TokenHooks{"space": func(tok *Token, pc *ParseContext) (bool, error) {
+		cnt++
+		cancel()
+		return false, nil
+	}}}
+	_, e = p.ParseString("", huge+" "+huge+" "+huge, hs, WithContext(ctx))
+	if e != context.Canceled {
+		t.Errorf("expecting canceled error, got %v", e)
+	}
+	if cnt != 1 {
+		t.Errorf("expecting 1 space token, got %d", cnt)
+	}
+}
+
+// stallReader returns its data and then never reaches the end.
+type stallReader struct {
+	data []byte
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		time.Sleep(time.Millisecond)
+		return 0, nil
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestParseReader(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /;/; g = {$name, ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := strings.Repeat("foo; ", 2000)
+	res, e := p.ParseReader(context.Background(), "", iotest.HalfReader(strings.NewReader(src)), &Hooks{Nodes: testNodeHooks})
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if len(res.(*treeNode).children) != 4000 {
+		t.Errorf("expecting 4000 tokens, got %d", len(res.(*treeNode).children))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e = p.ParseReader(ctx, "", strings.NewReader(src), nil)
+	if e != context.Canceled {
+		t.Errorf("expecting canceled error, got %v", e)
+	}
+
+	_, e = p.ParseReader(context.Background(), "", iotest.TimeoutReader(strings.NewReader(src)), nil)
+	if e != iotest.ErrTimeout {
+		t.Errorf("expecting timeout error, got %v", e)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("foo; "))
+		pw.CloseWithError(iotest.ErrTimeout)
+	}()
+	_, e = p.ParseReader(context.Background(), "", pr, nil)
+	if e != iotest.ErrTimeout {
+		t.Errorf("expecting timeout error, got %v", e)
+	}
+
+	_, e = p.ParseReader(context.Background(), "", &stallReader{[]byte("foo bar ")}, nil)
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnexpectedTokenError {
+		t.Errorf("expecting UnexpectedTokenError before read error, got %v", e)
+	}
+
+	f := p.NewFeeder(context.Background(), "", &Hooks{Nodes: testNodeHooks})
+	for _, chunk := range []string{"fo", "o; b", "ar", ";"} {
+		f.Feed([]byte(chunk))
+	}
+	res, e = f.Finish()
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	e = newTreeValidator(res.(*treeNode), "foo ; bar ;").validate()
+	if e != nil {
+		t.Errorf("validation error: %s", e)
+	}
+}
+
+func TestFeeder(t *testing.T) {
+	grammar := "!aside $space $comment; $space = /\\s+/; $comment = /\\(\\*.*?\\*\\)/; $name = /\\w+/; $op = /;/; g = {stmt}; stmt = $name, ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	fetched := make(chan string, 10)
+	hs := &Hooks{Tokens: TokenHooks{"name": func(tok *Token, pc *ParseContext) (bool, error) {
+		fetched <- fmt.Sprintf("%s@%d:%d", tok.Text(), tok.Line(), tok.Col())
+		return true, nil
+	}}}
+	expect := func(expected string) {
+		select {
+		case got := <-fetched:
+			if got != expected {
+				t.Errorf("expecting %q, got %q", expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %q to be fetched before Finish", expected)
+		}
+	}
+
+	f := p.NewFeeder(context.Background(), "src", hs)
+	f.Feed([]byte("foo;\nba"))
+	expect("foo@1:1")
+	f.Feed([]byte("r; (* multi\n"))
+	expect("bar@2:1")
+	f.Feed([]byte("line *) qux"))
+	f.Feed([]byte("; baz baz"))
+	expect("qux@3:9")
+	_, e = f.Finish()
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnexpectedTokenError || ee.Line != 3 || ee.Col != 18 {
+		t.Errorf("expecting UnexpectedTokenError at 3:18, got %v", e)
+	}
+	if e = f.Feed([]byte("foo;")); e == nil {
+		t.Errorf("expecting parsing error to be returned by Feed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f = p.NewFeeder(ctx, "src", hs)
+	f.Feed([]byte("foo"))
+	cancel()
+	select {
+	case <-f.done:
+		if f.err != context.Canceled {
+			t.Errorf("expecting canceled error, got %v", f.err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expecting parsing goroutine to exit when context is done")
+	}
+}
+
+func TestMaxNodes(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[()]/; g = {item}; item = $num | list; list = '(', {item}, ')';"
+	p, e := FromString("", grammar, WithMaxNodes(9))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []srcErrSample{
+		{"1 (2 (3)) 4", 0},
+		{"1 (2 (3)) (4)", TreeTooLargeError},
+		{"((((((((((1))))))))))", TreeTooLargeError},
+	}
+	for i, sample := range samples {
+		_, e = p.ParseString("", sample.src, nil)
+		if sample.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+
+	_, e = p.ParseString("", "1 (2 (3)) (4)", nil, WithMaxNodes(0))
+	if e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	grammar := "$nl = /\\n/; $name = /\\w+/; $inc = /@\\w+/; g = {line}; line = ($name | $inc), $nl;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := source.New("src", []byte("a\n@b\nc"))
+	q := source.NewQueue().Append(src)
+	hs := &Hooks{Tokens: TokenHooks{"inc": func(tok *Token, pc *ParseContext) (bool, error) {
+		q.Prepend(source.New(tok.Text(), []byte("d")))
+		return false, nil
+	}}}
+	_, e = p.Parse(q, hs)
+	if e == nil {
+		t.Fatal("expecting error without WithFinalNewline")
+	}
+
+	q = source.NewQueue().Append(src)
+	_, e = p.Parse(q, hs, WithFinalNewline())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if string(src.Content()) != "a\n@b\nc" {
+		t.Errorf("source content must not be modified, got %q", src.Content())
+	}
+
+	q = source.NewQueue().Append(src)
+	_, e = p.Parse(q, hs)
+	if e == nil {
+		t.Fatal("expecting error without WithFinalNewline after parsing with it")
+	}
+}
+
+func TestMaxInputBytes(t *testing.T) {
+	grammar := "!aside $space $inc; $space = /\\s+/; $inc = /@\\w+/; $name = /\\w+/; g = {$name};"
+	p, e := FromString("", grammar, WithMaxInputBytes(20))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []srcErrSample{
+		{"@x @x", 0},
+		{"@x @x @x", InputTooLargeError},
+		{"abcdefghij abcdefghij", InputTooLargeError},
+	}
+	for i, sample := range samples {
+		q := source.NewQueue().Append(source.New("", []byte(sample.src)))
+		hs := &Hooks{Tokens: TokenHooks{"inc": func(tok *Token, pc *ParseContext) (bool, error) {
+			q.Prepend(source.New(tok.Text(), []byte("a b c")))
+			return false, nil
+		}}}
+		_, e = p.Parse(q, hs)
+		if sample.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; g = {$num};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := strings.Repeat("12 ", 500)
+	var reports [][2]int
+	q := source.NewQueue().Append(source.New("first", []byte(src))).Append(source.New("second", []byte(src)))
+	_, e = p.Parse(q, nil, WithProgress(func(consumed, total int) {
+		reports = append(reports, [2]int{consumed, total})
+	}))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	if len(reports) < 3 {
+		t.Fatalf("expecting at least 3 reports, got %d", len(reports))
+	}
+	last := 0
+	for i, r := range reports {
+		if r[1] != len(src)*2 || r[0] < last || r[0] > r[1] {
+			t.Errorf("report #%d: unexpected %d/%d", i, r[0], r[1])
+		}
+		last = r[0]
+	}
+	if last != len(src)*2 {
+		t.Errorf("expecting final report %d, got %d", len(src)*2, last)
+	}
+}
+
+func TestLastTokenGroup(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $name = /\\w+/; !group $name; g = {$num | $name};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	groups := make([]string, 0)
+	hook := func(tok *Token, pc *ParseContext) (bool, error) {
+		groups = append(groups, fmt.Sprintf("%s:%d", tok.Text(), pc.LastTokenGroup()))
+		return true, nil
+	}
+	hs := &Hooks{Tokens: TokenHooks{"num": hook, "name": hook}}
+	_, e = p.ParseString("", "12 a1 3", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "12:0 a1:1 3:0"
+	got := strings.Join(groups, " ")
+	if got != expected {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+
+	grammar = spaceDef + "$num = /\\d+/; $name = /\\w+/; !group $name; g = {a | b}; a = $num, $name; b = $num, $num;"
+	p, e = FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	groups = groups[:0]
+	var discard []string
+	hs = &Hooks{Nodes: NodeHooks{AnyNode: func(node string, tok *Token, pc *ParseContext) (NodeHookInstance, error) {
+		groups = append(groups, fmt.Sprintf("%s:%s:%d", node, tok.Text(), tok.Group()))
+		return nthi{node, &discard}, nil
+	}}}
+	_, e = p.ParseString("", "12 a1 3 4", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected = "g::0 a:12:0 b:3:0"
+	got = strings.Join(groups, " ")
+	if got != expected {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+}
+
+func TestSetGroup(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[:;]/; $name = /\\w+/; !group $name; g = {$num | $name | ':' | ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	types := make([]string, 0)
+	hook := func(tok *Token, pc *ParseContext) (bool, error) {
+		types = append(types, tok.TypeName())
+		return true, nil
+	}
+	hs := &Hooks{
+		Tokens: TokenHooks{"num": hook, "name": hook},
+		Literals: TokenHooks{
+			":": func(tok *Token, pc *ParseContext) (bool, error) {
+				return true, pc.SetTokenGroup("name")
+			},
+			";": func(tok *Token, pc *ParseContext) (bool, error) {
+				return true, pc.SetGroup(-1)
+			},
+		},
+	}
+	_, e = p.ParseString("", "1 : 2 3 ; 4", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "num name name num"
+	got := strings.Join(types, " ")
+	if got != expected {
+		t.Errorf("expecting %q, got %q", expected, got)
+	}
+
+	hs.Literals[":"] = func(tok *Token, pc *ParseContext) (bool, error) {
+		return true, pc.SetGroup(2)
+	}
+	_, e = p.ParseString("", "1 : 2", hs)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownGroupError {
+		t.Errorf("expecting UnknownGroupError, got %v", e)
+	}
+}
+
+func TestGroupTokenHooks(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[:;]/; $name = /\\w+/; !group $name; g = {$num | $name | ':' | ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	record := func(prefix string) TokenHook {
+		return func(tok *Token, pc *ParseContext) (bool, error) {
+			if tok.Type() >= 0 && tok.TypeName() != "space" {
+				got = append(got, prefix+tok.Text())
+			}
+			return true, nil
+		}
+	}
+	hs := &Hooks{
+		Tokens:   TokenHooks{"num": record("num:"), AnyToken: record("any:")},
+		Literals: TokenHooks{":": record("lit:")},
+		Groups:   GroupTokenHooks{0: {"num": record("g0num:")}, 1: {AnyToken: record("g1:")}},
+	}
+	_, e = p.ParseString("", "1 a : 2 ;", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "g0num:1 g1:a lit:: g0num:2 any:;"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expecting %q, got %q", expected, strings.Join(got, " "))
+	}
+
+	samples := []struct {
+		groups GroupTokenHooks
+		code   int
+	}{
+		{GroupTokenHooks{1: {"num": record("")}}, WrongGroupError},
+		{GroupTokenHooks{2: {AnyToken: record("")}}, UnknownGroupError},
+		{GroupTokenHooks{0: {"foo": record("")}}, UnknownTokenTypeError},
+	}
+	for i, s := range samples {
+		_, e = p.ParseString("", "1", &Hooks{Groups: s.groups})
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != s.code {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.code, e)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;?]/; " +
+		"g = {stmt}; stmt = assign | junk; assign = $name, '=', $num, ';'; junk = '?';"
+	p, e := FromString("", grammar, WithErrorRecovery("junk"))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	samples := []srcExprSample{
+		{"a = 1;", "(stmt (assign a = 1 ;))"},
+		{"a = 1; b 2; c = 3;", "(stmt (assign a = 1 ;)) (stmt (assign b (junk 2 ;))) (stmt (assign c = 3 ;))"},
+		{"a = ; 3; b = 2;", "(stmt (assign a = (junk ;) 3 ;)) (stmt (assign b = 2 ;))"},
+		{"a = 1 2 = 3; c = 3;", "(stmt (assign a = 1 (junk 2 = 3) ;)) (stmt (assign c = 3 ;))"},
+	}
+	for i, sample := range samples {
+		r, e := p.ParseString("", sample.src, hs)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		e = newTreeValidator(r.(*treeNode), sample.expr).validate()
+		if e != nil {
+			t.Errorf("sample #%d: validation error: %s", i, e)
+		}
+	}
+
+	_, e = p.ParseString("", "a = 1; b =", hs)
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnexpectedEoiError {
+		t.Errorf("expecting UnexpectedEoiError, got %v", e)
+	}
+
+	_, e = p.ParseString("", "a = 1;", hs, WithErrorRecovery("unknown"))
+	ee, valid = e.(*llx.Error)
+	if !valid || ee.Code != UnknownNodeError {
+		t.Errorf("expecting UnknownNodeError, got %v", e)
+	}
+}
+
+func TestErrorRecoverySync(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;?]/; " +
+		"g = {stmt}; stmt = assign | junk; assign = $name, '=', $num, ';'; junk = '?';"
+	p, e := FromString("", grammar, WithErrorRecovery("junk"), WithSyncTokens(";"))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	samples := []srcExprSample{
+		{"a = 1;", "(stmt (assign a = 1 ;))"},
+		{"a = x 3; b = 2;", "(stmt (assign a = (junk x 3 ;))) (stmt (assign b = 2 ;))"},
+		{"a = 1 2 = 3; c = 3;", "(stmt (assign a = 1 (junk 2 = 3 ;))) (stmt (assign c = 3 ;))"},
+	}
+	for i, sample := range samples {
+		r, e := p.ParseString("", sample.src, hs)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		e = newTreeValidator(r.(*treeNode), sample.expr).validate()
+		if e != nil {
+			t.Errorf("sample #%d: validation error: %s", i, e)
+		}
+	}
+
+	_, e = p.ParseString("", "a = 1;", hs, WithSyncTokens("$unknown"))
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnknownTokenTypeError {
+		t.Errorf("expecting UnknownTokenTypeError, got %v", e)
+	}
+
+	_, e = p.ParseString("", "a = 1;", hs, WithSyncTokens("}"))
+	ee, valid = e.(*llx.Error)
+	if !valid || ee.Code != UnknownTokenLiteralError {
+		t.Errorf("expecting UnknownTokenLiteralError, got %v", e)
+	}
+}
+
+func TestErrorCollection(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;?]/; " +
+		"g = {stmt}; stmt = assign | junk; assign = $name, '=', $num, ';'; junk = '?';"
+	p, e := FromString("", grammar, WithErrorRecovery("junk"), WithSyncTokens(";"))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	src := "a = 1 2; b 3; c = 3;"
+	r, e := p.ParseString("", src, hs, WithErrorCollection(0))
+	el, valid := e.(ErrorList)
+	if !valid || len(el) != 2 || el[0].Line != 1 || el[0].Col != 7 || el[1].Col != 12 {
+		t.Fatalf("expecting ErrorList of 2 errors, got %v", e)
+	}
+	for _, ee := range el {
+		if ee.Code != UnexpectedTokenError {
+			t.Errorf("expecting UnexpectedTokenError, got %v", ee)
+		}
+	}
+	e = newTreeValidator(r.(*treeNode), "(stmt (assign a = 1 (junk 2 ;))) (stmt (assign b (junk 3 ;))) (stmt (assign c = 3 ;))").validate()
+	if e != nil {
+		t.Errorf("validation error: %s", e)
+	}
+
+	r, e = p.ParseString("", src, hs, WithErrorCollection(1))
+	el, valid = e.(ErrorList)
+	if r != nil || !valid || len(el) != 1 {
+		t.Errorf("expecting ErrorList of 1 error and no result, got %v, %v", r, e)
+	}
+
+	_, e = p.ParseString("", "a 1; b =", hs, WithErrorCollection(0))
+	el, valid = e.(ErrorList)
+	if !valid || len(el) != 2 || el[1].Code != UnexpectedEoiError {
+		t.Errorf("expecting ErrorList ending with UnexpectedEoiError, got %v", e)
+	}
+
+	r, e = p.ParseString("", "a = 1;", hs, WithErrorCollection(0))
+	if e != nil || r == nil {
+		t.Errorf("expecting result and no error, got %v, %v", r, e)
+	}
+
+	hookError := errors.New("hook error")
+	stopHooks := &Hooks{Tokens: TokenHooks{"name": func(tok *Token, pc *ParseContext) (bool, error) {
+		if tok.Text() == "c" {
+			return false, hookError
+		}
+		return true, nil
+	}}}
+	samples := []struct {
+		src  string
+		hs   *Hooks
+		code int
+	}{
+		{"a = 1 2; b = 3; %", hs, lexer.WrongCharError},
+		{"a = 1 2; b = 3; c = 4;", stopHooks, StopError},
+	}
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, s.hs, WithErrorCollection(0))
+		el, valid = e.(ErrorList)
+		if !valid || len(el) != 2 || el[0].Code != UnexpectedTokenError || el[1].Code != s.code {
+			t.Errorf("sample #%d: expecting ErrorList ending with error code %d, got %v", i, s.code, e)
+		}
+	}
+	if len(el) == 2 && el[1].Text != hookError.Error() {
+		t.Errorf("expecting hook error message, got %q", el[1].Text)
+	}
+}
+
+func TestManyTokenTypes(t *testing.T) {
+	const total = 150
+	var b strings.Builder
+	b.WriteString(spaceDef)
+	names := make([]string, total)
+	for i := 0; i < total; i++ {
+		fmt.Fprintf(&b, "$t%d = /t%d\\b/; ", i, i)
+		names[i] = fmt.Sprintf("$t%d", i)
+	}
+	b.WriteString("g = {" + strings.Join(names, " | ") + "};")
+
+	p, e := FromString("", b.String())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var types []string
+	hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+		if tok.TypeName() != "space" {
+			types = append(types, tok.TypeName())
+		}
+		return true, nil
+	}}}
+	q := source.NewQueue().Append(source.New("", []byte("t0 t149 t64 t100")))
+	_, e = p.Parse(q, hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "t0 t149 t64 t100"
+	if strings.Join(types, " ") != expected || !q.IsEmpty() {
+		t.Errorf("expecting %q tokens, got %q", expected, types)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	grammar := "!aside $space $dim; !extern $num $unit; $space = /\\s+/; $dim = /\\w+/; g = {$num, $unit};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	re := regexp.MustCompile("^(?:(\\d+)|([a-z]+))")
+	var tokens []*Token
+	hs := &Hooks{Tokens: TokenHooks{"dim": func(tok *Token, pc *ParseContext) (bool, error) {
+		ts, e := pc.Split(tok, re, "num", "unit")
+		for _, st := range ts {
+			if e == nil {
+				e = pc.EmitToken(st)
+			}
+		}
+		tokens = append(tokens, ts...)
+		return false, e
+	}}}
+
+	_, e = p.ParseString("", "12px\n 3em", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "num 12 1:1, unit px 1:3, num 3 2:2, unit em 2:3"
+	got := make([]string, len(tokens))
+	for i, tok := range tokens {
+		got[i] = fmt.Sprintf("%s %s %d:%d", tok.TypeName(), tok.Text(), tok.Line(), tok.Col())
+	}
+	if strings.Join(got, ", ") != expected {
+		t.Errorf("expecting %q, got %q", expected, strings.Join(got, ", "))
+	}
+
+	_, e = p.ParseString("", "12_", hs)
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != SplitTokenError {
+		t.Errorf("expecting SplitTokenError, got %v", e)
+	}
+}
+
+func TestFirstTokens(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[-()]/; " +
+		"g = {expr}; expr = ['-'], val; val = $num | call; call = $name, '(', ')';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := map[string]string{
+		"g":       "$name $num -",
+		"expr":    "$name $num -",
+		"val":     "$name $num",
+		"call":    "$name",
+		"unknown": "",
+	}
+	for name, expected := range samples {
+		got := strings.Join(p.FirstTokens(name), " ")
+		if got != expected {
+			t.Errorf("node %s: expecting %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestCaselessNode(t *testing.T) {
+	grammar := spaceDef + "$name = /[A-Za-z]+/; !caseless select; " +
+		"query = stmt, from; stmt = select; select = 'select', $name; from = 'from', $name;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []srcErrSample{
+		{"select foo from bar", 0},
+		{"SELECT foo from bar", 0},
+		{"SeLeCt foo from bar", 0},
+		{"select foo FROM bar", UnexpectedTokenError},
+	}
+	for i, sample := range samples {
+		_, e = p.ParseString("", sample.src, nil)
+		if sample.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != sample.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, sample.err, e)
+		}
+	}
+}
+
+func TestCaselessLiterals(t *testing.T) {
+	grammars := []string{
+		spaceDef + "!caseless-literals; $name = /[A-Za-z]+/; query = 'select', $name, 'from', $name;",
+		spaceDef + "!caseless-literals; !caseless $name; $name = /[A-Za-z]+/; query = 'select', $name, 'from', $name;",
+	}
+	samples := []srcErrSample{
+		{"select foo from bar", 0},
+		{"SELECT foo FROM bar", 0},
+		{"SeLeCt foo fRoM bar", 0},
+		{"select foo bar baz", UnexpectedTokenError},
+	}
+
+	for gi, grammar := range grammars {
+		p, e := FromString("", grammar)
+		if e != nil {
+			t.Fatalf("grammar #%d: unexpected error: %s", gi, e)
+		}
+
+		for i, sample := range samples {
+			_, e = p.ParseString("", sample.src, nil)
+			if sample.err == 0 {
+				if e != nil {
+					t.Errorf("grammar #%d, sample #%d: unexpected error: %s", gi, i, e)
+				}
+				continue
+			}
+
+			ee, valid := e.(*llx.Error)
+			if !valid || ee.Code != sample.err {
+				t.Errorf("grammar #%d, sample #%d: expecting error code %d, got %v", gi, i, sample.err, e)
+			}
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[-+^<\\[\\];]/; !prec '<'; !left '+' '-'; !right '^'; " +
+		"g = {expr, ';'}; expr = (expr, ('+' | '-' | '^' | '<'), expr) | $num | ('[', expr, ']');"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	samples := []srcExprSample{
+		{"1;", "(expr (expr-2 (expr-3 (expr-4 1)))) ;"},
+		{"1+2-3;", "(expr (expr-2 (expr-3 (expr-4 1)) + (expr-3 (expr-4 2)) - (expr-3 (expr-4 3)))) ;"},
+		{"1^2^3;", "(expr (expr-2 (expr-3 (expr-4 1) ^ (expr-3 (expr-4 2) ^ (expr-3 (expr-4 3)))))) ;"},
+		{"1<2+3;", "(expr (expr-2 (expr-3 (expr-4 1))) < (expr-2 (expr-3 (expr-4 2)) + (expr-3 (expr-4 3)))) ;"},
+		{"[1<2];", "(expr (expr-2 (expr-3 (expr-4 [ (expr (expr-2 (expr-3 (expr-4 1))) < (expr-2 (expr-3 (expr-4 2)))) ])))) ;"},
+	}
+	for i, sample := range samples {
+		r, e := p.ParseString("", sample.src, hs)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		e = newTreeValidator(r.(*treeNode), sample.expr).validate()
+		if e != nil {
+			t.Errorf("sample #%d: validation error: %s", i, e)
+		}
+	}
+
+	_, e = p.ParseString("", "1<2<3;", hs)
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnexpectedTokenError {
+		t.Errorf("expecting UnexpectedTokenError, got %v", e)
+	}
+}
+
+func TestPreamble(t *testing.T) {
+	grammar := "!aside $space $shebang; !preamble $shebang; $space = /\\s+/; $shebang = /#![^\\n]*\\n/; " +
+		"$op = /[#!]/; $name = /\\w+/; g = {$name | '#' | '!'};"
+	samples := []struct {
+		src, expected string
+	}{
+		{"#!/bin/sh\nfoo", "shebang name"},
+		{"foo #!bar\n", "name op op name"},
+		{" #!foo", "op op name"},
+	}
+	for _, dfa := range []bool{false, true} {
+		var opts []ParseOption
+		if dfa {
+			opts = append(opts, WithDFALexer())
+		}
+		p, e := FromString("", grammar, opts...)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		for i, s := range samples {
+			var got []string
+			hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+				if tok.Type() >= 0 && tok.TypeName() != "space" {
+					got = append(got, tok.TypeName())
+				}
+				return true, nil
+			}}}
+			_, e = p.ParseString("", s.src, hs)
+			if e != nil {
+				t.Errorf("dfa %v, sample #%d: unexpected error: %s", dfa, i, e)
+			} else if strings.Join(got, " ") != s.expected {
+				t.Errorf("dfa %v, sample #%d: expecting %q, got %q", dfa, i, s.expected, strings.Join(got, " "))
+			}
+		}
+	}
+}
+
+func TestPreambleStream(t *testing.T) {
+	grammar := "!aside $space $nl $shebang; !preamble $shebang; $space = /[ \\t]+/; $nl = /\\n/; " +
+		"$shebang = /#![^\\n]*\\n/; $op = /[#!]/; $name = /\\w+/; g = {$name | '#' | '!'};"
+	samples := []struct {
+		chunks   []string
+		expected string
+	}{
+		{[]string{"#!/bin/sh\n", "foo"}, "shebang name"},
+		{[]string{"foo\n", "#!bar\n"}, "name nl op op name nl"},
+		{[]string{"fo", "o #!bar\n"}, "name op op name nl"},
+	}
+	for _, dfa := range []bool{false, true} {
+		var opts []ParseOption
+		if dfa {
+			opts = append(opts, WithDFALexer())
+		}
+		p, e := FromString("", grammar, opts...)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		var got []string
+		hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+			if tok.Type() >= 0 && tok.TypeName() != "space" {
+				got = append(got, tok.TypeName())
+			}
+			return true, nil
+		}}}
+		check := func(mode string, i int, e error) {
+			if e != nil {
+				t.Errorf("dfa %v, %s, sample #%d: unexpected error: %s", dfa, mode, i, e)
+			} else if strings.Join(got, " ") != samples[i].expected {
+				t.Errorf("dfa %v, %s, sample #%d: expecting %q, got %q", dfa, mode, i, samples[i].expected, strings.Join(got, " "))
+			}
+		}
+
+		for i, s := range samples {
+			src := strings.Join(s.chunks, "")
+
+			got = nil
+			_, e = p.ParseReader(context.Background(), "", iotest.OneByteReader(strings.NewReader(src)), hs)
+			check("reader", i, e)
+
+			got = nil
+			f := p.NewFeeder(context.Background(), "", hs)
+			for _, chunk := range s.chunks {
+				f.Feed([]byte(chunk))
+			}
+			_, e = f.Finish()
+			check("feeder", i, e)
+		}
+	}
+}
+
+func TestValidateTokenTypes(t *testing.T) {
+	grammar := "!aside $space; !extern $indent $dedent; !error $wrong; $space = /\\s+/; $name = /\\w+/; $wrong = /\\W/; " +
+		"g = {$name | $indent | $dedent | 'foo'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	e = p.ValidateTokenTypes()
+	if e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+	e = p.ValidateTokenTypes("indent", "dedent", "name", "space")
+	if e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+
+	samples := []string{"unknown", "foo", "wrong", AnyToken, EofToken}
+	for _, name := range samples {
+		e = p.ValidateTokenTypes("indent", name)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != UnknownTokenTypeError {
+			t.Errorf("%s: expecting UnknownTokenTypeError, got %v", name, e)
+		}
+	}
+}
+
+func TestStartNode(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[;=]/; " +
+		"prog = {stmt}; stmt = $name, '=', val, ';'; val = $num | $name;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	q := source.NewQueue().Append(source.New("", []byte("a = 1; b = 2;")))
+	q.Seek(7)
+	res, e := p.Parse(q, &Hooks{Nodes: testNodeHooks}, WithStartNode("stmt"))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	e = newTreeValidator(res.(*treeNode), "b = (val 2) ;").validate()
+	if e != nil {
+		t.Errorf("validation error: %s", e)
+	}
+
+	_, e = p.ParseString("", "a = 1;", nil, WithStartNode("unknown"))
+	ee, valid := e.(*llx.Error)
+	if !valid || ee.Code != UnknownNodeError {
+		t.Errorf("expecting UnknownNodeError, got %v", e)
+	}
+}
+
+type countingHook struct {
+	NodeHookInstance
+	ends *int
+}
+
+func (ch countingHook) EndNode() (any, error) {
+	*ch.ends++
+	return ch.NodeHookInstance.EndNode()
+}
+
+func TestMiddleware(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[;]/; g = {stmt}; stmt = $name, {$name}, ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var (
+		log        []string
+		tokens     int
+		nodes      int
+		ends       int
+		innerNodes int
+	)
+	counter := Middleware{
+		Token: func(token *Token, pc *ParseContext, next TokenHook) (bool, error) {
+			tokens++
+			return next(token, pc)
+		},
+		Node: func(node string, token *Token, pc *ParseContext, next NodeHook) (NodeHookInstance, error) {
+			nodes++
+			nhi, e := next(node, token, pc)
+			return countingHook{nhi, &ends}, e
+		},
+	}
+	rewriter := Middleware{
+		Token: func(token *Token, pc *ParseContext, next TokenHook) (bool, error) {
+			log = append(log, token.Text())
+			if token.Text() != "x" {
+				return next(token, pc)
+			}
+
+			y, e := pc.MakeToken(token.TypeName(), []byte("y"))
+			if e == nil {
+				e = pc.EmitToken(y)
+			}
+			return false, e
+		},
+		Node: func(node string, token *Token, pc *ParseContext, next NodeHook) (NodeHookInstance, error) {
+			innerNodes++
+			return next(node, token, pc)
+		},
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	res, e := p.ParseString("", "a x; x b;", hs, WithMiddleware(counter, rewriter))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	e = newTreeValidator(res.(*treeNode), "(stmt a y ;) (stmt y b ;)").validate()
+	if e != nil {
+		t.Error(e)
+	}
+	if tokens != 10 || strings.Join(log, "") != "a x; x b;" {
+		t.Errorf("unexpected tokens: %d %q", tokens, log)
+	}
+	if nodes != 3 || ends != 3 || innerNodes != 3 {
+		t.Errorf("unexpected node counters: %d %d %d", nodes, ends, innerNodes)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[;=]/; g = {stmt}; stmt = ($name, ';') | ($name, '=', $name, ';');"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var b strings.Builder
+	_, e = p.ParseString("src", "a = b; c;", nil, WithTrace(&b))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := []string{
+		"push node g",
+		"fetched token $name \"a\" at 1:1",
+		"node g state 0: token $name \"a\" at 1:1, keys [$name, any], rule $name push stmt -> state 0",
+		"  push node stmt",
+		"  resolve [$name -> state 2; $name -> state 3] using [$name \"a\" at 1:1, $op \"=\" at 1:3]: chosen $name -> state 3",
+		"  node stmt state 3: token $op \"=\" at 1:3, keys [\"=\", $op, any], rule \"=\" -> state 4",
+		"  drop node stmt",
+		"node g state 0: token $-end-of-input- \"\", keys [$-end-of-input-, any], rule any -> final",
+		"drop node g",
+	}
+	lines := strings.Split(b.String(), "\n")
+	i := 0
+	for _, l := range lines {
+		if i < len(expected) && l == expected[i] {
+			i++
+		}
+	}
+	if i < len(expected) {
+		t.Errorf("expecting %q in trace:\n%s", expected[i], b.String())
+	}
+}
+
+func TestExpectedTokens(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $name = /\\w+/; $op = /[=:;]/; " +
+		"g = {stmt}; stmt = $name, ('=' | ':'), [$num], ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		src, expected, nodes string
+	}{
+		{"a = x", "$num ;", "g stmt"},
+		{"a;", ": =", "g stmt"},
+		{"a = 1; b", ": =", "g stmt"},
+	}
+
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, nil)
+		ee, valid := e.(*llx.Error)
+		if !valid {
+			t.Errorf("sample #%d: expecting llx.Error, got %v", i, e)
+			continue
+		}
+
+		expected := make([]string, len(ee.Expected))
+		for j, x := range ee.Expected {
+			expected[j] = strings.Trim(x, "\"")
+		}
+		sort.Strings(expected)
+		if strings.Join(expected, " ") != s.expected || strings.Join(ee.Nodes, " ") != s.nodes {
+			t.Errorf("sample #%d: expecting %q in %q, got %q in %q", i, s.expected, s.nodes, ee.Expected, ee.Nodes)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $name = /\\w+/; $op = /[=:;]/; " +
+		"g = {stmt}; stmt = $name, ('=' | ':'), [$num], ';';"
+	ambiguous := spaceDef + "$num = /\\d+/; $name = /\\w+/; $op = /[=;]/; " +
+		"g = {stmt}; stmt = decl | assign; decl = $name, ';'; assign = $name, '=', $num, ';';"
+
+	samples := []struct {
+		grammar, prefix, expected string
+	}{
+		{grammar, "", "$-end-of-input- $name"},
+		{grammar, "a ", "\":\" \"=\""},
+		{grammar, "a = ", "\";\" $num"},
+		{grammar, "a = 1; ", "$-end-of-input- $name"},
+		{grammar, "a 1", ""},
+		{ambiguous, "a ", "\";\" \"=\""},
+		{ambiguous, "a; b", "\";\" \"=\""},
+		{ambiguous, "a = ", "$num"},
+	}
+
+	for i, s := range samples {
+		p, e := FromString("", s.grammar)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		res, e := p.Complete(context.Background(), s.prefix)
+		if s.expected == "" {
+			if e == nil {
+				t.Errorf("sample #%d: expecting error, got %v", i, res)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		names := make([]string, len(res))
+		for j, x := range res {
+			if x.Literal {
+				names[j] = strconv.Quote(x.Name)
+			} else {
+				names[j] = "$" + x.Name
+			}
+		}
+		sort.Strings(names)
+		if strings.Join(names, " ") != s.expected {
+			t.Errorf("sample #%d: expecting %s, got %s", i, s.expected, strings.Join(names, " "))
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	grammar := "!aside $space; !group $str; $space = /\\s+/; $name = /\\w+/; $op = /[=;\"]/; $str = /[^\"]+/; " +
+		"g = {stmt}; stmt = $name, '=', '\"', $str, '\"', ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	q := source.NewQueue().Append(source.New("", []byte("a = \"b c\"; d")))
+	toks, e := p.Tokenize(context.Background(), q)
+	if e == nil {
+		t.Error("expecting error")
+	}
+
+	names := make([]string, len(toks))
+	for i, tok := range toks {
+		names[i] = tok.TypeName() + ":" + tok.Text()
+	}
+	expected := "name:a space:  op:= space:  op:\" str:b c op:\" op:; space:  name:d"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expecting %q, got %q", expected, strings.Join(names, " "))
+	}
+}
+
+func TestAmbiguityOptions(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $name = /\\w+/; $op = /[=;]/; " +
+		"g = {stmt}; stmt = ($name, ';') | ($name, '=', $name, ';') | ($name, '=', $num, ';');"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		src  string
+		opts []ParseOption
+		err  int
+	}{
+		{"a; b = c; d = 1;", nil, 0},
+		{"a; b = c; d = 1;", []ParseOption{WithMaxLookahead(2)}, 0},
+		{"a; b = c; d = 1;", []ParseOption{WithMaxLookahead(1)}, UnexpectedTokenError},
+		{"a; b = c;", []ParseOption{WithMaxLookahead(1)}, 0},
+		{"a; b = c;", []ParseOption{WithMaxLookahead(1), WithAmbiguityError()}, AmbiguityError},
+		{"a; b = c; d = 1;", []ParseOption{WithAmbiguityError()}, 0},
+		{"a; a;", []ParseOption{WithPreferFirstRule()}, 0},
+		{"a; b = c;", []ParseOption{WithPreferFirstRule()}, UnexpectedTokenError},
+	}
+
+	for i, s := range samples {
+		_, e := p.ParseString("", s.src, nil, s.opts...)
+		if s.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != s.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.err, e)
+		}
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	grammar := spaceDef + "!decode $num int; !decode $str unquote; !decode $name upper; " +
+		"$num = /0x[0-9a-f]+|\\d+/; $str = /\"[^\"]*\"/; $name = /\\w+/; g = {$num | $str | $name};"
+	upper := WithDecoder("upper", func(token *Token) (any, error) {
+		return strings.ToUpper(token.Text()), nil
+	})
+
+	var values []any
+	hooks := &Hooks{Tokens: TokenHooks{AnyToken: func(token *Token, pc *ParseContext) (bool, error) {
+		if token.Value() != nil {
+			values = append(values, token.Value())
+		}
+		return true, nil
+	}}}
+
+	p, e := FromString("", grammar, upper)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	_, e = p.ParseString("", `12 0x1f "foo" bar`, hooks)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	expected := []any{int64(12), int64(31), "foo", "BAR"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expecting %v, got %v", expected, values)
+	}
+
+	p, _ = FromString("", grammar)
+	_, e = p.ParseString("", "1", hooks)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownDecoderError {
+		t.Errorf("expecting UnknownDecoderError, got %v", e)
+	}
+
+	_, e = p.ParseString("", "99999999999999999999", hooks, upper)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != TokenValueError || ee.Line != 1 {
+		t.Errorf("expecting TokenValueError, got %v", e)
+	}
+}
+
+type scopeHookInstance struct {
+	pc   *ParseContext
+	node string
+}
+
+func (shi *scopeHookInstance) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (shi *scopeHookInstance) HandleNode(node string, result any) error {
+	return nil
+}
+
+func (shi *scopeHookInstance) HandleToken(token *Token) error {
+	if token.TypeName() != "name" {
+		return nil
+	}
+
+	name := token.Text()
+	scope := shi.pc.Scope()
+	if shi.node == "decl" {
+		if _, found := scope.Local(name); found {
+			return llx.FormatErrorPos(token, 1, "%s redeclared", name)
+		}
+		scope.Set(name, true)
+	} else if _, found := scope.Get(name); !found {
+		return llx.FormatErrorPos(token, 2, "%s undeclared", name)
+	}
+	return nil
+}
+
+func (shi *scopeHookInstance) EndNode() (result any, e error) {
+	return nil, nil
+}
+
+func TestScopes(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[{};]/; " +
+		"g = {stmt}; stmt = decl | use | block; decl = 'var', $name, ';'; use = $name, ';'; block = '{', {stmt}, '}';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hooks := &Hooks{Nodes: NodeHooks{AnyNode: func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		return &scopeHookInstance{pc, node}, nil
+	}}}
+	samples := []struct {
+		src string
+		err int
+	}{
+		{"var a; a; { var b; a; b; } a;", 0},
+		{"var a; { var a; a; } a;", 0},
+		{"var a; var a;", 1},
+		{"{ var b; } b;", 2},
+		{"a; var a;", 2},
+	}
+
+	for i, s := range samples {
+		_, e := p.ParseString("", s.src, hooks, WithScopes("block"))
+		if s.err == 0 {
+			if e != nil {
+				t.Errorf("sample #%d: unexpected error: %s", i, e)
+			}
+			continue
+		}
+
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != s.err {
+			t.Errorf("sample #%d: expecting error code %d, got %v", i, s.err, e)
+		}
+	}
+
+	_, e = p.ParseString("", "a;", hooks, WithScopes("foo"))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownNodeError {
+		t.Errorf("expecting UnknownNodeError, got %v", e)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	p, e := FromString("", spaceDef+"$name = /[a-z]+/; $op = /;/; g = {$name, ';'};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	texts := []string{"a; b;", "a b;", "", "c;", "d"}
+	sources := make([]*source.Source, len(texts))
+	for i, text := range texts {
+		sources[i] = source.New(strconv.Itoa(i), []byte(text))
+	}
+	failed := map[int]bool{1: true, 4: true}
+
+	results := p.ParseAll(context.Background(), sources, nil, WithWorkers(2))
+	if len(results) != len(sources) {
+		t.Fatalf("expecting %d results, got %d", len(sources), len(results))
+	}
+	for i, r := range results {
+		if r.Source != sources[i] {
+			t.Errorf("result #%d: wrong source %q", i, r.Source.Name())
+		}
+		if (r.Error != nil) != failed[i] {
+			t.Errorf("result #%d: unexpected error value: %v", i, r.Error)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, r := range p.ParseAll(ctx, sources, nil) {
+		if r.Error != context.Canceled {
+			t.Errorf("cancelled result #%d: expecting context.Canceled, got %v", i, r.Error)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	p, e := FromString("", spaceDef+"$name = /[a-z]+/; $op = /[();]/; g = {stmt}; stmt = $name, ['(', $name, ')'], ';';")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	collect := func(src string) string {
+		var res []string
+		for ev := range p.Events(context.Background(), source.NewQueue().Append(source.New("", []byte(src)))) {
+			switch ev.Kind {
+			case EnterNodeEvent:
+				res = append(res, ev.Node+"{")
+			case TokenEvent:
+				res = append(res, ev.Token.Text())
+			case LeaveNodeEvent:
+				res = append(res, "}"+ev.Node)
+			case ErrorEvent:
+				res = append(res, "error")
+			}
+		}
+		return strings.Join(res, " ")
+	}
+
+	samples := []struct {
+		src, expected string
+	}{
+		{"a; b (c);", "g{ stmt{ a ; }stmt stmt{ b ( c ) ; }stmt }g"},
+		{"a b;", "g{ stmt{ a error"},
+	}
+	for i, s := range samples {
+		got := collect(s.src)
+		if got != s.expected {
+			t.Errorf("sample #%d: expecting %q, got %q", i, s.expected, got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := p.Events(ctx, source.NewQueue().Append(source.New("", []byte("a; b; c;"))))
+	<-ch
+	cancel()
+	for range ch {
+	}
+}
+
+type sumHookInstance struct {
+	sum int
+}
+
+func (shi *sumHookInstance) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (shi *sumHookInstance) HandleNode(node string, result int) error {
+	shi.sum += result
+	return nil
+}
+
+func (shi *sumHookInstance) HandleToken(token *Token) error {
+	if token.TypeName() != "num" {
+		return nil
+	}
+
+	n, e := strconv.Atoi(token.Text())
+	shi.sum += n
+	return e
+}
+
+func (shi *sumHookInstance) EndNode() (result int, e error) {
+	return shi.sum, nil
+}
+
+func TestTypedNodeHook(t *testing.T) {
+	p, e := FromString("", spaceDef+"$num = /\\d+/; $op = /[()]/; g = {$num | list}; list = '(', {$num | list}, ')';")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hook := TypedNodeHook(func(node string, token *Token, pc *ParseContext) (TypedHookInstance[int], error) {
+		return &sumHookInstance{}, nil
+	})
+	hs := &Hooks{Nodes: NodeHooks{"g": hook, "list": hook}}
+	res, e := p.ParseString("", "1 (2 (3) 4) 5", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	if res != 15 {
+		t.Errorf("expecting 15, got %v", res)
+	}
+
+	hs.Nodes["list"] = func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		return &nthi{node, &[]string{}}, nil
+	}
+	_, e = p.ParseString("", "1 (2)", hs)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != WrongResultTypeError {
+		t.Errorf("expecting WrongResultTypeError, got %v", e)
+	}
+}
+
+func TestTokenArena(t *testing.T) {
+	grammar := "!aside $space; $space = /\\s+/; $name = /\\w+/; $op = /[=;]/; g = {$name, '=', $name, ';'};"
+	p, e := FromString("", grammar, WithTokenArena(2), WithReuse())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	for i := 0; i < 2; i++ {
+		var toks []*Token
+		hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+			toks = append(toks, tok)
+			return true, nil
+		}}}
+		_, e = p.ParseString("", "a = b; c = d;", hs)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		texts := make([]string, 0, len(toks))
+		for _, tok := range toks {
+			if tok.Type() >= 0 {
+				texts = append(texts, tok.Text())
+			}
+		}
+		expected := "a   =   b ;   c   =   d ;"
+		if strings.Join(texts, " ") != expected {
+			t.Errorf("pass #%d: expecting %q, got %q", i, expected, strings.Join(texts, " "))
+		}
+	}
+}
+
+type lastTextHookInstance struct {
+	result any
+}
+
+func (lti *lastTextHookInstance) NewNode(node string, token *Token) error {
+	return nil
+}
+
+func (lti *lastTextHookInstance) HandleNode(node string, result any) error {
+	lti.result = result
+	return nil
+}
+
+func (lti *lastTextHookInstance) HandleToken(token *Token) error {
+	lti.result = token.Text()
+	return nil
+}
+
+func (lti *lastTextHookInstance) EndNode() (any, error) {
+	return lti.result, nil
+}
+
+func TestNodeStack(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[()]/; g = {item}; item = $name | group; group = '(', {item}, ')';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	hs := &Hooks{Nodes: NodeHooks{"item": func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		got = append(got, fmt.Sprintf("%d:%s:%s:%v", pc.Depth(), pc.ParentNode(), strings.Join(pc.Ancestors(), "/"), pc.ParentResult()))
+		return &lastTextHookInstance{}, nil
+	}}}
+	_, e = p.ParseString("", "a (b c)", hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	expected := "1:g:g:<nil> 1:g:g:a 3:group:group/item/g:<nil> 3:group:group/item/g:b"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expecting %q, got %q", expected, strings.Join(got, " "))
+	}
+}
+
+func TestContinuedSources(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;]/; g = {$name, '=', $num, ';'};"
+	for _, dfa := range []bool{false, true} {
+		var opts []ParseOption
+		if dfa {
+			opts = append(opts, WithDFALexer())
+		}
+		p, e := FromString("", grammar, opts...)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		var got []string
+		hook := func(tok *Token, pc *ParseContext) (bool, error) {
+			if tok.TypeName() != "space" {
+				got = append(got, fmt.Sprintf("%s@%d:%d", tok.Text(), tok.Line(), tok.Col()))
+			}
+			return true, nil
+		}
+		hs := &Hooks{Tokens: TokenHooks{AnyToken: hook, EofToken: hook}}
+
+		q := source.NewQueue().Append(source.New("1", []byte("a ="), source.WithContinuation()))
+		go func() {
+			q.Feed(source.New("2", []byte(" 1;\nb"), source.WithContinuation()))
+			q.Feed(source.New("3", []byte("=2"), source.WithContinuation()))
+			q.Feed(source.New("4", []byte("3;"), source.WithContinuation()))
+			q.Close()
+		}()
+		_, e = p.Parse(q, hs)
+		if e != nil {
+			t.Fatalf("dfa %v: unexpected error: %s", dfa, e)
+		}
+
+		expected := "a@1:1 =@1:3 1@1:5 ;@1:6 b@2:1 =@2:2 23@2:3 ;@2:5 @2:6"
+		if strings.Join(got, " ") != expected {
+			t.Errorf("dfa %v: expecting %q, got %q", dfa, expected, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestIsIncomplete(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-z]+/; $num = /\\d+/; $op = /[=;]/; g = {$name, '=', $num, ';'};"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		src        string
+		incomplete bool
+	}{
+		{"a = 1;", false},
+		{"a = 1; b", true},
+		{"a =", true},
+		{"a = ;", false},
+	}
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, nil)
+		if IsIncomplete(e) != s.incomplete {
+			t.Errorf("sample #%d: expecting %v, got %v for error %v", i, s.incomplete, !s.incomplete, e)
+		}
+	}
+
+	_, e = p.ParseString("", "a = ;\nb = 2", nil, WithErrorRecovery("g"), WithErrorCollection(0))
+	if IsIncomplete(e) {
+		t.Errorf("syntax error followed by end of input must not be incomplete, got %v", e)
+	}
+	if !IsIncomplete(ErrorList{unexpectedEofError(lexer.EoiToken(), "';'")}) {
+		t.Errorf("expecting error list containing only end of input error to be incomplete")
+	}
+}
+
+func TestActions(t *testing.T) {
+	grammar := spaceDef + "$num = /\\d+/; $op = /[-+*()]/;" +
+		"expr = term, {('+', term @add) | ('-', term @sub)}; term = value, {'*', value @mul}; value = $num @push | ('(', expr, ')');"
+	var stack []int
+	pop := func() int {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	var last string
+	binary := func(f func(a, b int) int) Action {
+		return func(pc *ParseContext) error {
+			b, a := pop(), pop()
+			stack = append(stack, f(a, b))
+			return nil
+		}
+	}
+	p, e := FromString("", grammar,
+		WithAction("push", func(pc *ParseContext) error {
+			v, _ := strconv.Atoi(last)
+			stack = append(stack, v)
+			return nil
+		}),
+		WithAction("add", binary(func(a, b int) int { return a + b })),
+		WithAction("sub", binary(func(a, b int) int { return a - b })),
+		WithAction("mul", binary(func(a, b int) int { return a * b })),
+	)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Tokens: TokenHooks{"num": func(t *Token, pc *ParseContext) (bool, error) {
+		last = t.Text()
+		return true, nil
+	}}}
+	samples := []struct {
+		src    string
+		result int
+	}{
+		{"7", 7},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 2 - 3", 5},
+		{"2 * (3 - 1) * 4 - 5", 11},
+	}
+	for i, s := range samples {
+		stack = nil
+		_, e = p.ParseString("", s.src, hs)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		} else if len(stack) != 1 || stack[0] != s.result {
+			t.Errorf("sample #%d: expecting [%d], got %v", i, s.result, stack)
+		}
+	}
+
+	stack = nil
+	_, e = p.ParseString("", "1 + 2", hs, WithAction("add", func(pc *ParseContext) error {
+		return errors.New("no adding")
+	}))
+	if e == nil || e.Error() != "no adding" {
+		t.Errorf("expecting action error, got %v", e)
+	}
+
+	stack = nil
+	_, e = p.ParseString("", "1 + 2", hs, WithAction("add", nil))
+	if e != nil || len(stack) != 2 {
+		t.Errorf("expecting unbound action to be ignored, got %v, %v", stack, e)
+	}
+
+	_, e = FromString("", grammar, WithAction("div", binary(func(a, b int) int { return a / b })))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownActionError {
+		t.Errorf("expecting UnknownActionError, got %v", e)
+	}
+	_, e = p.ParseString("", "1", hs, WithAction("div", nil))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownActionError {
+		t.Errorf("expecting UnknownActionError, got %v", e)
+	}
+	var called []string
+	record := func(pc *ParseContext) error {
+		called = append(called, pc.ParentNode())
+		return nil
+	}
+	p, e = FromString("", spaceDef+"$name = /[a-z]+/; $op = /[=(),]/; g = call | assign;"+
+		"call = $name @callee, '(', [$name, {',', $name}], ')'; assign = $name @target, '=', $name;",
+		WithAction("callee", record), WithAction("target", record))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	_, e = p.ParseString("", "foo(bar)", nil)
+	if e != nil || len(called) != 1 || called[0] != "call" {
+		t.Errorf("expecting [call], got %v, %v", called, e)
+	}
+}
+
+func TestAdjacency(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[()>]/; g = {call | shift}; call = $name ~ '(', [$name], ')'; shift = '>' ~ '>';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		src  string
+		code int
+		col  int
+	}{
+		{"foo() >> bar( x )", 0, 0},
+		{"foo ()", NotAdjacentError, 5},
+		{"foo() > > bar()", NotAdjacentError, 9},
+		{"foo()>>bar()", 0, 0},
+	}
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, nil)
+		ee, valid := e.(*llx.Error)
+		if s.code == 0 && e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		} else if s.code != 0 && (!valid || ee.Code != s.code || ee.Col != s.col) {
+			t.Errorf("sample #%d: expecting error code %d at column %d, got %v", i, s.code, s.col, e)
+		}
+	}
+
+	_, e = p.ParseString("", "foo () > >", nil, WithErrorRecovery("g"), WithErrorCollection(0))
+	var el ErrorList
+	if !errors.As(e, &el) || len(el) != 2 || el[0].Code != NotAdjacentError || el[1].Code != NotAdjacentError {
+		t.Errorf("expecting 2 NotAdjacentError errors, got %v", e)
+	}
+}
+
+func TestErrorProductions(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[=;]+/; g = {cond, ';'}; " +
+		"cond = $name, ('==' | !err(\"use == to compare values\") '='), $name;"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	_, e = p.ParseString("", "a == b; c == d;", nil)
+	if e != nil {
+		t.Errorf("unexpected error: %s", e)
+	}
+
+	_, e = p.ParseString("", "a == b; c = d;", nil)
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != ErrorProductionError || ee.Message != "use == to compare values" || ee.Col != 13 {
+		t.Errorf("expecting ErrorProductionError at column 13, got %v", e)
+	}
+
+	_, e = p.ParseString("", "a = b; c == d; e = f;", nil, WithErrorCollection(0))
+	var el ErrorList
+	if !errors.As(e, &el) || len(el) != 2 || el[0].Col != 5 || el[1].Col != 20 {
+		t.Errorf("expecting 2 errors at columns 5 and 20, got %v", e)
+	}
+}
+
+func TestLiteralSuggestions(t *testing.T) {
+	grammar := spaceDef + "$name = /[a-zA-Z]+/; $op = /[=;]/; g = {stmt}; stmt = $name, '=', ('true' | 'false' | 'null'), ';';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		src         string
+		suggestions []string
+	}{
+		{"a = true; b = ture;", []string{"true"}},
+		{"a = nul;", []string{"null"}},
+		{"a = Null;", []string{"null"}},
+		{"a = flase;", []string{"false"}},
+		{"a = x;", nil},
+		{"a = maybe;", nil},
+	}
+
+	var expected []string
+	hs := &Hooks{Literals: TokenHooks{"true": func(tok *Token, pc *ParseContext) (bool, error) {
+		expected = pc.Expected()
+		return true, nil
+	}}}
+	_, e = p.ParseString("", "a = true;", hs)
+	if e != nil || !reflect.DeepEqual(expected, []string{`"true"`, `"false"`, `"null"`}) {
+		t.Errorf("expecting literals, got %v (error: %v)", expected, e)
+	}
+
+	for i, s := range samples {
+		_, e = p.ParseString("", s.src, nil)
+		ee, valid := e.(*llx.Error)
+		if !valid || ee.Code != UnexpectedTokenError || !reflect.DeepEqual(ee.Suggestions, s.suggestions) {
+			t.Errorf("sample #%d: expecting UnexpectedTokenError with suggestions %v, got %#v", i, s.suggestions, e)
+			continue
+		}
+
+		fix := ""
+		if len(s.suggestions) > 0 {
+			fix = s.suggestions[0]
+			if !strings.HasSuffix(ee.Message, fmt.Sprintf("did you mean %q?", fix)) {
+				t.Errorf("sample #%d: expecting suggestion in message, got %q", i, ee.Message)
+			}
+		}
+		if ee.Fix != fix {
+			t.Errorf("sample #%d: expecting fix %q, got %q", i, fix, ee.Fix)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	samples := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"done", "dnoe", 2},
+		{"фу", "фух", 1},
+	}
+
+	for i, s := range samples {
+		d := editDistance(s.a, s.b)
+		if d != s.distance {
+			t.Errorf("sample #%d: expecting %d, got %d", i, s.distance, d)
+		}
+	}
+}
+
+// wordProvider fetches words of any case as tokens of given type, skipping spaces.
+type wordProvider struct {
+	tokenType int
+}
+
+func (wp wordProvider) NextOf(q *source.Queue, types lexer.TokenTypeSet) (*Token, error) {
+	for {
+		content, pos := q.ContentPos()
+		src := q.Source()
+		if src == nil {
+			return lexer.EoiToken(), nil
+		}
+		if pos >= len(content) {
+			q.NextSource()
+			return lexer.EofToken(src), nil
+		}
+
+		end := pos
+		for end < len(content) && content[end] != ' ' {
+			end++
+		}
+		if end == pos {
+			q.Skip(1)
+			continue
+		}
+
+		if !types.Contains(wp.tokenType) {
+			return nil, nil
+		}
+
+		sp := q.SourcePos()
+		q.Skip(end - pos)
+		return lexer.NewToken(wp.tokenType, "word", content[pos:end], sp), nil
+	}
+}
+
+func TestTokenProvider(t *testing.T) {
+	g, e := langdef.ParseString("", "$word = /[a-z]+/; $op = /[()]/; !group $op; g = {$word | '(' | ')'};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	p, e := New(g, WithTokenProvider(0, wordProvider{0}))
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+		got = append(got, tok.Text())
+		return true, nil
+	}}}
+	_, e = p.ParseString("", "Foo  BAR-1 (", hs)
+	expected := []string{"Foo", "BAR-1", "("}
+	if e != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expecting %v, got %v (error: %v)", expected, got, e)
+	}
+
+	_, e = New(g, WithTokenProvider(2, wordProvider{0}))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownGroupError {
+		t.Errorf("expecting UnknownGroupError, got %v", e)
+	}
+}
+
+func TestNestedTokens(t *testing.T) {
+	grammar := spaceDef + "!aside $comment; $comment = nested('/*', '*/'); $num = /\\d+/; $op = /[*\\/]/; " +
+		"g = $num, {('*' | '/'), $num};"
+	for _, dfa := range []bool{false, true} {
+		var opts []ParseOption
+		if dfa {
+			opts = append(opts, WithDFALexer())
+		}
+		p, e := FromString("", grammar, opts...)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		var got []string
+		hs := &Hooks{Tokens: TokenHooks{"comment": func(tok *Token, pc *ParseContext) (bool, error) {
+			got = append(got, tok.Text())
+			return true, nil
+		}}}
+		_, e = p.ParseString("", "1 /* a /* b */ c */ * 2 / 3 /**/", hs)
+		expected := []string{"/* a /* b */ c */", "/**/"}
+		if e != nil || !reflect.DeepEqual(got, expected) {
+			t.Errorf("dfa %v: expecting %q, got %q (error: %v)", dfa, expected, got, e)
+		}
+
+		_, e = p.ParseString("", "1 * 2 /* a /* b */", nil)
+		if ee, valid := e.(*llx.Error); !valid || ee.Code != lexer.UnclosedTokenError || ee.Col != 7 {
+			t.Errorf("dfa %v: expecting UnclosedTokenError at col 7, got %v", dfa, e)
+		}
+	}
+}
+
+func TestNodeLexerGroups(t *testing.T) {
+	grammar := spaceDef + "$name = /\\w+/; $op = /[+;\"{}]|\\$\\{/; " +
+		"!group $part $delim; $part = /(?:[^\"$\\\\]|\\\\.|\\$[^{\"])+/; $delim = /\"|\\$\\{/; !lexer $part str; " +
+		"g = {expr, ';'}; expr = operand, {'+', operand}; operand = $name | str; " +
+		"str = '\"', {$part | interp}, '\"'; interp = '${', expr, '}';"
+	p, e := FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var got []string
+	hs := &Hooks{Tokens: TokenHooks{AnyToken: func(tok *Token, pc *ParseContext) (bool, error) {
+		got = append(got, tok.TypeName()+":"+tok.Text())
+		return true, nil
+	}}}
+	_, e = p.ParseString("", "\"a ${x + \"y\"} b\" + z;", hs)
+	expected := []string{"op:\"", "part:a ", "delim:${", "name:x", "space: ", "op:+", "space: ", "op:\"", "part:y",
+		"delim:\"", "op:}", "part: b", "delim:\"", "space: ", "op:+", "space: ", "name:z", "op:;"}
+	if e != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expecting %q, got %q (error: %v)", expected, got, e)
+	}
+}
+
+func TestContextWaitNext(t *testing.T) {
+	p, e := FromString("", spaceDef+"$name = /\\w+/; g = {$name};")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	q := source.NewQueue().Append(source.New("", []byte("a "), source.WithContinuation()))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, e = p.Parse(q, nil, WithContext(ctx))
+	if e != context.Canceled {
+		t.Errorf("expecting canceled error while waiting for next source, got %v", e)
+	}
+}
+
+func TestContextLongToken(t *testing.T) {
+	grammar := spaceDef + "!aside $comment; $comment = nested('(*', '*)'); $str = /\"[^\"]*\"/; g = {$str};"
+	huge := strings.Repeat("x", 1<<20)
+	fetched := false
+	hook := func(tok *Token, pc *ParseContext) (bool, error) {
+		fetched = true
+		return true, nil
+	}
+	hs := &Hooks{Tokens: TokenHooks{"str": hook, "comment": hook}}
+	for _, dfa := range []bool{false, true} {
+		samples := []string{"(*" + huge + "*)", "\"" + huge + "\""}
+		var opts []ParseOption
+		if dfa {
+			opts = append(opts, WithDFALexer())
+		}
+		p, e := FromString("", grammar, opts...)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+
+		for i, src := range samples {
+			fetched = false
+			ctx := test.NewCountdownContext(4)
+			_, e = p.ParseString("", src, hs, WithContext(ctx))
+			if e != context.Canceled || fetched {
+				t.Errorf("dfa %v, sample #%d: expecting canceled error while matching token, got %v (fetched: %v)",
+					dfa, i, e, fetched)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package parser
+
+import (
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+// TokenProvider is a user-implemented lexer backend for a token group, e.g. a hand-written scanner
+// for tokens that regular expressions cannot describe. lexer.Lexer implements this interface.
+type TokenProvider interface {
+	// NextOf fetches a token of one of given types (indexes of grammar.Grammar.Tokens) starting at current
+	// source position and advances current position. It must follow lexer.Lexer.NextOf conventions:
+	// return nil, nil and make no changes if there is no suitable token, return EoF token and discard current source
+	// (see source.Queue.NextSource) at the end of current source, return EoI token if the queue is empty.
+	// Literal tokens need not be recognized by provider: a token whose text matches a literal
+	// is converted by parser the same way as tokens fetched by built-in lexers.
+	NextOf(q *source.Queue, types lexer.TokenTypeSet) (*Token, error)
+}
+
+// WithTokenProvider makes New use p instead of built-in lexer of given group (0 is the default group),
+// replacing previous provider for that group if any. Token regexps of that group are ignored.
+// Group number not used by grammar is an error (UnknownGroupError returned by New).
+// Has no effect on Parse options. WithTokenArena option does not affect custom providers.
+func WithTokenProvider(group int, p TokenProvider) ParseOption {
+	return func(po *parseOptions) {
+		if po.providers == nil {
+			po.providers = make(map[int]TokenProvider)
+		}
+		po.providers[group] = p
+	}
+}
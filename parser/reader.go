@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ava12/llx/source"
+)
+
+// readChunkSize is the size of a chunk read by ParseReader at once.
+const readChunkSize = 4096
+
+// ParseReader is same as Parse, except it reads source content from r until io.EOF
+// and parses a single source having given name while reading it (see Feeder).
+// Read error other than io.EOF stops parsing and is returned as soon as it occurs.
+// Reading stops with ctx.Err() as soon as the context is done (checked between reads, so a blocked Read
+// is not interrupted), the context is also used while parsing as if passed with WithContext option.
+// Reading stops as well if parsing ends before the end of content, e.g. with syntax error.
+func (p *Parser) ParseReader(ctx context.Context, name string, r io.Reader, hs *Hooks, opts ...ParseOption) (result any, e error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	f := p.NewFeeder(ctx, name, hs, opts...)
+	chunk := make([]byte, readChunkSize)
+	for {
+		select {
+		case <-f.done:
+			return f.result, f.err
+		default:
+		}
+
+		e = ctx.Err()
+		if e != nil {
+			break
+		}
+
+		n, re := r.Read(chunk)
+		f.Feed(chunk[:n])
+		if re == io.EOF {
+			return f.Finish()
+		}
+		if re != nil {
+			e = re
+			break
+		}
+	}
+
+	cancel()
+	<-f.done
+	return nil, e
+}
+
+// Feeder is a push-style parser driver for content arriving in chunks, e.g. over a network connection.
+// Parsing runs in a separate goroutine started by NewFeeder and proceeds as content arrives:
+// each chunk is passed to parser as a continued source (see source.WithContinuation), so a token may span
+// several chunks. Hooks are called from the parsing goroutine.
+// The goroutine exits when parsing ends or its context is done, so cancel the context to release it
+// if Finish is not going to be called.
+// Feed and Finish must not be called concurrently, Feeder must not be used after Finish is called.
+type Feeder struct {
+	name   string
+	queue  *source.Queue
+	line   int
+	done   chan struct{}
+	result any
+	err    error
+}
+
+// NewFeeder creates a feeder for a source having given name and starts parsing, the parser waits for fed content.
+// ctx is used while parsing as if passed with WithContext option.
+// Each fed chunk of the source is a separate source.Source, line numbers continue across chunks.
+func (p *Parser) NewFeeder(ctx context.Context, name string, hs *Hooks, opts ...ParseOption) *Feeder {
+	f := &Feeder{name: name, queue: source.NewQueue(), line: 1, done: make(chan struct{})}
+	f.queue.Append(source.New(name, nil, source.WithContinuation()))
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx))
+	go func() {
+		f.result, f.err = p.Parse(f.queue, hs, opts...)
+		f.queue.Close()
+		close(f.done)
+	}()
+	return f
+}
+
+// Feed passes a chunk of source content to parser.
+// The chunk is copied, so the caller may reuse it.
+// Returns parsing error if parsing has already ended with one, the chunk is discarded in this case.
+func (f *Feeder) Feed(chunk []byte) error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+	}
+
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	content := append([]byte(nil), chunk...)
+	f.queue.Feed(source.New(f.name, content, source.WithContinuation(), source.WithFirstLine(f.line)))
+	f.line += bytes.Count(content, []byte("\n"))
+	return nil
+}
+
+// Finish tells parser that there is no more content, waits for parsing to end, and returns
+// the same results as Parse does.
+func (f *Feeder) Finish() (result any, e error) {
+	f.queue.Feed(source.New(f.name, nil, source.WithFirstLine(f.line)))
+	f.queue.Close()
+	<-f.done
+	return f.result, f.err
+}
@@ -0,0 +1,79 @@
+package parser
+
+// Scope is a set of named values visible to hooks while a node is being parsed, e.g. declared identifiers.
+// Scopes are nested: a value set in a scope is visible in all inner scopes unless redefined there.
+// The outermost (global) scope exists during the whole parsing process, inner scopes are created
+// for nodes listed with WithScopes option and are discarded when those nodes are dropped.
+type Scope struct {
+	parent *Scope
+	vars   map[string]any
+}
+
+// WithScopes makes parser create a new scope each time one of listed nodes is pushed on stack.
+// The scope is created after the node hook is called, so the node hook itself and EndNode method
+// of its instance see the enclosing scope while other methods of the instance see the new one.
+func WithScopes(nodes ...string) ParseOption {
+	return func(po *parseOptions) {
+		po.scopeNodes = append(po.scopeNodes, nodes...)
+	}
+}
+
+// Parent returns enclosing scope, nil for the global scope.
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
+// Set sets a value in this scope, hiding the value with the same name in enclosing scopes if any.
+func (s *Scope) Set(name string, value any) {
+	if s.vars == nil {
+		s.vars = make(map[string]any)
+	}
+	s.vars[name] = value
+}
+
+// Get returns the value set in this scope or in the nearest enclosing scope.
+// Returns false if the name is not set in any of those scopes.
+func (s *Scope) Get(name string) (value any, found bool) {
+	for ; s != nil; s = s.parent {
+		value, found = s.vars[name]
+		if found {
+			return
+		}
+	}
+	return nil, false
+}
+
+// Local returns the value set in this scope ignoring enclosing scopes, e.g. to detect redeclarations.
+func (s *Scope) Local(name string) (value any, found bool) {
+	value, found = s.vars[name]
+	return
+}
+
+// Scope returns the innermost scope of current node, see WithScopes.
+func (pc *ParseContext) Scope() *Scope {
+	for n := pc.node; n != nil; n = n.prev {
+		if n.scope != nil {
+			return n.scope
+		}
+	}
+
+	if pc.globalScope == nil {
+		pc.globalScope = &Scope{}
+	}
+	return pc.globalScope
+}
+
+func (pc *ParseContext) initScopes() error {
+	for _, name := range pc.options.scopeNodes {
+		i, f := pc.parser.names[nodeKey(name)]
+		if !f || i < 0 {
+			return unknownNodeError(name)
+		}
+
+		if pc.scopeNodes == nil {
+			pc.scopeNodes = make(map[int]bool)
+		}
+		pc.scopeNodes[i] = true
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"unicode"
+
+	"github.com/ava12/llx/grammar"
+)
+
+// WithInlineNodes makes parser inline nodes flagged with !inline directive (see langdef package)
+// and listed nodes: such nodes are not passed to node hooks, their tokens and nested nodes are passed
+// directly to the hook instance of the enclosing node instead, so that hooks (e.g. tree.NodeHook) build
+// compact trees without post-processing. Inlined nodes stay on parser stack (see ParseContext.Ancestors).
+// The root node is never inlined. Unknown node names cause UnknownNodeError.
+func WithInlineNodes(names ...string) ParseOption {
+	return func(po *parseOptions) {
+		po.inline = true
+		po.inlineNodes = append(po.inlineNodes, names...)
+	}
+}
+
+// WithSkipPunctuation makes parser skip punctuation literal tokens when passing tokens to node hook instances,
+// token hooks still receive such tokens. By default punctuation literals are the ones consisting only
+// of brackets (Unicode categories Ps and Pe), commas, and semicolons, e.g. "(", "]);", or ",".
+// If literals are listed, exactly these literals are skipped instead. Unknown literals cause UnknownTokenLiteralError.
+func WithSkipPunctuation(literals ...string) ParseOption {
+	return func(po *parseOptions) {
+		po.skipPunct = true
+		po.punctuation = append(po.punctuation, literals...)
+	}
+}
+
+// WithPassThroughUnwrap makes parser replace non-root nodes having exactly one child (either a node or a token,
+// aside tokens are counted too) with that child: the result of the child node or the token itself
+// is passed to the hook instance of the enclosing node. Hook of the replaced node is still called,
+// but its result is discarded. Applied after WithInlineNodes and WithSkipPunctuation.
+func WithPassThroughUnwrap() ParseOption {
+	return func(po *parseOptions) {
+		po.passThrough = true
+	}
+}
+
+func (pc *ParseContext) initInlineNodes() error {
+	if !pc.options.inline {
+		return nil
+	}
+
+	pc.inlineNodes = make(map[int]bool)
+	for i, nt := range pc.parser.grammar.Nodes {
+		if i != grammar.RootNode && nt.Flags&grammar.InlineNode != 0 {
+			pc.inlineNodes[i] = true
+		}
+	}
+	for _, name := range pc.options.inlineNodes {
+		i, f := pc.parser.names[nodeKey(name)]
+		if !f || i < 0 {
+			return unknownNodeError(name)
+		}
+
+		if i != grammar.RootNode {
+			pc.inlineNodes[i] = true
+		}
+	}
+	return nil
+}
+
+func (pc *ParseContext) initPunctuation() error {
+	if !pc.options.skipPunct {
+		return nil
+	}
+
+	pc.punctuation = make(map[int]bool)
+	for _, l := range pc.options.punctuation {
+		i, f := pc.parser.literals.Lookup([]byte(l))
+		if !f {
+			return unknownTokenLiteralError(l)
+		}
+
+		pc.punctuation[i] = true
+	}
+	if len(pc.options.punctuation) > 0 {
+		return nil
+	}
+
+	for i, t := range pc.parser.grammar.Tokens {
+		if t.Flags&grammar.LiteralToken != 0 && isPunctuation(t.Name) {
+			pc.punctuation[i] = true
+		}
+	}
+	return nil
+}
+
+func isPunctuation(text string) bool {
+	for _, r := range text {
+		if r != ',' && r != ';' && !unicode.In(r, unicode.Ps, unicode.Pe) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+func (pc *ParseContext) isPunctuation(tok *Token) bool {
+	i, f := pc.parser.literals.Lookup(tok.Content())
+	return f && pc.punctuation[i]
+}
+
+// passResult passes result of finished node to the hook instance of the enclosing node.
+func (pc *ParseContext) passResult(nt *nodeRec, res any) error {
+	switch h := nt.hook.(type) {
+	case *inlineInstance:
+		return nil
+
+	case *passThroughInstance:
+		if h.count == 1 {
+			e := handleLabel(pc.node.hook, nt.label)
+			if e != nil {
+				return e
+			}
+			if h.token != nil {
+				return pc.node.hook.HandleToken(h.token)
+			}
+			return pc.node.hook.HandleNode(h.node, h.result)
+		}
+	}
+
+	e := handleLabel(pc.node.hook, nt.label)
+	if e != nil {
+		return e
+	}
+	return pc.node.hook.HandleNode(pc.parser.grammar.Nodes[nt.index].Name, res)
+}
+
+// inlineInstance passes everything to the hook instance of the enclosing node.
+type inlineInstance struct {
+	parent NodeHookInstance
+}
+
+func (ii *inlineInstance) NewNode(node string, token *Token) error {
+	return ii.parent.NewNode(node, token)
+}
+
+func (ii *inlineInstance) HandleNode(node string, result any) error {
+	return ii.parent.HandleNode(node, result)
+}
+
+func (ii *inlineInstance) HandleToken(token *Token) error {
+	return ii.parent.HandleToken(token)
+}
+
+func (ii *inlineInstance) HandleLabel(label string) error {
+	return handleLabel(ii.parent, label)
+}
+
+func (ii *inlineInstance) EndNode() (result any, e error) {
+	return nil, nil
+}
+
+func (ii *inlineInstance) PendingResult() any {
+	if h, valid := ii.parent.(PendingResultHolder); valid {
+		return h.PendingResult()
+	}
+	return nil
+}
+
+// passThroughInstance remembers the only child of a node.
+type passThroughInstance struct {
+	hook   NodeHookInstance
+	count  int
+	node   string
+	result any
+	token  *Token
+}
+
+func (pti *passThroughInstance) NewNode(node string, token *Token) error {
+	return pti.hook.NewNode(node, token)
+}
+
+func (pti *passThroughInstance) HandleNode(node string, result any) error {
+	pti.count++
+	pti.node, pti.result, pti.token = node, result, nil
+	return pti.hook.HandleNode(node, result)
+}
+
+func (pti *passThroughInstance) HandleToken(token *Token) error {
+	pti.count++
+	pti.token = token
+	return pti.hook.HandleToken(token)
+}
+
+func (pti *passThroughInstance) HandleLabel(label string) error {
+	return handleLabel(pti.hook, label)
+}
+
+func (pti *passThroughInstance) EndNode() (result any, e error) {
+	return pti.hook.EndNode()
+}
+
+func (pti *passThroughInstance) PendingResult() any {
+	if h, valid := pti.hook.(PendingResultHolder); valid {
+		return h.PendingResult()
+	}
+	return nil
+}
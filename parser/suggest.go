@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ava12/llx/grammar"
+)
+
+// maxSuggestions limits the number of literals suggested for unexpected token.
+const maxSuggestions = 3
+
+// Expected returns token types (prefixed with $) and quoted literals acceptable at current parsing position,
+// including ones acceptable after current node and its ancestors are finalized (see llx.Error.Expected).
+// When called from a token hook, the result includes the key of the token being handled.
+// Returns nil if the parser stack is empty.
+func (pc *ParseContext) Expected() []string {
+	if pc.node == nil {
+		return nil
+	}
+
+	keys := pc.expectedKeys()
+	res := make([]string, len(keys))
+	for i, k := range keys {
+		res[i] = pc.keyName(k)
+	}
+	return res
+}
+
+// suggestLiterals returns expected literals similar to the text of unexpected token, closest first.
+// A literal is similar if it differs from the text (ignoring case) in at most 2 runes and in at most a half of its runes.
+func (pc *ParseContext) suggestLiterals(tok *Token) []string {
+	text := tok.Text()
+	if text == "" {
+		return nil
+	}
+
+	type suggestion struct {
+		literal  string
+		distance int
+	}
+	var found []suggestion
+	lower := strings.ToLower(text)
+	for _, k := range pc.expectedKeys() {
+		if k < 0 {
+			continue
+		}
+
+		t := pc.parser.grammar.Tokens[k]
+		if t.Flags&grammar.LiteralToken == 0 || t.Name == text {
+			continue
+		}
+
+		d := editDistance(lower, strings.ToLower(t.Name))
+		if d <= 2 && d*2 <= utf8.RuneCountInString(t.Name) {
+			found = append(found, suggestion{t.Name, d})
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].distance < found[j].distance
+	})
+	if len(found) > maxSuggestions {
+		found = found[:maxSuggestions]
+	}
+	var res []string
+	for _, s := range found {
+		res = append(res, s.literal)
+	}
+	return res
+}
+
+// editDistance returns Levenshtein distance between a and b counted in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := range ra {
+		cur[0] = i + 1
+		for j := range rb {
+			cost := 1
+			if ra[i] == rb[j] {
+				cost = 0
+			}
+			cur[j+1] = prev[j] + cost
+			if prev[j+1]+1 < cur[j+1] {
+				cur[j+1] = prev[j+1] + 1
+			}
+			if cur[j]+1 < cur[j+1] {
+				cur[j+1] = cur[j] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
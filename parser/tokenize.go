@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+// Tokenize returns all tokens fetched from source queue in source order, including aside tokens,
+// but excluding end-of-file and end-of-input tokens. Token types are resolved by lexer groups
+// selected by grammar states, so the sources are actually parsed, but no hooks are used and no tree is built.
+// In case of error returns tokens fetched so far and the error, e.g. a syntax highlighter may
+// fall back to plain text for the rest of the source.
+// The context is used as if passed with WithContext option.
+func (p *Parser) Tokenize(ctx context.Context, q *source.Queue, opts ...ParseOption) ([]*Token, error) {
+	var res []*Token
+	collector := Middleware{
+		Token: func(token *Token, pc *ParseContext, next TokenHook) (bool, error) {
+			if token.Type() != lexer.EofTokenType && token.Type() != lexer.EoiTokenType {
+				res = append(res, token)
+			}
+			return next(token, pc)
+		},
+	}
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx), WithMiddleware(collector))
+	_, e := p.Parse(q, nil, opts...)
+	return res, e
+}
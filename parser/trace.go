@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ava12/llx/grammar"
+)
+
+// WithTrace makes parser write a step log to w: each token received from lexer, current node and state,
+// tried rule keys and chosen rule for each step, resolved ambiguities, and pushed and dropped nodes.
+// Each record is a single line, nested nodes are indented. Write errors are ignored.
+// The log is intended for debugging grammars, its format may change.
+func WithTrace(w io.Writer) ParseOption {
+	return func(po *parseOptions) {
+		po.trace = w
+	}
+}
+
+func (pc *ParseContext) tracef(format string, params ...any) {
+	depth := 0
+	for n := pc.node; n != nil && n.prev != nil; n = n.prev {
+		depth++
+	}
+	fmt.Fprintf(pc.options.trace, strings.Repeat("  ", depth)+format+"\n", params...)
+}
+
+func (pc *ParseContext) traceToken(t *Token) string {
+	if t == nil {
+		return "none"
+	}
+
+	res := "$" + t.TypeName() + " " + strconv.Quote(t.Text())
+	if t.Source() != nil {
+		res += " at " + strconv.Itoa(t.Line()) + ":" + strconv.Itoa(t.Col())
+	}
+	return res
+}
+
+func (pc *ParseContext) traceRule(r grammar.Rule) string {
+	var b strings.Builder
+	b.WriteString(pc.keyName(r.Token))
+	if r.Node != grammar.SameNode {
+		b.WriteString(" push " + pc.parser.grammar.Nodes[r.Node].Name)
+	}
+	switch r.State {
+	case grammar.FinalState:
+		b.WriteString(" -> final")
+	case repeatState:
+		b.WriteString(" -> same state")
+	default:
+		b.WriteString(" -> state " + strconv.Itoa(r.State))
+	}
+	return b.String()
+}
+
+func (pc *ParseContext) traceStep(t *Token, rule grammar.Rule, found bool) {
+	s := pc.parser.grammar.States[pc.node.state]
+	keys, _ := pc.possibleRuleKeys(t, s.Caseless)
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = pc.keyName(k)
+	}
+
+	res := "no rule"
+	if found {
+		res = "rule " + pc.traceRule(rule)
+	}
+	pc.tracef("node %s state %d: token %s, keys [%s], %s", pc.parser.grammar.Nodes[pc.node.index].Name,
+		pc.node.state, pc.traceToken(t), strings.Join(names, ", "), res)
+}
+
+func (pc *ParseContext) traceResolve(rules []grammar.Rule, tokens []*Token, chosen grammar.Rule) {
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = pc.traceRule(r)
+	}
+	lookahead := make([]string, len(tokens))
+	for i, t := range tokens {
+		lookahead[i] = pc.traceToken(t)
+	}
+	pc.tracef("resolve [%s] using [%s]: chosen %s", strings.Join(names, "; "), strings.Join(lookahead, ", "), pc.traceRule(chosen))
+}
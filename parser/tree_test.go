@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ava12/llx"
 	"github.com/ava12/llx/grammar"
 	"github.com/ava12/llx/langdef"
 	"github.com/ava12/llx/lexer"
@@ -168,7 +169,7 @@ func (tv *treeValidator) validate() error {
 }
 
 func parseAsTestNode(g *grammar.Grammar, src string, ths, lhs TokenHooks) (*treeNode, error) {
-	hs := &Hooks{ths, lhs, testNodeHooks}
+	hs := &Hooks{ths, lhs, testNodeHooks, nil, nil}
 	parser, _ := New(g)
 	q := source.NewQueue().Append(source.New("sample", []byte(src)))
 	r, e := parser.Parse(q, hs)
@@ -216,3 +217,50 @@ func TestParseTreeExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestTreeSimplification(t *testing.T) {
+	grammarSrc := "!aside $space; $space = /\\s+/; $num = /\\d+/; $name = /[a-z]+/; $op = /[-+*()=;]/; !inline operand;" +
+		"g = {stmt}; stmt = $name, '=', expr, ';'; expr = term, {('+' | '-'), term}; term = operand, {'*', operand};" +
+		"operand = value | ('(', expr, ')'); value = $num | $name;"
+	src := "x = 1 + 2 * (y);"
+	samples := []struct {
+		opts []ParseOption
+		expr string
+	}{
+		{nil, "(stmt x = (expr (term (operand (value 1))) + (term (operand (value 2)) * " +
+			"(operand '(' (expr (term (operand (value y)))) ')'))) ;)"},
+		{[]ParseOption{WithInlineNodes()}, "(stmt x = (expr (term (value 1)) + (term (value 2) * '(' (expr (term (value y))) ')')) ;)"},
+		{[]ParseOption{WithInlineNodes("value")}, "(stmt x = (expr (term 1) + (term 2 * '(' (expr (term y)) ')')) ;)"},
+		{[]ParseOption{WithSkipPunctuation()}, "(stmt x = (expr (term (operand (value 1))) + (term (operand (value 2)) * " +
+			"(operand (expr (term (operand (value y))))))))"},
+		{[]ParseOption{WithSkipPunctuation("=", ";")}, "(stmt x (expr (term (operand (value 1))) + (term (operand (value 2)) * " +
+			"(operand '(' (expr (term (operand (value y)))) ')'))))"},
+		{[]ParseOption{WithPassThroughUnwrap()}, "(stmt x = (expr 1 + (term 2 * (operand '(' y ')'))) ;)"},
+		{[]ParseOption{WithPassThroughUnwrap(), WithSkipPunctuation(), WithInlineNodes()}, "(stmt x = (expr 1 + (term 2 * y)))"},
+	}
+
+	p, e := FromString("", grammarSrc, WithReuse())
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	hs := &Hooks{Nodes: testNodeHooks}
+	for i, s := range samples {
+		r, e := p.ParseString("", src, hs, s.opts...)
+		if e == nil {
+			e = newTreeValidator(r.(*treeNode), s.expr).validate()
+		}
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+		}
+	}
+
+	_, e = p.ParseString("", src, hs, WithInlineNodes("foo"))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownNodeError {
+		t.Errorf("expecting UnknownNodeError, got %v", e)
+	}
+	_, e = p.ParseString("", src, hs, WithSkipPunctuation("!"))
+	if ee, valid := e.(*llx.Error); !valid || ee.Code != UnknownTokenLiteralError {
+		t.Errorf("expecting UnknownTokenLiteralError, got %v", e)
+	}
+}
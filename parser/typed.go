@@ -0,0 +1,59 @@
+package parser
+
+// TypedHookInstance is same as NodeHookInstance, except node results have type T.
+// Use TypedNodeHook to convert it to regular node hook.
+type TypedHookInstance[T any] interface {
+	// NewNode is called when a child node is pushed on stack.
+	NewNode(node string, token *Token) error
+
+	// HandleNode is called when a child node is dropped from stack, result is the result of child node
+	// (zero value if the child node returned nil).
+	HandleNode(node string, result T) error
+
+	// HandleToken is called when a token is added to the node.
+	HandleToken(token *Token) error
+
+	// EndNode is called when the node is dropped from stack, returns node result.
+	EndNode() (result T, e error)
+}
+
+// TypedNodeHook converts a function creating typed hook instances to node hook, so that hook instances
+// need no type assertions for results of child nodes. A child node result that is neither nil nor of type T
+// causes WrongResultTypeError. If f returns nil instance then the node is handled as if it had no hook.
+func TypedNodeHook[T any](f func(node string, token *Token, pc *ParseContext) (TypedHookInstance[T], error)) NodeHook {
+	return func(node string, token *Token, pc *ParseContext) (NodeHookInstance, error) {
+		hook, e := f(node, token, pc)
+		if e != nil || hook == nil {
+			return nil, e
+		}
+
+		return &typedHookInstance[T]{hook, token}, nil
+	}
+}
+
+type typedHookInstance[T any] struct {
+	hook      TypedHookInstance[T]
+	lastToken *Token
+}
+
+func (thi *typedHookInstance[T]) NewNode(node string, token *Token) error {
+	thi.lastToken = token
+	return thi.hook.NewNode(node, token)
+}
+
+func (thi *typedHookInstance[T]) HandleNode(node string, result any) error {
+	value, valid := result.(T)
+	if !valid && result != nil {
+		return wrongResultTypeError(thi.lastToken, node, result, value)
+	}
+
+	return thi.hook.HandleNode(node, value)
+}
+
+func (thi *typedHookInstance[T]) HandleToken(token *Token) error {
+	return thi.hook.HandleToken(token)
+}
+
+func (thi *typedHookInstance[T]) EndNode() (result any, e error) {
+	return thi.hook.EndNode()
+}
@@ -0,0 +1,123 @@
+// Package lsp converts source positions to Language Server Protocol positions and back.
+// LSP positions use 0-based line numbers and 0-based character offsets counted in UTF-16 code units,
+// conversions do not depend on column options of sources.
+package lsp
+
+import (
+	"unicode/utf8"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+// Position is LSP position in a text document.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is LSP range in a text document, End is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic severities:
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+	SeverityHint        = 4
+)
+
+// Diagnostic is LSP diagnostic.
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity,omitempty"`
+	Code     int    `json:"code,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Message  string `json:"message"`
+}
+
+// FromPos converts source position to LSP position. Returns zero value if the source is not known.
+func FromPos(p source.Pos) Position {
+	s := p.Source()
+	if s == nil {
+		return Position{}
+	}
+
+	line, _ := s.LineCol(p.Pos())
+	content := s.Content()
+	end := p.Pos()
+	if end > len(content) {
+		end = len(content)
+	}
+	character := 0
+	for i := s.Pos(line, 1); i < end; {
+		r, rl := utf8.DecodeRune(content[i:])
+		character += utf16Len(r)
+		i += rl
+	}
+	return Position{line - 1, character}
+}
+
+// ToPos converts LSP position to source position. Character offset exceeding line length means the end of line,
+// offset pointing to the middle of surrogate pair means the start of corresponding rune.
+// Line number exceeding total number of lines means the end of source.
+func ToPos(s *source.Source, p Position) source.Pos {
+	if p.Line < 0 {
+		return source.NewPos(s, 0)
+	}
+
+	content := s.Content()
+	i := s.Pos(p.Line+1, 1)
+	for character := 0; i < len(content); {
+		r, rl := utf8.DecodeRune(content[i:])
+		character += utf16Len(r)
+		if r == '\n' || character > p.Character {
+			break
+		}
+
+		i += rl
+	}
+	return source.NewPos(s, i)
+}
+
+// RangeOf converts a pair of source positions to LSP range.
+func RangeOf(start, end source.Pos) Range {
+	return Range{FromPos(start), FromPos(end)}
+}
+
+// TokenRange returns LSP range of token text.
+func TokenRange(t *lexer.Token) Range {
+	return RangeOf(t.Pos(), t.EndPos())
+}
+
+// Diagnostics converts errors reported for given source to LSP diagnostics with error severity.
+// Each diagnostic has an empty range at error position, the start of the source is used for errors
+// without position information. Errors reported for other sources are skipped.
+func Diagnostics(s *source.Source, errs ...*llx.Error) []Diagnostic {
+	res := make([]Diagnostic, 0, len(errs))
+	for _, e := range errs {
+		if e.SourceName != "" && e.SourceName != s.Name() {
+			continue
+		}
+
+		pos := FromPos(source.NewPos(s, s.Pos(e.Line, e.Col)))
+		res = append(res, Diagnostic{
+			Range:    Range{pos, pos},
+			Severity: SeverityError,
+			Code:     e.Code,
+			Message:  e.Message,
+		})
+	}
+	return res
+}
+
+func utf16Len(r rune) int {
+	if r > 0xffff {
+		return 2
+	}
+	return 1
+}
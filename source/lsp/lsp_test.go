@@ -0,0 +1,75 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/source"
+)
+
+func TestPositions(t *testing.T) {
+	s := source.New("", []byte("a😀b\n\tяz\n"), source.WithTabWidth(4))
+	samples := []struct {
+		pos int
+		lsp Position
+	}{
+		{0, Position{0, 0}},
+		{1, Position{0, 1}},
+		{5, Position{0, 3}},
+		{6, Position{0, 4}},
+		{7, Position{1, 0}},
+		{8, Position{1, 1}},
+		{10, Position{1, 2}},
+		{12, Position{2, 0}},
+	}
+
+	for i, sample := range samples {
+		got := FromPos(source.NewPos(s, sample.pos))
+		if got != sample.lsp {
+			t.Errorf("sample #%d: expecting %v, got %v", i, sample.lsp, got)
+		}
+		back := ToPos(s, sample.lsp).Pos()
+		if back != sample.pos {
+			t.Errorf("sample #%d: expecting position %d, got %d", i, sample.pos, back)
+		}
+	}
+
+	positions := []struct {
+		lsp Position
+		pos int
+	}{
+		{Position{0, 2}, 1},
+		{Position{0, 10}, 6},
+		{Position{5, 0}, 12},
+		{Position{-1, 3}, 0},
+	}
+	for i, sample := range positions {
+		got := ToPos(s, sample.lsp).Pos()
+		if got != sample.pos {
+			t.Errorf("position #%d: expecting %d, got %d", i, sample.pos, got)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	s := source.New("src", []byte("foo\n\t😀bar"), source.WithTabWidth(8))
+	errs := []*llx.Error{
+		llx.FormatErrorPos(source.NewPos(s, 9), 1, "first"),
+		llx.NewError(2, "second", "other", 1, 1),
+		llx.FormatError(3, "third"),
+	}
+
+	ds := Diagnostics(s, errs...)
+	expected := []Diagnostic{
+		{Range{Position{1, 3}, Position{1, 3}}, SeverityError, 1, "", errs[0].Message},
+		{Range{}, SeverityError, 3, "", "third"},
+	}
+	if len(ds) != len(expected) {
+		t.Fatalf("expecting %d diagnostics, got %d", len(expected), len(ds))
+	}
+	for i, d := range ds {
+		if d != expected[i] {
+			t.Errorf("diagnostic #%d: expecting %v, got %v", i, expected[i], d)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package source
 
 import (
 	"bytes"
+	"context"
+	"sync"
 	"unicode/utf8"
 
+	"github.com/ava12/llx"
 	"github.com/ava12/llx/internal/queue"
 )
 
@@ -14,14 +17,86 @@ type Source struct {
 	content       []byte
 	lineStarts    []int
 	prevLineIndex int
+	tabWidth      int
+	colUnits      colUnits
+	continued     bool
+	lineOffset    int
+}
+
+type colUnits int
+
+const (
+	runeCols colUnits = iota
+	byteCols
+	utf16Cols
+)
+
+// Option tunes column numbering of a source.
+type Option func(s *Source)
+
+// WithTabWidth makes tab runes advance column number to the next tab stop, tab stops are placed every n columns.
+// By default (or if n < 2) a tab rune counts as a single rune.
+func WithTabWidth(n int) Option {
+	return func(s *Source) {
+		s.tabWidth = n
+	}
+}
+
+// WithByteColumns makes column numbers count bytes instead of runes.
+func WithByteColumns() Option {
+	return func(s *Source) {
+		s.colUnits = byteCols
+	}
+}
+
+// WithUTF16Columns makes column numbers count UTF-16 code units instead of runes,
+// as used by Language Server Protocol and many editors.
+func WithUTF16Columns() Option {
+	return func(s *Source) {
+		s.colUnits = utf16Cols
+	}
+}
+
+// WithContinuation marks source as continued by the next source in a queue: lexer emits no EoF token
+// at the end of such source, it proceeds to the next source, waiting for one if necessary (see Queue.Feed).
+// Intended for incremental input (e.g. REPL), where more lines may follow the text ending mid-statement.
+// A token may span several sources: lexer joins a continued source with the next one (see Queue.JoinNext)
+// if more input could change the lexeme matched at its end, or if it does not end with line feed,
+// so that the next source continues its last line.
+func WithContinuation() Option {
+	return func(s *Source) {
+		s.continued = true
+	}
+}
+
+// WithFirstLine makes line numbers start from n instead of 1, e.g. for a source continuing another one.
+// Values less than 1 are ignored.
+func WithFirstLine(n int) Option {
+	return func(s *Source) {
+		if n > 1 {
+			s.lineOffset = n - 1
+		}
+	}
 }
 
 // New creates new source.
 // Name may be any string identifying the source, does not have to be unique, may be empty.
 // Content should be a valid UTF-8 encoded text, lines should be separated by "\n" rune.
 // Content should not be modified.
-func New(name string, content []byte) *Source {
+// By default column numbers count runes, options may change this.
+func New(name string, content []byte, opts ...Option) *Source {
 	s := &Source{name: name, content: content, prevLineIndex: -1}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+	s.findLineStarts()
+	return s
+}
+
+func (s *Source) findLineStarts() {
+	content := s.content
 	lineCnt := bytes.Count(content, []byte("\n")) + 1
 	s.lineStarts = make([]int, lineCnt, lineCnt)
 	s.lineStarts[0] = 0
@@ -32,8 +107,6 @@ func New(name string, content []byte) *Source {
 			j++
 		}
 	}
-
-	return s
 }
 
 // Name returns source name.
@@ -46,12 +119,18 @@ func (s *Source) Content() []byte {
 	return s.content
 }
 
+// Continued returns true if the source is created with WithContinuation option.
+func (s *Source) Continued() bool {
+	return s.continued
+}
+
 // Len returns source content length in bytes.
 func (s *Source) Len() int {
 	return len(s.content)
 }
 
-// LineCol returns line and column number (both 1-based) of rune starting at given position in the source content.
+// LineCol returns line and column number (both 1-based, unless WithFirstLine is used) of rune starting
+// at given position in the source content.
 // Negative position is treated as 0, position equal to or higher than length of content is treated
 // as position right after EoF.
 func (s *Source) LineCol(pos int) (line, col int) {
@@ -67,13 +146,43 @@ func (s *Source) LineCol(pos int) (line, col int) {
 	}
 
 	lineStart := s.lineStarts[lineIndex]
-	return lineIndex + 1, utf8.RuneCount(s.content[lineStart:pos]) + 1
+	line = lineIndex + 1 + s.lineOffset
+	if s.tabWidth < 2 && s.colUnits == runeCols {
+		return line, utf8.RuneCount(s.content[lineStart:pos]) + 1
+	}
+
+	col = 1
+	for i := lineStart; i < pos; {
+		r, rl := utf8.DecodeRune(s.content[i:])
+		col = s.nextCol(col, r, rl)
+		i += rl
+	}
+	return line, col
+}
+
+// nextCol returns the column number following the rune r of rl bytes located at column col.
+func (s *Source) nextCol(col int, r rune, rl int) int {
+	if r == '\t' && s.tabWidth > 1 {
+		return ((col-1)/s.tabWidth+1)*s.tabWidth + 1
+	}
+
+	switch s.colUnits {
+	case byteCols:
+		return col + rl
+	case utf16Cols:
+		if r > 0xffff {
+			return col + 2
+		}
+	}
+	return col + 1
 }
 
 // Pos returns position in source content corresponding to given line and column.
-// Returns 0 for lines or columns < 1. Returns content length for line exceeding total number of lines.
+// Returns 0 for lines preceding the first one (see WithFirstLine) or columns < 1. Returns content length for line exceeding total number of lines.
 // Returns position after the last rune in line for column exceeding number of runes in line.
+// Returns position of the rune spanning several columns (e.g. a tab) for any of those columns.
 func (s *Source) Pos(line, col int) int {
+	line -= s.lineOffset
 	if line <= 0 || col <= 0 {
 		return 0
 	}
@@ -84,14 +193,18 @@ func (s *Source) Pos(line, col int) int {
 	}
 
 	res := s.lineStarts[line-1]
-	for col > 1 && res < l {
+	for c := 1; c < col && res < l; {
 		r, rl := utf8.DecodeRune(s.content[res:])
 		if r == '\n' {
 			break
 		}
 
+		c = s.nextCol(c, r, rl)
+		if c > col {
+			break
+		}
+
 		res += rl
-		col--
 	}
 	if res > l {
 		res = l
@@ -99,6 +212,33 @@ func (s *Source) Pos(line, col int) int {
 	return res
 }
 
+// Slice returns part of source content between start (inclusive) and end (exclusive) positions.
+// Returns nil and false if any of positions is captured from other source, if positions are out of content bounds,
+// or if start is greater than end.
+func (s *Source) Slice(start, end Pos) ([]byte, bool) {
+	if start.src != s || end.src != s || start.pos < 0 || end.pos > len(s.content) || start.pos > end.pos {
+		return nil, false
+	}
+
+	return s.content[start.pos:end.pos], true
+}
+
+// Line returns content of given 1-based line without line terminator.
+// Returns nil and false if line number is out of range.
+func (s *Source) Line(line int) ([]byte, bool) {
+	line -= s.lineOffset
+	if line <= 0 || line > len(s.lineStarts) {
+		return nil, false
+	}
+
+	start := s.lineStarts[line-1]
+	end := len(s.content)
+	if line < len(s.lineStarts) {
+		end = s.lineStarts[line] - 1
+	}
+	return s.content[start:end], true
+}
+
 func (s *Source) findLineIndex(pos int) int {
 	if s.prevLineIndex >= 0 && s.lineStarts[s.prevLineIndex] <= pos {
 		lineIndex := s.prevLineIndex
@@ -137,11 +277,37 @@ func (s *Source) findLineIndex(pos int) int {
 	return index
 }
 
+// Set is a list of sources searched by name, it implements llx.SourceLines interface.
+// Queue discards processed sources, so keep sources in a Set to format errors after parsing.
+type Set []*Source
+
+// SourceLine returns given 1-based line of the source having given name without line terminator.
+// Several sources may have the same name (e.g. parts of a stream, see WithFirstLine), the first one
+// containing the line is used, the empty line following the final line feed of a source is used
+// only if no other source contains the line. Returns false if there is no such source or line.
+func (ss Set) SourceLine(name string, line int) (string, bool) {
+	found := false
+	for _, s := range ss {
+		if s == nil || s.name != name {
+			continue
+		}
+
+		text, valid := s.Line(line)
+		if valid && (len(text) > 0 || line < s.lineOffset+len(s.lineStarts)) {
+			return string(text), true
+		}
+		found = found || valid
+	}
+	return "", found
+}
+
 // Pos combines captured source, position, line, and column number corresponding to that position.
+// Positions obtained from a queue also capture include chain of the source (see Queue.Include).
 // Zero value means no source and position information available.
 type Pos struct {
 	src            *Source
 	pos, line, col int
+	from           *Pos
 }
 
 // NewPos returns Pos structure. Returns zero value if s is nil.
@@ -151,7 +317,15 @@ func NewPos(s *Source, pos int) Pos {
 	}
 
 	l, c := s.LineCol(pos)
-	return Pos{s, pos, l, c}
+	return Pos{src: s, pos: pos, line: l, col: c}
+}
+
+// At returns given position in captured source with the same include chain.
+// Returns zero value if there is no captured source.
+func (p Pos) At(pos int) Pos {
+	res := NewPos(p.src, pos)
+	res.from = p.from
+	return res
 }
 
 // Source returns captured source or nil.
@@ -183,21 +357,90 @@ func (p Pos) Col() int {
 	return p.col
 }
 
+// Includes returns include chain of captured source: the position the source was included from,
+// the position the including source was included from, and so on. Returns nil if the source was not included.
+func (p Pos) Includes() []Pos {
+	var res []Pos
+	for f := p.from; f != nil; f = f.from {
+		res = append(res, *f)
+	}
+	return res
+}
+
+// IncludeTrace returns the same positions as Includes, implements llx.IncludeTracer.
+func (p Pos) IncludeTrace() []llx.SourcePos {
+	includes := p.Includes()
+	if len(includes) == 0 {
+		return nil
+	}
+
+	res := make([]llx.SourcePos, len(includes))
+	for i, inc := range includes {
+		res[i] = inc
+	}
+	return res
+}
+
 type queueItem struct {
 	source *Source
 	pos    int
+	from   *Pos
+	inner  bool
 }
 
 // Queue represents a queue of source files to be processed.
+// Queue is not safe for concurrent use, except for Feed and Close methods.
 type Queue struct {
-	q      *queue.Queue[queueItem]
-	source *Source
-	pos    int
+	*queueState
+	finalNewline bool
+	nlSource     *Source
+	nlContent    []byte
+	nlConsumed   bool
+}
+
+// queueState is shared by a queue and its views (see Queue.WithFinalNewline).
+type queueState struct {
+	q       *queue.Queue[queueItem]
+	source  *Source
+	pos     int
+	from    *Pos
+	dropped int
+	inner   bool // position 0 of current source is not the beginning of a logical source
+
+	feedLock sync.Mutex
+	fed      *sync.Cond
+	feed     []*Source
+	closed   bool
 }
 
 // NewQueue creates empty queue.
 func NewQueue() *Queue {
-	return &Queue{queue.New[queueItem](), nil, 0}
+	qs := &queueState{q: queue.New[queueItem]()}
+	qs.fed = sync.NewCond(&qs.feedLock)
+	return &Queue{queueState: qs}
+}
+
+// WithFinalNewline returns a view of the queue treating every non-empty source not ending with "\n" as if it had
+// final "\n", e.g. for grammars requiring every line to be terminated (see also EnsureFinalNewline).
+// Continued sources (see WithContinuation) are kept intact, since the next source continues their last line.
+// The view shares sources and current position with q, so sources added to either of them are seen by both,
+// but neither q nor its sources are modified. Tokens covering the added newline have content not present in source.
+func (q *Queue) WithFinalNewline() *Queue {
+	return &Queue{queueState: q.queueState, finalNewline: true}
+}
+
+// pendingNewline returns true if the queue is a view adding final newline to current source
+// and the newline is not consumed yet.
+func (q *Queue) pendingNewline() bool {
+	src := q.source
+	if !q.finalNewline || src == nil || src.continued || len(src.content) == 0 || src.content[len(src.content)-1] == '\n' {
+		return false
+	}
+
+	if q.nlSource != src {
+		q.nlSource, q.nlContent, q.nlConsumed = src, nil, false
+	}
+	return !q.nlConsumed
 }
 
 // Source returns current (i.e. first) source in the queue or nil if the queue is empty.
@@ -219,13 +462,28 @@ func (q *Queue) Pos() int {
 	return q.pos
 }
 
-// SourcePos returns current source and current position in it.
+// SourcePos returns current source and current position in it together with include chain of the source.
 // Returns zero value if the queue is empty.
 func (q *Queue) SourcePos() Pos {
-	res := Pos{q.source, q.pos, 0, 0}
-	if q.source != nil {
-		res.line, res.col = q.source.LineCol(q.pos)
+	return q.PosAt(q.pos)
+}
+
+// AtSourceStart returns true if current position is the beginning of a logical source, i.e. position 0
+// of a source that neither continues a continued one (see WithContinuation) nor results from JoinNext
+// started past the beginning of the joined source. Used by lexer to decide whether preamble is allowed.
+func (q *Queue) AtSourceStart() bool {
+	return q.source != nil && q.pos == 0 && !q.inner
+}
+
+// PosAt returns given position in current source together with include chain of the source.
+// Returns zero value if the queue is empty.
+func (q *Queue) PosAt(pos int) Pos {
+	if q.source == nil {
+		return Pos{}
 	}
+
+	res := NewPos(q.source, pos)
+	res.from = q.from
 	return res
 }
 
@@ -233,17 +491,36 @@ func (q *Queue) SourcePos() Pos {
 // The next source (if there is one) becomes the current one and its saved current position is restored.
 // Returns true if the queue is not empty.
 func (q *Queue) NextSource() bool {
-	qi, fetched := q.q.First()
-	if !fetched {
-		q.source = nil
-		q.pos = 0
-	} else {
-		q.source = qi.source
-		q.pos = qi.pos
+	continued := false
+	if q.source != nil {
+		q.dropped += q.source.Len()
+		continued = q.source.continued && (q.inner || q.source.Len() > 0)
 	}
+	q.nlSource = nil
+	qi, fetched := q.q.First()
+	q.source = qi.source
+	q.pos = qi.pos
+	q.from = qi.from
+	q.inner = qi.inner || (fetched && continued)
 	return fetched
 }
 
+// Progress returns the number of processed bytes and the total length of all sources,
+// both counting sources already discarded from the queue.
+// Processed bytes of a queued source is its saved position.
+func (q *Queue) Progress() (consumed, total int) {
+	consumed = q.dropped + q.pos
+	total = q.dropped
+	if q.source != nil {
+		total += q.source.Len()
+	}
+	for _, qi := range q.q.Items() {
+		consumed += qi.pos
+		total += qi.source.Len()
+	}
+	return
+}
+
 // Append adds new source to the end of the queue.
 // Does nothing if s is nil. Does not add empty source if the queue is not empty.
 func (q *Queue) Append(s *Source) *Queue {
@@ -254,8 +531,31 @@ func (q *Queue) Append(s *Source) *Queue {
 	if q.source == nil || q.source.Len() == 0 {
 		q.source = s
 		q.pos = 0
+		q.from = nil
+		q.inner = false
 	} else {
-		q.q.Append(queueItem{s, 0})
+		q.q.Append(queueItem{source: s})
+	}
+	return q
+}
+
+// AppendWithBoundary adds new source to the end of the queue.
+// Unlike Append it never drops or replaces sources, so lexer emits EoF token for current source
+// before switching to the added one even if either of them is empty.
+// Intended for incremental input (e.g. REPL), where each added source must be terminated independently.
+// Does nothing if s is nil.
+func (q *Queue) AppendWithBoundary(s *Source) *Queue {
+	if s == nil {
+		return q
+	}
+
+	if q.source == nil {
+		q.source = s
+		q.pos = 0
+		q.from = nil
+		q.inner = false
+	} else {
+		q.q.Append(queueItem{source: s})
 	}
 	return q
 }
@@ -269,15 +569,151 @@ func (q *Queue) Prepend(s *Source) *Queue {
 	}
 
 	if q.source != nil && q.source.Len() > 0 {
-		q.q.Prepend(queueItem{q.source, q.pos})
+		q.q.Prepend(queueItem{q.source, q.pos, q.from, q.inner})
 	}
 
 	q.source = s
 	q.pos = 0
+	q.from = nil
+	q.inner = false
+
+	return q
+}
+
+// Include is same as Prepend, but also records that s is included at given position
+// (usually the position of include directive), see Pos.Includes. Zero position means current position
+// of the queue, a position in current source not obtained from the queue gets include chain of current source.
+// The include chain is kept by the queue, s is not modified.
+func (q *Queue) Include(s *Source, at Pos) *Queue {
+	if s == nil {
+		return q
+	}
 
+	if at.src == nil {
+		at = q.SourcePos()
+	} else if at.from == nil && at.src == q.source {
+		at.from = q.from
+	}
+	q.Prepend(s)
+	if q.source == s {
+		q.from = &at
+	}
 	return q
 }
 
+// Feed adds new source to the list of sources awaited by WaitNext, it will be appended to the queue
+// with AppendWithBoundary when needed. May be called from any goroutine, e.g. while another one is parsing.
+// Does nothing if s is nil or the queue is closed.
+func (q *Queue) Feed(s *Source) {
+	q.feedLock.Lock()
+	defer q.feedLock.Unlock()
+	if s != nil && !q.closed {
+		q.feed = append(q.feed, s)
+		q.fed.Broadcast()
+	}
+}
+
+// Close tells that no more sources will be fed to the queue, so that WaitNext stops waiting.
+// May be called from any goroutine.
+func (q *Queue) Close() {
+	q.feedLock.Lock()
+	defer q.feedLock.Unlock()
+	q.closed = true
+	q.fed.Broadcast()
+}
+
+// IsClosed returns true if Close was called.
+func (q *Queue) IsClosed() bool {
+	q.feedLock.Lock()
+	defer q.feedLock.Unlock()
+	return q.closed
+}
+
+// WaitNext returns true if the queue contains a source following the current one. If there is no such source,
+// it appends fed sources (see Feed) to the queue, waiting for new ones unless the queue is closed.
+// Returns false if there are no queued or fed sources and the queue is closed.
+// Waiting stops with ctx.Err() as soon as the context is done, ctx may be nil.
+// Used by lexer at the end of a continued source (see WithContinuation).
+func (q *Queue) WaitNext(ctx context.Context) (bool, error) {
+	if !q.q.IsEmpty() {
+		return true, nil
+	}
+
+	q.feedLock.Lock()
+	if len(q.feed) == 0 && !q.closed && ctx != nil && ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				q.feedLock.Lock()
+				q.fed.Broadcast()
+				q.feedLock.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for len(q.feed) == 0 && !q.closed {
+		if ctx != nil && ctx.Err() != nil {
+			q.feedLock.Unlock()
+			return false, ctx.Err()
+		}
+		q.fed.Wait()
+	}
+	feed := q.feed
+	q.feed = nil
+	q.feedLock.Unlock()
+
+	for _, s := range feed {
+		q.AppendWithBoundary(s)
+	}
+	return !q.q.IsEmpty(), nil
+}
+
+// JoinNext replaces continued current source (see WithContinuation) and the next one with a single source
+// holding the rest of the current source starting at the beginning of current line followed by the content
+// of the next source, so that positions and line numbers of the rest stay the same. The joined source has
+// the name and column options of the current one and is continued if the next one is.
+// Waits for the next source as WaitNext does, returns false if there is none or current source is not continued.
+// Sources themselves are not modified.
+func (q *Queue) JoinNext(ctx context.Context) (bool, error) {
+	cur := q.source
+	if cur == nil || !cur.continued {
+		return false, nil
+	}
+
+	hasNext, e := q.WaitNext(ctx)
+	if !hasNext || e != nil {
+		return false, e
+	}
+
+	qi, _ := q.q.First()
+	next := qi.source
+	start := bytes.LastIndexByte(cur.content[:q.pos], '\n') + 1
+	line, _ := cur.LineCol(start)
+	content := make([]byte, 0, len(cur.content)-start+len(next.content))
+	content = append(content, cur.content[start:]...)
+	content = append(content, next.content...)
+
+	joined := &Source{
+		name:          cur.name,
+		content:       content,
+		prevLineIndex: -1,
+		tabWidth:      cur.tabWidth,
+		colUnits:      cur.colUnits,
+		continued:     next.continued,
+		lineOffset:    line - 1,
+	}
+	joined.findLineStarts()
+
+	q.dropped += start
+	q.source = joined
+	q.pos -= start
+	q.inner = q.inner || start > 0
+	q.nlSource = nil
+	return true, nil
+}
+
 // IsEmpty returns true if the queue is empty (contains no sources) and false otherwise.
 func (q *Queue) IsEmpty() bool {
 	return q.source == nil
@@ -285,16 +721,23 @@ func (q *Queue) IsEmpty() bool {
 
 // Eof returns true if the queue is empty or current source position is beyond the end of current source.
 func (q *Queue) Eof() bool {
-	return q.source == nil || q.pos >= q.source.Len()
+	return q.source == nil || q.pos >= q.source.Len() && !q.pendingNewline()
 }
 
 // ContentPos returns content of current source and current position, or (nil, 0) if the queue is empty.
 func (q *Queue) ContentPos() ([]byte, int) {
 	if q.source == nil {
 		return nil, 0
-	} else {
-		return q.source.Content(), q.pos
 	}
+
+	if q.pendingNewline() {
+		if q.nlContent == nil {
+			c := q.source.content
+			q.nlContent = append(c[:len(c):len(c)], '\n')
+		}
+		return q.nlContent, q.pos
+	}
+	return q.source.Content(), q.pos
 }
 
 // Skip increases current source position by given amount of bytes.
@@ -307,6 +750,9 @@ func (q *Queue) Skip(size int) {
 
 	q.pos += size
 	if q.pos >= q.source.Len() {
+		if q.pos > q.source.Len() && q.pendingNewline() {
+			q.nlConsumed = true
+		}
 		q.pos = q.source.Len()
 	}
 }
@@ -319,6 +765,10 @@ func (q *Queue) Rewind(size int) {
 		return
 	}
 
+	if q.nlConsumed && q.nlSource == q.source {
+		q.nlConsumed = false
+		size--
+	}
 	if q.pos <= size {
 		q.pos = 0
 	} else {
@@ -334,6 +784,7 @@ func (q *Queue) Seek(pos int) {
 		return
 	}
 
+	q.nlConsumed = false
 	if pos <= 0 {
 		q.pos = 0
 	} else {
@@ -356,6 +807,19 @@ func (q *Queue) LineCol(pos int) (line, col int) {
 	}
 }
 
+// EnsureFinalNewline appends "\n" to non-empty content not ending with "\n".
+// Returns true if content was changed. Empty content is not changed.
+// Intended for grammars requiring every line to be terminated, should be used after NormalizeNls.
+func EnsureFinalNewline(content *[]byte) bool {
+	l := len(*content)
+	if l == 0 || (*content)[l-1] == '\n' {
+		return false
+	}
+
+	*content = append(*content, '\n')
+	return true
+}
+
 // NormalizeNls replaces all occurrences of "\r" and "\r\n" with "\n".
 func NormalizeNls(content *[]byte) {
 	const (
@@ -1,9 +1,11 @@
 package source
 
 import (
+	"context"
 	"strconv"
 	"testing"
 
+	"github.com/ava12/llx"
 	. "github.com/ava12/llx/internal/test"
 )
 
@@ -246,6 +248,17 @@ func TestEmptySource(t *testing.T) {
 	assertSourceName("baz")
 }
 
+func TestAppendWithBoundary(t *testing.T) {
+	queue := NewQueue()
+	queue.AppendWithBoundary(New("foo", nil)).AppendWithBoundary(New("bar", nil)).AppendWithBoundary(src("baz"))
+	names := []string{"foo", "bar", "baz"}
+	for i, name := range names {
+		Assert(t, queue.SourceName() == name, "step %d: expecting %q source, got %q", i, name, queue.SourceName())
+		queue.NextSource()
+	}
+	Assert(t, queue.IsEmpty(), "queue not empty")
+}
+
 func TestResizeSource(t *testing.T) {
 	queue := NewQueue()
 	queue.Append(src("c")).Append(src("d")).Append(src("e")).Append(src("f")).
@@ -284,6 +297,31 @@ func TestNextSource(t *testing.T) {
 	}
 }
 
+func TestSourceLine(t *testing.T) {
+	ss := Set{New("a", []byte("foo\nbar\n")), New("b", []byte("baz"))}
+	samples := []struct {
+		name  string
+		line  int
+		text  string
+		found bool
+	}{
+		{"a", 1, "foo", true},
+		{"a", 2, "bar", true},
+		{"a", 3, "", true},
+		{"a", 4, "", false},
+		{"a", 0, "", false},
+		{"b", 1, "baz", true},
+		{"c", 1, "", false},
+	}
+
+	for i, s := range samples {
+		text, found := ss.SourceLine(s.name, s.line)
+		if text != s.text || found != s.found {
+			t.Errorf("sample #%d: expecting (%q, %v), got (%q, %v)", i, s.text, s.found, text, found)
+		}
+	}
+}
+
 func TestAddSourceAfterEof(t *testing.T) {
 	queue := NewQueue().Append(New("dropped", []byte("-")))
 	queue.NextSource()
@@ -324,6 +362,62 @@ func TestNormalizeNls(t *testing.T) {
 	}
 }
 
+func TestEnsureFinalNewline(t *testing.T) {
+	samples := []struct {
+		src, res string
+		changed  bool
+	}{
+		{"", "", false},
+		{"\n", "\n", false},
+		{"foo\n", "foo\n", false},
+		{"foo", "foo\n", true},
+		{"foo\nbar", "foo\nbar\n", true},
+	}
+
+	for i, s := range samples {
+		c := []byte(s.src)
+		changed := EnsureFinalNewline(&c)
+		cs := string(c)
+		if cs != s.res || changed != s.changed {
+			t.Errorf("sample #%d: expecting %q, %v, got %q, %v", i, s.res, s.changed, cs, changed)
+		}
+	}
+}
+
+func TestQueueFinalNewline(t *testing.T) {
+	a := New("a", []byte("foo\nbar"))
+	q := NewQueue().Append(a).Append(New("b", []byte("baz\n")))
+	v := q.WithFinalNewline()
+	q.Append(New("c", []byte("qux"))).Append(New("d", []byte("-"), WithContinuation()))
+	expected := []string{"foo\nbar\n", "baz\n", "qux\n", "-"}
+	for i, exp := range expected {
+		added := string(q.Source().Content()) != exp
+		if added && string(q.Source().Content())+"\n" != exp {
+			t.Errorf("source #%d: queue content changed to %q", i, q.Source().Content())
+		}
+		v.Seek(1)
+		v.Skip(v.Source().Len() - 1)
+		if v.Eof() == added || v.Pos() != v.Source().Len() {
+			t.Errorf("source #%d: expecting pending newline: %v", i, added)
+		}
+		v.Rewind(100)
+		content, pos := v.ContentPos()
+		if got := string(content[pos:]); got != exp {
+			t.Errorf("source #%d: expecting %q, got %q", i, exp, got)
+		}
+
+		v.Skip(len(exp))
+		if !v.Eof() {
+			t.Errorf("source #%d: expecting EoF after newline", i)
+		}
+		v.NextSource()
+	}
+
+	if !q.IsEmpty() || string(a.Content()) != "foo\nbar" {
+		t.Errorf("original source must not be modified, got %q", a.Content())
+	}
+}
+
 func TestPosBounds(t *testing.T) {
 	q := NewQueue().Append(New("", make([]byte, 10)))
 
@@ -344,3 +438,257 @@ func TestPosBounds(t *testing.T) {
 	q.Seek(-1)
 	ExpectInt(t, 0, q.Pos())
 }
+
+func TestSlice(t *testing.T) {
+	s := New("", []byte("foo\nbar baz"))
+	other := New("", []byte("foo\nbar baz"))
+
+	samples := []struct {
+		start, end Pos
+		text       string
+		valid      bool
+	}{
+		{NewPos(s, 4), NewPos(s, 7), "bar", true},
+		{NewPos(s, 0), NewPos(s, 11), "foo\nbar baz", true},
+		{NewPos(s, 5), NewPos(s, 5), "", true},
+		{NewPos(s, 7), NewPos(s, 4), "", false},
+		{NewPos(s, 0), NewPos(s, 12), "", false},
+		{NewPos(s, -1), NewPos(s, 3), "", false},
+		{NewPos(s, 0), NewPos(other, 3), "", false},
+		{NewPos(other, 0), NewPos(other, 3), "", false},
+		{Pos{}, NewPos(s, 3), "", false},
+	}
+	for i, sample := range samples {
+		text, valid := s.Slice(sample.start, sample.end)
+		Assert(t, valid == sample.valid, "sample #%d: expecting valid=%v, got %v", i, sample.valid, valid)
+		Assert(t, string(text) == sample.text, "sample #%d: expecting %q, got %q", i, sample.text, text)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	check := func(q *Queue, consumed, total int) {
+		c, tt := q.Progress()
+		Assert(t, c == consumed && tt == total, "expecting %d/%d, got %d/%d", consumed, total, c, tt)
+	}
+
+	queue := NewQueue()
+	check(queue, 0, 0)
+
+	queue.Append(New("first", []byte("foo"))).Append(New("second", []byte("barbaz")))
+	check(queue, 0, 9)
+	queue.Skip(2)
+	check(queue, 2, 9)
+	queue.Prepend(New("included", []byte("qux")))
+	check(queue, 2, 12)
+	queue.Skip(3)
+	check(queue, 5, 12)
+	queue.NextSource()
+	check(queue, 5, 12)
+	queue.NextSource()
+	queue.Skip(1)
+	check(queue, 7, 12)
+	queue.NextSource()
+	check(queue, 12, 12)
+}
+
+func TestInclude(t *testing.T) {
+	main := New("main", []byte("foo\ninclude a"))
+	a := New("a", []byte("include b"))
+	b := New("b", []byte("bar"))
+
+	queue := NewQueue().Append(main)
+	queue.Skip(4)
+	queue.Include(a, Pos{})
+	queue.Include(b, NewPos(a, 0))
+
+	includes := queue.SourcePos().Includes()
+	Assert(t, len(includes) == 2, "expecting 2 includes, got %d", len(includes))
+	Assert(t, includes[0].Source() == a && includes[0].Pos() == 0, "expecting a:0, got %s:%d", includes[0].SourceName(), includes[0].Pos())
+	Assert(t, includes[1].Source() == main && includes[1].Line() == 2, "expecting main:2, got %s:%d", includes[1].SourceName(), includes[1].Line())
+	Assert(t, len(NewPos(b, 0).Includes()) == 0, "include chain must not be stored in source")
+
+	e := llx.FormatErrorPos(queue.PosAt(1), 1, "error")
+	expected := "error in b at line 1 col 2, included from a at line 1 col 1, included from main at line 2 col 1"
+	Assert(t, e.Message == expected, "expecting %q, got %q", expected, e.Message)
+	Assert(t, len(e.Includes) == 2 && e.Includes[1] == llx.IncludePos{"main", 2, 1}, "unexpected includes: %v", e.Includes)
+
+	queue.NextSource()
+	Assert(t, len(queue.SourcePos().Includes()) == 1, "expecting include chain of a to be restored")
+	queue.Include(New("empty", nil), Pos{})
+	Assert(t, queue.Source() == a && len(queue.SourcePos().Includes()) == 1, "dropped source must not change include chain")
+	queue.NextSource()
+	Assert(t, queue.Source() == main && len(queue.SourcePos().Includes()) == 0, "main source is not included")
+}
+
+func TestColumnOptions(t *testing.T) {
+	content := []byte("\tя😀x\n  \tz")
+	samples := []struct {
+		opts []Option
+		cols []int
+	}{
+		{nil, []int{1, 2, 3, 4, 5, 1, 2, 3, 4}},
+		{[]Option{WithTabWidth(4)}, []int{1, 5, 6, 7, 8, 1, 2, 3, 5}},
+		{[]Option{WithByteColumns()}, []int{1, 2, 4, 8, 9, 1, 2, 3, 4}},
+		{[]Option{WithUTF16Columns()}, []int{1, 2, 3, 5, 6, 1, 2, 3, 4}},
+		{[]Option{WithUTF16Columns(), WithTabWidth(8)}, []int{1, 9, 10, 12, 13, 1, 2, 3, 9}},
+	}
+	positions := []int{0, 1, 3, 7, 8, 9, 10, 11, 12}
+
+	for i, sample := range samples {
+		s := New("", content, sample.opts...)
+		for j, pos := range positions {
+			line, col := s.LineCol(pos)
+			Assert(t, col == sample.cols[j], "sample #%d: pos %d: expecting col %d, got %d", i, pos, sample.cols[j], col)
+			Assert(t, s.Pos(line, col) == pos, "sample #%d: line %d col %d: expecting pos %d, got %d", i, line, col, pos, s.Pos(line, col))
+		}
+	}
+
+	s := New("", content, WithTabWidth(4))
+	Assert(t, s.Pos(1, 3) == 0, "expecting tab position for column inside tab, got %d", s.Pos(1, 3))
+}
+
+func TestFirstLine(t *testing.T) {
+	s1 := New("a", []byte("foo\n"))
+	s2 := New("a", []byte("bar\nbaz"), WithFirstLine(2))
+	line, col := s2.LineCol(5)
+	if line != 3 || col != 2 {
+		t.Errorf("expecting 3:2, got %d:%d", line, col)
+	}
+	if s2.Pos(3, 2) != 5 || s2.Pos(1, 1) != 0 {
+		t.Errorf("unexpected positions: %d, %d", s2.Pos(3, 2), s2.Pos(1, 1))
+	}
+
+	samples := []struct {
+		line  int
+		text  string
+		found bool
+	}{
+		{1, "foo", true},
+		{2, "bar", true},
+		{3, "baz", true},
+		{4, "", false},
+	}
+	for i, sample := range samples {
+		text, found := Set{s1, s2}.SourceLine("a", sample.line)
+		if text != sample.text || found != sample.found {
+			t.Errorf("sample #%d: expecting (%q, %v), got (%q, %v)", i, sample.text, sample.found, text, found)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	s1 := New("s1", []byte("a"), WithContinuation())
+	s2 := New("s2", []byte("b"))
+	q := NewQueue().Append(s1)
+	if !s1.Continued() || s2.Continued() {
+		t.Fatal("wrong continuation flags")
+	}
+
+	go q.Feed(s2)
+	if next, _ := q.WaitNext(nil); !next {
+		t.Fatal("expecting fed source")
+	}
+	if !q.NextSource() || q.Source() != s2 {
+		t.Fatalf("expecting s2, got %q", q.SourceName())
+	}
+
+	go q.Close()
+	if next, _ := q.WaitNext(nil); next {
+		t.Fatal("expecting no sources after Close")
+	}
+	if !q.IsClosed() {
+		t.Fatal("expecting closed queue")
+	}
+
+	q.Feed(s1)
+	if next, _ := q.WaitNext(nil); next {
+		t.Fatal("expecting closed queue to ignore fed sources")
+	}
+
+	q = NewQueue().Append(New("s1", []byte("a"), WithContinuation()))
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if next, e := q.WaitNext(ctx); next || e != context.Canceled {
+		t.Fatalf("expecting canceled error, got %v, %v", next, e)
+	}
+}
+
+func TestJoinNext(t *testing.T) {
+	s1 := New("s1", []byte("a\nbc"), WithContinuation(), WithFirstLine(3), WithTabWidth(4))
+	s2 := New("s2", []byte("d\ne"))
+	q := NewQueue().Append(s1)
+	q.Skip(3)
+	q.Feed(s2)
+	q.Close()
+
+	if joined, e := q.JoinNext(nil); !joined || e != nil {
+		t.Fatalf("expecting joined sources, got %v, %v", joined, e)
+	}
+	s := q.Source()
+	if s.Name() != "s1" || string(s.Content()) != "bcd\ne" || s.Continued() || q.Pos() != 1 {
+		t.Fatalf("wrong joined source %q %q at %d", s.Name(), s.Content(), q.Pos())
+	}
+	if line, col := s.LineCol(q.Pos()); line != 4 || col != 2 {
+		t.Errorf("expecting 4:2, got %d:%d", line, col)
+	}
+	if consumed, total := q.Progress(); consumed != 3 || total != 7 {
+		t.Errorf("expecting 3 of 7 bytes, got %d of %d", consumed, total)
+	}
+	if string(s1.Content()) != "a\nbc" || !s1.Continued() {
+		t.Error("joined source must not be modified")
+	}
+
+	if joined, _ := q.JoinNext(nil); joined {
+		t.Error("expecting no join for non-continued source")
+	}
+}
+
+func TestAtSourceStart(t *testing.T) {
+	q := NewQueue()
+	if q.AtSourceStart() {
+		t.Error("empty queue must not be at source start")
+	}
+
+	q.AppendWithBoundary(New("s1", nil, WithContinuation()))
+	q.AppendWithBoundary(New("s2", []byte("a\n"), WithContinuation()))
+	q.AppendWithBoundary(New("s3", []byte("b"), WithContinuation()))
+	q.AppendWithBoundary(New("s4", []byte("c")))
+	q.AppendWithBoundary(New("s5", []byte("d")))
+	if !q.AtSourceStart() {
+		t.Error("expecting first source start")
+	}
+	q.NextSource()
+	if !q.AtSourceStart() {
+		t.Error("expecting source start after empty continued source")
+	}
+	q.Skip(1)
+	if q.AtSourceStart() {
+		t.Error("expecting no source start at non-zero position")
+	}
+	q.NextSource()
+	if q.AtSourceStart() {
+		t.Error("expecting no source start after continued source")
+	}
+	q.Skip(1)
+	if joined, _ := q.JoinNext(nil); !joined {
+		t.Fatal("expecting joined sources")
+	}
+	q.Seek(0)
+	if q.AtSourceStart() {
+		t.Error("expecting no source start in source joined with continued source")
+	}
+	q.NextSource()
+	if !q.AtSourceStart() {
+		t.Error("expecting source start after non-continued source")
+	}
+
+	q = NewQueue().Append(New("s1", []byte("a\nb"), WithContinuation())).Append(New("s2", []byte("c")))
+	q.Skip(3)
+	if joined, _ := q.JoinNext(nil); !joined {
+		t.Fatal("expecting joined sources")
+	}
+	q.Seek(0)
+	if q.AtSourceStart() {
+		t.Error("expecting no source start in source joined past its beginning")
+	}
+}
@@ -0,0 +1,233 @@
+package tree
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// ChangeKind classifies changes reported by Diff.
+type ChangeKind int
+
+const (
+	// Inserted: New element is absent in the old tree.
+	Inserted ChangeKind = iota + 1
+	// Removed: Old element is absent in the new tree.
+	Removed
+	// Moved: Old and New elements are equal, but have different parents or relative positions.
+	Moved
+	// Modified: Old and New are tokens of the same type having different text.
+	Modified
+)
+
+// Change describes a single difference between two trees.
+type Change struct {
+	// Kind is the change kind.
+	Kind ChangeKind
+
+	// Old is the element of the old tree, nil for inserted elements.
+	Old Element
+
+	// New is the element of the new tree, nil for removed elements.
+	New Element
+
+	// OldIndex is the index of Old element among its siblings, -1 if Old is nil or is the root.
+	OldIndex int
+
+	// NewIndex is the index of New element among its siblings, -1 if New is nil or is the root.
+	NewIndex int
+}
+
+// Diff compares two trees and returns a list of changes transforming tree a to tree b.
+// Elements are equal if they have the same type names, token texts, and equal children.
+// Children of nodes having the same type are matched using the longest common subsequence of equal children,
+// unmatched children of the same type are compared recursively, the rest are reported as removed and inserted.
+// Removed and inserted elements that are equal are reported as moved. Token positions and user data are ignored.
+// Returns nil if the trees are equal.
+func Diff(a, b Element) []Change {
+	d := &differ{keys: make(map[Element]uint64), equals: make(map[[2]Element]bool)}
+	d.diff(a, b, -1, -1)
+	return d.result()
+}
+
+type differ struct {
+	keys    map[Element]uint64
+	equals  map[[2]Element]bool
+	changes []Change
+}
+
+// key returns structural hash of an element.
+func (d *differ) key(el Element) uint64 {
+	if k, has := d.keys[el]; has {
+		return k
+	}
+
+	h := fnv.New64a()
+	if el.IsNode() {
+		h.Write([]byte("(" + el.TypeName()))
+		for _, c := range Children(el) {
+			h.Write([]byte(" " + strconv.FormatUint(d.key(c), 16)))
+		}
+		h.Write([]byte(")"))
+	} else {
+		h.Write([]byte(el.TypeName() + " "))
+		if el.Token() != nil {
+			h.Write([]byte(strconv.Quote(el.Token().Text())))
+		}
+	}
+
+	k := h.Sum64()
+	d.keys[el] = k
+	return k
+}
+
+// equal returns true if elements are structurally equal. Hashes are compared first,
+// matching hashes are confirmed by comparing elements themselves, since hashes may collide.
+func (d *differ) equal(a, b Element) bool {
+	if d.key(a) != d.key(b) {
+		return false
+	}
+
+	pair := [2]Element{a, b}
+	if res, has := d.equals[pair]; has {
+		return res
+	}
+
+	res := a.IsNode() == b.IsNode() && a.TypeName() == b.TypeName()
+	if res && a.IsNode() {
+		ac, bc := Children(a), Children(b)
+		res = len(ac) == len(bc)
+		for i := 0; res && i < len(ac); i++ {
+			res = d.equal(ac[i], bc[i])
+		}
+	} else if res {
+		res = tokenElementText(a) == tokenElementText(b)
+	}
+	d.equals[pair] = res
+	return res
+}
+
+func tokenElementText(el Element) string {
+	if el.Token() == nil {
+		return ""
+	}
+	return el.Token().Text()
+}
+
+func (d *differ) add(kind ChangeKind, oldEl, newEl Element, oldIndex, newIndex int) {
+	d.changes = append(d.changes, Change{kind, oldEl, newEl, oldIndex, newIndex})
+}
+
+func (d *differ) diff(a, b Element, ai, bi int) {
+	if d.equal(a, b) {
+		return
+	}
+
+	if a.IsNode() != b.IsNode() || a.TypeName() != b.TypeName() {
+		d.add(Removed, a, nil, ai, -1)
+		d.add(Inserted, nil, b, -1, bi)
+		return
+	}
+
+	if !a.IsNode() {
+		d.add(Modified, a, b, ai, bi)
+		return
+	}
+
+	ac, bc := Children(a), Children(b)
+	matches := d.lcs(ac, bc)
+	i, j := 0, 0
+	for _, m := range append(matches, [2]int{len(ac), len(bc)}) {
+		for i < m[0] && j < m[1] && ac[i].IsNode() == bc[j].IsNode() && ac[i].TypeName() == bc[j].TypeName() {
+			d.diff(ac[i], bc[j], i, j)
+			i++
+			j++
+		}
+		for ; i < m[0]; i++ {
+			d.add(Removed, ac[i], nil, i, -1)
+		}
+		for ; j < m[1]; j++ {
+			d.add(Inserted, nil, bc[j], -1, j)
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+}
+
+// lcs returns index pairs of the longest common subsequence of equal elements.
+func (d *differ) lcs(a, b []Element) [][2]int {
+	n, m := len(a), len(b)
+	lens := make([][]int, n+1)
+	for i := range lens {
+		lens[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case d.equal(a[i], b[j]):
+				lens[i][j] = lens[i+1][j+1] + 1
+			case lens[i+1][j] >= lens[i][j+1]:
+				lens[i][j] = lens[i+1][j]
+			default:
+				lens[i][j] = lens[i][j+1]
+			}
+		}
+	}
+
+	var res [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case d.equal(a[i], b[j]):
+			res = append(res, [2]int{i, j})
+			i++
+			j++
+		case lens[i+1][j] >= lens[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return res
+}
+
+// result converts pairs of equal removed and inserted elements to moved ones.
+func (d *differ) result() []Change {
+	inserted := make(map[uint64][]int)
+	for i, c := range d.changes {
+		if c.Kind == Inserted {
+			k := d.key(c.New)
+			inserted[k] = append(inserted[k], i)
+		}
+	}
+
+	dropped := make(map[int]bool)
+	for i, c := range d.changes {
+		if c.Kind != Removed {
+			continue
+		}
+
+		k := d.key(c.Old)
+		if len(inserted[k]) == 0 {
+			continue
+		}
+
+		n := 0
+		for n < len(inserted[k]) && !d.equal(c.Old, d.changes[inserted[k][n]].New) {
+			n++
+		}
+		if n == len(inserted[k]) {
+			continue
+		}
+
+		j := inserted[k][n]
+		inserted[k] = append(inserted[k][:n:n], inserted[k][n+1:]...)
+		d.changes[i] = Change{Moved, c.Old, d.changes[j].New, c.OldIndex, d.changes[j].NewIndex}
+		dropped[j] = true
+	}
+
+	var res []Change
+	for i, c := range d.changes {
+		if !dropped[i] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
@@ -1,6 +1,7 @@
 package tree
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -86,6 +87,18 @@ func TestChildren(t *testing.T) {
 	matchNodes(t, "(x)", Children(i["qux"])...)
 }
 
+func TestChildCount(t *testing.T) {
+	assert(t, ChildCount(nil) == 0)
+	assert(t, !HasSingleChild(nil))
+
+	root, i := buildTree(t, "(foo) (bar baz (qux (x)))")
+	assert(t, ChildCount(root) == 2 && !HasSingleChild(root))
+	assert(t, ChildCount(i["foo"]) == 0 && !HasSingleChild(i["foo"]))
+	assert(t, ChildCount(i["bar"]) == 2 && !HasSingleChild(i["bar"]))
+	assert(t, ChildCount(i["baz"]) == 0 && !HasSingleChild(i["baz"]))
+	assert(t, ChildCount(i["qux"]) == 1 && HasSingleChild(i["qux"]))
+}
+
 func TestDetach(t *testing.T) {
 	Detach(nil)
 
@@ -172,6 +185,25 @@ func TestReplace(t *testing.T) {
 	assert(t, second.Next() == re)
 }
 
+func TestMergeAdjacent(t *testing.T) {
+	MergeAdjacent(nil, "name", nil)
+
+	root, i := buildTree(t, "(foo a b 'c' d e g (bar h k) m)")
+	foo := i["foo"].(NodeElement)
+	i["a"].SetData(42)
+	MergeAdjacent(foo, "name", []byte("-"))
+	assert(t, serialize(root) == "(foo a-b 'c' d-e-g (bar h k) m)")
+
+	merged := foo.FirstChild()
+	assert(t, merged != i["a"] && merged.Data() == 42 && merged.TypeName() == "name")
+	assert(t, merged.Token().Pos() == i["a"].Token().Pos())
+	assert(t, i["b"].Parent() == nil && i["a"].Parent() == nil)
+	assert(t, merged.Next() == i["'c'"] && i["'c'"].Prev() == merged)
+
+	MergeAdjacent(i["bar"].(NodeElement), "name", nil)
+	assert(t, serialize(root) == "(foo a-b 'c' d-e-g (bar hk) m)")
+}
+
 func TestAppendSibling(t *testing.T) {
 	AppendSibling(nil, nil)
 
@@ -250,3 +282,45 @@ func TestAppendChild(t *testing.T) {
 	assert(t, re.Next() == re2)
 	assert(t, re2.Prev() == re)
 }
+
+func TestStats(t *testing.T) {
+	assert(t, Stats(nil) == TreeStats{})
+
+	root, i := buildTree(t, "(foo (f1 (f11 f111 f112)) f2)(bar b1)(baz)")
+	assert(t, Stats(root) == TreeStats{Nodes: 6, Tokens: 4, MaxDepth: 4, TokenBytes: 12})
+	assert(t, Stats(i["bar"]) == TreeStats{Nodes: 1, Tokens: 1, MaxDepth: 1, TokenBytes: 2})
+	assert(t, Stats(i["f2"]) == TreeStats{Tokens: 1, TokenBytes: 2})
+}
+
+func TestSignificant(t *testing.T) {
+	isTrivia := IsALiteral("sp", "cm")
+	assert(t, Significant(nil, isTrivia) == nil)
+
+	root, i := buildTree(t, "(foo sp (f1 f11 cm) sp f2) cm (bar sp)")
+	i["f1"].SetData(42)
+	sig := Significant(root, isTrivia)
+	assert(t, sig != nil && sig != root)
+	assert(t, serialize(sig.(NodeElement)) == "(foo (f1 f11) f2) (bar)")
+	assert(t, serialize(root) == "(foo sp (f1 f11 cm) sp f2) cm (bar sp)")
+
+	f1 := sig.(NodeElement).FirstChild().(NodeElement).FirstChild()
+	assert(t, f1.TypeName() == "f1" && f1 != i["f1"] && f1.Data() == 42)
+	assert(t, f1.(NodeElement).FirstChild().Token() == i["f11"].Token())
+
+	assert(t, Significant(i["sp"], isTrivia) == nil)
+}
+
+func TestComments(t *testing.T) {
+	isComment := IsALiteral("c1", "c2", "c3", "c4")
+	assert(t, Comments(nil, isComment) == nil)
+
+	root, i := buildTree(t, "c1 (foo c2 c3 f1) f2 c4")
+	cs := Comments(root, isComment)
+	assert(t, len(cs) == 4)
+	for j, c := range cs {
+		assert(t, c.Token == i[c.Text].Token() && c.Text == fmt.Sprintf("c%d", j+1))
+	}
+	assert(t, cs[0].Target == i["foo"])
+	assert(t, cs[1].Target == i["f1"] && cs[2].Target == i["f1"])
+	assert(t, cs[3].Target == nil)
+}
@@ -0,0 +1,37 @@
+package tree
+
+// Seq is an iterator over elements, it has the same type as iter.Seq[Element], so with Go 1.23 or later
+// it can be used in range loops, e.g.
+//
+//	for tok := range tree.Tokens(root) {
+//		fmt.Println(tok.Token().Text())
+//	}
+//
+// yield returns false to stop iteration.
+type Seq = func(yield func(Element) bool)
+
+// Elements returns an iterator over all elements of subtree (including root) in left-to-right, parent first order.
+func Elements(root Element) Seq {
+	return NewWalker(root, WalkLtr).Seq()
+}
+
+// Tokens returns an iterator over all token elements of subtree in left-to-right order.
+func Tokens(root Element) Seq {
+	return func(yield func(Element) bool) {
+		Elements(root)(func(el Element) bool {
+			return el.IsNode() || yield(el)
+		})
+	}
+}
+
+// Seq returns an iterator over elements fetched by walker, the walker is used up by iteration.
+func (w *Walker) Seq() Seq {
+	return func(yield func(Element) bool) {
+		w.Walk(func(s WalkStat) WalkerFlags {
+			if !yield(s.Element) {
+				return WalkerStop
+			}
+			return 0
+		})
+	}
+}
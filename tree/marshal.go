@@ -0,0 +1,243 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ava12/llx/grammar"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/source"
+)
+
+// MarshalOptions control tree serialization, zero value (or nil pointer) means default options.
+type MarshalOptions struct {
+	// Positions adds source name, line, and column to each token having known source.
+	Positions bool
+	// Aside recognizes aside tokens (spaces, comments, etc.), these tokens are omitted. nil keeps all tokens.
+	Aside Filter
+	// TrimText removes leading and trailing white space from token text.
+	TrimText bool
+}
+
+// UnmarshalOptions control tree deserialization, zero value (or nil pointer) means default options.
+type UnmarshalOptions struct {
+	// Sources are used to restore token positions: a position is restored if a source with the same name is listed.
+	// Positions are lost otherwise.
+	Sources []*source.Source
+	// Grammar is used to restore token types by type names. Token type is 0 if grammar is nil or type name is unknown.
+	Grammar *grammar.Grammar
+}
+
+type jsonElement struct {
+	Node     string         `json:"node,omitempty"`
+	Token    string         `json:"token,omitempty"`
+	Text     string         `json:"text,omitempty"`
+	Source   string         `json:"source,omitempty"`
+	Line     int            `json:"line,omitempty"`
+	Col      int            `json:"col,omitempty"`
+	Children []*jsonElement `json:"children,omitempty"`
+}
+
+// MarshalJSON serializes subtree to JSON. A node element is represented as an object containing
+// "node" (type name) and "children" (a list of child elements) fields, a token element is represented
+// as an object containing "token" (type name), "text", and optional "source", "line", and "col" fields, e.g.
+//
+//	{"node":"expr","children":[{"token":"num","text":"1"},{"token":"op","text":"+"},{"token":"num","text":"2"}]}
+//
+// Empty fields are omitted. Returns an error if root element itself is omitted.
+func MarshalJSON(root Element, opts *MarshalOptions) ([]byte, error) {
+	je := toJSONElement(root, marshalOptions(opts))
+	if je == nil {
+		return nil, errors.New("root element is omitted")
+	}
+
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON builds a tree from JSON produced by MarshalJSON.
+func UnmarshalJSON(data []byte, opts *UnmarshalOptions) (Element, error) {
+	var je jsonElement
+	e := json.Unmarshal(data, &je)
+	if e != nil {
+		return nil, e
+	}
+
+	return newUnmarshaller(opts).fromJSONElement(&je)
+}
+
+func marshalOptions(opts *MarshalOptions) *MarshalOptions {
+	if opts == nil {
+		return &MarshalOptions{}
+	}
+	return opts
+}
+
+func toJSONElement(el Element, opts *MarshalOptions) *jsonElement {
+	if opts.Aside != nil && !el.IsNode() && opts.Aside(el) {
+		return nil
+	}
+
+	if el.IsNode() {
+		je := &jsonElement{Node: el.TypeName()}
+		for c := el.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+			if cje := toJSONElement(c, opts); cje != nil {
+				je.Children = append(je.Children, cje)
+			}
+		}
+		return je
+	}
+
+	je := &jsonElement{Token: el.TypeName()}
+	tok := el.Token()
+	if tok == nil {
+		return je
+	}
+
+	je.Text = tokenText(tok, opts)
+	if opts.Positions && tok.Source() != nil {
+		je.Source = tok.SourceName()
+		je.Line = tok.Line()
+		je.Col = tok.Col()
+	}
+	return je
+}
+
+func tokenText(tok *lexer.Token, opts *MarshalOptions) string {
+	if opts.TrimText {
+		return strings.TrimSpace(tok.Text())
+	}
+	return tok.Text()
+}
+
+type unmarshaller struct {
+	sources map[string]*source.Source
+	types   map[string]int
+}
+
+func newUnmarshaller(opts *UnmarshalOptions) *unmarshaller {
+	u := &unmarshaller{sources: make(map[string]*source.Source), types: make(map[string]int)}
+	if opts == nil {
+		return u
+	}
+
+	for _, s := range opts.Sources {
+		u.sources[s.Name()] = s
+	}
+	if opts.Grammar != nil {
+		for i, t := range opts.Grammar.Tokens {
+			if t.Flags&grammar.LiteralToken == 0 {
+				u.types[t.Name] = i
+			}
+		}
+	}
+	return u
+}
+
+func (u *unmarshaller) newToken(typeName, text, srcName string, line, col int) *lexer.Token {
+	var pos source.Pos
+	if src := u.sources[srcName]; src != nil && line > 0 && col > 0 {
+		pos = source.NewPos(src, src.Pos(line, col))
+	}
+	return lexer.NewToken(u.types[typeName], typeName, []byte(text), pos)
+}
+
+// newNode creates node element with given children, the first token of the node becomes its initial token.
+func (u *unmarshaller) newNode(typeName string, children []Element) NodeElement {
+	n := NewNodeElement(typeName, nil).(*nodeElement)
+	for _, c := range children {
+		n.AddChild(c, nil)
+	}
+	if first := FirstTokenElement(n); first != nil {
+		n.token = first.Token()
+	}
+	return n
+}
+
+func (u *unmarshaller) fromJSONElement(je *jsonElement) (Element, error) {
+	if je.Token != "" {
+		if je.Node != "" || len(je.Children) > 0 {
+			return nil, errors.New("token " + je.Token + " cannot have node type or children")
+		}
+
+		return NewTokenElement(u.newToken(je.Token, je.Text, je.Source, je.Line, je.Col)), nil
+	}
+
+	if je.Node == "" {
+		return nil, errors.New("element must have either node or token type")
+	}
+
+	children := make([]Element, len(je.Children))
+	for i, cje := range je.Children {
+		var e error
+		children[i], e = u.fromJSONElement(cje)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return u.newNode(je.Node, children), nil
+}
+
+// MarshalSExpr serializes subtree to S-expression readable by FromSExpr and UnmarshalSExpr.
+// A node element is written as a parenthesized list of its type name followed by child elements,
+// a token element is written as its type name, a colon, Go-quoted text, and optional position
+// in the form of @"source":line:col, e.g.
+//
+//	(expr num:"1"@"src":1:1 op:"+"@"src":1:3 (term num:"2"@"src":1:5))
+//
+// Returns empty string if root element itself is omitted.
+func MarshalSExpr(root Element, opts *MarshalOptions) string {
+	var b bytes.Buffer
+	writeSExpr(&b, root, marshalOptions(opts))
+	return b.String()
+}
+
+func writeSExpr(b *bytes.Buffer, el Element, opts *MarshalOptions) {
+	if opts.Aside != nil && !el.IsNode() && opts.Aside(el) {
+		return
+	}
+
+	if b.Len() > 0 && b.Bytes()[b.Len()-1] != '(' {
+		b.WriteByte(' ')
+	}
+
+	if el.IsNode() {
+		b.WriteString("(" + el.TypeName())
+		for c := el.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+			writeSExpr(b, c, opts)
+		}
+		b.WriteByte(')')
+		return
+	}
+
+	b.WriteString(el.TypeName() + ":")
+	tok := el.Token()
+	if tok == nil {
+		b.WriteString(`""`)
+		return
+	}
+
+	b.WriteString(strconv.Quote(tokenText(tok, opts)))
+	if opts.Positions && tok.Source() != nil {
+		b.WriteString("@" + strconv.Quote(tok.SourceName()) + ":" + strconv.Itoa(tok.Line()) + ":" + strconv.Itoa(tok.Col()))
+	}
+}
+
+// UnmarshalSExpr builds a tree from S-expression produced by MarshalSExpr.
+// Description must contain exactly one top-level element, which is returned.
+func UnmarshalSExpr(s string, opts *UnmarshalOptions) (Element, error) {
+	list, e := parseSExprList(s, opts)
+	if e != nil {
+		return nil, e
+	}
+
+	first := list.FirstChild()
+	if first == nil || first.Next() != nil {
+		return nil, errors.New("S-expression must contain exactly one top-level element")
+	}
+
+	Detach(first)
+	return first, nil
+}
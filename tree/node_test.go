@@ -75,3 +75,14 @@ func TestNodeElement_RemoveChild(t *testing.T) {
 	assert(t, ntn.firstChild == nil)
 	assert(t, ntn.lastChild == nil)
 }
+
+func TestElementData(t *testing.T) {
+	elements := []Element{NewNodeElement("node", nil), NewTokenElement(nil)}
+	for _, el := range elements {
+		assert(t, el.Data() == nil)
+		el.SetData(42)
+		assert(t, el.Data() == 42)
+		el.SetData(nil)
+		assert(t, el.Data() == nil)
+	}
+}
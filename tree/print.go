@@ -0,0 +1,125 @@
+package tree
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/ava12/llx/lexer"
+)
+
+// Print writes the text of all tokens of given subtree to w in left-to-right order.
+// If the tree contains all tokens fetched by parser (including aside ones) and was not modified,
+// the output is exactly the source text the tree was built from.
+// Returns the number of written bytes and the first write error.
+func Print(root Element, w io.Writer) (written int, err error) {
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		el := s.Element
+		if el.IsNode() || el.Token() == nil {
+			return 0
+		}
+
+		n, e := w.Write(el.Token().Content())
+		written += n
+		if e != nil {
+			err = e
+			return WalkerStop
+		}
+		return 0
+	})
+	return
+}
+
+// Text returns the text of all tokens of given subtree, see Print.
+func Text(root Element) string {
+	var b strings.Builder
+	Print(root, &b)
+	return b.String()
+}
+
+// NormalizeSpace replaces content of all space tokens accepted by isSpace filter:
+// a token not containing line breaks becomes a single space, a token containing line breaks keeps
+// only line breaks (i.e. trailing spaces and indentation are removed). Empty space tokens are kept as is.
+func NormalizeSpace(root Element, isSpace Filter) {
+	replaceTokens(root, isSpace, func(el Element) []byte {
+		content := el.Token().Content()
+		if len(content) == 0 {
+			return content
+		}
+
+		nls := bytes.Count(content, []byte{'\n'})
+		if nls == 0 {
+			return []byte{' '}
+		}
+		return bytes.Repeat([]byte{'\n'}, nls)
+	})
+}
+
+// Reindent replaces indentation (the text after the last line break) in all space tokens accepted by isSpace filter
+// with indent string repeated once for each node accepted by isBlock filter that contains the next token.
+// The first and the last non-space tokens of a block (e.g. opening and closing braces) are indented
+// at the level of the block itself. Space tokens not containing line breaks are kept as is.
+func Reindent(root Element, isSpace, isBlock Filter, indent string) {
+	replaceTokens(root, isSpace, func(el Element) []byte {
+		content := el.Token().Content()
+		nl := bytes.LastIndexByte(content, '\n')
+		if nl < 0 {
+			return content
+		}
+
+		level := 0
+		next := NextTokenElement(el)
+		if next != nil {
+			for pa := next.Parent(); pa != nil; pa = pa.Parent() {
+				if isBlock(pa) {
+					first, last := edgeTokens(pa, isSpace)
+					if next != first && next != last {
+						level++
+					}
+				}
+			}
+		}
+
+		res := append([]byte{}, content[:nl+1]...)
+		return append(res, strings.Repeat(indent, level)...)
+	})
+}
+
+// edgeTokens returns the first and the last token elements of given node that are not accepted by isSpace filter.
+func edgeTokens(n Element, isSpace Filter) (first, last Element) {
+	Walk(n, WalkLtr, func(s WalkStat) WalkerFlags {
+		if !s.Element.IsNode() && !isSpace(s.Element) {
+			if first == nil {
+				first = s.Element
+			}
+			last = s.Element
+		}
+		return 0
+	})
+	return
+}
+
+// replaceTokens replaces token elements accepted by filter with new ones having content returned by f.
+// New tokens keep lexer group, but not decoded value.
+func replaceTokens(root Element, filter Filter, f func(el Element) []byte) {
+	var els []Element
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		el := s.Element
+		if !el.IsNode() && el.Token() != nil && el.Parent() != nil && filter(el) {
+			els = append(els, el)
+		}
+		return 0
+	})
+
+	for _, el := range els {
+		tok := el.Token()
+		content := f(el)
+		if bytes.Equal(content, tok.Content()) {
+			continue
+		}
+
+		nel := NewTokenElement(lexer.NewToken(tok.Type(), tok.TypeName(), content, tok.Pos()).WithGroup(tok.Group()))
+		nel.SetData(el.Data())
+		Replace(el, nel)
+	}
+}
@@ -0,0 +1,229 @@
+// Package query implements a small path expression language for syntax trees, similar to XPath.
+// A query is compiled to tree.Selector, e.g.
+//
+//	sel, e := query.Compile("section/entry[name='port']/value")
+//	values := sel.Apply(root)
+//
+// A query is a sequence of steps separated with / (child elements) or // (descendant elements).
+// A query starting with // looks for descendants of input elements, otherwise the first step selects children.
+// Each step is one of:
+//   - name: elements (nodes or tokens) of given type;
+//   - 'text' or "text": token elements having given text;
+//   - *: any element;
+//   - .: the element itself;
+//   - ..: parent element.
+//
+// A step may be followed by any number of predicates in square brackets:
+//   - [n]: n-th element (starting from 1) selected by this step for the same input element;
+//   - [query]: elements for which nested query selects at least one element;
+//   - [query='text']: elements for which nested query selects at least one element having given text.
+//
+// Element text is its token text for a token element or concatenated text of all tokens for a node element,
+// so [.='text'] compares the text of the element itself.
+// Output of compiled selector contains no duplicates.
+package query
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/ava12/llx/langdef"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/tree"
+)
+
+const queryGrammarDef = "!aside $space; $space = /\\s+/; " +
+	"$num = /\\d+/; $name = /[A-Za-z_][A-Za-z_0-9-]*/; $string = /'[^']*'|\"[^\"]*\"/; $op = /\\/\\/|\\.\\.|[\\/\\[\\]=*.]/; " +
+	"path = ['//'], step, {('/' | '//'), step}; step = ('..' | '.' | '*' | $name | $string), {predicate}; " +
+	"predicate = '[', ($num | (path, ['=', $string])), ']';"
+
+var (
+	queryParser     *parser.Parser
+	queryParserErr  error
+	queryParserOnce sync.Once
+	queryHooks      = &parser.Hooks{Nodes: parser.NodeHooks{parser.AnyNode: tree.NodeHook}}
+)
+
+// Compile parses query and returns selector that applies it to input elements.
+// Returns llx.Error if query is malformed.
+func Compile(query string) (*tree.Selector, error) {
+	queryParserOnce.Do(func() {
+		g, e := langdef.ParseString("query", queryGrammarDef)
+		if e == nil {
+			queryParser, e = parser.New(g)
+		}
+		queryParserErr = e
+	})
+	if queryParserErr != nil {
+		return nil, queryParserErr
+	}
+
+	root, e := queryParser.ParseString("query", query, queryHooks)
+	if e != nil {
+		return nil, e
+	}
+
+	return tree.NewSelector().Extract(compilePath(root.(tree.Element))).Unique(), nil
+}
+
+// MustCompile is same as Compile, except it panics if query is malformed.
+func MustCompile(query string) *tree.Selector {
+	s, e := Compile(query)
+	if e != nil {
+		panic(e)
+	}
+	return s
+}
+
+// Select compiles query and applies it to input elements.
+func Select(query string, input ...tree.Element) ([]tree.Element, error) {
+	s, e := Compile(query)
+	if e != nil {
+		return nil, e
+	}
+	return s.Apply(input...), nil
+}
+
+// significant returns child elements except for aside tokens.
+func significant(el tree.Element) []tree.Element {
+	var res []tree.Element
+	for _, c := range tree.Children(el) {
+		if c.IsNode() || c.TypeName() != "space" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func compilePath(path tree.Element) tree.Extractor {
+	var steps []tree.Extractor
+	axis := children
+	for _, item := range significant(path) {
+		if !item.IsNode() {
+			if item.Token().Text() == "//" {
+				axis = descendants
+			} else {
+				axis = children
+			}
+			continue
+		}
+
+		steps = append(steps, compileStep(item, axis))
+	}
+
+	return func(el tree.Element) []tree.Element {
+		res := []tree.Element{el}
+		for _, step := range steps {
+			var next []tree.Element
+			for _, r := range res {
+				next = append(next, step(r)...)
+			}
+			res = next
+		}
+		return res
+	}
+}
+
+func compileStep(step tree.Element, axis tree.Extractor) tree.Extractor {
+	items := significant(step)
+	test := items[0].Token()
+	var (
+		filter tree.Filter
+		text   = test.Text()
+	)
+	switch test.TypeName() {
+	case "name":
+		filter = tree.IsA(text)
+	case "string":
+		filter = tree.IsALiteral(text[1 : len(text)-1])
+	default:
+		switch text {
+		case ".":
+			axis = self
+		case "..":
+			axis = parent
+		}
+	}
+
+	var preds []func([]tree.Element) []tree.Element
+	for _, p := range items[1:] {
+		preds = append(preds, compilePredicate(p))
+	}
+
+	return func(el tree.Element) []tree.Element {
+		res := axis(el)
+		if filter != nil {
+			filtered := make([]tree.Element, 0, len(res))
+			for _, r := range res {
+				if filter(r) {
+					filtered = append(filtered, r)
+				}
+			}
+			res = filtered
+		}
+		for _, pred := range preds {
+			res = pred(res)
+		}
+		return res
+	}
+}
+
+func compilePredicate(pred tree.Element) func([]tree.Element) []tree.Element {
+	items := significant(pred)
+	if !items[1].IsNode() {
+		n, _ := strconv.Atoi(items[1].Token().Text())
+		return func(els []tree.Element) []tree.Element {
+			if n < 1 || n > len(els) {
+				return nil
+			}
+			return els[n-1 : n]
+		}
+	}
+
+	path := compilePath(items[1])
+	compare := len(items) > 3
+	var expected string
+	if compare {
+		expected = items[3].Token().Text()
+		expected = expected[1 : len(expected)-1]
+	}
+
+	return func(els []tree.Element) []tree.Element {
+		var res []tree.Element
+		for _, el := range els {
+			for _, found := range path(el) {
+				if !compare || tree.Text(found) == expected {
+					res = append(res, el)
+					break
+				}
+			}
+		}
+		return res
+	}
+}
+
+func self(el tree.Element) []tree.Element {
+	return []tree.Element{el}
+}
+
+func parent(el tree.Element) []tree.Element {
+	if el.Parent() == nil {
+		return nil
+	}
+	return []tree.Element{el.Parent()}
+}
+
+func children(el tree.Element) []tree.Element {
+	return tree.Children(el)
+}
+
+func descendants(el tree.Element) []tree.Element {
+	var res []tree.Element
+	tree.Walk(el, tree.WalkLtr, func(s tree.WalkStat) tree.WalkerFlags {
+		if s.Element != el {
+			res = append(res, s.Element)
+		}
+		return 0
+	})
+	return res
+}
@@ -0,0 +1,64 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ava12/llx"
+	"github.com/ava12/llx/tree"
+)
+
+const testTree = `(conf
+	(section (key main) (entry (key port) (val 80)) (entry (key host) (val localhost)))
+	(section (key extra) (entry (key port) (val 8080)) (group (entry (key debug) (val on)))))`
+
+func TestSelect(t *testing.T) {
+	root, e := tree.FromSExpr(testTree)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	samples := []struct {
+		query, expected string
+	}{
+		{"section/key", "main extra"},
+		{"section/entry[key='port']/val", "80 8080"},
+		{"//entry/val", "80 localhost 8080 on"},
+		{"section[2]//val", "8080 on"},
+		{"//entry[2]", "hostlocalhost"},
+		{"section[key = \"extra\"]/*[1]", "extra"},
+		{"//'port'/../../val", "80 8080"},
+		{"//val[.='on']/..", "debugon"},
+		{"section[group]/key", "extra"},
+		{"//entry[val='80'] // key", "port"},
+		{"section/entry/key/.", "port host port"},
+		{"//group/..//entry//key", "port debug"},
+		{"missing", ""},
+	}
+
+	for i, s := range samples {
+		res, e := Select(s.query, root)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected error: %s", i, e)
+			continue
+		}
+
+		texts := make([]string, len(res))
+		for j, el := range res {
+			texts[j] = tree.Text(el)
+		}
+		if strings.Join(texts, " ") != s.expected {
+			t.Errorf("sample #%d (%s): expecting %q, got %q", i, s.query, s.expected, strings.Join(texts, " "))
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	samples := []string{"", "a/", "a[", "a[1", "a[b=]", "/a", "a//", "a['x'=b]"}
+	for i, s := range samples {
+		_, e := Compile(s)
+		if _, valid := e.(*llx.Error); !valid {
+			t.Errorf("sample #%d (%s): expecting llx.Error, got %v", i, s, e)
+		}
+	}
+}
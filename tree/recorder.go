@@ -0,0 +1,134 @@
+package tree
+
+import (
+	"sort"
+
+	"github.com/ava12/llx/lexer"
+)
+
+// Recorder tracks changes of a tree made after the recorder was created and reports them as a list of edits
+// against the original text of the tree (i.e. the text Print would write at the moment of creation).
+// Any functions may be used to modify the tree, the recorder compares current tokens with the original ones:
+// a token is unchanged if the same *lexer.Token is still in the tree and keeps its relative order,
+// all other original tokens are considered removed and all other current tokens are considered inserted.
+// The root element itself must stay the same.
+type Recorder struct {
+	root    Element
+	indexes map[*lexer.Token]int
+	offsets []int
+}
+
+// NewRecorder creates a recorder and takes a snapshot of given tree.
+func NewRecorder(root Element) *Recorder {
+	r := &Recorder{root: root}
+	r.Reset()
+	return r
+}
+
+// Reset takes a new snapshot of the tree, e.g. after changes were saved.
+func (r *Recorder) Reset() {
+	r.indexes = make(map[*lexer.Token]int)
+	r.offsets = []int{0}
+	offset := 0
+	for _, tok := range tokens(r.root) {
+		if _, has := r.indexes[tok]; !has {
+			r.indexes[tok] = len(r.offsets) - 1
+		}
+		offset += len(tok.Content())
+		r.offsets = append(r.offsets, offset)
+	}
+}
+
+// Edits returns a minimal list of edits transforming the original text to the current one.
+// Edits are ordered by offset and do not overlap, all offsets refer to the original text.
+// Returns nil if the text is not changed.
+func (r *Recorder) Edits() []Edit {
+	toks := tokens(r.root)
+	kept := r.keptTokens(toks)
+
+	var (
+		res      []Edit
+		inserted []byte
+		next     int
+	)
+	flush := func(index int) {
+		removed := r.offsets[index] - r.offsets[next]
+		if removed > 0 || len(inserted) > 0 {
+			res = append(res, Edit{r.offsets[next], removed, inserted})
+		}
+		inserted = nil
+		next = index + 1
+	}
+
+	for i, tok := range toks {
+		if kept[i] {
+			flush(r.indexes[tok])
+		} else {
+			inserted = append(inserted, tok.Content()...)
+		}
+	}
+	flush(len(r.offsets) - 1)
+
+	return res
+}
+
+// keptTokens marks the longest sequence of current tokens that preserves their original order.
+func (r *Recorder) keptTokens(toks []*lexer.Token) []bool {
+	var (
+		res   = make([]bool, len(toks))
+		prev  = make([]int, len(toks))
+		tails []int
+		used  = make(map[*lexer.Token]bool)
+	)
+
+	for i, tok := range toks {
+		index, has := r.indexes[tok]
+		if !has || used[tok] {
+			continue
+		}
+
+		used[tok] = true
+		l := sort.Search(len(tails), func(j int) bool {
+			return r.indexes[toks[tails[j]]] >= index
+		})
+		prev[i] = -1
+		if l > 0 {
+			prev[i] = tails[l-1]
+		}
+		if l == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[l] = i
+		}
+	}
+
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			res[i] = true
+		}
+	}
+	return res
+}
+
+// ApplyEdits returns a copy of content with edits applied. Edits must be ordered by offset and must not overlap.
+func ApplyEdits(content []byte, edits []Edit) []byte {
+	res := make([]byte, 0, len(content))
+	pos := 0
+	for _, ed := range edits {
+		res = append(res, content[pos:ed.Offset]...)
+		res = append(res, ed.Inserted...)
+		pos = ed.Offset + ed.Removed
+	}
+	return append(res, content[pos:]...)
+}
+
+func tokens(root Element) []*lexer.Token {
+	var res []*lexer.Token
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		if !s.Element.IsNode() && s.Element.Token() != nil {
+			res = append(res, s.Element.Token())
+		}
+		return 0
+	})
+	return res
+}
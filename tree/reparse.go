@@ -0,0 +1,164 @@
+package tree
+
+import (
+	"errors"
+
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/source"
+)
+
+// Edit describes a change of source content: Removed bytes starting at Offset are replaced with Inserted bytes.
+type Edit struct {
+	Offset, Removed int
+	Inserted        []byte
+}
+
+// Reparse applies edit to the source captured by given tree and updates the tree accordingly.
+// The tree must be built by p using NodeHook from a single source, all its elements must be created
+// by NewNodeElement or NewTokenElement.
+//
+// Reparse searches for the innermost node containing the edited range (not touching its first and last tokens
+// boundaries), re-parses the node in place using parser.WithStartNode, and replaces the old node with the new one
+// if the new node ends exactly where the old one ended (adjusted by the edit), otherwise tries the parent node.
+// Tokens of all other elements are replaced with tokens referencing the new source with adjusted positions,
+// user data is kept. If no node can be re-parsed this way, the whole source is parsed again.
+// Other hooks are not used, options are passed to parser.
+//
+// Returns the root of updated tree (the same root if it was not re-parsed) or nil and an error.
+// The original tree may be partially updated in case of error.
+func Reparse(p *parser.Parser, root NodeElement, ed Edit, opts ...parser.ParseOption) (NodeElement, error) {
+	first := FirstTokenElement(root)
+	var oldSrc *source.Source
+	if first != nil {
+		oldSrc = first.Token().Source()
+	}
+	if oldSrc == nil || ed.Offset < 0 || ed.Removed < 0 || ed.Offset+ed.Removed > oldSrc.Len() {
+		return nil, errors.New("cannot apply edit to the tree")
+	}
+
+	old := oldSrc.Content()
+	content := make([]byte, 0, len(old)-ed.Removed+len(ed.Inserted))
+	content = append(content, old[:ed.Offset]...)
+	content = append(content, ed.Inserted...)
+	content = append(content, old[ed.Offset+ed.Removed:]...)
+	newSrc := source.New(oldSrc.Name(), content)
+	delta := len(ed.Inserted) - ed.Removed
+
+	if isReparsable(root, oldSrc) {
+		for n := innermostNode(root, ed); n != nil; n = n.Parent() {
+			if n.Parent() == nil {
+				break
+			}
+
+			start := FirstTokenElement(n).Token().Pos().Pos()
+			end := LastTokenElement(n).Token().EndPos().Pos() + delta
+			replacement := reparseNode(p, n.TypeName(), newSrc, start, end, opts)
+			if replacement == nil {
+				continue
+			}
+
+			rebaseTokens(root, oldSrc, newSrc, ed.Offset, delta)
+			SetLabel(replacement, Label(n))
+			Replace(n, replacement)
+			return root, nil
+		}
+	}
+
+	q := source.NewQueue().Append(newSrc)
+	res, e := p.Parse(q, &parser.Hooks{Nodes: parser.NodeHooks{parser.AnyNode: NodeHook}}, opts...)
+	if e != nil {
+		return nil, e
+	}
+
+	newRoot, _ := res.(NodeElement)
+	return newRoot, nil
+}
+
+func isReparsable(root Element, src *source.Source) bool {
+	res := true
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		switch el := s.Element.(type) {
+		case *nodeElement:
+		case *tokenElement:
+			if el.token == nil || el.token.Source() != src {
+				res = false
+			}
+		default:
+			res = false
+		}
+		if res {
+			return 0
+		}
+		return WalkerStop
+	})
+	return res
+}
+
+func innermostNode(root NodeElement, ed Edit) NodeElement {
+	var res NodeElement
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		if !s.Element.IsNode() {
+			return WalkerSkipChildren
+		}
+
+		first := FirstTokenElement(s.Element)
+		last := LastTokenElement(s.Element)
+		if first == nil || first.Token().Pos().Pos() >= ed.Offset || last.Token().EndPos().Pos() <= ed.Offset+ed.Removed {
+			return WalkerSkipChildren
+		}
+
+		res = s.Element.(NodeElement)
+		return 0
+	})
+	return res
+}
+
+func reparseNode(p *parser.Parser, typeName string, src *source.Source, start, end int, opts []parser.ParseOption) NodeElement {
+	q := source.NewQueue().Append(src)
+	q.Seek(start)
+	hs := &parser.Hooks{Nodes: parser.NodeHooks{parser.AnyNode: NodeHook}}
+	nodeOpts := make([]parser.ParseOption, len(opts), len(opts)+1)
+	copy(nodeOpts, opts)
+	res, e := p.Parse(q, hs, append(nodeOpts, parser.WithStartNode(typeName))...)
+	if e != nil {
+		return nil
+	}
+
+	n, valid := res.(NodeElement)
+	if !valid || n.TypeName() != typeName {
+		return nil
+	}
+
+	first := FirstTokenElement(n)
+	last := LastTokenElement(n)
+	if first == nil || first.Token().Pos().Pos() != start || last.Token().EndPos().Pos() != end {
+		return nil
+	}
+
+	return n
+}
+
+func rebaseTokens(root Element, oldSrc, newSrc *source.Source, offset, delta int) {
+	rebase := func(t *lexer.Token) *lexer.Token {
+		if t == nil || t.Source() != oldSrc {
+			return t
+		}
+
+		pos := t.Pos().Pos()
+		if pos >= offset {
+			pos += delta
+		}
+		return t.WithPos(source.NewPos(newSrc, pos))
+	}
+
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		switch el := s.Element.(type) {
+		case *nodeElement:
+			el.token = rebase(el.token)
+		case *tokenElement:
+			el.token = rebase(el.token)
+		}
+		return 0
+	})
+}
@@ -0,0 +1,154 @@
+package tree
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ava12/llx/langdef"
+	"github.com/ava12/llx/lexer"
+	"github.com/ava12/llx/parser"
+)
+
+const sexprGrammarDef = "!aside $space; $space = /\\s+/; " +
+	"$typed = /[^\\s()\"'@:]*:\"(?:[^\"\\\\\\n]|\\\\.)*\"(?:@\"(?:[^\"\\\\\\n]|\\\\.)*\":\\d+:\\d+)?/; " +
+	"$name = /[^\\s()\"'@:]+/; $string = /\".*?\"|'.*?'/; $op = /[()]/; " +
+	"tree-def = {$typed | $name | $string | nt}; nt = '(', $name, {$typed | $name | $string | nt}, ')';"
+
+type sexprHook struct {
+	u       *unmarshaller
+	nt      *nodeElement
+	gotName bool
+}
+
+func (h *sexprHook) NewNode(node string, token *lexer.Token) error {
+	return nil
+}
+
+func (h *sexprHook) HandleNode(node string, result any) error {
+	h.nt.AddChild(result.(Element), nil)
+	return nil
+}
+
+func (h *sexprHook) HandleToken(token *lexer.Token) error {
+	t := token.Text()
+	switch {
+	case t == "(" || t == ")":
+	case token.TypeName() == "typed":
+		tok, e := h.u.typedToken(t)
+		if e != nil {
+			return e
+		}
+		h.nt.AddChild(NewTokenElement(tok), nil)
+	case h.gotName:
+		h.nt.AddChild(NewTokenElement(token), nil)
+	default:
+		h.nt.typeName = t
+		h.gotName = true
+	}
+	return nil
+}
+
+// EndNode makes the first token of the node its initial token, nodes without tokens keep opening parenthesis.
+func (h *sexprHook) EndNode() (result any, e error) {
+	if first := FirstTokenElement(h.nt); first != nil {
+		h.nt.token = first.Token()
+	}
+	return h.nt, nil
+}
+
+// typedToken converts typed token lexeme (type:"text" with optional @"source":line:col) to token.
+func (u *unmarshaller) typedToken(lexeme string) (*lexer.Token, error) {
+	colon := strings.IndexByte(lexeme, ':')
+	typeName, rest := lexeme[:colon], lexeme[colon+1:]
+	quoted, e := strconv.QuotedPrefix(rest)
+	if e != nil {
+		return nil, e
+	}
+
+	text, e := strconv.Unquote(quoted)
+	if e != nil {
+		return nil, e
+	}
+
+	var (
+		srcName   string
+		line, col int
+	)
+	if pos := rest[len(quoted):]; pos != "" {
+		quoted, e = strconv.QuotedPrefix(pos[1:])
+		if e == nil {
+			srcName, e = strconv.Unquote(quoted)
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		parts := strings.Split(pos[len(quoted)+2:], ":")
+		line, _ = strconv.Atoi(parts[0])
+		col, _ = strconv.Atoi(parts[1])
+	}
+	return u.newToken(typeName, text, srcName, line, col), nil
+}
+
+var (
+	sexprParser     *parser.Parser
+	sexprParserErr  error
+	sexprParserOnce sync.Once
+)
+
+// parseSExprList parses a list of S-expressions and returns a node element with empty type name
+// containing all top-level elements. Typed tokens are restored using opts.
+func parseSExprList(s string, opts *UnmarshalOptions) (NodeElement, error) {
+	sexprParserOnce.Do(func() {
+		g, e := langdef.ParseString("sexpr", sexprGrammarDef)
+		if e == nil {
+			sexprParser, e = parser.New(g)
+		}
+		sexprParserErr = e
+	})
+	if sexprParserErr != nil {
+		return nil, sexprParserErr
+	}
+
+	u := newUnmarshaller(opts)
+	hooks := &parser.Hooks{
+		Nodes: parser.NodeHooks{
+			parser.AnyNode: func(node string, tok *lexer.Token, pc *parser.ParseContext) (parser.NodeHookInstance, error) {
+				return &sexprHook{u: u, nt: &nodeElement{token: tok}, gotName: (node == "tree-def")}, nil
+			},
+		},
+	}
+	res, e := sexprParser.ParseString("sexpr", s, hooks)
+	if e != nil {
+		return nil, e
+	}
+
+	return res.(NodeElement), nil
+}
+
+// FromSExpr builds a tree from its description in the form of S-expression, e.g.
+//
+//	(expr (num 1) "+" (num 2))
+//
+// Node element is written as a parenthesized list of its type name followed by child elements.
+// Token element is either a name (a sequence of characters other than spaces, parentheses, quotes, "@", and ":"),
+// a quoted string (quotes are kept in token text), or a typed token written by MarshalSExpr.
+// Type names of the first two are "name" and "string" respectively.
+// Description must contain exactly one top-level node element, which is returned.
+// Intended for building test fixtures and expected trees.
+func FromSExpr(s string) (NodeElement, error) {
+	list, e := parseSExprList(s, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	first := list.FirstChild()
+	if first == nil || !first.IsNode() || first.Next() != nil {
+		return nil, errors.New("S-expression must contain exactly one top-level node")
+	}
+
+	Detach(first)
+	return first.(NodeElement), nil
+}
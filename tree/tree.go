@@ -3,9 +3,11 @@
 package tree
 
 import (
+	"bytes"
 	"errors"
 	"github.com/ava12/llx/lexer"
 	"github.com/ava12/llx/parser"
+	"github.com/ava12/llx/source"
 )
 
 // Element represents parse tree element, either a node or a token.
@@ -31,6 +33,27 @@ type Element interface {
 	// SetNext sets the next sibling for element, nil to make the last sibling or to remove element from tree.
 	// Used by functions manipulating child and sibling elements.
 	SetNext(Element)
+	// Data returns user data attached to element, nil by default.
+	Data() any
+	// SetData attaches arbitrary user data (e.g. analysis results) to element replacing previously attached data.
+	SetData(any)
+	// StartPos returns starting position of the first token (the element itself or descendant) having known source.
+	// Returns zero value if there are no such tokens.
+	StartPos() source.Pos
+	// EndPos returns position right after the last token (the element itself or descendant) having known source.
+	// Returns zero value if there are no such tokens.
+	EndPos() source.Pos
+	// Span returns source range covered by element.
+	// Node positions are computed on each call, so they reflect any changes made to subtree.
+	Span() Span
+}
+
+// Span represents source range covered by tree element.
+type Span struct {
+	// Start is the position of the first byte.
+	Start source.Pos
+	// End is the position right after the last byte.
+	End source.Pos
 }
 
 // NodeElement represents parse tree node.
@@ -146,6 +169,81 @@ func Children(n Element) []Element {
 	return res
 }
 
+// Label returns the label given to element by node definition (e.g. "typ" in "var-def = typ:type, name:$name;"),
+// empty string if element has no label or is not created by this package.
+func Label(el Element) string {
+	if l, is := el.(labeled); is {
+		return l.label()
+	}
+	return ""
+}
+
+// SetLabel sets element label, empty string removes the label.
+// Does nothing if element is not created by this package.
+func SetLabel(el Element, label string) {
+	if l, is := el.(labeled); is {
+		l.setLabel(label)
+	}
+}
+
+// Child returns the first child element having given label or nil if there is no such child.
+func Child(n Element, label string) Element {
+	if n == nil || !n.IsNode() {
+		return nil
+	}
+
+	for c := n.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+		if Label(c) == label {
+			return c
+		}
+	}
+	return nil
+}
+
+// LabeledChildren returns all child elements having given label in left-to-right order.
+func LabeledChildren(n Element, label string) []Element {
+	if n == nil || !n.IsNode() {
+		return nil
+	}
+
+	var res []Element
+	for c := n.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+		if Label(c) == label {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+type labeled interface {
+	label() string
+	setLabel(string)
+}
+
+// ChildCount returns the number of child elements or 0 if given element is not a node.
+// Unlike Children it does not allocate memory.
+func ChildCount(n Element) int {
+	if n == nil || !n.IsNode() {
+		return 0
+	}
+
+	res := 0
+	for c := n.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+		res++
+	}
+	return res
+}
+
+// HasSingleChild returns true if given element is a node having exactly one child element.
+func HasSingleChild(n Element) bool {
+	if n == nil || !n.IsNode() {
+		return false
+	}
+
+	c := n.(NodeElement).FirstChild()
+	return c != nil && c.Next() == nil
+}
+
 // Detach removes element from tree. Parent and sibling references are removed, but descendants are kept.
 // Does nothing if the element is the tree root.
 func Detach(n Element) {
@@ -227,6 +325,41 @@ func AppendChild(parent NodeElement, el Element) {
 	parent.AddChild(el, nil)
 }
 
+// MergeAdjacent replaces each run of consecutive token children of given type with a single token element.
+// Merged token content is the concatenation of run tokens' content separated with sep,
+// merged token keeps type, position, and lexer group of the first token in run, merged element keeps its user data.
+// Merged token has no decoded value (see lexer.Token.Value), since its content differs from the content of run tokens.
+// Other children are not changed. Does nothing if parent is nil.
+func MergeAdjacent(parent NodeElement, typeName string, sep []byte) {
+	if parent == nil {
+		return
+	}
+
+	isMergeable := func(el Element) bool {
+		return el != nil && !el.IsNode() && el.Token() != nil && el.TypeName() == typeName
+	}
+
+	for c := parent.FirstChild(); c != nil; c = c.Next() {
+		if !isMergeable(c) || !isMergeable(c.Next()) {
+			continue
+		}
+
+		first := c.Token()
+		content := append([]byte{}, first.Content()...)
+		for isMergeable(c.Next()) {
+			next := c.Next()
+			content = append(content, sep...)
+			content = append(content, next.Token().Content()...)
+			Detach(next)
+		}
+
+		merged := NewTokenElement(lexer.NewToken(first.Type(), first.TypeName(), content, first.Pos()).WithGroup(first.Group()))
+		merged.SetData(c.Data())
+		Replace(c, merged)
+		c = merged
+	}
+}
+
 // WalkerFlags instruct walker which parts of subtree must be skipped.
 type WalkerFlags = int
 
@@ -371,6 +504,106 @@ func Walk(root Element, mode WalkMode, visitor Visitor) {
 	NewWalker(root, mode).Walk(visitor)
 }
 
+// TreeStats contains size information about a subtree.
+type TreeStats struct {
+	// Nodes is the number of node elements including subtree root.
+	Nodes int
+	// Tokens is the number of token elements.
+	Tokens int
+	// MaxDepth is the maximum nest level of subtree elements, 0 if subtree contains only the root.
+	MaxDepth int
+	// TokenBytes is the total length of all token elements' content.
+	TokenBytes int
+}
+
+// Stats traverses given subtree and returns its size information.
+// Returns zero value if root is nil.
+func Stats(root Element) TreeStats {
+	var res TreeStats
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		if s.Element.IsNode() {
+			res.Nodes++
+		} else {
+			res.Tokens++
+			if s.Element.Token() != nil {
+				res.TokenBytes += len(s.Element.Token().Content())
+			}
+		}
+		if s.Level > res.MaxDepth {
+			res.MaxDepth = s.Level
+		}
+		return 0
+	})
+	return res
+}
+
+// Significant returns a copy of given subtree with all elements accepted by isTrivia filter
+// (e.g. space and comment tokens) removed. Copied elements keep their tokens and user data.
+// Returns nil if root is nil or accepted by isTrivia. Original subtree is not modified.
+// Intended for comparing trees ignoring insignificant tokens.
+func Significant(root Element, isTrivia Filter) Element {
+	if root == nil || isTrivia(root) {
+		return nil
+	}
+
+	var res Element
+	if root.IsNode() {
+		node := NewNodeElement(root.TypeName(), root.Token())
+		for c := root.(NodeElement).FirstChild(); c != nil; c = c.Next() {
+			sc := Significant(c, isTrivia)
+			if sc != nil {
+				node.AddChild(sc, nil)
+			}
+		}
+		res = node
+	} else {
+		res = NewTokenElement(root.Token())
+	}
+	res.SetData(root.Data())
+	return res
+}
+
+// Comment describes a comment element found by Comments.
+type Comment struct {
+	// Token is the comment token (initial token for a node element).
+	Token *lexer.Token
+	// Text is the comment token text.
+	Text string
+	// Target is the nearest significant element following the comment, nil if there is no such element.
+	Target Element
+}
+
+// Comments traverses given subtree left to right and returns all elements accepted by isComment filter
+// in source order. Comment target is the next traversed element that is either a node element
+// or a token element other than comment or blank one (empty or containing only whitespace).
+// Note that target node is the outermost node starting after the comment.
+// Comment nodes are not traversed. Returns nil if root is nil.
+func Comments(root Element, isComment Filter) []Comment {
+	var res []Comment
+	pending := 0
+	Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+		el := s.Element
+		if isComment(el) {
+			c := Comment{Token: el.Token()}
+			if c.Token != nil {
+				c.Text = c.Token.Text()
+			}
+			res = append(res, c)
+			return WalkerSkipChildren
+		}
+
+		if !el.IsNode() && (el.Token() == nil || len(bytes.TrimSpace(el.Token().Content())) == 0) {
+			return 0
+		}
+
+		for ; pending < len(res); pending++ {
+			res[pending].Target = el
+		}
+		return 0
+	})
+	return res
+}
+
 // Filter examines given non-nil element and decides whether it is accepted and must be kept in element list (true)
 // or rejected and must be removed from list (false).
 type Filter func(n Element) bool
@@ -681,6 +914,8 @@ type tokenElement struct {
 	parent     NodeElement
 	prev, next Element
 	token      *lexer.Token
+	data       any
+	labelName  string
 }
 
 // NewTokenElement creates token element for given token.
@@ -724,12 +959,48 @@ func (t *tokenElement) SetNext(n Element) {
 	t.next = n
 }
 
+func (t *tokenElement) Data() any {
+	return t.data
+}
+
+func (t *tokenElement) SetData(d any) {
+	t.data = d
+}
+
+func (t *tokenElement) label() string {
+	return t.labelName
+}
+
+func (t *tokenElement) setLabel(l string) {
+	t.labelName = l
+}
+
+func (t *tokenElement) StartPos() source.Pos {
+	if t.token == nil {
+		return source.Pos{}
+	}
+	return t.token.Pos()
+}
+
+func (t *tokenElement) EndPos() source.Pos {
+	if t.token == nil {
+		return source.Pos{}
+	}
+	return t.token.EndPos()
+}
+
+func (t *tokenElement) Span() Span {
+	return Span{t.StartPos(), t.EndPos()}
+}
+
 type nodeElement struct {
 	typeName              string
 	token                 *lexer.Token
 	parent                NodeElement
 	prev, next            Element
 	firstChild, lastChild Element
+	data                  any
+	labelName             string
 }
 
 // NewNodeElement creates node element of given type with given initial token, token may be nil.
@@ -831,12 +1102,56 @@ func (n *nodeElement) SetNext(next Element) {
 	n.next = next
 }
 
+func (n *nodeElement) Data() any {
+	return n.data
+}
+
+func (n *nodeElement) SetData(d any) {
+	n.data = d
+}
+
+func (n *nodeElement) label() string {
+	return n.labelName
+}
+
+func (n *nodeElement) setLabel(l string) {
+	n.labelName = l
+}
+
+func (n *nodeElement) StartPos() source.Pos {
+	return positionedToken(n, WalkLtr).StartPos()
+}
+
+func (n *nodeElement) EndPos() source.Pos {
+	return positionedToken(n, WalkRtl).EndPos()
+}
+
+func (n *nodeElement) Span() Span {
+	return Span{n.StartPos(), n.EndPos()}
+}
+
+// positionedToken returns the first (in given direction) descendant token element having known source
+// or a token element having no token if there are no such descendants.
+func positionedToken(n NodeElement, mode WalkMode) Element {
+	var res Element = &tokenElement{}
+	Walk(n, mode, func(s WalkStat) WalkerFlags {
+		el := s.Element
+		if !el.IsNode() && el.Token() != nil && el.Token().Source() != nil {
+			res = el
+			return WalkerStop
+		}
+		return 0
+	})
+	return res
+}
+
 type HookInstance struct {
-	node NodeElement
+	node  NodeElement
+	label string
 }
 
 func NewHookInstance(typeName string, tok *lexer.Token) *HookInstance {
-	return &HookInstance{NewNodeElement(typeName, tok)}
+	return &HookInstance{NewNodeElement(typeName, tok), ""}
 }
 
 func (hi *HookInstance) NewNode(node string, token *lexer.Token) error {
@@ -849,21 +1164,37 @@ func (hi *HookInstance) HandleNode(name string, result interface{}) error {
 		return errors.New("node " + name + " is not a tree.Element")
 	}
 
-	hi.node.AddChild(node, nil)
+	hi.addChild(node)
 	return nil
 }
 
 func (hi *HookInstance) HandleToken(token *lexer.Token) error {
-	hi.node.AddChild(NewTokenElement(token), nil)
+	hi.addChild(NewTokenElement(token))
+	return nil
+}
+
+// HandleLabel implements parser.LabelHandler, the label is given to the next child element.
+func (hi *HookInstance) HandleLabel(label string) error {
+	hi.label = label
 	return nil
 }
 
+func (hi *HookInstance) addChild(el Element) {
+	if hi.label != "" {
+		SetLabel(el, hi.label)
+		hi.label = ""
+	}
+	hi.node.AddChild(el, nil)
+}
+
 func (hi *HookInstance) EndNode() (result interface{}, e error) {
 	return hi.node, nil
 }
 
 // NodeHook implements parser.NodeHook and builds syntax tree.
 // Intended to be used as node hook for parser.AnyNode.
+// Compact trees can be built using parser.WithInlineNodes, parser.WithSkipPunctuation,
+// and parser.WithPassThroughUnwrap options.
 func NodeHook(node string, tok *lexer.Token, pc *parser.ParseContext) (parser.NodeHookInstance, error) {
 	return NewHookInstance(node, tok), nil
 }
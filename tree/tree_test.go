@@ -12,65 +12,10 @@ import (
 	"github.com/ava12/llx/source"
 )
 
-const treeGrammarDef = "!aside $space; $space = /\\s+/; " +
-	"$name = /[a-z0-9]+/; $string = /\".*?\"|'.*?'/; $op = /[()]/; " +
-	"tree-def = {$name | $string | nt}; nt = '(', $name, {$name | $string | nt}, ')';"
-
-type treeDefHook struct {
-	nt      *nodeElement
-	gotName bool
-}
-
-func (h *treeDefHook) NewNode(node string, token *lexer.Token) error {
-	return nil
-}
-
-func (h *treeDefHook) HandleNode(node string, result interface{}) error {
-	h.nt.AddChild(result.(Element), nil)
-	return nil
-}
-
-func (h *treeDefHook) HandleToken(token *lexer.Token) error {
-	t := token.Text()
-	if t != "(" && t != ")" {
-		if h.gotName {
-			h.nt.AddChild(NewTokenElement(token), nil)
-		} else {
-			h.nt.typeName = t
-			h.gotName = true
-		}
-	}
-	return nil
-}
-
-func (h *treeDefHook) EndNode() (result interface{}, e error) {
-	return h.nt, nil
-}
-
-func newTreeDefHook(node string, tok *lexer.Token, pc *parser.ParseContext) (parser.NodeHookInstance, error) {
-	return &treeDefHook{nt: &nodeElement{token: tok}, gotName: (node == "tree-def")}, nil
-}
-
-var (
-	treeHooks = &parser.Hooks{
-		Nodes: parser.NodeHooks{
-			parser.AnyNode: NodeHook,
-		},
-	}
-	treeDefHooks = &parser.Hooks{
-		Nodes: parser.NodeHooks{
-			parser.AnyNode: newTreeDefHook,
-		},
-	}
-	treeParser *parser.Parser
-)
-
-func init() {
-	g, e := langdef.ParseString("src", treeGrammarDef)
-	if e != nil {
-		fmt.Println("error in tree description grammar:", e.Error())
-	}
-	treeParser, _ = parser.New(g)
+var treeHooks = &parser.Hooks{
+	Nodes: parser.NodeHooks{
+		parser.AnyNode: NodeHook,
+	},
 }
 
 func serialize(root NodeElement) string {
@@ -151,16 +96,12 @@ func TestParsing(t *testing.T) {
 }
 
 func parseTreeDescription(t *testing.T, src string) NodeElement {
-	if treeParser == nil {
-		t.Fatal("cannot parse tree")
-	}
-
-	res, e := treeParser.ParseString("src", src, treeDefHooks)
+	res, e := parseSExprList(src, nil)
 	if e != nil {
 		t.Fatal("error: " + e.Error())
 	}
 
-	return res.(NodeElement)
+	return res
 }
 
 func buildTree(t *testing.T, src string) (NodeElement, map[string]Element) {
@@ -576,3 +517,442 @@ func TestNextSiblings(t *testing.T) {
 	matchNodes(t, "(baz)", NextSiblings(els[1])...)
 	matchNodes(t, "", NextSiblings(els[2])...)
 }
+
+func TestFromSExpr(t *testing.T) {
+	root, e := FromSExpr(" (expr (num 1) '+' (num 2)) ")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	assert(t, root.TypeName() == "expr" && root.Parent() == nil && root.Next() == nil)
+	assert(t, serialize(root) == "(num 1) '+' (num 2)")
+	op := root.FirstChild().Next()
+	assert(t, !op.IsNode() && op.TypeName() == "string" && op.Token().Text() == "'+'")
+
+	samples := []string{"", "foo", "(foo) (bar)", "(foo", "(foo x:)", `(foo x:"y)`}
+	for i, s := range samples {
+		_, e = FromSExpr(s)
+		if e == nil {
+			t.Errorf("sample #%d: expecting error", i)
+		}
+	}
+}
+
+func TestReparse(t *testing.T) {
+	grammar := "!aside $space; $space = /\\s+/; $name = /[a-z]+/; $num = /\\d+/; $op = /[();=]/; " +
+		"prog = {stmt}; stmt = $name, '=', expr, ';'; expr = $num | $name | ('(', expr, ')');"
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "a = 1;\nb = (x);\nc = 3;"
+	res, e := p.ParseString("src", src, treeHooks)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	root := res.(NodeElement)
+	stmtA := root.FirstChild()
+	stmtC := root.LastChild()
+	stmtC.SetData(42)
+
+	checkTokens := func(root NodeElement) {
+		content := FirstTokenElement(root).Token().Source().Content()
+		Walk(root, WalkLtr, func(s WalkStat) WalkerFlags {
+			tok := s.Element.Token()
+			start := tok.Pos().Pos()
+			assert(t, string(content[start:start+len(tok.Content())]) == tok.Text())
+			return 0
+		})
+	}
+
+	x := strings.Index(src, "x")
+	root2, e := Reparse(p, root, Edit{x, 1, []byte("(yy)")})
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	assert(t, root2 == root && root.FirstChild() == stmtA && root.LastChild() == stmtC && stmtC.Data() == 42)
+	assert(t, serialize(root) == "(stmt a = (expr 1) ;) (stmt b = (expr ( (expr ( (expr yy) )) )) ;) (stmt c = (expr 3) ;)")
+	assert(t, stmtC.Token().Line() == 3 && stmtC.Token().Col() == 1)
+	checkTokens(root)
+
+	one := strings.Index(src, "1")
+	root3, e := Reparse(p, root, Edit{one, 1, []byte("1; d = 2")})
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	assert(t, root3 != root)
+	assert(t, serialize(root3) == "(stmt a = (expr 1) ;) (stmt d = (expr 2) ;) (stmt b = (expr ( (expr ( (expr yy) )) )) ;) (stmt c = (expr 3) ;)")
+	checkTokens(root3)
+
+	_, e = Reparse(p, root3, Edit{0, 1000, nil})
+	assert(t, e != nil)
+	_, e = Reparse(p, root3, Edit{-1, 0, nil})
+	assert(t, e != nil)
+}
+
+func TestReparseKeepsTokenData(t *testing.T) {
+	grammar := "!aside $space; $space = /\\s+/; $num = /\\d+/; $name = /[a-z]+/; $op = /[;=]/; " +
+		"!group $name; !decode $num int; prog = {stmt}; stmt = $name, '=', $num, ';';"
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "a = 1;\nb = 2;\nc = 3;"
+	res, e := p.ParseString("src", src, treeHooks)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	root := res.(NodeElement)
+	two := strings.Index(src, "2")
+	root2, e := Reparse(p, root, Edit{two, 1, []byte("22")})
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	assert(t, root2 == root && serialize(root) == "(stmt a = 1 ;) (stmt b = 22 ;) (stmt c = 3 ;)")
+	for stmt := root.FirstChild(); stmt != nil; stmt = stmt.Next() {
+		name := stmt.(NodeElement).FirstChild().Token()
+		num := stmt.(NodeElement).FirstChild().Next().Next().Token()
+		assert(t, name.Group() == 1 && num.Group() == 0 && fmt.Sprint(num.Value()) == num.Text())
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	g, e := langdef.ParseString("grammar", "$sp = / +/; $num = /\\d+/; $op = /[-+]/; "+
+		"g = expr; expr = $num, {[$sp], $op, [$sp], $num};")
+	assert(t, e == nil)
+	p, _ := parser.New(g)
+	src := source.New("src", []byte("1 + 22-3"))
+	root, e := p.Parse(source.NewQueue().Append(src), treeHooks)
+	assert(t, e == nil)
+
+	samples := []struct {
+		opts       *MarshalOptions
+		json, expr string
+	}{
+		{
+			nil,
+			`{"node":"g","children":[{"node":"expr","children":[{"token":"num","text":"1"},{"token":"sp","text":" "},` +
+				`{"token":"op","text":"+"},{"token":"sp","text":" "},{"token":"num","text":"22"},{"token":"op","text":"-"},` +
+				`{"token":"num","text":"3"}]}]}`,
+			`(g (expr num:"1" sp:" " op:"+" sp:" " num:"22" op:"-" num:"3"))`,
+		},
+		{
+			&MarshalOptions{TrimText: true},
+			`{"node":"g","children":[{"node":"expr","children":[{"token":"num","text":"1"},{"token":"sp"},` +
+				`{"token":"op","text":"+"},{"token":"sp"},{"token":"num","text":"22"},{"token":"op","text":"-"},` +
+				`{"token":"num","text":"3"}]}]}`,
+			`(g (expr num:"1" sp:"" op:"+" sp:"" num:"22" op:"-" num:"3"))`,
+		},
+		{
+			&MarshalOptions{Positions: true, Aside: IsA("sp")},
+			`{"node":"g","children":[{"node":"expr","children":[{"token":"num","text":"1","source":"src","line":1,"col":1},` +
+				`{"token":"op","text":"+","source":"src","line":1,"col":3},{"token":"num","text":"22","source":"src","line":1,"col":5},` +
+				`{"token":"op","text":"-","source":"src","line":1,"col":7},{"token":"num","text":"3","source":"src","line":1,"col":8}]}]}`,
+			`(g (expr num:"1"@"src":1:1 op:"+"@"src":1:3 num:"22"@"src":1:5 op:"-"@"src":1:7 num:"3"@"src":1:8))`,
+		},
+	}
+
+	uopts := &UnmarshalOptions{Sources: []*source.Source{src}, Grammar: g}
+	for i, s := range samples {
+		data, e := MarshalJSON(root.(Element), s.opts)
+		if e != nil || string(data) != s.json {
+			t.Errorf("sample #%d: expecting JSON:\n%s\ngot:\n%s (error: %v)", i, s.json, data, e)
+		}
+		expr := MarshalSExpr(root.(Element), s.opts)
+		if expr != s.expr {
+			t.Errorf("sample #%d: expecting S-expression:\n%s\ngot:\n%s", i, s.expr, expr)
+		}
+
+		fromJSON, e := UnmarshalJSON(data, uopts)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected JSON error: %s", i, e)
+			continue
+		}
+		fromExpr, e := UnmarshalSExpr(expr, uopts)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected S-expression error: %s", i, e)
+			continue
+		}
+
+		fromSExpr, e := FromSExpr(expr)
+		if e != nil {
+			t.Errorf("sample #%d: unexpected FromSExpr error: %s", i, e)
+			continue
+		}
+		if want, got := MarshalSExpr(fromExpr, nil), MarshalSExpr(fromSExpr, nil); got != want {
+			t.Errorf("sample #%d: expecting tree built by FromSExpr:\n%s\ngot:\n%s", i, want, got)
+		}
+
+		for _, el := range []Element{fromJSON, fromExpr} {
+			got := MarshalSExpr(el, s.opts)
+			if got != s.expr {
+				t.Errorf("sample #%d: expecting restored tree:\n%s\ngot:\n%s", i, s.expr, got)
+			}
+
+			num := FirstTokenElement(el).Token()
+			if num.Type() != FirstTokenElement(root.(Element)).Token().Type() || el.Token() != num {
+				t.Errorf("sample #%d: wrong first token %v", i, num)
+			}
+			if s.opts != nil && s.opts.Positions && num.Source() != src {
+				t.Errorf("sample #%d: token position not restored", i)
+			}
+		}
+	}
+
+	for i, s := range []string{"", "(g", `(g num:)`, `num:"x`, `(g num:"1"@"src":1)`, `(g) (g)`} {
+		_, e = UnmarshalSExpr(s, nil)
+		if e == nil {
+			t.Errorf("S-expression #%d: expecting error", i)
+		}
+	}
+	for i, s := range []string{"", "{}", `{"token":"num","children":[{"node":"x"}]}`, `{"node":"g","children":[{}]}`} {
+		_, e = UnmarshalJSON([]byte(s), nil)
+		if e == nil {
+			t.Errorf("JSON #%d: expecting error", i)
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	g, e := langdef.ParseString("grammar", "!aside $sp; $sp = /\\s+/; $num = /\\d+/; $op = /[-+]/; "+
+		"g = expr; expr = $num, {$op, $num};")
+	assert(t, e == nil)
+	p, _ := parser.New(g)
+	res, e := p.Parse(source.NewQueue().Append(source.New("src", []byte("1 +\n22- 3 "))), treeHooks)
+	assert(t, e == nil)
+
+	root := res.(NodeElement)
+	expr := root.FirstChild().(NodeElement)
+	pos := func(p source.Pos) string {
+		return fmt.Sprintf("%d:%d", p.Line(), p.Col())
+	}
+	span := func(el Element) string {
+		s := el.Span()
+		return pos(s.Start) + "-" + pos(s.End)
+	}
+
+	assert(t, span(root) == "1:1-2:6")
+	assert(t, span(expr) == "1:1-2:6")
+	assert(t, span(expr.FirstChild()) == "1:1-1:2")
+	assert(t, span(expr.LastChild()) == "2:5-2:6")
+
+	Detach(expr.LastChild())
+	assert(t, span(root) == "1:1-2:4")
+	Detach(expr.FirstChild())
+	assert(t, pos(root.StartPos()) == "1:3")
+
+	AppendChild(root, NewTokenElement(lexer.NewToken(0, "ext", []byte("x"), source.Pos{})))
+	assert(t, pos(root.EndPos()) == "2:4")
+
+	empty := NewNodeElement("empty", nil)
+	AppendChild(root, empty)
+	assert(t, empty.Span() == Span{})
+}
+
+func TestPrint(t *testing.T) {
+	grammar := "!aside $space; $space = /\\s+/; $name = /[a-z]+/; $num = /\\d+/; $op = /[{};=]/; " +
+		"prog = {stmt}; stmt = ($name, '=', $num, ';') | block; block = '{', {stmt}, '}';"
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "a = 1;\n{  b=2;\n\n      {\nc = 3;  }\n}\n"
+	hs := &parser.Hooks{
+		Tokens: parser.TokenHooks{
+			parser.AnyToken: func(*lexer.Token, *parser.ParseContext) (bool, error) {
+				return true, nil
+			},
+		},
+		Nodes: treeHooks.Nodes,
+	}
+	res, e := p.ParseString("src", src, hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	root := res.(NodeElement)
+	var b strings.Builder
+	n, e := Print(root, &b)
+	if e != nil || n != len(src) || b.String() != src {
+		t.Fatalf("expecting %q (%d bytes), got %q (%d bytes, error: %v)", src, len(src), b.String(), n, e)
+	}
+
+	isSpace := IsA("space")
+	Reindent(root, isSpace, IsA("block"), "\t")
+	expected := "a = 1;\n{  b=2;\n\n\t{\n\t\tc = 3;  }\n}\n"
+	if Text(root) != expected {
+		t.Errorf("expecting %q after Reindent, got %q", expected, Text(root))
+	}
+
+	NormalizeSpace(root, isSpace)
+	expected = "a = 1;\n{ b=2;\n\n{\nc = 3; }\n}\n"
+	if Text(root) != expected {
+		t.Errorf("expecting %q after NormalizeSpace, got %q", expected, Text(root))
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	grammar := "!aside $space; $space = /\\s+/; $name = /[a-z]+/; $num = /\\d+/; $op = /[;=]/; " +
+		"prog = {stmt}; stmt = $name, '=', $num, ';';"
+	p, e := parser.FromString("", grammar)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	src := "a = 1;\nb = 2;\nc = 3;\n"
+	hs := &parser.Hooks{
+		Tokens: parser.TokenHooks{
+			parser.AnyToken: func(*lexer.Token, *parser.ParseContext) (bool, error) {
+				return true, nil
+			},
+		},
+		Nodes: treeHooks.Nodes,
+	}
+	res, e := p.ParseString("src", src, hs)
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	root := res.(NodeElement)
+	r := NewRecorder(root)
+	assert(t, r.Edits() == nil)
+
+	stmts := Children(root)
+	num := func(stmt Element) Element {
+		return NewSelector().Search(IsA("num")).Apply(stmt)[0]
+	}
+	Replace(num(stmts[0]), NewTokenElement(lexer.NewToken(0, "num", []byte("10"), source.Pos{})))
+	Detach(stmts[1])
+	PrependSibling(stmts[0], stmts[1])
+
+	eds := r.Edits()
+	expected := Text(root)
+	assert(t, len(eds) == 3)
+	assert(t, eds[1].Offset == 4 && eds[1].Removed == 1 && string(eds[1].Inserted) == "10")
+	if string(ApplyEdits([]byte(src), eds)) != expected {
+		t.Errorf("expecting %q, got %q", expected, ApplyEdits([]byte(src), eds))
+	}
+
+	r.Reset()
+	assert(t, r.Edits() == nil)
+}
+
+func TestDiff(t *testing.T) {
+	tree := func(src string) Element {
+		root, e := FromSExpr(src)
+		if e != nil {
+			t.Fatalf("unexpected error: %s", e)
+		}
+		return root
+	}
+	describe := func(changes []Change) string {
+		res := make([]string, len(changes))
+		for i, c := range changes {
+			var oldText, newText string
+			if c.Old != nil {
+				oldText = Text(c.Old)
+			}
+			if c.New != nil {
+				newText = Text(c.New)
+			}
+			res[i] = fmt.Sprintf("%d:%s:%d:%s:%d", c.Kind, oldText, c.OldIndex, newText, c.NewIndex)
+		}
+		return strings.Join(res, " ")
+	}
+
+	samples := []struct {
+		a, b, expected string
+	}{
+		{"(prog (stmt a 1) (stmt b 2))", "(prog (stmt a 1) (stmt b 2))", ""},
+		{"(prog (stmt a 1) (stmt b 2))", "(prog (stmt a 1) (stmt b 3))", "4:2:1:3:1"},
+		{"(prog (stmt a 1))", "(prog (stmt a 1) (stmt b 2))", "1::-1:b2:1"},
+		{"(prog (stmt a 1) (stmt b 2) (stmt c 3))", "(prog (stmt c 3) (stmt a 5) (stmt b 2) (stmt d 4))",
+			"2:a1:0::-1 3:b2:1:b2:2 1::-1:a5:1 1::-1:d4:3"},
+		{"(prog (stmt a 1))", "(list (stmt a 1))", "2:a1:-1::-1 1::-1:a1:-1"},
+	}
+
+	for i, s := range samples {
+		got := describe(Diff(tree(s.a), tree(s.b)))
+		if got != s.expected {
+			t.Errorf("sample #%d: expecting %q, got %q", i, s.expected, got)
+		}
+	}
+
+	a, b := tree("(prog (stmt a 1))"), tree("(prog (stmt a 2))")
+	keys := map[Element]uint64{a: 1, b: 1, a.(NodeElement).FirstChild(): 2, b.(NodeElement).FirstChild(): 2}
+	d := &differ{keys: keys, equals: make(map[[2]Element]bool)}
+	d.diff(a, b, -1, -1)
+	if got := describe(d.result()); got != "4:1:1:2:1" {
+		t.Errorf("colliding hashes: expecting %q, got %q", "4:1:1:2:1", got)
+	}
+}
+
+func TestSeq(t *testing.T) {
+	root, e := FromSExpr("(expr (num 1) '+' (num 2) '*' (num 3))")
+	if e != nil {
+		t.Fatalf("unexpected error: %s", e)
+	}
+
+	var names []string
+	Elements(root)(func(el Element) bool {
+		names = append(names, el.TypeName())
+		return true
+	})
+	assert(t, strings.Join(names, " ") == "expr num name string num name string num name")
+
+	var texts []string
+	Tokens(root)(func(el Element) bool {
+		texts = append(texts, el.Token().Text())
+		return len(texts) < 3
+	})
+	assert(t, strings.Join(texts, " ") == "1 '+' 2")
+
+	texts = nil
+	NewWalker(root, WalkRtl).Seq()(func(el Element) bool {
+		if !el.IsNode() {
+			texts = append(texts, el.Token().Text())
+		}
+		return true
+	})
+	assert(t, strings.Join(texts, " ") == "3 '*' 2 '+' 1")
+}
+
+func TestLabels(t *testing.T) {
+	g, e := langdef.ParseString("grammar", "!aside $sp; $sp = /\\s+/; $name = /\\w+/; $op = /[;=,]/; "+
+		"g = {v}; v = typ:type, name:$name, {',', name:$name}, ['=', val:value], ';'; type = $name; value = $name;")
+	assert(t, e == nil)
+	p, _ := parser.New(g)
+
+	res, e := p.Parse(source.NewQueue().Append(source.New("src", []byte("int x = y; str z, w;"))), treeHooks)
+	assert(t, e == nil)
+	vs := Children(res.(NodeElement))
+	assert(t, len(vs) == 2)
+	assert(t, Child(vs[0], "typ").TypeName() == "type")
+	assert(t, Child(vs[0], "name").Token().Text() == "x")
+	assert(t, Label(Child(vs[0], "val")) == "val")
+	assert(t, Label(Child(vs[0], "typ").(NodeElement).FirstChild()) == "")
+	assert(t, Child(vs[1], "val") == nil)
+	names := LabeledChildren(vs[1], "name")
+	assert(t, len(names) == 2 && names[1].Token().Text() == "w")
+	assert(t, Label(vs[0]) == "")
+
+	res, e = p.Parse(source.NewQueue().Append(source.New("src", []byte("int x = y;"))), treeHooks,
+		parser.WithPassThroughUnwrap())
+	assert(t, e == nil)
+	v := res.(NodeElement).FirstChild()
+	assert(t, Child(v, "typ").Token().Text() == "int")
+	assert(t, Child(v, "val").Token().Text() == "y")
+
+	SetLabel(v, "first")
+	assert(t, Label(v) == "first")
+	SetLabel(v, "")
+	assert(t, Label(v) == "")
+}